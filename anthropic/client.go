@@ -0,0 +1,266 @@
+// Package anthropic provides a client for the Anthropic Messages API.
+package anthropic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jeremyhunt/agent-runner/llm"
+	"github.com/jeremyhunt/agent-runner/logger"
+	"github.com/jeremyhunt/agent-runner/models"
+	"github.com/jeremyhunt/agent-runner/tokens"
+)
+
+const (
+	// APIVersion is the version of the Messages API the client speaks
+	APIVersion = "2023-06-01"
+
+	// DefaultContextWindow is the context window assumed for Claude models missing from the model registry
+	DefaultContextWindow = 200000
+)
+
+// TokenCounter is an interface for token counting
+type TokenCounter interface {
+	CountText(text, model string) (int, error)
+}
+
+// Client is a client for the Anthropic Messages API
+type Client struct {
+	apiKey       string
+	transport    llm.Transport
+	baseURL      string
+	model        string
+	tokenCounter TokenCounter
+
+	// contextWindow overrides the model's context window (0 uses the registry)
+	contextWindow int
+
+	// models gives the context window and max output of the known models
+	models *models.Registry
+}
+
+// NewClient creates a new Anthropic client. There is no local tokenizer for Claude, so token
+// counts are estimates.
+func NewClient(apiKey, model string) *Client {
+	return &Client{
+		apiKey:       apiKey,
+		transport:    llm.NewTransport(),
+		baseURL:      "https://api.anthropic.com",
+		model:        model,
+		tokenCounter: tokens.NewCounter(),
+		models:       models.NewRegistry(),
+	}
+}
+
+// WithRetryPolicy returns a copy of the client that retries transient failures according to the policy
+func (c *Client) WithRetryPolicy(policy llm.RetryPolicy) *Client {
+	clone := *c
+	clone.transport.RetryPolicy = policy
+	return &clone
+}
+
+// WithHTTPClient returns a copy of the client that sends requests with another HTTP client, such as a
+// cassette recorder or player
+func (c *Client) WithHTTPClient(client llm.HTTPClient) *Client {
+	clone := *c
+	clone.transport.HTTPClient = client
+	return &clone
+}
+
+// WithModels returns a copy of the client that looks up model limits and tokenizers in a registry
+func (c *Client) WithModels(registry *models.Registry) *Client {
+	clone := *c
+	clone.models = registry
+	clone.tokenCounter = tokens.NewCounterWithRegistry(registry)
+	return &clone
+}
+
+// WithContextWindow returns a copy of the client that assumes the model has a different context window
+func (c *Client) WithContextWindow(tokens int) *Client {
+	clone := *c
+	clone.contextWindow = tokens
+	return &clone
+}
+
+// WithModel implements llm.Provider
+func (c *Client) WithModel(model string) llm.Provider {
+	clone := *c
+	clone.model = model
+	return &clone
+}
+
+// Model implements llm.Provider
+func (c *Client) Model() string {
+	return c.model
+}
+
+// Endpoint returns the base URL requests are sent to
+func (c *Client) Endpoint() string {
+	return c.baseURL
+}
+
+// ContextWindow implements llm.Provider
+func (c *Client) ContextWindow() int {
+	if c.contextWindow > 0 {
+		return c.contextWindow
+	}
+	if info, ok := c.models.Lookup(c.model); ok && info.ContextWindow > 0 {
+		return info.ContextWindow
+	}
+	return DefaultContextWindow
+}
+
+// MaxOutputTokens implements llm.Provider
+func (c *Client) MaxOutputTokens() int {
+	info, _ := c.models.Lookup(c.model)
+	return info.MaxOutputTokens
+}
+
+// SupportsResponseSchema implements llm.Provider. The Messages API has no structured outputs, so the
+// review asks for its tag format instead.
+func (c *Client) SupportsResponseSchema() bool {
+	return false
+}
+
+// CountText implements llm.Provider
+func (c *Client) CountText(text string) (int, error) {
+	return c.tokenCounter.CountText(text, c.model)
+}
+
+// message is a user or assistant turn in a Messages API request
+type message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// messagesRequest is a request to the Messages API
+type messagesRequest struct {
+	Model         string    `json:"model"`
+	System        string    `json:"system,omitempty"`
+	Messages      []message `json:"messages"`
+	MaxTokens     int       `json:"max_tokens"`
+	Temperature   *float32  `json:"temperature,omitempty"`
+	StopSequences []string  `json:"stop_sequences,omitempty"`
+	Stream        bool      `json:"stream,omitempty"`
+}
+
+// usage is the token usage as the API reports it. Input tokens exclude those read from or written to the cache.
+type usage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+}
+
+// toUsage converts the reported usage
+func (u usage) toUsage() llm.Usage {
+	return llm.Usage{
+		PromptTokens:     u.InputTokens + u.CacheReadInputTokens + u.CacheCreationInputTokens,
+		CachedTokens:     u.CacheReadInputTokens,
+		CompletionTokens: u.OutputTokens,
+	}
+}
+
+// messagesResponse is a response from the Messages API
+type messagesResponse struct {
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	Usage usage `json:"usage"`
+}
+
+// Chat implements llm.Provider. System messages are sent as the request's system prompt.
+func (c *Client) Chat(ctx context.Context, messages []llm.Message, opts llm.ChatOptions) (string, error) {
+	if len(messages) == 0 {
+		return "", fmt.Errorf("no messages to send")
+	}
+	if opts.ResponseSchema != nil {
+		return "", fmt.Errorf("response schemas are not supported by the Anthropic provider")
+	}
+
+	reqBody := messagesRequest{
+		Model:         c.model,
+		MaxTokens:     opts.MaxTokens,
+		Temperature:   opts.Temperature,
+		StopSequences: opts.Stop,
+		Stream:        opts.OnDelta != nil,
+	}
+	// The API requires a response limit
+	if reqBody.MaxTokens == 0 {
+		reqBody.MaxTokens = llm.ResponseTokens(c.MaxOutputTokens())
+	}
+
+	var system []string
+	var all strings.Builder
+	for _, m := range messages {
+		all.WriteString(m.Content)
+		if m.Role == llm.RoleSystem {
+			system = append(system, m.Content)
+			continue
+		}
+		reqBody.Messages = append(reqBody.Messages, message{Role: m.Role, Content: m.Content})
+	}
+	reqBody.System = strings.Join(system, "\n\n")
+	if len(reqBody.Messages) == 0 {
+		return "", fmt.Errorf("no user messages to send")
+	}
+
+	// Estimate the prompt size, leaving room in the context window for the response
+	tokenCount, err := c.CountText(all.String())
+	if err != nil {
+		return "", fmt.Errorf("error counting tokens: %w", err)
+	}
+	maxTokens := llm.PromptBudget(c)
+	if tokenCount > maxTokens {
+		return "", fmt.Errorf("token count (%d) exceeds maximum limit (%d)", tokenCount, maxTokens)
+	}
+	logger.Verbose("Sending %d messages to %s (estimated token count: %d)", len(messages), c.model, tokenCount)
+
+	reqBytes, err := json.Marshal(reqBody)
+	if err != nil {
+		return "", fmt.Errorf("error marshaling request: %w", err)
+	}
+
+	header := http.Header{}
+	header.Set("x-api-key", c.apiKey)
+	header.Set("anthropic-version", APIVersion)
+	resp, err := c.transport.Post(ctx, c.baseURL+"/v1/messages", header, reqBytes, reqBody.Stream)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if reqBody.Stream {
+		content, reported, err := readStream(resp.Body, opts.OnDelta)
+		if err != nil {
+			return "", err
+		}
+		if opts.OnUsage != nil {
+			opts.OnUsage(reported.toUsage())
+		}
+		return content, nil
+	}
+
+	var result messagesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("error decoding response: %w", err)
+	}
+
+	var content strings.Builder
+	for _, block := range result.Content {
+		if block.Type == "text" {
+			content.WriteString(block.Text)
+		}
+	}
+	if content.Len() == 0 {
+		return "", fmt.Errorf("no text in response")
+	}
+	if opts.OnUsage != nil {
+		opts.OnUsage(result.Usage.toUsage())
+	}
+	return content.String(), nil
+}
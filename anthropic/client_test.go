@@ -0,0 +1,122 @@
+package anthropic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jeremyhunt/agent-runner/llm"
+)
+
+// fixedCounter is a TokenCounter that doesn't need the tokenizer data
+type fixedCounter struct{}
+
+func (fixedCounter) CountText(text, model string) (int, error) { return len(text), nil }
+
+// newTestClient creates a client that sends requests to a test server
+func newTestClient(server *httptest.Server) *Client {
+	client := NewClient("test-key", "claude-sonnet-4-5")
+	client.transport.HTTPClient = server.Client()
+	client.baseURL = server.URL
+	client.tokenCounter = fixedCounter{}
+	return client
+}
+
+func TestChat(t *testing.T) {
+	var received messagesRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" || r.Header.Get("x-api-key") != "test-key" || r.Header.Get("anthropic-version") != APIVersion {
+			t.Errorf("Unexpected request %s with headers %v", r.URL.Path, r.Header)
+		}
+		received = messagesRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		fmt.Fprint(w, `{"content": [{"type": "text", "text": "Hel"}, {"type": "text", "text": "lo"}],
+			"usage": {"input_tokens": 100, "output_tokens": 20, "cache_read_input_tokens": 900}}`)
+	}))
+	defer server.Close()
+
+	var usage llm.Usage
+	response, err := newTestClient(server).Chat(context.Background(), []llm.Message{
+		{Role: llm.RoleSystem, Content: "You are a reviewer."},
+		{Role: llm.RoleUser, Content: "Review this."},
+	}, llm.ChatOptions{Stop: []string{"</ISSUE>"}, OnUsage: func(u llm.Usage) { usage = u }})
+	if err != nil || response != "Hello" {
+		t.Fatalf("Expected Hello, got %q (error: %v)", response, err)
+	}
+
+	// The system message moves to the system prompt and a response limit is always sent
+	if received.System != "You are a reviewer." || !reflect.DeepEqual(received.Messages, []message{{Role: "user", Content: "Review this."}}) {
+		t.Errorf("Unexpected messages: system %q, messages %+v", received.System, received.Messages)
+	}
+	if received.MaxTokens != llm.ResponseReserve || !reflect.DeepEqual(received.StopSequences, []string{"</ISSUE>"}) {
+		t.Errorf("Unexpected options in request: %+v", received)
+	}
+	if usage != (llm.Usage{PromptTokens: 1000, CachedTokens: 900, CompletionTokens: 20}) {
+		t.Errorf("Unexpected usage %+v", usage)
+	}
+
+	if _, err := newTestClient(server).Chat(context.Background(), []llm.Message{{Role: llm.RoleSystem, Content: "Hi"}}, llm.ChatOptions{}); err == nil {
+		t.Error("Expected an error for a conversation without user messages")
+	}
+}
+
+func TestChatStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range []string{
+			"event: message_start\ndata: {\"type\": \"message_start\", \"message\": {\"usage\": {\"input_tokens\": 12, \"output_tokens\": 1}}}",
+			"event: ping\ndata: {\"type\": \"ping\"}",
+			"event: content_block_delta\ndata: {\"type\": \"content_block_delta\", \"delta\": {\"type\": \"text_delta\", \"text\": \"Hel\"}}",
+			"event: content_block_delta\ndata: {\"type\": \"content_block_delta\", \"delta\": {\"type\": \"text_delta\", \"text\": \"lo\"}}",
+			"event: message_delta\ndata: {\"type\": \"message_delta\", \"usage\": {\"output_tokens\": 2}}",
+			"event: message_stop\ndata: {\"type\": \"message_stop\"}",
+		} {
+			fmt.Fprintf(w, "%s\n\n", event)
+		}
+	}))
+	defer server.Close()
+
+	var deltas []string
+	var usage llm.Usage
+	response, err := newTestClient(server).Chat(context.Background(), []llm.Message{{Role: llm.RoleUser, Content: "Hi"}},
+		llm.ChatOptions{
+			OnDelta: func(delta string) { deltas = append(deltas, delta) },
+			OnUsage: func(u llm.Usage) { usage = u },
+		})
+	if err != nil || response != "Hello" {
+		t.Fatalf("Expected Hello, got %q (error: %v)", response, err)
+	}
+	if !reflect.DeepEqual(deltas, []string{"Hel", "lo"}) {
+		t.Errorf("Unexpected deltas %v", deltas)
+	}
+	if usage.PromptTokens != 12 || usage.CompletionTokens != 2 {
+		t.Errorf("Unexpected usage %+v", usage)
+	}
+}
+
+func TestReadStreamErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		stream  string
+		wantErr string
+	}{
+		{name: "Error event", stream: "data: {\"type\": \"error\", \"error\": {\"message\": \"overloaded\"}}\n\n", wantErr: "overloaded"},
+		{name: "Truncated stream", stream: "data: {\"type\": \"content_block_delta\", \"delta\": {\"type\": \"text_delta\", \"text\": \"Hel\"}}\n\n", wantErr: "stream ended before completion"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := readStream(strings.NewReader(tt.stream), func(string) {})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
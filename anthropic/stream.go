@@ -0,0 +1,74 @@
+package anthropic
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jeremyhunt/agent-runner/llm"
+)
+
+// streamEvent is one server-sent event of a streaming Messages API response
+type streamEvent struct {
+	Type string `json:"type"`
+
+	// Message is set on message_start and carries the prompt usage
+	Message *struct {
+		Usage usage `json:"usage"`
+	} `json:"message"`
+
+	// Delta is set on content_block_delta
+	Delta *struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"delta"`
+
+	// Usage is set on message_delta and carries the output tokens so far
+	Usage *usage `json:"usage"`
+
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// readStream reads a server-sent event stream of a Messages API response, passing each text delta
+// to onDelta, and returns the full content and usage once the message stops
+func readStream(body io.Reader, onDelta func(string)) (string, usage, error) {
+	var content strings.Builder
+	var reported usage
+	err := llm.ReadEvents(body, func(data string) (bool, error) {
+		var event streamEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return false, fmt.Errorf("error decoding stream event: %w", err)
+		}
+
+		switch event.Type {
+		case "message_start":
+			if event.Message != nil {
+				reported = event.Message.Usage
+			}
+		case "content_block_delta":
+			if event.Delta != nil && event.Delta.Type == "text_delta" && event.Delta.Text != "" {
+				content.WriteString(event.Delta.Text)
+				onDelta(event.Delta.Text)
+			}
+		case "message_delta":
+			if event.Usage != nil {
+				reported.OutputTokens = event.Usage.OutputTokens
+			}
+		case "message_stop":
+			return true, nil
+		case "error":
+			if event.Error != nil {
+				return false, fmt.Errorf("error in stream: %s", event.Error.Message)
+			}
+			return false, fmt.Errorf("error in stream")
+		}
+		return false, nil
+	})
+	if err != nil {
+		return "", usage{}, err
+	}
+	return content.String(), reported, nil
+}
@@ -0,0 +1,232 @@
+// Package cache stores LLM responses on disk, keyed by a hash of the request, so identical prompts
+// are answered without calling the provider again.
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jeremyhunt/agent-runner/llm"
+	"github.com/jeremyhunt/agent-runner/logger"
+)
+
+// Entry is a cached response
+type Entry struct {
+	// Model is the model that produced the response
+	Model string `json:"model"`
+
+	// Response is the assistant's reply
+	Response string `json:"response"`
+
+	// Usage is the token usage reported when the response was produced
+	Usage llm.Usage `json:"usage"`
+
+	// Created is when the response was stored
+	Created time.Time `json:"created"`
+}
+
+// Store is a directory of cached responses, one JSON file per request hash. It is safe for concurrent use.
+type Store struct {
+	dir string
+
+	// ttl is how long entries are used (0 keeps them forever)
+	ttl time.Duration
+
+	// now returns the current time, replaced in tests
+	now func() time.Time
+}
+
+// NewStore creates a store in dir whose entries expire after ttl (0 keeps them forever)
+func NewStore(dir string, ttl time.Duration) *Store {
+	return &Store{dir: dir, ttl: ttl, now: time.Now}
+}
+
+// path returns the file of a key, spread over subdirectories by its first two characters
+func (s *Store) path(key string) string {
+	return filepath.Join(s.dir, key[:2], key+".json")
+}
+
+// expired reports whether an entry is older than the store's TTL
+func (s *Store) expired(entry Entry) bool {
+	return s.ttl > 0 && s.now().Sub(entry.Created) > s.ttl
+}
+
+// Get returns the entry stored under key, if there is one that hasn't expired
+func (s *Store) Get(key string) (Entry, bool) {
+	content, err := os.ReadFile(s.path(key))
+	if err != nil {
+		return Entry{}, false
+	}
+
+	var entry Entry
+	if err := json.Unmarshal(content, &entry); err != nil || s.expired(entry) {
+		return Entry{}, false
+	}
+	return entry, true
+}
+
+// Put stores an entry under key, replacing any existing one
+func (s *Store) Put(key string, entry Entry) error {
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	content, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode cache entry: %w", err)
+	}
+
+	// Write to a temporary file first so concurrent readers never see a partial entry
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".entry-*")
+	if err != nil {
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	return nil
+}
+
+// Prune removes the expired entries, or every entry if all is set, and returns how many were removed
+func (s *Store) Prune(all bool) (int, error) {
+	removed := 0
+	err := filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || filepath.Ext(path) != ".json" {
+			return nil
+		}
+
+		if !all {
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			// Entries that can't be read are removed along with the expired ones
+			var entry Entry
+			if err := json.Unmarshal(content, &entry); err == nil && !s.expired(entry) {
+				return nil
+			}
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		removed++
+		return nil
+	})
+	if err != nil {
+		return removed, fmt.Errorf("failed to prune cache: %w", err)
+	}
+	return removed, nil
+}
+
+// endpointer is implemented by providers that report the URL their requests are sent to
+type endpointer interface {
+	Endpoint() string
+}
+
+// Endpoint identifies the backend a provider sends requests to: its type, and its URL when it reports
+// one, so the same model name on OpenAI and Azure or on two compatible servers gets its own responses
+func Endpoint(provider llm.Provider) string {
+	name := fmt.Sprintf("%T", provider)
+	if e, ok := provider.(endpointer); ok {
+		return name + " " + e.Endpoint()
+	}
+	return name
+}
+
+// Key hashes everything that determines a response: the endpoint and model, the messages, the sampling
+// options and the response schema.
+// Callbacks such as OnDelta don't change the response, so they are not part of the key.
+func Key(endpoint, model string, messages []llm.Message, opts llm.ChatOptions) string {
+	request := struct {
+		Endpoint    string              `json:"endpoint"`
+		Model       string              `json:"model"`
+		Messages    []llm.Message       `json:"messages"`
+		Temperature *float32            `json:"temperature,omitempty"`
+		MaxTokens   int                 `json:"max_tokens,omitempty"`
+		Seed        *int                `json:"seed,omitempty"`
+		Stop        []string            `json:"stop,omitempty"`
+		Schema      *llm.ResponseSchema `json:"schema,omitempty"`
+	}{endpoint, model, messages, opts.Temperature, opts.MaxTokens, opts.Seed, opts.Stop, opts.ResponseSchema}
+
+	// Encoding these types can't fail
+	content, _ := json.Marshal(request)
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// Provider is an llm.Provider that answers repeated requests from a store
+type Provider struct {
+	llm.Provider
+	store *Store
+}
+
+// Wrap returns a provider that answers requests from the store when it can and stores the other responses
+func Wrap(provider llm.Provider, store *Store) *Provider {
+	return &Provider{Provider: provider, store: store}
+}
+
+// WithModel implements llm.Provider, keeping the cache
+func (p *Provider) WithModel(model string) llm.Provider {
+	return Wrap(p.Provider.WithModel(model), p.store)
+}
+
+// Chat implements llm.Provider. A cached response is passed to OnDelta in one piece; it reports no
+// usage, since nothing was sent.
+func (p *Provider) Chat(ctx context.Context, messages []llm.Message, opts llm.ChatOptions) (string, error) {
+	key := Key(Endpoint(p.Provider), p.Model(), messages, opts)
+	if entry, ok := p.store.Get(key); ok {
+		logger.Debug("Using cached response %s from %s", key[:12], entry.Created.Format(time.RFC3339))
+		if opts.OnDelta != nil {
+			opts.OnDelta(entry.Response)
+		}
+		return entry.Response, nil
+	}
+
+	// Keep the reported usage with the response
+	entry := Entry{Model: p.Model()}
+	onUsage := opts.OnUsage
+	opts.OnUsage = func(usage llm.Usage) {
+		entry.Usage = usage
+		if onUsage != nil {
+			onUsage(usage)
+		}
+	}
+
+	response, err := p.Provider.Chat(ctx, messages, opts)
+	if err != nil {
+		return "", err
+	}
+
+	entry.Response = response
+	entry.Created = p.store.now()
+	if err := p.store.Put(key, entry); err != nil {
+		// The response is still good; it just won't be reused
+		logger.Debug("Warning: %v", err)
+	}
+	return response, nil
+}
@@ -0,0 +1,171 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jeremyhunt/agent-runner/anthropic"
+	"github.com/jeremyhunt/agent-runner/llm"
+	"github.com/jeremyhunt/agent-runner/openai"
+)
+
+type stubProvider struct {
+	model string
+	url   string
+	calls int
+}
+
+func (p *stubProvider) Chat(ctx context.Context, messages []llm.Message, opts llm.ChatOptions) (string, error) {
+	p.calls++
+	if opts.OnUsage != nil {
+		opts.OnUsage(llm.Usage{PromptTokens: 10, CompletionTokens: 2})
+	}
+	return "reply from " + p.model, nil
+}
+
+func (p *stubProvider) CountText(text string) (int, error) { return len(text), nil }
+
+func (p *stubProvider) ContextWindow() int { return 128000 }
+
+func (p *stubProvider) MaxOutputTokens() int { return 0 }
+
+func (p *stubProvider) SupportsResponseSchema() bool { return false }
+
+func (p *stubProvider) Model() string { return p.model }
+
+func (p *stubProvider) Endpoint() string { return p.url }
+
+func (p *stubProvider) WithModel(model string) llm.Provider {
+	return &stubProvider{model: model, url: p.url}
+}
+
+func TestChat(t *testing.T) {
+	stub := &stubProvider{model: "gpt-4o"}
+	store := NewStore(t.TempDir(), time.Hour)
+	provider := Wrap(stub, store)
+	messages := []llm.Message{{Role: llm.RoleUser, Content: "Review this"}}
+
+	var usage llm.Usage
+	response, err := provider.Chat(context.Background(), messages, llm.ChatOptions{OnUsage: func(u llm.Usage) { usage = u }})
+	if err != nil || response != "reply from gpt-4o" || usage.PromptTokens != 10 {
+		t.Fatalf("Expected the provider's response and usage, got %q, %+v, %v", response, usage, err)
+	}
+
+	// The same request is answered from the cache
+	var streamed string
+	usage = llm.Usage{}
+	response, err = provider.Chat(context.Background(), messages, llm.ChatOptions{
+		OnDelta: func(delta string) { streamed += delta },
+		OnUsage: func(u llm.Usage) { usage = u },
+	})
+	if err != nil || response != "reply from gpt-4o" || streamed != response || stub.calls != 1 {
+		t.Errorf("Expected a cached response, got %q (streamed %q) after %d calls, %v", response, streamed, stub.calls, err)
+	}
+	if usage != (llm.Usage{}) {
+		t.Errorf("Expected a cached response to report no usage, got %+v", usage)
+	}
+
+	// Another request or model is sent to the provider
+	temperature := float32(0.5)
+	if _, err := provider.Chat(context.Background(), messages, llm.ChatOptions{Temperature: &temperature}); err != nil || stub.calls != 2 {
+		t.Errorf("Expected other options to miss the cache, got %d calls, %v", stub.calls, err)
+	}
+	other := provider.WithModel("gpt-4.1")
+	if response, err := other.Chat(context.Background(), messages, llm.ChatOptions{}); err != nil || response != "reply from gpt-4.1" {
+		t.Errorf("Expected another model to miss the cache, got %q, %v", response, err)
+	}
+	if _, ok := other.(*Provider); !ok {
+		t.Error("Expected WithModel to keep the cache")
+	}
+
+	// The same model on another server doesn't share responses
+	server := &stubProvider{model: "gpt-4o", url: "http://localhost:11434/v1"}
+	if _, err := Wrap(server, store).Chat(context.Background(), messages, llm.ChatOptions{}); err != nil || server.calls != 1 {
+		t.Errorf("Expected another endpoint to miss the cache, got %d calls, %v", server.calls, err)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	dir := t.TempDir()
+	store := NewStore(dir, time.Hour)
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	old := Key("openai", "gpt-4o", []llm.Message{{Role: llm.RoleUser, Content: "old"}}, llm.ChatOptions{})
+	recent := Key("openai", "gpt-4o", []llm.Message{{Role: llm.RoleUser, Content: "recent"}}, llm.ChatOptions{})
+	if err := store.Put(old, Entry{Model: "gpt-4o", Response: "old", Created: now.Add(-2 * time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(recent, Entry{Model: "gpt-4o", Response: "recent", Created: now.Add(-time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := store.Get(old); ok {
+		t.Error("Expected the old entry to have expired")
+	}
+	if entry, ok := store.Get(recent); !ok || entry.Response != "recent" {
+		t.Errorf("Expected the recent entry, got %+v, %v", entry, ok)
+	}
+
+	// Without a TTL nothing expires
+	if _, ok := NewStore(dir, 0).Get(old); !ok {
+		t.Error("Expected entries to be kept forever without a TTL")
+	}
+
+	removed, err := store.Prune(false)
+	if err != nil || removed != 1 {
+		t.Errorf("Expected to prune 1 entry, removed %d, %v", removed, err)
+	}
+	removed, err = store.Prune(true)
+	if err != nil || removed != 1 {
+		t.Errorf("Expected to clear 1 entry, removed %d, %v", removed, err)
+	}
+
+	// Pruning a cache that was never written is not an error
+	if removed, err := NewStore(dir+"/missing", 0).Prune(true); err != nil || removed != 0 {
+		t.Errorf("Expected nothing to prune, removed %d, %v", removed, err)
+	}
+}
+
+func TestKey(t *testing.T) {
+	messages := []llm.Message{{Role: llm.RoleUser, Content: "Review this"}}
+	seed := 1
+
+	base := Key("openai", "gpt-4o", messages, llm.ChatOptions{})
+	if Key("openai", "gpt-4o", messages, llm.ChatOptions{OnDelta: func(string) {}}) != base {
+		t.Error("Expected callbacks not to change the key")
+	}
+	for name, key := range map[string]string{
+		"endpoint":   Key("azure", "gpt-4o", messages, llm.ChatOptions{}),
+		"model":      Key("openai", "gpt-4.1", messages, llm.ChatOptions{}),
+		"messages":   Key("openai", "gpt-4o", append(messages, llm.Message{Role: llm.RoleAssistant, Content: "OK"}), llm.ChatOptions{}),
+		"max tokens": Key("openai", "gpt-4o", messages, llm.ChatOptions{MaxTokens: 100}),
+		"seed":       Key("openai", "gpt-4o", messages, llm.ChatOptions{Seed: &seed}),
+		"stop":       Key("openai", "gpt-4o", messages, llm.ChatOptions{Stop: []string{"END"}}),
+		"schema":     Key("openai", "gpt-4o", messages, llm.ChatOptions{ResponseSchema: &llm.ResponseSchema{Name: "review", Schema: []byte(`{}`)}}),
+	} {
+		if key == base {
+			t.Errorf("Expected the %s to change the key", name)
+		}
+	}
+}
+
+func TestEndpoint(t *testing.T) {
+	// The same model name on different backends has a different endpoint
+	endpoints := map[string]string{
+		"openai":     Endpoint(openai.NewClient("key", "gpt-4o")),
+		"azure":      Endpoint(openai.NewAzureClient("https://shop.openai.azure.com", "key", "gpt-4o", "2024-10-21")),
+		"ollama":     Endpoint(openai.NewCompatibleClient("http://localhost:11434/v1", "", "gpt-4o")),
+		"vllm":       Endpoint(openai.NewCompatibleClient("http://gpu:8000/v1", "", "gpt-4o")),
+		"anthropic":  Endpoint(anthropic.NewClient("key", "gpt-4o")),
+		"unreported": Endpoint(&stubProvider{model: "gpt-4o"}),
+	}
+	seen := make(map[string]string)
+	for name, endpoint := range endpoints {
+		if other, ok := seen[endpoint]; ok {
+			t.Errorf("Expected %s and %s to have different endpoints, both got %q", name, other, endpoint)
+		}
+		seen[endpoint] = name
+	}
+}
@@ -0,0 +1,290 @@
+// Package cassette records the HTTP exchanges of LLM requests to a file and replays them, so a review
+// can be rerun offline with the same responses.
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/jeremyhunt/agent-runner/llm"
+)
+
+// Cassette is a recording of HTTP exchanges
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is one recorded request and its response
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+// Request is a recorded request. Headers aren't recorded, so the file holds no credentials.
+type Request struct {
+	Method string          `json:"method"`
+	URL    string          `json:"url"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+// Response is a recorded response
+type Response struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body"`
+}
+
+// Load reads a cassette file
+func Load(path string) (*Cassette, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cassette: %w", err)
+	}
+
+	var cassette Cassette
+	if err := json.Unmarshal(content, &cassette); err != nil {
+		return nil, fmt.Errorf("failed to parse cassette %s: %w", path, err)
+	}
+	return &cassette, nil
+}
+
+// Save writes the cassette to a file, replacing it in one step
+func (c *Cassette) Save(path string) error {
+	content, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode cassette: %w", err)
+	}
+
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create cassette directory: %w", err)
+		}
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0644); err != nil {
+		return fmt.Errorf("failed to write cassette: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write cassette: %w", err)
+	}
+	return nil
+}
+
+// newRequest records a request, reading its body without consuming it
+func newRequest(req *http.Request) (Request, error) {
+	recorded := Request{Method: req.Method, URL: req.URL.String()}
+
+	var body []byte
+	switch {
+	case req.GetBody != nil:
+		reader, err := req.GetBody()
+		if err != nil {
+			return recorded, err
+		}
+		body, err = io.ReadAll(reader)
+		if err != nil {
+			return recorded, err
+		}
+	case req.Body != nil:
+		var err error
+		body, err = io.ReadAll(req.Body)
+		if err != nil {
+			return recorded, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	recorded.Body = normalizeBody(body)
+	return recorded, nil
+}
+
+// normalizeBody compacts a JSON body so requests match however they were formatted. Other bodies are
+// kept as a JSON string.
+func normalizeBody(body []byte) json.RawMessage {
+	if len(body) == 0 {
+		return nil
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, body); err == nil {
+		return compact.Bytes()
+	}
+	quoted, _ := json.Marshal(string(body))
+	return quoted
+}
+
+// key identifies a request for matching
+func (r Request) key() string {
+	return r.Method + " " + r.URL + "\n" + string(normalizeBody(r.Body))
+}
+
+// recordedHeader keeps the response headers that affect how a response is handled, such as retry delays,
+// and drops the rest, which can identify the account
+func recordedHeader(header http.Header) http.Header {
+	kept := make(http.Header)
+	for name, values := range header {
+		lower := strings.ToLower(name)
+		if lower == "content-type" || lower == "retry-after" || strings.Contains(lower, "ratelimit") {
+			kept[name] = values
+		}
+	}
+	return kept
+}
+
+// Recorder is an llm.HTTPClient that sends requests with another client and adds each exchange to a
+// cassette file as soon as its response has been read, so a run that fails part way is still recorded
+type Recorder struct {
+	client llm.HTTPClient
+	path   string
+
+	mu       sync.Mutex
+	cassette Cassette
+}
+
+// NewRecorder creates a recorder that sends requests with client and writes them to the file at path
+func NewRecorder(client llm.HTTPClient, path string) *Recorder {
+	return &Recorder{client: client, path: path}
+}
+
+// Do implements llm.HTTPClient. The response body is recorded as it is read, so streams are still
+// delivered as they arrive.
+func (r *Recorder) Do(req *http.Request) (*http.Response, error) {
+	request, err := newRequest(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record request: %w", err)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Body = &recordingBody{ReadCloser: resp.Body, done: func(body []byte) error {
+		return r.add(Interaction{
+			Request:  request,
+			Response: Response{Status: resp.StatusCode, Header: recordedHeader(resp.Header), Body: string(body)},
+		})
+	}}
+	return resp, nil
+}
+
+// add appends an exchange and saves the cassette
+func (r *Recorder) add(interaction Interaction) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+	return r.cassette.Save(r.path)
+}
+
+// recordingBody is a response body that keeps a copy of what is read and records it when closed. The
+// rest of the body is read first, since stream readers stop at the final event rather than the end of the
+// body. A body cut off by an error isn't recorded.
+type recordingBody struct {
+	io.ReadCloser
+	buf    bytes.Buffer
+	done   func(body []byte) error
+	failed bool
+	closed bool
+}
+
+// Read implements io.Reader
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.buf.Write(p[:n])
+	if err != nil && err != io.EOF {
+		b.failed = true
+	}
+	return n, err
+}
+
+// Close implements io.Closer, recording the response unless reading it failed
+func (b *recordingBody) Close() error {
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+
+	if !b.failed {
+		if _, err := io.Copy(&b.buf, b.ReadCloser); err != nil {
+			b.failed = true
+		}
+	}
+	err := b.ReadCloser.Close()
+	if !b.failed {
+		if recordErr := b.done(b.buf.Bytes()); recordErr != nil && err == nil {
+			err = recordErr
+		}
+	}
+	return err
+}
+
+// Player is an llm.HTTPClient that answers requests from a cassette without sending them. Identical
+// requests get their recorded responses in order, and the last one again once they run out.
+type Player struct {
+	mu        sync.Mutex
+	responses map[string][]Response
+	played    map[string]int
+}
+
+// NewPlayer creates a player for a cassette
+func NewPlayer(cassette *Cassette) *Player {
+	p := &Player{responses: make(map[string][]Response), played: make(map[string]int)}
+	for _, interaction := range cassette.Interactions {
+		key := interaction.Request.key()
+		p.responses[key] = append(p.responses[key], interaction.Response)
+	}
+	return p
+}
+
+// Do implements llm.HTTPClient. A request that wasn't recorded gets a 404 response, which isn't retried.
+func (p *Player) Do(req *http.Request) (*http.Response, error) {
+	request, err := newRequest(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request: %w", err)
+	}
+
+	p.mu.Lock()
+	key := request.key()
+	responses := p.responses[key]
+	i := p.played[key]
+	if i < len(responses) {
+		p.played[key]++
+	}
+	p.mu.Unlock()
+
+	if len(responses) == 0 {
+		return newResponse(req, Response{
+			Status: http.StatusNotFound,
+			Body:   fmt.Sprintf("the cassette has no recorded response for %s %s with this request body", req.Method, req.URL),
+		}), nil
+	}
+	if i >= len(responses) {
+		i = len(responses) - 1
+	}
+	return newResponse(req, responses[i]), nil
+}
+
+// newResponse builds the HTTP response for a recorded one
+func newResponse(req *http.Request, recorded Response) *http.Response {
+	header := recorded.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recorded.Status, http.StatusText(recorded.Status)),
+		StatusCode:    recorded.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(recorded.Body)),
+		ContentLength: int64(len(recorded.Body)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,125 @@
+package cassette
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jeremyhunt/agent-runner/llm"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case strings.Contains(string(body), "stream"):
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "data: {\"delta\":\"Hel\"}\n\ndata: {\"delta\":\"lo\"}\n\ndata: [DONE]\n\n")
+		case strings.Contains(string(body), "limited") && calls == 2:
+			w.Header().Set("Retry-After", "0")
+			w.Header().Set("Openai-Organization", "acme")
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, `{"error":"rate limited"}`)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"reply":"call %d"}`, calls)
+		}
+	}))
+
+	path := filepath.Join(t.TempDir(), "cassettes", "review.json")
+	policy := llm.RetryPolicy{MaxAttempts: 2}
+	header := http.Header{"Authorization": []string{"Bearer secret"}}
+	post := func(t *testing.T, transport llm.Transport, body string, stream bool) string {
+		t.Helper()
+		resp, err := transport.Post(context.Background(), server.URL+"/chat", header, []byte(body), stream)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		defer resp.Body.Close()
+
+		// Stream readers stop at the final event without reading to the end of the body
+		if stream {
+			var events []string
+			scanner := bufio.NewScanner(resp.Body)
+			for scanner.Scan() && scanner.Text() != "data: [DONE]" {
+				if scanner.Text() != "" {
+					events = append(events, scanner.Text())
+				}
+			}
+			return strings.Join(events, "|")
+		}
+		content, _ := io.ReadAll(resp.Body)
+		return string(content)
+	}
+
+	recorder := llm.Transport{HTTPClient: NewRecorder(server.Client(), path), RetryPolicy: policy}
+	first := post(t, recorder, `{"prompt": "first"}`, false)
+	limited := post(t, recorder, `{"prompt": "limited"}`, false)
+	streamed := post(t, recorder, `{"prompt": "stream"}`, true)
+	server.Close()
+
+	cassette, err := Load(path)
+	if err != nil {
+		t.Fatalf("Failed to load cassette: %v", err)
+	}
+	if len(cassette.Interactions) != 4 {
+		t.Fatalf("Expected 4 recorded exchanges including the rate-limited one, got %d", len(cassette.Interactions))
+	}
+	limitedResponse := cassette.Interactions[1].Response
+	if limitedResponse.Status != http.StatusTooManyRequests || limitedResponse.Header.Get("Retry-After") != "0" ||
+		limitedResponse.Header.Get("Openai-Organization") != "" {
+		t.Errorf("Expected the rate limit and its retry delay without account headers, got %+v", limitedResponse)
+	}
+
+	// The server is gone, so every response comes from the cassette
+	player := llm.Transport{HTTPClient: NewPlayer(cassette), RetryPolicy: policy}
+	if got := post(t, player, `{"prompt":"first"}`, false); got != first {
+		t.Errorf("Expected %q, got %q", first, got)
+	}
+	if got := post(t, player, `{"prompt":"limited"}`, false); got != limited {
+		t.Errorf("Expected the rate limit to be replayed before %q, got %q", limited, got)
+	}
+	if got := post(t, player, `{"prompt":"stream"}`, true); got != streamed || got == "" {
+		t.Errorf("Expected the stream %q, got %q", streamed, got)
+	}
+
+	// Repeated requests get the last recorded response again
+	if got := post(t, player, `{"prompt":"first"}`, false); got != first {
+		t.Errorf("Expected %q again, got %q", first, got)
+	}
+
+	// Requests that weren't recorded fail without retrying
+	_, err = player.Post(context.Background(), server.URL+"/chat", header, []byte(`{"prompt":"other"}`), false)
+	if err == nil || !strings.Contains(err.Error(), "404") || strings.Contains(err.Error(), "giving up") {
+		t.Errorf("Expected a missing recording error, got %v", err)
+	}
+}
+
+func TestNewRequest(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPost, "https://api.example.com/v1/messages", strings.NewReader(`{"a": 1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorded, err := newRequest(req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(recorded.Body) != `{"a":1}` {
+		t.Errorf("Expected a compacted body, got %s", recorded.Body)
+	}
+
+	// The request can still be sent after it was recorded
+	body, _ := io.ReadAll(req.Body)
+	if string(body) != `{"a": 1}` {
+		t.Errorf("Expected the body to be left unread, got %q", body)
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jeremyhunt/agent-runner/cache"
+	"github.com/jeremyhunt/agent-runner/logger"
+)
+
+// handleCache runs a cache command: "prune" removes the expired responses and "clear" removes them all
+func handleCache(store *cache.Store, args []string) {
+	if len(args) != 1 || (args[0] != "prune" && args[0] != "clear") {
+		fmt.Fprintf(os.Stderr, "Usage: agent cache prune|clear\n")
+		os.Exit(1)
+	}
+
+	removed, err := store.Prune(args[0] == "clear")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	logger.Success("Removed %d cached responses", removed)
+}
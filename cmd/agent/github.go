@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/jeremyhunt/agent-runner/github"
+	"github.com/jeremyhunt/agent-runner/logger"
+	"github.com/jeremyhunt/agent-runner/review"
+)
+
+// githubOptions are the settings for reading the pull request from GitHub and posting the review on it
+type githubOptions struct {
+	// pr is the pull request number, or 0 to use the open pull request of the branch
+	pr int
+
+	// fromGitHub reads the pull request through the API instead of the local clone
+	fromGitHub bool
+
+	// post posts the review on the pull request once it completes, and dryRun prints it instead
+	post   bool
+	dryRun bool
+
+	baseURL string
+	token   string
+}
+
+// client returns the GitHub API client of the settings
+func (o githubOptions) client() *github.Client {
+	return github.NewClient(o.baseURL, o.token)
+}
+
+// postReview posts the final summary and the inline comments of the findings as a review on the pull
+// request, or prints them for a dry run
+func postReview(workflow *review.Workflow, repo, branch string, opts githubOptions) error {
+	if !opts.dryRun && opts.token == "" {
+		return errors.New("GITHUB_TOKEN environment variable is not set")
+	}
+
+	pullRequest, skipped, err := workflow.GitHubReview()
+	if err != nil {
+		return err
+	}
+	if skipped > 0 {
+		logger.Verbose("%d findings are not on a line of the diff and are left to the summary", skipped)
+	}
+
+	client := opts.client()
+	ctx := context.Background()
+	number := opts.pr
+	if number == 0 {
+		// A pull request read from GitHub was already found
+		number = workflow.Ctx.PullRequestNumber
+	}
+	if number == 0 {
+		pr, err := client.FindPullRequest(ctx, repo, branch)
+		if err != nil {
+			return err
+		}
+		number = pr.Number
+		logger.Info("Found pull request #%d: %s", pr.Number, pr.Title)
+	}
+
+	if opts.dryRun {
+		logger.Info("Dry run: the review %s#%d would get", repo, number)
+		fmt.Printf("\n%s\n", pullRequest.Body)
+		for _, comment := range pullRequest.Comments {
+			location := fmt.Sprintf("%s:%d", comment.Path, comment.Line)
+			if comment.StartLine > 0 {
+				location = fmt.Sprintf("%s:%d-%d", comment.Path, comment.StartLine, comment.Line)
+			}
+			fmt.Printf("\n--- %s\n%s", location, comment.Body)
+		}
+		fmt.Println()
+		return nil
+	}
+
+	url, err := client.CreateReview(ctx, repo, number, pullRequest)
+	// GitHub rejects the whole review if any comment is outside its diff, as when the local diff was
+	// taken against a different base, so the summary is posted on its own rather than lost
+	var apiError *github.Error
+	if errors.As(err, &apiError) && apiError.StatusCode == http.StatusUnprocessableEntity && len(pullRequest.Comments) > 0 {
+		logger.Error("GitHub rejected the inline comments (%s), posting the summary without them", apiError.Message)
+		pullRequest.Comments = nil
+		url, err = client.CreateReview(ctx, repo, number, pullRequest)
+	}
+	if err != nil {
+		return err
+	}
+
+	logger.Success("Posted review with %d inline comments to %s", len(pullRequest.Comments), url)
+	return nil
+}
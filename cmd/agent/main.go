@@ -5,25 +5,54 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 
+	"github.com/jeremyhunt/agent-runner/cache"
+	"github.com/jeremyhunt/agent-runner/cassette"
 	"github.com/jeremyhunt/agent-runner/config"
+	"github.com/jeremyhunt/agent-runner/llm"
 	"github.com/jeremyhunt/agent-runner/logger"
-	"github.com/jeremyhunt/agent-runner/openai"
+	"github.com/jeremyhunt/agent-runner/models"
 	"github.com/jeremyhunt/agent-runner/review"
+	"github.com/jeremyhunt/agent-runner/tokens"
 )
 
 func main() {
 	// Define command-line flags
-	modelFlag := flag.String("model", "", "OpenAI model to use (overrides env variable)")
+	modelFlag := flag.String("model", "", "Model to use, or the deployment name for Azure OpenAI (overrides env variable)")
 	reviewFlag := flag.Bool("review", false, "Run PR review workflow")
 	statusFlag := flag.Bool("status", false, "Check status of integrations")
 	ticketFlag := flag.String("ticket", "", "Ticket number for PR review (e.g., WIRE-1231)")
 	repoFlag := flag.String("repo", "", "Repository name for PR review (e.g., BambooHR/payroll-gateway)")
 	branchFlag := flag.String("branch", "", "PR branch name for review (e.g., username/WIRE-1231)")
 	designDocFlag := flag.String("design-doc", "", "Design document name to include in review context (e.g., WIRE-1231-design.md)")
+	baseFlag := flag.String("base", "", "Base branch or commit SHA to compare against (defaults to main/master)")
+	headFlag := flag.String("head", "", "Head branch or commit SHA to review (defaults to --branch)")
+
+	// Per-step model flags, e.g. --model-validation=anthropic:claude-opus-4-1
+	stepModelFlags := make(map[string]*string, len(config.ModelSteps))
+	for _, step := range config.ModelSteps {
+		stepModelFlags[step] = flag.String("model-"+step, "",
+			fmt.Sprintf("Model for the %s steps, optionally prefixed with a provider as in anthropic:claude-opus-4-1 (overrides env variable)", step))
+	}
+
+	// Checkpoint flags
+	resumeFlag := flag.Bool("resume", false, "Skip review steps whose inputs are unchanged since the last run")
+	fromStepFlag := flag.String("from-step", "", "Rerun the review from this step onwards, reusing earlier output (e.g., synthesis)")
+	onlyStepFlag := flag.String("only-step", "", "Rerun only this review step, reusing the output of the others (e.g., final-summary)")
+	pipelineFlag := flag.String("pipeline", "", "YAML (or .json) file listing the review pipeline steps (overrides env variable)")
+	concurrencyFlag := flag.Int("concurrency", 0, "Number of files to analyze at the same time (overrides env variable)")
+	noCacheFlag := flag.Bool("no-cache", false, "Send every review request to the LLM instead of reusing cached responses")
+	recordFlag := flag.String("record", "", "Record the LLM requests and responses to this cassette file (overrides env variable)")
+	replayFlag := flag.String("replay", "", "Replay the LLM responses from this cassette file instead of sending requests (overrides env variable)")
+	githubFlag := flag.Bool("github", false, "Read the pull request's diff, files and contents from the GitHub API instead of a local clone")
+	postFlag := flag.Bool("post", false, "Post the final summary and inline comments as a review on the GitHub pull request")
+	prFlag := flag.Int("pr", 0, "Pull request number to post the review on (defaults to the open pull request of --branch)")
+	dryRunFlag := flag.Bool("dry-run", false, "Print the review --post would send instead of posting it")
+	githubURLFlag := flag.String("github-url", "", "GitHub API URL, e.g. https://github.example.com/api/v3 for GitHub Enterprise (overrides env variable)")
 
 	// Verbosity flags
 	verboseFlag := flag.Bool("verbose", false, "Enable verbose output")
@@ -33,8 +62,21 @@ func main() {
 	// Parse flags
 	flag.Parse()
 
+	// A cache command, e.g. "agent cache prune", sends no LLM requests, so it needs no API keys
+	cacheCommand := flag.NArg() > 0 && flag.Arg(0) == "cache"
+
+	// A cassette is either recorded or replayed; replaying sends no requests, so it needs no API keys
+	if *recordFlag != "" && *replayFlag != "" {
+		fmt.Fprintf(os.Stderr, "Error: --record and --replay cannot be used together\n")
+		os.Exit(1)
+	}
+
 	// Load configuration
-	cfg, err := config.Load()
+	cfg, err := config.LoadWithOptions(config.LoadOptions{
+		SkipCredentials: cacheCommand,
+		RecordPath:      *recordFlag,
+		ReplayPath:      *replayFlag,
+	})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
 		os.Exit(1)
@@ -52,6 +94,12 @@ func main() {
 	// Initialize logger with verbosity level
 	logger.Initialize(cfg.Verbosity)
 
+	// Manage the response cache without creating any provider
+	if cacheCommand {
+		handleCache(cache.NewStore(cfg.CacheDir, cfg.CacheTTL), flag.Args()[1:])
+		return
+	}
+
 	// Only show the model info in normal verbosity mode
 	if cfg.Verbosity == logger.VerbosityNormal {
 		logger.Info("Using model: %s", cfg.Model)
@@ -62,8 +110,52 @@ func main() {
 		cfg.Model = *modelFlag
 	}
 
-	// Create OpenAI client
-	client := openai.NewClient(cfg.OpenAIAPIKey, cfg.Model)
+	for step, model := range stepModelFlags {
+		if *model == "" {
+			continue
+		}
+		if err := cfg.SetStepModel(step, *model); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --model-%s: %v\n", step, err)
+			os.Exit(1)
+		}
+	}
+
+	// Load the model limits and tokenizers
+	registry := models.NewRegistry()
+	if cfg.ModelsPath != "" {
+		if err := registry.LoadFile(cfg.ModelsPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading models: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Record the LLM exchanges to a cassette, or replay them from one
+	var httpClient llm.HTTPClient
+	switch {
+	case cfg.RecordPath != "":
+		logger.Info("Recording LLM requests to %s", cfg.RecordPath)
+		httpClient = cassette.NewRecorder(&http.Client{}, cfg.RecordPath)
+	case cfg.ReplayPath != "":
+		recording, err := cassette.Load(cfg.ReplayPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading cassette: %v\n", err)
+			os.Exit(1)
+		}
+		logger.Info("Replaying %d LLM responses from %s", len(recording.Interactions), cfg.ReplayPath)
+		httpClient = cassette.NewPlayer(recording)
+	}
+
+	// Create the LLM provider
+	client, err := newProvider(cfg, registry, httpClient)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating LLM provider: %v\n", err)
+		os.Exit(1)
+	}
+	stepClients, err := newStepProviders(cfg, registry, httpClient)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating LLM provider: %v\n", err)
+		os.Exit(1)
+	}
 
 	// Model info already logged during initialization
 
@@ -87,7 +179,81 @@ func main() {
 			os.Exit(1)
 		}
 
-		handleReview(client, *ticketFlag, *repoFlag, *branchFlag, *designDocFlag)
+		if *concurrencyFlag < 0 {
+			fmt.Fprintf(os.Stderr, "Error: --concurrency must not be negative\n")
+			flag.Usage()
+			os.Exit(1)
+		}
+
+		if *fromStepFlag != "" && *onlyStepFlag != "" {
+			fmt.Fprintf(os.Stderr, "Error: --from-step and --only-step cannot be used together\n")
+			flag.Usage()
+			os.Exit(1)
+		}
+
+		if (*githubFlag || *postFlag || *dryRunFlag) && *prFlag <= 0 && *branchFlag == "" {
+			fmt.Fprintf(os.Stderr, "Error: --pr or --branch is required to read the pull request from GitHub or post the review\n")
+			flag.Usage()
+			os.Exit(1)
+		}
+
+		// Reuse the responses to requests sent before, unless asked not to. A recording must capture
+		// every request and a replay must answer them all, so neither uses the cache.
+		if !*noCacheFlag && httpClient == nil {
+			store := cache.NewStore(cfg.CacheDir, cfg.CacheTTL)
+			client = cache.Wrap(client, store)
+			for step, stepClient := range stepClients {
+				stepClients[step] = cache.Wrap(stepClient, store)
+			}
+		}
+
+		opts := reviewOptions{
+			ticket:    *ticketFlag,
+			repo:      *repoFlag,
+			branch:    *branchFlag,
+			designDoc: *designDocFlag,
+			baseRef:   cfg.BaseRef,
+			headRef:   cfg.HeadRef,
+			languages: cfg.LanguageProfilesPath,
+			resume:    *resumeFlag,
+			fromStep:  *fromStepFlag,
+			onlyStep:  *onlyStepFlag,
+			pipeline:  cfg.PipelinePath,
+			pricing:   cfg.PricingPath,
+			models:    stepClients,
+			registry:  registry,
+
+			concurrency:       cfg.Concurrency,
+			requestsPerMinute: cfg.RequestsPerMinute,
+			tokensPerMinute:   cfg.TokensPerMinute,
+		}
+		if *pipelineFlag != "" {
+			opts.pipeline = *pipelineFlag
+		}
+		if *concurrencyFlag > 0 {
+			opts.concurrency = *concurrencyFlag
+		}
+		opts.github = githubOptions{
+			pr:         *prFlag,
+			fromGitHub: *githubFlag,
+			post:       *postFlag || *dryRunFlag,
+			dryRun:     *dryRunFlag,
+			baseURL:    cfg.GitHubURL,
+			token:      cfg.GitHubToken,
+		}
+		if *githubURLFlag != "" {
+			opts.github.baseURL = *githubURLFlag
+		}
+
+		// Flags override the configured refs
+		if *baseFlag != "" {
+			opts.baseRef = *baseFlag
+		}
+		if *headFlag != "" {
+			opts.headRef = *headFlag
+		}
+
+		handleReview(client, opts)
 		return
 	}
 
@@ -95,14 +261,16 @@ func main() {
 	if flag.NArg() > 0 {
 		// Join all non-flag arguments as the prompt
 		prompt := strings.Join(flag.Args(), " ")
-		handlePrompt(client, prompt)
+		handlePrompt(client, nil, prompt)
 		return
 	}
 
 	// Interactive mode
 	logger.Info("Agent Runner v0.1.0")
-	logger.Info("Type your prompt and press Enter. Type 'exit' to quit.")
+	logger.Info("Type your prompt and press Enter. Type 'reset' to start a new conversation or 'exit' to quit.")
 
+	// Keep the conversation so follow-up prompts have the earlier turns as context
+	var history []llm.Message
 	scanner := bufio.NewScanner(os.Stdin)
 	for {
 		fmt.Print("> ")
@@ -114,8 +282,13 @@ func main() {
 		if input == "exit" {
 			break
 		}
+		if input == "reset" {
+			history = nil
+			logger.Info("Started a new conversation")
+			continue
+		}
 
-		handlePrompt(client, input)
+		history = handlePrompt(client, history, input)
 	}
 
 	if err := scanner.Err(); err != nil {
@@ -123,52 +296,157 @@ func main() {
 	}
 }
 
-func handlePrompt(client *openai.Client, prompt string) {
+// handlePrompt sends a prompt after the earlier turns of the conversation and returns the conversation
+// including the reply. The history is returned unchanged if the prompt fails, so it can be retried.
+func handlePrompt(client llm.Provider, history []llm.Message, prompt string) []llm.Message {
+	messages := append(history[:len(history):len(history)], llm.Message{Role: llm.RoleUser, Content: prompt})
+
 	// Count tokens in the prompt first
 	tokenCount, err := client.CountText(prompt)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error counting tokens: %v\n", err)
-		return
+		return history
 	}
 
-	logger.Verbose("Sending prompt to OpenAI (%d tokens)", tokenCount)
+	logger.Verbose("Sending prompt to %s (%d tokens)", client.Model(), tokenCount)
 
-	response, err := client.Complete(context.Background(), prompt)
+	// Print the response as it streams in
+	fmt.Println("\nResponse:")
+	response, err := client.Chat(context.Background(), messages, llm.ChatOptions{
+		OnDelta: func(delta string) { fmt.Print(delta) },
+	})
+	fmt.Println()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		return
+		return history
 	}
 
-	fmt.Println("\nResponse:")
-	fmt.Println(response)
+	return append(messages, llm.Message{Role: llm.RoleAssistant, Content: response})
+}
+
+// reviewOptions holds the command-line settings for a PR review
+type reviewOptions struct {
+	ticket    string
+	repo      string
+	branch    string
+	designDoc string
+	baseRef   string
+	headRef   string
+	languages string
+	resume    bool
+	fromStep  string
+	onlyStep  string
+	pipeline  string
+	pricing   string
+
+	// models are the providers of the step groups that use their own model
+	models map[string]llm.Provider
+
+	// registry gives the review's token counter the tokenizer of each model
+	registry *models.Registry
+
+	// concurrency and the per-minute budgets pace the per-file analysis (0 keeps the defaults)
+	concurrency       int
+	requestsPerMinute int
+	tokensPerMinute   int
+
+	// github reads the pull request from GitHub or posts the review to it
+	github githubOptions
 }
 
 // handleReview runs the PR review workflow
-func handleReview(client *openai.Client, ticket string, repo string, branch string, designDoc string) {
-	logger.Info("Starting PR review for ticket %s", ticket)
+func handleReview(client llm.Provider, opts reviewOptions) {
+	logger.Info("Starting PR review for ticket %s", opts.ticket)
 
 	// Create review context
-	ctx := review.NewReviewContext(ticket, client)
+	ctx := review.NewReviewContext(opts.ticket, client)
+	ctx.TokenCounter = tokens.NewCounterWithRegistry(opts.registry)
 
 	// Set repository directory and branch if provided
-	if repo != "" {
+	if opts.repo != "" {
 		// Extract repo name from full path (e.g., "BambooHR/payroll-gateway" -> "payroll-gateway")
-		repoName := repo
-		if idx := strings.LastIndex(repo, "/"); idx != -1 {
-			repoName = repo[idx+1:]
+		repoName := opts.repo
+		if idx := strings.LastIndex(opts.repo, "/"); idx != -1 {
+			repoName = opts.repo[idx+1:]
+		}
+		ctx.RepoName = repoName
+		if opts.github.fromGitHub {
+			ctx.GitHub = opts.github.client()
+			ctx.GitHubRepo = opts.repo
+			ctx.PullRequestNumber = opts.github.pr
+			logger.Info("Reading the pull request from GitHub repository %s", opts.repo)
+		} else {
+			ctx.RepoDir = filepath.Join(".context", "projects", repoName)
+			logger.Info("Using repository at %s", ctx.RepoDir)
 		}
-		ctx.RepoDir = filepath.Join(".context", "projects", repoName)
-		logger.Info("Using repository at %s", ctx.RepoDir)
 	}
 
-	if branch != "" {
-		ctx.Branch = branch
+	if opts.branch != "" {
+		ctx.Branch = opts.branch
 		logger.Info("Using PR branch %s", ctx.Branch)
 	}
 
-	if designDoc != "" {
-		ctx.DesignDocPath = designDoc
-		logger.Info("Using design document %s", designDoc)
+	if opts.baseRef != "" {
+		ctx.BaseRef = opts.baseRef
+		logger.Info("Using base %s", ctx.BaseRef)
+	}
+
+	if opts.headRef != "" {
+		ctx.HeadRef = opts.headRef
+		logger.Info("Using head %s", ctx.HeadRef)
+	}
+
+	if opts.designDoc != "" {
+		ctx.DesignDocPath = opts.designDoc
+		logger.Info("Using design document %s", opts.designDoc)
+	}
+
+	if opts.languages != "" {
+		if err := ctx.Languages.LoadFile(opts.languages); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading language profiles: %v\n", err)
+			os.Exit(1)
+		}
+		logger.Info("Using language profiles from %s", opts.languages)
+	}
+
+	if opts.pipeline != "" {
+		pipeline, err := review.LoadPipelineConfig(opts.pipeline)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading pipeline: %v\n", err)
+			os.Exit(1)
+		}
+		ctx.Pipeline = pipeline
+		logger.Info("Using pipeline from %s", opts.pipeline)
+	}
+
+	if opts.pricing != "" {
+		if err := ctx.Pricing.LoadFile(opts.pricing); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading pricing: %v\n", err)
+			os.Exit(1)
+		}
+		logger.Info("Using pricing from %s", opts.pricing)
+	}
+
+	for step, client := range opts.models {
+		if err := ctx.SetGroupClient(step, client); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		logger.Info("Using %s for the %s steps", client.Model(), step)
+	}
+
+	if opts.concurrency > 0 {
+		ctx.Concurrency = opts.concurrency
+	}
+	ctx.RequestsPerMinute = opts.requestsPerMinute
+	ctx.TokensPerMinute = opts.tokensPerMinute
+	logger.Verbose("Analyzing up to %d files at a time", ctx.Concurrency)
+
+	ctx.Resume = opts.resume
+	ctx.FromStep = opts.fromStep
+	ctx.OnlyStep = opts.onlyStep
+	if opts.resume {
+		logger.Info("Resuming from %s", review.ManifestPath(ctx.OutputDir, ctx.Ticket))
 	}
 
 	// Create workflow
@@ -182,4 +460,11 @@ func handleReview(client *openai.Client, ticket string, repo string, branch stri
 	}
 
 	logger.Success("PR review completed successfully")
+
+	if opts.github.post {
+		if err := postReview(workflow, opts.repo, opts.branch, opts.github); err != nil {
+			fmt.Fprintf(os.Stderr, "Error posting review: %v\n", err)
+			os.Exit(1)
+		}
+	}
 }
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/jeremyhunt/agent-runner/anthropic"
+	"github.com/jeremyhunt/agent-runner/config"
+	"github.com/jeremyhunt/agent-runner/llm"
+	"github.com/jeremyhunt/agent-runner/models"
+	"github.com/jeremyhunt/agent-runner/openai"
+)
+
+// newProvider creates the LLM provider selected by the configuration. Requests are sent with httpClient,
+// or the default HTTP client when it is nil.
+func newProvider(cfg *config.Config, registry *models.Registry, httpClient llm.HTTPClient) (llm.Provider, error) {
+	return newModelProvider(cfg, registry, httpClient, cfg.Provider, cfg.Model, cfg.ContextWindow)
+}
+
+// newStepProviders creates the providers of the step groups configured to use their own model
+func newStepProviders(cfg *config.Config, registry *models.Registry, httpClient llm.HTTPClient) (map[string]llm.Provider, error) {
+	providers := make(map[string]llm.Provider, len(cfg.StepModels))
+	for step, spec := range cfg.StepModels {
+		provider, model := cfg.ParseModel(spec)
+
+		// The configured context window only describes the default model
+		contextWindow := 0
+		if provider == cfg.Provider && model == cfg.Model {
+			contextWindow = cfg.ContextWindow
+		}
+
+		client, err := newModelProvider(cfg, registry, httpClient, provider, model, contextWindow)
+		if err != nil {
+			return nil, fmt.Errorf("%s steps: %w", step, err)
+		}
+		providers[step] = client
+	}
+	return providers, nil
+}
+
+// newModelProvider creates a provider for a model, using the configured credentials and retry policy
+// and the model limits in the registry
+func newModelProvider(cfg *config.Config, registry *models.Registry, httpClient llm.HTTPClient, provider, model string,
+	contextWindow int) (llm.Provider, error) {
+	policy := llm.DefaultRetryPolicy
+	if cfg.MaxAttempts > 0 {
+		policy.MaxAttempts = cfg.MaxAttempts
+	}
+
+	switch provider {
+	case config.ProviderOpenAI, config.ProviderAzure, config.ProviderCompatible:
+		var client *openai.Client
+		switch provider {
+		case config.ProviderAzure:
+			client = openai.NewAzureClient(cfg.AzureEndpoint, cfg.AzureAPIKey, model, cfg.AzureAPIVersion)
+		case config.ProviderCompatible:
+			client = openai.NewCompatibleClient(cfg.BaseURL, cfg.APIKey, model)
+		default:
+			client = openai.NewClient(cfg.OpenAIAPIKey, model)
+		}
+		client = client.WithRetryPolicy(policy).WithModels(registry)
+		if contextWindow > 0 {
+			client = client.WithContextWindow(contextWindow)
+		}
+		if httpClient != nil {
+			client = client.WithHTTPClient(httpClient)
+		}
+		return client, nil
+	case config.ProviderAnthropic:
+		client := anthropic.NewClient(cfg.AnthropicAPIKey, model).WithRetryPolicy(policy).WithModels(registry)
+		if contextWindow > 0 {
+			client = client.WithContextWindow(contextWindow)
+		}
+		if httpClient != nil {
+			client = client.WithHTTPClient(httpClient)
+		}
+		return client, nil
+	}
+	return nil, fmt.Errorf("unknown LLM provider %q", provider)
+}
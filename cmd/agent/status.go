@@ -56,9 +56,8 @@ func handleStatus() {
 	}
 	logger.Success("Config: Successfully loaded")
 
-	// Check OpenAI API
-	logger.Success("OpenAI API: API key is set")
-	logger.Debug("API key starts with: %s", cfg.OpenAIAPIKey[:10]+"...")
+	// The provider's settings are checked when the config is loaded
+	logger.Success("LLM provider: %s using %s", cfg.Provider, cfg.Model)
 
 	// Check Jira status
 	logger.Info("Checking Jira API...")
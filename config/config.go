@@ -2,40 +2,189 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/jeremyhunt/agent-runner/logger"
 	"github.com/joho/godotenv"
 )
 
+// LLM providers
+const (
+	ProviderOpenAI     = "openai"
+	ProviderAzure      = "azure"
+	ProviderAnthropic  = "anthropic"
+	ProviderCompatible = "openai-compatible"
+)
+
+// ModelSteps are the groups of review steps whose model can be chosen on its own
+var ModelSteps = []string{"discovery", "analysis", "synthesis", "review", "validation", "summary"}
+
+// DefaultCacheTTL is how long a cached LLM response is reused
+const DefaultCacheTTL = 7 * 24 * time.Hour
+
+// DefaultAzureAPIVersion is the Azure OpenAI api-version used unless configured otherwise
+const DefaultAzureAPIVersion = "2024-10-21"
+
 // Config holds the application configuration
 type Config struct {
+	// Provider selects the LLM backend: openai, azure, anthropic or openai-compatible
+	Provider string
+	// Model is the model requests are sent to (the deployment name for Azure OpenAI)
+	Model string
+	// MaxAttempts is the number of times a failed LLM request is attempted (0 uses the default)
+	MaxAttempts int
+	// ContextWindow overrides the provider's assumed context window in tokens (0 uses the provider default)
+	ContextWindow int
+	// StepModels maps groups of review steps from ModelSteps to the model they use instead of Model.
+	// A model can be prefixed with another provider, as in "anthropic:claude-opus-4-1".
+	StepModels map[string]string
+
+	// OpenAIAPIKey is the OpenAI API key
 	OpenAIAPIKey string
-	Model        string
+
+	// Azure OpenAI settings
+	AzureEndpoint   string
+	AzureAPIKey     string
+	AzureAPIVersion string
+
+	// AnthropicAPIKey is the Anthropic API key
+	AnthropicAPIKey string
+
+	// OpenAI-compatible server settings, such as Ollama or vLLM
+	BaseURL string
+	APIKey  string
 
 	// Jira settings
 	JiraURL   string
 	JiraEmail string
 	JiraToken string
 
+	// GitHub settings
+	// GitHubToken authenticates the requests that post reviews on pull requests
+	GitHubToken string
+	// GitHubURL is the GitHub API, such as https://HOST/api/v3 for GitHub Enterprise Server (empty for github.com)
+	GitHubURL string
+
+	// Review settings
+	// BaseRef is the branch or commit the PR is compared against (defaults to main/master)
+	BaseRef string
+	// HeadRef is the branch or commit under review (defaults to the PR branch)
+	HeadRef string
+	// LanguageProfilesPath is an optional JSON file of language profiles that extend or override the built-ins
+	LanguageProfilesPath string
+	// PipelinePath is an optional YAML or JSON file listing the review pipeline steps
+	PipelinePath string
+	// PricingPath is an optional JSON file of per-model rates that extend or override the built-ins
+	PricingPath string
+	// ModelsPath is an optional JSON file of model context windows, output limits and tokenizers
+	// that extend or override the built-ins
+	ModelsPath string
+	// CacheDir is the directory LLM responses are cached in
+	CacheDir string
+	// CacheTTL is how long a cached response is reused (0 reuses it until the cache is cleared)
+	CacheTTL time.Duration
+	// RecordPath is an optional cassette file the LLM requests and responses are recorded to
+	RecordPath string
+	// ReplayPath is an optional cassette file the LLM responses are replayed from instead of sending requests
+	ReplayPath string
+	// Concurrency is the number of files analyzed at the same time (0 uses the default)
+	Concurrency int
+	// RequestsPerMinute limits the per-file analysis requests sent each minute (0 for no limit)
+	RequestsPerMinute int
+	// TokensPerMinute limits the estimated tokens sent for per-file analysis each minute (0 for no limit)
+	TokensPerMinute int
+
 	// Logging settings
 	Verbosity logger.VerbosityLevel
+
+	// skipCredentials leaves out the checks for the providers' API keys
+	skipCredentials bool
+}
+
+// LoadOptions change what Load requires
+type LoadOptions struct {
+	// SkipCredentials doesn't require the LLM providers' API keys, for commands that send no LLM
+	// requests such as managing the response cache
+	SkipCredentials bool
+
+	// RecordPath and ReplayPath replace the cassette set in the environment, as given on the command line
+	RecordPath string
+	ReplayPath string
 }
 
 // Load loads the configuration from environment variables
 func Load() (*Config, error) {
+	return LoadWithOptions(LoadOptions{})
+}
+
+// LoadWithOptions loads the configuration from environment variables with the given options
+func LoadWithOptions(opts LoadOptions) (*Config, error) {
 	// Load .env file if it exists
 	_ = godotenv.Load()
 
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" {
-		return nil, errors.New("OPENAI_API_KEY environment variable is not set")
+	// Get the cassette to record to or replay from (optional); one given in the options replaces the environment's
+	recordPath := os.Getenv("LLM_RECORD_FILE")
+	replayPath := os.Getenv("LLM_REPLAY_FILE")
+	if opts.RecordPath != "" {
+		recordPath, replayPath = opts.RecordPath, ""
+	}
+	if opts.ReplayPath != "" {
+		recordPath, replayPath = "", opts.ReplayPath
+	}
+	if recordPath != "" && replayPath != "" {
+		return nil, errors.New("LLM_RECORD_FILE and LLM_REPLAY_FILE cannot both be set")
+	}
+
+	cfg := &Config{
+		// A replay sends no request, so the providers' API keys aren't needed
+		skipCredentials: opts.SkipCredentials || replayPath != "",
+		Provider:        os.Getenv("LLM_PROVIDER"),
+		OpenAIAPIKey:    os.Getenv("OPENAI_API_KEY"),
+		AzureEndpoint:   os.Getenv("AZURE_OPENAI_ENDPOINT"),
+		AzureAPIKey:     os.Getenv("AZURE_OPENAI_API_KEY"),
+		AzureAPIVersion: os.Getenv("AZURE_OPENAI_API_VERSION"),
+		AnthropicAPIKey: os.Getenv("ANTHROPIC_API_KEY"),
+		BaseURL:         os.Getenv("LLM_BASE_URL"),
+		APIKey:          os.Getenv("LLM_API_KEY"),
+	}
+	if cfg.Provider == "" {
+		cfg.Provider = ProviderOpenAI
 	}
 
-	// Get model from env or use default
-	model := os.Getenv("OPENAI_MODEL")
-	if model == "" {
-		model = "gpt-4o" // Default model
+	// Get model from env, falling back to the provider's default
+	cfg.Model = os.Getenv("LLM_MODEL")
+	if cfg.Model == "" {
+		cfg.Model = os.Getenv("OPENAI_MODEL")
+	}
+
+	// Check the selected provider's settings
+	if err := cfg.loadProvider(); err != nil {
+		return nil, err
+	}
+
+	// Get the per-step models (optional)
+	for _, step := range ModelSteps {
+		name := "LLM_MODEL_" + strings.ToUpper(step)
+		if spec := os.Getenv(name); spec != "" {
+			if err := cfg.SetStepModel(step, spec); err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+
+	// Get the retry attempts and context window (optional)
+	maxAttempts, err := intEnv("OPENAI_MAX_ATTEMPTS")
+	if err != nil {
+		return nil, err
+	}
+	contextWindow, err := intEnv("LLM_CONTEXT_WINDOW")
+	if err != nil {
+		return nil, err
 	}
 
 	// Get Jira settings (optional)
@@ -43,20 +192,201 @@ func Load() (*Config, error) {
 	jiraEmail := os.Getenv("JIRA_EMAIL")
 	jiraToken := os.Getenv("JIRA_API_TOKEN")
 
+	// Get GitHub settings (optional)
+	githubToken := os.Getenv("GITHUB_TOKEN")
+	githubURL := os.Getenv("GITHUB_API_URL")
+
+	// Get review refs (optional)
+	baseRef := os.Getenv("REVIEW_BASE_REF")
+	headRef := os.Getenv("REVIEW_HEAD_REF")
+
+	// Get extra language profiles (optional)
+	languageProfilesPath := os.Getenv("LANGUAGE_PROFILES_FILE")
+
+	// Get the pipeline definition (optional)
+	pipelinePath := os.Getenv("PIPELINE_FILE")
+
+	// Get the model rates used to estimate costs (optional)
+	pricingPath := os.Getenv("PRICING_FILE")
+
+	// Get the model capabilities (optional)
+	modelsPath := os.Getenv("MODELS_FILE")
+
+	// Get the response cache settings (optional)
+	cacheDir := os.Getenv("LLM_CACHE_DIR")
+	if cacheDir == "" {
+		cacheDir = filepath.Join(".context", "cache")
+	}
+	cacheTTL := DefaultCacheTTL
+	if value := os.Getenv("LLM_CACHE_TTL"); value != "" {
+		cacheTTL, err = time.ParseDuration(value)
+		if err != nil || cacheTTL < 0 {
+			return nil, fmt.Errorf("invalid LLM_CACHE_TTL %q (expected a duration such as 24h, or 0 to never expire)", value)
+		}
+	}
+
+	// Get the analysis concurrency and rate limits (optional)
+	concurrency, err := intEnv("ANALYSIS_CONCURRENCY")
+	if err != nil {
+		return nil, err
+	}
+	requestsPerMinute, err := intEnv("OPENAI_REQUESTS_PER_MINUTE")
+	if err != nil {
+		return nil, err
+	}
+	tokensPerMinute, err := intEnv("OPENAI_TOKENS_PER_MINUTE")
+	if err != nil {
+		return nil, err
+	}
+
 	// Default to normal verbosity
 	verbosity := logger.VerbosityNormal
 
-	return &Config{
-		OpenAIAPIKey: apiKey,
-		Model:        model,
-		JiraURL:      jiraURL,
-		JiraEmail:    jiraEmail,
-		JiraToken:    jiraToken,
-		Verbosity:    verbosity,
-	}, nil
+	cfg.MaxAttempts = maxAttempts
+	cfg.ContextWindow = contextWindow
+	cfg.JiraURL = jiraURL
+	cfg.JiraEmail = jiraEmail
+	cfg.JiraToken = jiraToken
+	cfg.GitHubToken = githubToken
+	cfg.GitHubURL = githubURL
+	cfg.BaseRef = baseRef
+	cfg.HeadRef = headRef
+	cfg.LanguageProfilesPath = languageProfilesPath
+	cfg.PipelinePath = pipelinePath
+	cfg.PricingPath = pricingPath
+	cfg.ModelsPath = modelsPath
+	cfg.CacheDir = cacheDir
+	cfg.CacheTTL = cacheTTL
+	cfg.RecordPath = recordPath
+	cfg.ReplayPath = replayPath
+	cfg.Concurrency = concurrency
+	cfg.RequestsPerMinute = requestsPerMinute
+	cfg.TokensPerMinute = tokensPerMinute
+	cfg.Verbosity = verbosity
+	return cfg, nil
+}
+
+// loadProvider checks the settings the selected provider needs and fills in its default model
+func (c *Config) loadProvider() error {
+	if err := c.checkCredentials(c.Provider); err != nil {
+		return err
+	}
+
+	switch c.Provider {
+	case ProviderOpenAI:
+		if c.Model == "" {
+			c.Model = "gpt-4o" // Default model
+		}
+	case ProviderAzure:
+		// The model is the deployment
+		if deployment := os.Getenv("AZURE_OPENAI_DEPLOYMENT"); deployment != "" {
+			c.Model = deployment
+		}
+		if c.Model == "" {
+			return errors.New("AZURE_OPENAI_DEPLOYMENT environment variable is not set")
+		}
+	case ProviderAnthropic:
+		if c.Model == "" {
+			c.Model = "claude-sonnet-4-5"
+		}
+	case ProviderCompatible:
+		if c.Model == "" {
+			return errors.New("LLM_MODEL environment variable is not set")
+		}
+	}
+	return nil
+}
+
+// checkCredentials reports a setting a provider needs that is missing, or only that the provider is
+// unknown when credentials aren't required
+func (c *Config) checkCredentials(provider string) error {
+	if provider == ProviderAzure && c.AzureAPIVersion == "" {
+		c.AzureAPIVersion = DefaultAzureAPIVersion
+	}
+	if c.skipCredentials {
+		switch provider {
+		case ProviderOpenAI, ProviderAzure, ProviderAnthropic, ProviderCompatible:
+			return nil
+		}
+	}
+
+	switch provider {
+	case ProviderOpenAI:
+		if c.OpenAIAPIKey == "" {
+			return errors.New("OPENAI_API_KEY environment variable is not set")
+		}
+	case ProviderAzure:
+		if c.AzureEndpoint == "" || c.AzureAPIKey == "" {
+			return errors.New("AZURE_OPENAI_ENDPOINT and AZURE_OPENAI_API_KEY environment variables must be set for Azure OpenAI")
+		}
+	case ProviderAnthropic:
+		if c.AnthropicAPIKey == "" {
+			return errors.New("ANTHROPIC_API_KEY environment variable is not set")
+		}
+	case ProviderCompatible:
+		if c.BaseURL == "" {
+			return errors.New("LLM_BASE_URL environment variable is not set (e.g. http://localhost:11434/v1 for Ollama)")
+		}
+	default:
+		return fmt.Errorf("unknown LLM_PROVIDER %q (expected %s, %s, %s or %s)",
+			provider, ProviderOpenAI, ProviderAzure, ProviderAnthropic, ProviderCompatible)
+	}
+	return nil
+}
+
+// SetStepModel sets the model of a group of steps from ModelSteps, checking that the credentials
+// of its provider are configured
+func (c *Config) SetStepModel(step, spec string) error {
+	known := false
+	for _, name := range ModelSteps {
+		known = known || name == step
+	}
+	if !known {
+		return fmt.Errorf("unknown model step %q (expected one of %s)", step, strings.Join(ModelSteps, ", "))
+	}
+
+	provider, model := c.ParseModel(spec)
+	if model == "" {
+		return fmt.Errorf("no model given for the %s steps", step)
+	}
+	if err := c.checkCredentials(provider); err != nil {
+		return err
+	}
+
+	if c.StepModels == nil {
+		c.StepModels = make(map[string]string)
+	}
+	c.StepModels[step] = spec
+	return nil
+}
+
+// ParseModel splits a model that may be prefixed with a provider, as in "anthropic:claude-opus-4-1".
+// Without a known provider prefix the whole value is a model of the configured provider, so model
+// names containing a colon such as "llama3.1:8b" are kept intact.
+func (c *Config) ParseModel(spec string) (provider, model string) {
+	if prefix, rest, found := strings.Cut(spec, ":"); found {
+		switch prefix {
+		case ProviderOpenAI, ProviderAzure, ProviderAnthropic, ProviderCompatible:
+			return prefix, rest
+		}
+	}
+	return c.Provider, spec
 }
 
 // HasJiraCredentials checks if all required Jira credentials are available
 func (c *Config) HasJiraCredentials() bool {
 	return c.JiraURL != "" && c.JiraEmail != "" && c.JiraToken != ""
 }
+
+// intEnv reads a non-negative integer environment variable, returning 0 when it is not set
+func intEnv(name string) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer, got %q", name, value)
+	}
+	return n, nil
+}
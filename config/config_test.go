@@ -1,7 +1,9 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"reflect"
 	"testing"
 )
 
@@ -12,6 +14,17 @@ func TestLoad(t *testing.T) {
 	originalJiraURL := os.Getenv("JIRA_URL")
 	originalJiraEmail := os.Getenv("JIRA_EMAIL")
 	originalJiraToken := os.Getenv("JIRA_API_TOKEN")
+	originalBaseRef := os.Getenv("REVIEW_BASE_REF")
+	originalHeadRef := os.Getenv("REVIEW_HEAD_REF")
+	originalLanguageProfiles := os.Getenv("LANGUAGE_PROFILES_FILE")
+	originalPipeline := os.Getenv("PIPELINE_FILE")
+	originalConcurrency := os.Getenv("ANALYSIS_CONCURRENCY")
+	originalMaxAttempts := os.Getenv("OPENAI_MAX_ATTEMPTS")
+	originalRequestsPerMinute := os.Getenv("OPENAI_REQUESTS_PER_MINUTE")
+	originalTokensPerMinute := os.Getenv("OPENAI_TOKENS_PER_MINUTE")
+	originalCacheTTL := os.Getenv("LLM_CACHE_TTL")
+	originalRecordFile := os.Getenv("LLM_RECORD_FILE")
+	originalReplayFile := os.Getenv("LLM_REPLAY_FILE")
 
 	// Restore environment variables after test
 	defer func() {
@@ -20,6 +33,17 @@ func TestLoad(t *testing.T) {
 		os.Setenv("JIRA_URL", originalJiraURL)
 		os.Setenv("JIRA_EMAIL", originalJiraEmail)
 		os.Setenv("JIRA_API_TOKEN", originalJiraToken)
+		os.Setenv("REVIEW_BASE_REF", originalBaseRef)
+		os.Setenv("REVIEW_HEAD_REF", originalHeadRef)
+		os.Setenv("LANGUAGE_PROFILES_FILE", originalLanguageProfiles)
+		os.Setenv("PIPELINE_FILE", originalPipeline)
+		os.Setenv("ANALYSIS_CONCURRENCY", originalConcurrency)
+		os.Setenv("OPENAI_MAX_ATTEMPTS", originalMaxAttempts)
+		os.Setenv("OPENAI_REQUESTS_PER_MINUTE", originalRequestsPerMinute)
+		os.Setenv("OPENAI_TOKENS_PER_MINUTE", originalTokensPerMinute)
+		os.Setenv("LLM_CACHE_TTL", originalCacheTTL)
+		os.Setenv("LLM_RECORD_FILE", originalRecordFile)
+		os.Setenv("LLM_REPLAY_FILE", originalReplayFile)
 	}()
 
 	// Test cases
@@ -49,6 +73,93 @@ func TestLoad(t *testing.T) {
 			expectError:   true,
 			errorContains: "OPENAI_API_KEY environment variable is not set",
 		},
+		{
+			name: "Review refs set",
+			envVars: map[string]string{
+				"OPENAI_API_KEY":  "test-key",
+				"REVIEW_BASE_REF": "develop",
+				"REVIEW_HEAD_REF": "abc1234",
+			},
+			expectError: false,
+		},
+		{
+			name: "Language profiles file set",
+			envVars: map[string]string{
+				"OPENAI_API_KEY":         "test-key",
+				"LANGUAGE_PROFILES_FILE": "config/languages.json",
+			},
+			expectError: false,
+		},
+		{
+			name: "Pipeline file set",
+			envVars: map[string]string{
+				"OPENAI_API_KEY": "test-key",
+				"PIPELINE_FILE":  "config/pipeline.json",
+			},
+			expectError: false,
+		},
+		{
+			name: "Concurrency and rate limits set",
+			envVars: map[string]string{
+				"OPENAI_API_KEY":             "test-key",
+				"ANALYSIS_CONCURRENCY":       "8",
+				"OPENAI_REQUESTS_PER_MINUTE": "500",
+				"OPENAI_TOKENS_PER_MINUTE":   "30000",
+			},
+			expectError: false,
+		},
+		{
+			name: "Max attempts set",
+			envVars: map[string]string{
+				"OPENAI_API_KEY":      "test-key",
+				"OPENAI_MAX_ATTEMPTS": "6",
+			},
+			expectError: false,
+		},
+		{
+			name: "Invalid rate limit",
+			envVars: map[string]string{
+				"OPENAI_API_KEY":           "test-key",
+				"OPENAI_TOKENS_PER_MINUTE": "lots",
+			},
+			expectError:   true,
+			errorContains: "OPENAI_TOKENS_PER_MINUTE must be a non-negative integer",
+		},
+		{
+			name: "Cache TTL set",
+			envVars: map[string]string{
+				"OPENAI_API_KEY": "test-key",
+				"LLM_CACHE_TTL":  "24h0m0s",
+			},
+			expectError: false,
+		},
+		{
+			name: "Invalid cache TTL",
+			envVars: map[string]string{
+				"OPENAI_API_KEY": "test-key",
+				"LLM_CACHE_TTL":  "-1h",
+			},
+			expectError:   true,
+			errorContains: "invalid LLM_CACHE_TTL",
+		},
+		{
+			name: "Replay file set",
+			envVars: map[string]string{
+				"OPENAI_API_KEY":  "test-key",
+				"LLM_REPLAY_FILE": "testdata/review.json",
+			},
+			expectError: false,
+		},
+		{
+			name: "Record and replay files both set",
+			envVars: map[string]string{
+				"OPENAI_API_KEY":  "test-key",
+				"LLM_RECORD_FILE": "review.json",
+				"LLM_REPLAY_FILE": "review.json",
+			},
+			expectError:   true,
+			errorContains: "cannot both be set",
+		},
 		{
 			name: "Default model when not specified",
 			envVars: map[string]string{
@@ -82,6 +193,39 @@ func TestLoad(t *testing.T) {
 			if _, exists := tt.envVars["JIRA_API_TOKEN"]; !exists {
 				os.Unsetenv("JIRA_API_TOKEN")
 			}
+			if _, exists := tt.envVars["REVIEW_BASE_REF"]; !exists {
+				os.Unsetenv("REVIEW_BASE_REF")
+			}
+			if _, exists := tt.envVars["REVIEW_HEAD_REF"]; !exists {
+				os.Unsetenv("REVIEW_HEAD_REF")
+			}
+			if _, exists := tt.envVars["LANGUAGE_PROFILES_FILE"]; !exists {
+				os.Unsetenv("LANGUAGE_PROFILES_FILE")
+			}
+			if _, exists := tt.envVars["PIPELINE_FILE"]; !exists {
+				os.Unsetenv("PIPELINE_FILE")
+			}
+			if _, exists := tt.envVars["OPENAI_MAX_ATTEMPTS"]; !exists {
+				os.Unsetenv("OPENAI_MAX_ATTEMPTS")
+			}
+			if _, exists := tt.envVars["ANALYSIS_CONCURRENCY"]; !exists {
+				os.Unsetenv("ANALYSIS_CONCURRENCY")
+			}
+			if _, exists := tt.envVars["OPENAI_REQUESTS_PER_MINUTE"]; !exists {
+				os.Unsetenv("OPENAI_REQUESTS_PER_MINUTE")
+			}
+			if _, exists := tt.envVars["OPENAI_TOKENS_PER_MINUTE"]; !exists {
+				os.Unsetenv("OPENAI_TOKENS_PER_MINUTE")
+			}
+			if _, exists := tt.envVars["LLM_CACHE_TTL"]; !exists {
+				os.Unsetenv("LLM_CACHE_TTL")
+			}
+			if _, exists := tt.envVars["LLM_RECORD_FILE"]; !exists {
+				os.Unsetenv("LLM_RECORD_FILE")
+			}
+			if _, exists := tt.envVars["LLM_REPLAY_FILE"]; !exists {
+				os.Unsetenv("LLM_REPLAY_FILE")
+			}
 
 			// Load configuration
 			cfg, err := Load()
@@ -124,6 +268,36 @@ func TestLoad(t *testing.T) {
 			if tt.envVars["JIRA_API_TOKEN"] != "" && cfg.JiraToken != tt.envVars["JIRA_API_TOKEN"] {
 				t.Errorf("Expected JiraToken %q but got %q", tt.envVars["JIRA_API_TOKEN"], cfg.JiraToken)
 			}
+			if cfg.BaseRef != tt.envVars["REVIEW_BASE_REF"] {
+				t.Errorf("Expected BaseRef %q but got %q", tt.envVars["REVIEW_BASE_REF"], cfg.BaseRef)
+			}
+			if cfg.HeadRef != tt.envVars["REVIEW_HEAD_REF"] {
+				t.Errorf("Expected HeadRef %q but got %q", tt.envVars["REVIEW_HEAD_REF"], cfg.HeadRef)
+			}
+			if cfg.LanguageProfilesPath != tt.envVars["LANGUAGE_PROFILES_FILE"] {
+				t.Errorf("Expected LanguageProfilesPath %q but got %q", tt.envVars["LANGUAGE_PROFILES_FILE"], cfg.LanguageProfilesPath)
+			}
+			if cfg.PipelinePath != tt.envVars["PIPELINE_FILE"] {
+				t.Errorf("Expected PipelinePath %q but got %q", tt.envVars["PIPELINE_FILE"], cfg.PipelinePath)
+			}
+			if fmt.Sprint(cfg.MaxAttempts) != defaultString(tt.envVars["OPENAI_MAX_ATTEMPTS"], "0") {
+				t.Errorf("Expected MaxAttempts %q but got %d", tt.envVars["OPENAI_MAX_ATTEMPTS"], cfg.MaxAttempts)
+			}
+			if fmt.Sprint(cfg.Concurrency) != defaultString(tt.envVars["ANALYSIS_CONCURRENCY"], "0") {
+				t.Errorf("Expected Concurrency %q but got %d", tt.envVars["ANALYSIS_CONCURRENCY"], cfg.Concurrency)
+			}
+			if fmt.Sprint(cfg.RequestsPerMinute) != defaultString(tt.envVars["OPENAI_REQUESTS_PER_MINUTE"], "0") {
+				t.Errorf("Expected RequestsPerMinute %q but got %d", tt.envVars["OPENAI_REQUESTS_PER_MINUTE"], cfg.RequestsPerMinute)
+			}
+			if fmt.Sprint(cfg.TokensPerMinute) != defaultString(tt.envVars["OPENAI_TOKENS_PER_MINUTE"], "0") {
+				t.Errorf("Expected TokensPerMinute %q but got %d", tt.envVars["OPENAI_TOKENS_PER_MINUTE"], cfg.TokensPerMinute)
+			}
+			if cfg.ReplayPath != tt.envVars["LLM_REPLAY_FILE"] {
+				t.Errorf("Expected ReplayPath %q but got %q", tt.envVars["LLM_REPLAY_FILE"], cfg.ReplayPath)
+			}
+			if cfg.CacheTTL.String() != defaultString(tt.envVars["LLM_CACHE_TTL"], DefaultCacheTTL.String()) {
+				t.Errorf("Expected CacheTTL %q but got %s", tt.envVars["LLM_CACHE_TTL"], cfg.CacheTTL)
+			}
 
 			// Check default values
 			if tt.envVars["OPENAI_MODEL"] == "" && cfg.Model == "" {
@@ -198,3 +372,212 @@ func contains(s, substr string) bool {
 	}
 	return false
 }
+
+// defaultString returns value, or fallback when value is empty
+func defaultString(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+func TestLoadProvider(t *testing.T) {
+	names := []string{"LLM_PROVIDER", "LLM_MODEL", "OPENAI_MODEL", "OPENAI_API_KEY", "AZURE_OPENAI_ENDPOINT", "AZURE_OPENAI_API_KEY",
+		"AZURE_OPENAI_DEPLOYMENT", "AZURE_OPENAI_API_VERSION", "ANTHROPIC_API_KEY", "LLM_BASE_URL", "LLM_API_KEY", "LLM_CONTEXT_WINDOW"}
+	for _, name := range names {
+		if value, ok := os.LookupEnv(name); ok {
+			defer os.Setenv(name, value)
+		} else {
+			defer os.Unsetenv(name)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		envVars   map[string]string
+		wantModel string
+		wantErr   string
+	}{
+		{name: "OpenAI by default", envVars: map[string]string{"OPENAI_API_KEY": "key"}, wantModel: "gpt-4o"},
+		{name: "Azure deployment", envVars: map[string]string{"LLM_PROVIDER": "azure", "AZURE_OPENAI_ENDPOINT": "https://example.openai.azure.com",
+			"AZURE_OPENAI_API_KEY": "key", "AZURE_OPENAI_DEPLOYMENT": "review-gpt4o"}, wantModel: "review-gpt4o"},
+		{name: "Azure without deployment", envVars: map[string]string{"LLM_PROVIDER": "azure", "AZURE_OPENAI_ENDPOINT": "https://example.openai.azure.com",
+			"AZURE_OPENAI_API_KEY": "key"}, wantErr: "AZURE_OPENAI_DEPLOYMENT"},
+		{name: "Anthropic default model", envVars: map[string]string{"LLM_PROVIDER": "anthropic", "ANTHROPIC_API_KEY": "key"}, wantModel: "claude-sonnet-4-5"},
+		{name: "Anthropic without key", envVars: map[string]string{"LLM_PROVIDER": "anthropic"}, wantErr: "ANTHROPIC_API_KEY"},
+		{name: "Compatible server", envVars: map[string]string{"LLM_PROVIDER": "openai-compatible", "LLM_BASE_URL": "http://localhost:11434/v1",
+			"LLM_MODEL": "llama3.1", "LLM_CONTEXT_WINDOW": "32768"}, wantModel: "llama3.1"},
+		{name: "Compatible server without model", envVars: map[string]string{"LLM_PROVIDER": "openai-compatible", "LLM_BASE_URL": "http://localhost:11434/v1"},
+			wantErr: "LLM_MODEL"},
+		{name: "Unknown provider", envVars: map[string]string{"LLM_PROVIDER": "bard"}, wantErr: "unknown LLM_PROVIDER"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range names {
+				os.Unsetenv(name)
+			}
+			for key, value := range tt.envVars {
+				os.Setenv(key, value)
+			}
+
+			cfg, err := Load()
+			if tt.wantErr != "" {
+				if err == nil || !contains(err.Error(), tt.wantErr) {
+					t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if cfg.Model != tt.wantModel {
+				t.Errorf("Expected model %q, got %q", tt.wantModel, cfg.Model)
+			}
+			if cfg.Provider == ProviderAzure && cfg.AzureAPIVersion != DefaultAzureAPIVersion {
+				t.Errorf("Expected the default api-version, got %q", cfg.AzureAPIVersion)
+			}
+			if fmt.Sprint(cfg.ContextWindow) != defaultString(tt.envVars["LLM_CONTEXT_WINDOW"], "0") {
+				t.Errorf("Expected ContextWindow %q, got %d", tt.envVars["LLM_CONTEXT_WINDOW"], cfg.ContextWindow)
+			}
+		})
+	}
+}
+
+func TestStepModels(t *testing.T) {
+	names := []string{"LLM_PROVIDER", "LLM_MODEL", "OPENAI_MODEL", "OPENAI_API_KEY", "ANTHROPIC_API_KEY",
+		"LLM_MODEL_ANALYSIS", "LLM_MODEL_VALIDATION"}
+	for _, name := range names {
+		if value, ok := os.LookupEnv(name); ok {
+			defer os.Setenv(name, value)
+		} else {
+			defer os.Unsetenv(name)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		envVars map[string]string
+		want    map[string]string
+		wantErr string
+	}{
+		{name: "No step models", envVars: map[string]string{"OPENAI_API_KEY": "key"}, want: nil},
+		{name: "Same provider", envVars: map[string]string{"OPENAI_API_KEY": "key", "LLM_MODEL_ANALYSIS": "gpt-4o-mini"},
+			want: map[string]string{"analysis": "gpt-4o-mini"}},
+		{name: "Other provider", envVars: map[string]string{"OPENAI_API_KEY": "key", "ANTHROPIC_API_KEY": "key",
+			"LLM_MODEL_VALIDATION": "anthropic:claude-opus-4-1"}, want: map[string]string{"validation": "anthropic:claude-opus-4-1"}},
+		{name: "Other provider without key", envVars: map[string]string{"OPENAI_API_KEY": "key",
+			"LLM_MODEL_VALIDATION": "anthropic:claude-opus-4-1"}, wantErr: "LLM_MODEL_VALIDATION: ANTHROPIC_API_KEY"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range names {
+				os.Unsetenv(name)
+			}
+			for key, value := range tt.envVars {
+				os.Setenv(key, value)
+			}
+
+			cfg, err := Load()
+			if tt.wantErr != "" {
+				if err == nil || !contains(err.Error(), tt.wantErr) {
+					t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(cfg.StepModels, tt.want) {
+				t.Errorf("Expected step models %v, got %v", tt.want, cfg.StepModels)
+			}
+		})
+	}
+}
+
+func TestParseModel(t *testing.T) {
+	cfg := &Config{Provider: ProviderCompatible}
+	tests := []struct {
+		spec         string
+		wantProvider string
+		wantModel    string
+	}{
+		{"llama3.1", ProviderCompatible, "llama3.1"},
+		{"llama3.1:8b", ProviderCompatible, "llama3.1:8b"},
+		{"anthropic:claude-opus-4-1", ProviderAnthropic, "claude-opus-4-1"},
+		{"openai-compatible:qwen2.5:32b", ProviderCompatible, "qwen2.5:32b"},
+	}
+
+	for _, tt := range tests {
+		provider, model := cfg.ParseModel(tt.spec)
+		if provider != tt.wantProvider || model != tt.wantModel {
+			t.Errorf("ParseModel(%q) = %q, %q, expected %q, %q", tt.spec, provider, model, tt.wantProvider, tt.wantModel)
+		}
+	}
+
+	if err := cfg.SetStepModel("drafting", "gpt-4o"); err == nil {
+		t.Error("Expected an error for an unknown step")
+	}
+}
+
+func TestLoadWithoutCredentials(t *testing.T) {
+	names := []string{"LLM_PROVIDER", "LLM_MODEL", "OPENAI_MODEL", "OPENAI_API_KEY", "ANTHROPIC_API_KEY", "LLM_MODEL_VALIDATION"}
+	for _, name := range names {
+		if value, ok := os.LookupEnv(name); ok {
+			defer os.Setenv(name, value)
+		} else {
+			defer os.Unsetenv(name)
+		}
+		os.Unsetenv(name)
+	}
+	os.Setenv("LLM_MODEL_VALIDATION", "anthropic:claude-opus-4-1")
+
+	if _, err := Load(); err == nil || !contains(err.Error(), "OPENAI_API_KEY") {
+		t.Errorf("Expected the missing API key to be reported, got %v", err)
+	}
+
+	cfg, err := LoadWithOptions(LoadOptions{SkipCredentials: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.Model != "gpt-4o" || cfg.StepModels["validation"] != "anthropic:claude-opus-4-1" {
+		t.Errorf("Expected the models to be loaded, got %q and %v", cfg.Model, cfg.StepModels)
+	}
+
+	// An unknown provider is still an error
+	os.Setenv("LLM_PROVIDER", "bard")
+	if _, err := LoadWithOptions(LoadOptions{SkipCredentials: true}); err == nil {
+		t.Error("Expected an error for an unknown provider")
+	}
+}
+
+func TestLoadReplayWithoutCredentials(t *testing.T) {
+	names := []string{"LLM_PROVIDER", "LLM_MODEL", "OPENAI_MODEL", "OPENAI_API_KEY", "LLM_RECORD_FILE", "LLM_REPLAY_FILE"}
+	for _, name := range names {
+		if value, ok := os.LookupEnv(name); ok {
+			defer os.Setenv(name, value)
+		} else {
+			defer os.Unsetenv(name)
+		}
+		os.Unsetenv(name)
+	}
+
+	cfg, err := LoadWithOptions(LoadOptions{ReplayPath: "run.json"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.ReplayPath != "run.json" || cfg.RecordPath != "" {
+		t.Errorf("Expected to replay run.json, got record %q and replay %q", cfg.RecordPath, cfg.ReplayPath)
+	}
+
+	os.Setenv("LLM_REPLAY_FILE", "env.json")
+	if _, err := Load(); err != nil {
+		t.Errorf("Expected LLM_REPLAY_FILE not to need an API key, got %v", err)
+	}
+
+	// Recording sends the requests, so it still needs the key
+	if _, err := LoadWithOptions(LoadOptions{RecordPath: "run.json"}); err == nil || !contains(err.Error(), "OPENAI_API_KEY") {
+		t.Errorf("Expected the missing API key to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,367 @@
+// Package diff parses unified git diffs into files, hunks and line numbers.
+package diff
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// LineKind identifies whether a diff line was added, removed or left unchanged
+type LineKind int
+
+const (
+	// Context is an unchanged line shown for context
+	Context LineKind = iota
+	// Added is a line that only exists in the new file
+	Added
+	// Removed is a line that only exists in the old file
+	Removed
+)
+
+// Line is a single line within a hunk
+type Line struct {
+	Kind    LineKind
+	Content string
+	// OldLine is the line number in the old file (0 for added lines)
+	OldLine int
+	// NewLine is the line number in the new file (0 for removed lines)
+	NewLine int
+}
+
+// Hunk is a contiguous block of changes within a file
+type Hunk struct {
+	OldStart int
+	OldLines int
+	NewStart int
+	NewLines int
+	// Section is the optional function or class context git prints after the range
+	Section string
+	Lines   []Line
+}
+
+// File is the diff of a single file
+type File struct {
+	OldPath   string
+	NewPath   string
+	IsNew     bool
+	IsDeleted bool
+	IsRename  bool
+	IsBinary  bool
+	Hunks     []*Hunk
+
+	// raw holds the original diff text for this file
+	raw string
+}
+
+// Diff is a parsed unified diff
+type Diff struct {
+	Files []*File
+}
+
+// Path returns the path the file has after the change, or its old path if it was deleted
+func (f *File) Path() string {
+	if f.IsDeleted {
+		return f.OldPath
+	}
+	return f.NewPath
+}
+
+// String returns the original diff text for the file
+func (f *File) String() string {
+	return f.raw
+}
+
+// ChangedLines returns the new-file line numbers that were added by the diff
+func (f *File) ChangedLines() []int {
+	var lines []int
+	for _, hunk := range f.Hunks {
+		for _, line := range hunk.Lines {
+			if line.Kind == Added {
+				lines = append(lines, line.NewLine)
+			}
+		}
+	}
+	return lines
+}
+
+// IsChangedLine reports whether the given new-file line number was added by the diff
+func (f *File) IsChangedLine(lineNumber int) bool {
+	for _, hunk := range f.Hunks {
+		if lineNumber < hunk.NewStart || lineNumber >= hunk.NewStart+hunk.NewLines {
+			continue
+		}
+		for _, line := range hunk.Lines {
+			if line.Kind == Added && line.NewLine == lineNumber {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// InHunk reports whether the given new-file line number falls inside any hunk, including context lines
+func (f *File) InHunk(lineNumber int) bool {
+	for _, hunk := range f.Hunks {
+		if lineNumber >= hunk.NewStart && lineNumber < hunk.NewStart+hunk.NewLines {
+			return true
+		}
+	}
+	return false
+}
+
+// File returns the diff for the given path, matching either the old or new path
+func (d *Diff) File(path string) *File {
+	path = strings.TrimPrefix(path, "/")
+	if path == "" {
+		return nil
+	}
+	for _, file := range d.Files {
+		if file.NewPath == path || file.OldPath == path {
+			return file
+		}
+	}
+	return nil
+}
+
+// IsChangedLine reports whether the given line of a file was added by the diff
+func (d *Diff) IsChangedLine(path string, lineNumber int) bool {
+	file := d.File(path)
+	if file == nil {
+		return false
+	}
+	return file.IsChangedLine(lineNumber)
+}
+
+// Parse parses the output of git diff into a Diff
+func Parse(text string) (*Diff, error) {
+	result := &Diff{}
+
+	var current *File
+	var hunk *Hunk
+	var raw strings.Builder
+	var oldLine, newLine int
+
+	finishFile := func() {
+		if current != nil {
+			current.raw = raw.String()
+			result.Files = append(result.Files, current)
+		}
+		raw.Reset()
+		current = nil
+		hunk = nil
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	// Allow for very long lines such as minified files
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+
+	lineNumber := 0
+	for scanner.Scan() {
+		line := scanner.Text()
+		lineNumber++
+
+		if strings.HasPrefix(line, "diff --git ") {
+			finishFile()
+			current = &File{}
+			current.OldPath, current.NewPath = parseGitHeader(line)
+			raw.WriteString(line + "\n")
+			continue
+		}
+
+		// Anything before the first file header (such as a token count banner) is ignored
+		if current == nil {
+			continue
+		}
+		raw.WriteString(line + "\n")
+
+		// Inside a hunk, lines are consumed until the declared line counts are exhausted
+		if hunk != nil && (oldLine < hunk.OldStart+hunk.OldLines || newLine < hunk.NewStart+hunk.NewLines) {
+			switch {
+			case strings.HasPrefix(line, "+"):
+				hunk.Lines = append(hunk.Lines, Line{Kind: Added, Content: line[1:], NewLine: newLine})
+				newLine++
+			case strings.HasPrefix(line, "-"):
+				hunk.Lines = append(hunk.Lines, Line{Kind: Removed, Content: line[1:], OldLine: oldLine})
+				oldLine++
+			case strings.HasPrefix(line, " "), line == "":
+				content := ""
+				if line != "" {
+					content = line[1:]
+				}
+				hunk.Lines = append(hunk.Lines, Line{Kind: Context, Content: content, OldLine: oldLine, NewLine: newLine})
+				oldLine++
+				newLine++
+			case strings.HasPrefix(line, `\`):
+				// "\ No newline at end of file"
+			default:
+				return nil, fmt.Errorf("line %d: unexpected line in hunk: %q", lineNumber, line)
+			}
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "@@"):
+			parsed, err := parseHunkHeader(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+			}
+			hunk = parsed
+			current.Hunks = append(current.Hunks, hunk)
+			oldLine = hunk.OldStart
+			newLine = hunk.NewStart
+		case strings.HasPrefix(line, `\`):
+			// "\ No newline at end of file" after the last line of a hunk
+		case strings.HasPrefix(line, "new file mode"):
+			current.IsNew = true
+		case strings.HasPrefix(line, "deleted file mode"):
+			current.IsDeleted = true
+		case strings.HasPrefix(line, "rename from "):
+			current.IsRename = true
+			current.OldPath = unquote(strings.TrimPrefix(line, "rename from "))
+		case strings.HasPrefix(line, "rename to "):
+			current.IsRename = true
+			current.NewPath = unquote(strings.TrimPrefix(line, "rename to "))
+		case strings.HasPrefix(line, "Binary files "), line == "GIT binary patch":
+			current.IsBinary = true
+		case strings.HasPrefix(line, "--- "):
+			current.OldPath = trimPathPrefix(strings.TrimPrefix(line, "--- "))
+		case strings.HasPrefix(line, "+++ "):
+			current.NewPath = trimPathPrefix(strings.TrimPrefix(line, "+++ "))
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error scanning diff: %w", err)
+	}
+	finishFile()
+
+	return result, nil
+}
+
+// parseGitHeader extracts the old and new paths from a "diff --git a/x b/y" line
+func parseGitHeader(line string) (string, string) {
+	rest := strings.TrimPrefix(line, "diff --git ")
+
+	// Paths with special characters are C-quoted, as in "a/na\303\257ve.go"
+	if strings.HasPrefix(rest, `"`) || strings.HasSuffix(rest, `"`) {
+		if oldPath, newPath, ok := splitQuoted(rest); ok {
+			return strings.TrimPrefix(oldPath, "a/"), strings.TrimPrefix(newPath, "b/")
+		}
+	}
+
+	// Paths without spaces split cleanly; otherwise rely on the b/ separator
+	if idx := strings.Index(rest, " b/"); idx != -1 {
+		return strings.TrimPrefix(rest[:idx], "a/"), rest[idx+3:]
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) == 2 {
+		return strings.TrimPrefix(fields[0], "a/"), strings.TrimPrefix(fields[1], "b/")
+	}
+	return "", ""
+}
+
+// splitQuoted splits the two paths of a git header where either may be quoted
+func splitQuoted(rest string) (string, string, bool) {
+	var first string
+	if strings.HasPrefix(rest, `"`) {
+		end := closingQuote(rest)
+		if end == -1 {
+			return "", "", false
+		}
+		first, rest = unquote(rest[:end+1]), strings.TrimPrefix(rest[end+1:], " ")
+	} else {
+		idx := strings.Index(rest, ` "`)
+		if idx == -1 {
+			return "", "", false
+		}
+		first, rest = rest[:idx], rest[idx+1:]
+	}
+	return first, unquote(rest), true
+}
+
+// closingQuote returns the index of the quote closing the quoted string s starts with, or -1
+func closingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// unquote decodes a path git quoted because it contains special or non-ASCII characters, and
+// returns other paths as they are
+func unquote(path string) string {
+	if !strings.HasPrefix(path, `"`) {
+		return path
+	}
+	if unquoted, err := strconv.Unquote(path); err == nil {
+		return unquoted
+	}
+	return path
+}
+
+// trimPathPrefix strips the a/ or b/ prefix from a ---/+++ path, returning "" for /dev/null
+func trimPathPrefix(path string) string {
+	// Git appends a tab when the path contains spaces
+	path = unquote(strings.TrimSuffix(path, "\t"))
+	if path == "/dev/null" {
+		return ""
+	}
+	if strings.HasPrefix(path, "a/") || strings.HasPrefix(path, "b/") {
+		return path[2:]
+	}
+	return path
+}
+
+// parseHunkHeader parses a "@@ -a,b +c,d @@ section" line
+func parseHunkHeader(line string) (*Hunk, error) {
+	end := strings.Index(line[2:], "@@")
+	if end == -1 {
+		return nil, fmt.Errorf("malformed hunk header: %q", line)
+	}
+
+	ranges := strings.Fields(line[2 : end+2])
+	if len(ranges) != 2 || !strings.HasPrefix(ranges[0], "-") || !strings.HasPrefix(ranges[1], "+") {
+		return nil, fmt.Errorf("malformed hunk header: %q", line)
+	}
+
+	hunk := &Hunk{Section: strings.TrimSpace(line[end+4:])}
+
+	var err error
+	hunk.OldStart, hunk.OldLines, err = parseRange(ranges[0][1:])
+	if err != nil {
+		return nil, fmt.Errorf("malformed hunk header %q: %w", line, err)
+	}
+	hunk.NewStart, hunk.NewLines, err = parseRange(ranges[1][1:])
+	if err != nil {
+		return nil, fmt.Errorf("malformed hunk header %q: %w", line, err)
+	}
+
+	return hunk, nil
+}
+
+// parseRange parses "start,count" or "start" (count defaults to 1)
+func parseRange(value string) (int, int, error) {
+	startText, countText, hasCount := strings.Cut(value, ",")
+
+	start, err := strconv.Atoi(startText)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !hasCount {
+		return start, 1, nil
+	}
+
+	count, err := strconv.Atoi(countText)
+	if err != nil {
+		return 0, 0, err
+	}
+	return start, count, nil
+}
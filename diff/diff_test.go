@@ -0,0 +1,208 @@
+package diff
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const sampleDiff = `diff --git a/src/keep.go b/src/keep.go
+index 1111111..2222222 100644
+--- a/src/keep.go
++++ b/src/keep.go
+@@ -1,5 +1,6 @@ package src
+ package src
+
+ func Keep() int {
+-	return 1
++	value := 2
++	return value
+ }
+diff --git a/src/new.go b/src/new.go
+new file mode 100644
+index 0000000..3333333
+--- /dev/null
++++ b/src/new.go
+@@ -0,0 +1,3 @@
++package src
++
++func New() {}
+diff --git a/src/remove.go b/src/remove.go
+deleted file mode 100644
+index 4444444..0000000
+--- a/src/remove.go
++++ /dev/null
+@@ -1,2 +0,0 @@
+-package src
+-func Remove() {}
+\ No newline at end of file
+diff --git a/src/old_name.go b/src/new_name.go
+similarity index 100%
+rename from src/old_name.go
+rename to src/new_name.go
+diff --git a/assets/logo.png b/assets/logo.png
+index 5555555..6666666 100644
+Binary files a/assets/logo.png and b/assets/logo.png differ
+`
+
+func TestParse(t *testing.T) {
+	parsed, err := Parse(sampleDiff)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(parsed.Files) != 5 {
+		t.Fatalf("Expected 5 files, got %d", len(parsed.Files))
+	}
+
+	tests := []struct {
+		name      string
+		path      string
+		oldPath   string
+		isNew     bool
+		isDeleted bool
+		isRename  bool
+		isBinary  bool
+		hunks     int
+		changed   []int
+	}{
+		{name: "Modified file", path: "src/keep.go", oldPath: "src/keep.go", hunks: 1, changed: []int{4, 5}},
+		{name: "Added file", path: "src/new.go", isNew: true, hunks: 1, changed: []int{1, 2, 3}},
+		{name: "Deleted file", path: "src/remove.go", oldPath: "src/remove.go", isDeleted: true, hunks: 1},
+		{name: "Renamed file", path: "src/new_name.go", oldPath: "src/old_name.go", isRename: true},
+		{name: "Binary file", path: "assets/logo.png", oldPath: "assets/logo.png", isBinary: true},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := parsed.Files[i]
+
+			if file.Path() != tt.path {
+				t.Errorf("Expected path %q, got %q", tt.path, file.Path())
+			}
+			if file.OldPath != tt.oldPath {
+				t.Errorf("Expected old path %q, got %q", tt.oldPath, file.OldPath)
+			}
+			if file.IsNew != tt.isNew || file.IsDeleted != tt.isDeleted || file.IsRename != tt.isRename || file.IsBinary != tt.isBinary {
+				t.Errorf("Unexpected flags: new=%v deleted=%v rename=%v binary=%v",
+					file.IsNew, file.IsDeleted, file.IsRename, file.IsBinary)
+			}
+			if len(file.Hunks) != tt.hunks {
+				t.Errorf("Expected %d hunks, got %d", tt.hunks, len(file.Hunks))
+			}
+			if !reflect.DeepEqual(file.ChangedLines(), tt.changed) {
+				t.Errorf("Expected changed lines %v, got %v", tt.changed, file.ChangedLines())
+			}
+			if !strings.HasPrefix(file.String(), "diff --git ") {
+				t.Errorf("Expected raw diff to start with the file header, got %q", file.String())
+			}
+		})
+	}
+}
+
+func TestHunkLineNumbers(t *testing.T) {
+	parsed, err := Parse(sampleDiff)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	hunk := parsed.File("src/keep.go").Hunks[0]
+	if hunk.OldStart != 1 || hunk.OldLines != 5 || hunk.NewStart != 1 || hunk.NewLines != 6 {
+		t.Errorf("Unexpected hunk range: -%d,%d +%d,%d", hunk.OldStart, hunk.OldLines, hunk.NewStart, hunk.NewLines)
+	}
+	if hunk.Section != "package src" {
+		t.Errorf("Expected section %q, got %q", "package src", hunk.Section)
+	}
+
+	removed := hunk.Lines[3]
+	if removed.Kind != Removed || removed.OldLine != 4 || removed.Content != "\treturn 1" {
+		t.Errorf("Unexpected removed line: %+v", removed)
+	}
+
+	closing := hunk.Lines[6]
+	if closing.Kind != Context || closing.OldLine != 5 || closing.NewLine != 6 {
+		t.Errorf("Unexpected context line: %+v", closing)
+	}
+}
+
+func TestIsChangedLine(t *testing.T) {
+	parsed, err := Parse("\n\nThis diff contains **10 tokens** when processed by gpt-4o.\n\n" + sampleDiff)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		line     int
+		expected bool
+	}{
+		{name: "Added line", path: "src/keep.go", line: 4, expected: true},
+		{name: "Leading slash", path: "/src/keep.go", line: 5, expected: true},
+		{name: "Context line", path: "src/keep.go", line: 3, expected: false},
+		{name: "Outside hunk", path: "src/keep.go", line: 40, expected: false},
+		{name: "Line in new file", path: "src/new.go", line: 3, expected: true},
+		{name: "Unknown file", path: "src/missing.go", line: 1, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parsed.IsChangedLine(tt.path, tt.line); got != tt.expected {
+				t.Errorf("IsChangedLine(%q, %d) = %v, expected %v", tt.path, tt.line, got, tt.expected)
+			}
+		})
+	}
+
+	if !parsed.File("src/keep.go").InHunk(3) {
+		t.Error("Expected context line 3 to be inside a hunk")
+	}
+}
+
+func TestParseMalformedHunk(t *testing.T) {
+	_, err := Parse("diff --git a/x.go b/x.go\n--- a/x.go\n+++ b/x.go\n@@ -1 +1 @@\n-old\n+new\n@@ broken\n")
+	if err == nil {
+		t.Error("Expected error for malformed hunk header")
+	}
+}
+
+func TestParseQuotedPaths(t *testing.T) {
+	text := `diff --git "a/src/na\303\257ve.go" "b/src/na\303\257ve.go"
+index 1111111..2222222 100644
+--- "a/src/na\303\257ve.go"
++++ "b/src/na\303\257ve.go"
+@@ -1 +1 @@
+-old
++new
+diff --git a/docs/old "name".md b/docs/new name.md
+similarity index 90%
+rename from "docs/old \"name\".md"
+rename to docs/new name.md
+diff --git a/docs/read me.md b/docs/read me.md
+new file mode 100644
+index 0000000..3333333
+--- /dev/null
++++ b/docs/read me.md	
+@@ -0,0 +1 @@
++# Notes
+`
+	parsed, err := Parse(text)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var paths []string
+	for _, file := range parsed.Files {
+		paths = append(paths, file.OldPath+" -> "+file.NewPath)
+	}
+	want := []string{
+		"src/naïve.go -> src/naïve.go",
+		`docs/old "name".md -> docs/new name.md`,
+		" -> docs/read me.md",
+	}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("Expected paths %q, got %q", want, paths)
+	}
+	if !parsed.IsChangedLine("src/naïve.go", 1) {
+		t.Error("Expected line 1 of the quoted path to be changed")
+	}
+}
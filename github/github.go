@@ -0,0 +1,380 @@
+// Package github calls the GitHub REST API to read pull requests and post reviews on them.
+package github
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultBaseURL is the API of github.com. GitHub Enterprise Server serves it at https://HOST/api/v3.
+const DefaultBaseURL = "https://api.github.com"
+
+// apiVersion is the REST API version requests are made against
+const apiVersion = "2022-11-28"
+
+// Review events
+const (
+	EventComment        = "COMMENT"
+	EventApprove        = "APPROVE"
+	EventRequestChanges = "REQUEST_CHANGES"
+)
+
+// SideRight is the side of the diff showing the PR's version of a file
+const SideRight = "RIGHT"
+
+// Client calls the GitHub REST API
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+// NewClient creates a client for the API at baseURL (DefaultBaseURL if empty), authenticated with a token
+func NewClient(baseURL, token string) *Client {
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		token:      token,
+		httpClient: &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+// WithHTTPClient returns a copy of the client that sends its requests with a different HTTP client
+func (c *Client) WithHTTPClient(client *http.Client) *Client {
+	clone := *c
+	clone.httpClient = client
+	return &clone
+}
+
+// PullRequest is a pull request, as returned by the API
+type PullRequest struct {
+	Number  int    `json:"number"`
+	Title   string `json:"title"`
+	Body    string `json:"body"`
+	HTMLURL string `json:"html_url"`
+	User    User   `json:"user"`
+	Head    Ref    `json:"head"`
+	Base    Ref    `json:"base"`
+}
+
+// Ref is the branch and commit of a side of a pull request
+type Ref struct {
+	Ref string `json:"ref"`
+	SHA string `json:"sha"`
+}
+
+// User is the account that opened a pull request or wrote a comment
+type User struct {
+	Login string `json:"login"`
+}
+
+// File statuses of a pull request's changed files
+const (
+	StatusAdded    = "added"
+	StatusRemoved  = "removed"
+	StatusModified = "modified"
+	StatusRenamed  = "renamed"
+	StatusCopied   = "copied"
+	StatusChanged  = "changed"
+)
+
+// File is a file changed by a pull request
+type File struct {
+	Filename string `json:"filename"`
+	Status   string `json:"status"`
+
+	// PreviousFilename is the path a renamed file had before
+	PreviousFilename string `json:"previous_filename"`
+
+	Additions int `json:"additions"`
+	Deletions int `json:"deletions"`
+
+	// Patch is the file's diff hunks, empty for binary files and diffs too large to show
+	Patch string `json:"patch"`
+}
+
+// Comment is an inline review comment already on a pull request
+type Comment struct {
+	Path string `json:"path"`
+
+	// Line is the line the comment is on, or 0 when the comment is outdated
+	Line int `json:"line"`
+
+	Body    string `json:"body"`
+	User    User   `json:"user"`
+	HTMLURL string `json:"html_url"`
+}
+
+// Review is a pull request review to post
+type Review struct {
+	// CommitID is the commit the comments refer to. Empty uses the pull request's latest commit.
+	CommitID string `json:"commit_id,omitempty"`
+
+	Body string `json:"body"`
+
+	// Event is EventComment, EventApprove or EventRequestChanges
+	Event string `json:"event"`
+
+	Comments []ReviewComment `json:"comments,omitempty"`
+}
+
+// ReviewComment is an inline comment on a line, or range of lines, of the pull request's diff
+type ReviewComment struct {
+	Path string `json:"path"`
+
+	// Line is the last line the comment applies to, and StartLine the first of a range
+	Line      int    `json:"line"`
+	Side      string `json:"side"`
+	StartLine int    `json:"start_line,omitempty"`
+	StartSide string `json:"start_side,omitempty"`
+
+	Body string `json:"body"`
+}
+
+// Error is an error response of the API
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("GitHub API returned %d: %s", e.StatusCode, e.Message)
+}
+
+// PullRequest returns a repository's pull request, where repo is owner/name
+func (c *Client) PullRequest(ctx context.Context, repo string, number int) (*PullRequest, error) {
+	if err := checkRepo(repo); err != nil {
+		return nil, err
+	}
+	var pr PullRequest
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/repos/%s/pulls/%d", repo, number), nil, &pr); err != nil {
+		return nil, fmt.Errorf("failed to get pull request %s#%d: %w", repo, number, err)
+	}
+	return &pr, nil
+}
+
+// FindPullRequest returns the open pull request of a branch in repo, where repo is owner/name
+func (c *Client) FindPullRequest(ctx context.Context, repo, branch string) (*PullRequest, error) {
+	if err := checkRepo(repo); err != nil {
+		return nil, err
+	}
+	owner, _, _ := strings.Cut(repo, "/")
+	query := url.Values{"head": {owner + ":" + branch}, "state": {"open"}}
+	var prs []PullRequest
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/repos/%s/pulls?%s", repo, query.Encode()), nil, &prs); err != nil {
+		return nil, fmt.Errorf("failed to find the pull request of %s: %w", branch, err)
+	}
+	if len(prs) == 0 {
+		return nil, fmt.Errorf("no open pull request in %s for branch %s", repo, branch)
+	}
+	return &prs[0], nil
+}
+
+// PullRequestDiff returns the unified diff of a pull request against its merge-base
+func (c *Client) PullRequestDiff(ctx context.Context, repo string, number int) (string, error) {
+	if err := checkRepo(repo); err != nil {
+		return "", err
+	}
+	content, err := c.send(ctx, http.MethodGet, fmt.Sprintf("/repos/%s/pulls/%d", repo, number), "application/vnd.github.diff", nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to get the diff of %s#%d: %w", repo, number, err)
+	}
+	return string(content), nil
+}
+
+// PullRequestFiles returns the files a pull request changes
+func (c *Client) PullRequestFiles(ctx context.Context, repo string, number int) ([]File, error) {
+	if err := checkRepo(repo); err != nil {
+		return nil, err
+	}
+	files, err := listAll[File](ctx, c, fmt.Sprintf("/repos/%s/pulls/%d/files", repo, number))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the files of %s#%d: %w", repo, number, err)
+	}
+	return files, nil
+}
+
+// ReviewComments returns the inline review comments on a pull request, oldest first
+func (c *Client) ReviewComments(ctx context.Context, repo string, number int) ([]Comment, error) {
+	if err := checkRepo(repo); err != nil {
+		return nil, err
+	}
+	comments, err := listAll[Comment](ctx, c, fmt.Sprintf("/repos/%s/pulls/%d/comments", repo, number))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the review comments of %s#%d: %w", repo, number, err)
+	}
+	return comments, nil
+}
+
+// MergeBase returns the common ancestor of two commits, which a pull request's diff is taken against
+func (c *Client) MergeBase(ctx context.Context, repo, base, head string) (string, error) {
+	if err := checkRepo(repo); err != nil {
+		return "", err
+	}
+	var comparison struct {
+		MergeBaseCommit struct {
+			SHA string `json:"sha"`
+		} `json:"merge_base_commit"`
+	}
+	path := fmt.Sprintf("/repos/%s/compare/%s...%s?per_page=1", repo, url.PathEscape(base), url.PathEscape(head))
+	if err := c.do(ctx, http.MethodGet, path, nil, &comparison); err != nil {
+		return "", fmt.Errorf("failed to compare %s with %s: %w", head, base, err)
+	}
+	if comparison.MergeBaseCommit.SHA == "" {
+		return "", fmt.Errorf("no merge-base between %s and %s", base, head)
+	}
+	return comparison.MergeBaseCommit.SHA, nil
+}
+
+// FileContent returns the content of a file at a commit or branch
+func (c *Client) FileContent(ctx context.Context, repo, ref, path string) (string, error) {
+	if err := checkRepo(repo); err != nil {
+		return "", err
+	}
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	endpoint := fmt.Sprintf("/repos/%s/contents/%s?ref=%s", repo, strings.Join(segments, "/"), url.QueryEscape(ref))
+	content, err := c.send(ctx, http.MethodGet, endpoint, "application/vnd.github.raw+json", nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to get %s at %s: %w", path, ref, err)
+	}
+	return string(content), nil
+}
+
+// Repository reads the files of one repository through the API
+type Repository struct {
+	client *Client
+	name   string
+}
+
+// Repository returns a reader of the files of a repository, where name is owner/name
+func (c *Client) Repository(name string) *Repository {
+	return &Repository{client: c, name: name}
+}
+
+// Show returns the content of a file at a commit or branch, like git show ref:path
+func (r *Repository) Show(ref, path string) (string, error) {
+	return r.client.FileContent(context.Background(), r.name, ref, path)
+}
+
+// CreateReview posts a review on a pull request and returns its URL
+func (c *Client) CreateReview(ctx context.Context, repo string, number int, review Review) (string, error) {
+	if err := checkRepo(repo); err != nil {
+		return "", err
+	}
+	var created struct {
+		HTMLURL string `json:"html_url"`
+	}
+	if err := c.do(ctx, http.MethodPost, fmt.Sprintf("/repos/%s/pulls/%d/reviews", repo, number), review, &created); err != nil {
+		return "", fmt.Errorf("failed to post review on %s#%d: %w", repo, number, err)
+	}
+	return created.HTMLURL, nil
+}
+
+// checkRepo checks that a repository is given as owner/name
+func checkRepo(repo string) error {
+	owner, name, found := strings.Cut(repo, "/")
+	if !found || owner == "" || name == "" || strings.Contains(name, "/") {
+		return fmt.Errorf("invalid repository %q (expected owner/name)", repo)
+	}
+	return nil
+}
+
+// perPage is the page size of list requests, the largest the API allows
+const perPage = 100
+
+// listAll requests every page of a list endpoint; a short page is the last
+func listAll[T any](ctx context.Context, c *Client, path string) ([]T, error) {
+	var all []T
+	for page := 1; ; page++ {
+		var items []T
+		if err := c.do(ctx, http.MethodGet, fmt.Sprintf("%s?per_page=%d&page=%d", path, perPage, page), nil, &items); err != nil {
+			return nil, err
+		}
+		all = append(all, items...)
+		if len(items) < perPage {
+			return all, nil
+		}
+	}
+}
+
+// do sends a request with an optional JSON body and decodes the JSON response into out
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	content, err := c.send(ctx, method, path, "application/vnd.github+json", body)
+	if err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(content, out); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil
+}
+
+// send sends a request with an optional JSON body and returns the response body in the accepted media type
+func (c *Client) send(ctx context.Context, method, path, accept string, body interface{}) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		content, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode request: %w", err)
+		}
+		reader = bytes.NewReader(content)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", accept)
+	req.Header.Set("X-GitHub-Api-Version", apiVersion)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var apiError struct {
+			Message string `json:"message"`
+			Errors  []struct {
+				Message string `json:"message"`
+			} `json:"errors"`
+		}
+		message := strings.TrimSpace(string(content))
+		if json.Unmarshal(content, &apiError) == nil && apiError.Message != "" {
+			message = apiError.Message
+			for _, detail := range apiError.Errors {
+				if detail.Message != "" {
+					message += "; " + detail.Message
+				}
+			}
+		}
+		return nil, &Error{StatusCode: resp.StatusCode, Message: message}
+	}
+	return content, nil
+}
@@ -0,0 +1,144 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPullRequests(t *testing.T) {
+	var posted Review
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" || r.Header.Get("Accept") != "application/vnd.github+json" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"message":"Bad credentials"}`)
+			return
+		}
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v3/repos/acme/shop/pulls":
+			if r.URL.Query().Get("head") == "acme:feature/cart" {
+				fmt.Fprint(w, `[{"number":7,"title":"Add cart","head":{"ref":"feature/cart","sha":"abc"}}]`)
+				return
+			}
+			fmt.Fprint(w, `[]`)
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v3/repos/acme/shop/pulls/7/reviews":
+			if err := json.NewDecoder(r.Body).Decode(&posted); err != nil {
+				t.Errorf("Failed to decode review: %v", err)
+			}
+			if len(posted.Comments) > 0 && posted.Comments[0].Line == 999 {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				fmt.Fprint(w, `{"message":"Unprocessable Entity","errors":[{"message":"Line could not be resolved"}]}`)
+				return
+			}
+			fmt.Fprint(w, `{"html_url":"https://github.example.com/acme/shop/pull/7#review-1"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"message":"Not Found"}`)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL+"/api/v3/", "secret")
+	ctx := context.Background()
+
+	pr, err := client.FindPullRequest(ctx, "acme/shop", "feature/cart")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if pr.Number != 7 || pr.Head.SHA != "abc" {
+		t.Errorf("Unexpected pull request %+v", pr)
+	}
+	if _, err := client.FindPullRequest(ctx, "acme/shop", "other"); err == nil || !strings.Contains(err.Error(), "no open pull request") {
+		t.Errorf("Expected no pull request for the branch, got %v", err)
+	}
+
+	review := Review{Body: "Summary", Event: EventComment, Comments: []ReviewComment{
+		{Path: "cart.go", StartLine: 3, StartSide: SideRight, Line: 5, Side: SideRight, Body: "Check the total"},
+	}}
+	url, err := client.CreateReview(ctx, "acme/shop", 7, review)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if url != "https://github.example.com/acme/shop/pull/7#review-1" || posted.Body != "Summary" ||
+		len(posted.Comments) != 1 || posted.Comments[0].StartLine != 3 || posted.Comments[0].Path != "cart.go" {
+		t.Errorf("Unexpected review %+v posted to %s", posted, url)
+	}
+
+	// API errors keep their status and message
+	review.Comments[0].Line = 999
+	_, err = client.CreateReview(ctx, "acme/shop", 7, review)
+	var apiError *Error
+	if !errors.As(err, &apiError) || apiError.StatusCode != http.StatusUnprocessableEntity ||
+		apiError.Message != "Unprocessable Entity; Line could not be resolved" {
+		t.Errorf("Expected the rejected comment error, got %v", err)
+	}
+	if _, err := NewClient(server.URL+"/api/v3", "wrong").PullRequest(ctx, "acme/shop", 7); !errors.As(err, &apiError) || apiError.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected an authentication error, got %v", err)
+	}
+	if _, err := client.PullRequest(ctx, "shop", 7); err == nil || !strings.Contains(err.Error(), "owner/name") {
+		t.Errorf("Expected an invalid repository error, got %v", err)
+	}
+}
+
+func TestPullRequestContents(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accept := r.Header.Get("Accept")
+		switch {
+		case r.URL.Path == "/repos/acme/shop/pulls/7" && accept == "application/vnd.github.diff":
+			fmt.Fprint(w, "diff --git a/cart.go b/cart.go\n")
+		case r.URL.Path == "/repos/acme/shop/pulls/7/files":
+			// A full first page means there is another
+			count := perPage
+			if r.URL.Query().Get("page") == "2" {
+				count = 1
+			}
+			var files []File
+			for i := 0; i < count; i++ {
+				files = append(files, File{Filename: fmt.Sprintf("page%s/%d.go", r.URL.Query().Get("page"), i), Status: StatusModified})
+			}
+			json.NewEncoder(w).Encode(files)
+		case r.URL.Path == "/repos/acme/shop/pulls/7/comments":
+			fmt.Fprint(w, `[{"path":"cart.go","line":4,"body":"Why?","user":{"login":"sam"}}]`)
+		case r.URL.Path == "/repos/acme/shop/compare/main...abc":
+			fmt.Fprint(w, `{"merge_base_commit":{"sha":"base1"}}`)
+		case r.URL.Path == "/repos/acme/shop/contents/app/my file.go" && accept == "application/vnd.github.raw+json":
+			fmt.Fprintf(w, "content at %s", r.URL.Query().Get("ref"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"message":"Not Found"}`)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "")
+	ctx := context.Background()
+
+	if diff, err := client.PullRequestDiff(ctx, "acme/shop", 7); err != nil || diff != "diff --git a/cart.go b/cart.go\n" {
+		t.Errorf("Unexpected diff %q (%v)", diff, err)
+	}
+	files, err := client.PullRequestFiles(ctx, "acme/shop", 7)
+	if err != nil || len(files) != perPage+1 || files[perPage].Filename != "page2/0.go" {
+		t.Errorf("Expected both pages of files, got %d (%v)", len(files), err)
+	}
+	comments, err := client.ReviewComments(ctx, "acme/shop", 7)
+	if err != nil || len(comments) != 1 || comments[0].User.Login != "sam" || comments[0].Line != 4 {
+		t.Errorf("Unexpected comments %+v (%v)", comments, err)
+	}
+	if mergeBase, err := client.MergeBase(ctx, "acme/shop", "main", "abc"); err != nil || mergeBase != "base1" {
+		t.Errorf("Unexpected merge-base %q (%v)", mergeBase, err)
+	}
+
+	// The repository reads files like git show
+	if content, err := client.Repository("acme/shop").Show("base1", "app/my file.go"); err != nil || content != "content at base1" {
+		t.Errorf("Unexpected content %q (%v)", content, err)
+	}
+	var apiError *Error
+	if _, err := client.FileContent(ctx, "acme/shop", "base1", "missing.go"); !errors.As(err, &apiError) || apiError.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected a missing file error, got %v", err)
+	}
+}
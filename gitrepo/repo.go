@@ -0,0 +1,277 @@
+// Package gitrepo computes PR diffs and changed-file sets directly from a local git clone.
+package gitrepo
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Repo is a local git repository
+type Repo struct {
+	// Dir is the working directory of the repository
+	Dir string
+}
+
+// Open returns a Repo for the given directory after checking that it is a git repository
+func Open(dir string) (*Repo, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, fmt.Errorf("repository directory %s not found: %w", dir, err)
+	}
+
+	repo := &Repo{Dir: dir}
+	if _, err := repo.run("rev-parse", "--git-dir"); err != nil {
+		return nil, fmt.Errorf("%s is not a git repository: %w", dir, err)
+	}
+
+	return repo, nil
+}
+
+// run executes a git command in the repository and returns its standard output
+func (r *Repo) run(args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = r.Dir
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			return "", fmt.Errorf("git %s: %w", args[0], err)
+		}
+		return "", fmt.Errorf("git %s: %w: %s", args[0], err, msg)
+	}
+
+	return stdout.String(), nil
+}
+
+// DefaultBranch returns the branch PRs are merged into, trying main before master
+func (r *Repo) DefaultBranch() (string, error) {
+	for _, branch := range []string{"main", "master"} {
+		if _, err := r.run("rev-parse", "--verify", "--quiet", branch+"^{commit}"); err == nil {
+			return branch, nil
+		}
+	}
+
+	return "", fmt.Errorf("neither main nor master branch found in %s", r.Dir)
+}
+
+// MergeBase returns the common ancestor commit of base and head
+func (r *Repo) MergeBase(base, head string) (string, error) {
+	out, err := r.run("merge-base", base, head)
+	if err != nil {
+		return "", fmt.Errorf("failed to find merge-base of %s and %s: %w", base, head, err)
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// RevParse returns the commit a branch, tag or other ref points to
+func (r *Repo) RevParse(ref string) (string, error) {
+	out, err := r.run("rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s to a commit: %w", ref, err)
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// diffArgs are passed to every git diff so that a user's color, external diff driver or path
+// quoting settings don't change the output that is parsed
+var diffArgs = []string{"diff", "--no-color", "--no-ext-diff", "-M"}
+
+// diff runs git diff with diffArgs followed by args
+func (r *Repo) diff(args ...string) (string, error) {
+	return r.run(append(append([]string{}, diffArgs...), args...)...)
+}
+
+// Diff returns the unified diff between two commits
+func (r *Repo) Diff(from, to string) (string, error) {
+	out, err := r.diff(from + ".." + to)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate diff: %w", err)
+	}
+	return out, nil
+}
+
+// Show returns the content of a file at the given commit
+func (r *Repo) Show(ref, path string) (string, error) {
+	out, err := r.run("show", fmt.Sprintf("%s:%s", ref, path))
+	if err != nil {
+		return "", fmt.Errorf("failed to get content for %s at %s: %w", path, ref, err)
+	}
+	return out, nil
+}
+
+// Rename describes a file that was moved, possibly with changes
+type Rename struct {
+	From string
+	To   string
+}
+
+// FileStat holds the number of added and deleted lines for a file
+type FileStat struct {
+	Path    string
+	Added   int
+	Deleted int
+	Binary  bool
+}
+
+// ChangeSet is the set of files changed between two commits
+type ChangeSet struct {
+	Added    []string
+	Modified []string
+	Deleted  []string
+	Renamed  []Rename
+	Stats    []FileStat
+}
+
+// ChangedFiles returns the files that were added, modified, deleted or renamed between two commits
+func (r *Repo) ChangedFiles(from, to string) (*ChangeSet, error) {
+	rangeSpec := from + ".." + to
+
+	// With -z paths are NUL-separated and never quoted, and each status is followed by one path, or two
+	// for renames and copies
+	out, err := r.diff("--name-status", "-z", rangeSpec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list changed files: %w", err)
+	}
+
+	changes := &ChangeSet{}
+	fields := splitNUL(out)
+	for i := 0; i < len(fields); i++ {
+		status := fields[i]
+		if status == "" || i+1 >= len(fields) {
+			continue
+		}
+
+		// Status letters may carry a similarity score (e.g. R087)
+		switch status[0] {
+		case 'A':
+			changes.Added = append(changes.Added, fields[i+1])
+		case 'M', 'T':
+			changes.Modified = append(changes.Modified, fields[i+1])
+		case 'D':
+			changes.Deleted = append(changes.Deleted, fields[i+1])
+		case 'R':
+			if i+2 < len(fields) {
+				changes.Renamed = append(changes.Renamed, Rename{From: fields[i+1], To: fields[i+2]})
+			}
+			i++
+		case 'C':
+			// A copy leaves the source untouched, so the destination is new
+			if i+2 < len(fields) {
+				changes.Added = append(changes.Added, fields[i+2])
+			}
+			i++
+		}
+		i++
+	}
+
+	sort.Strings(changes.Added)
+	sort.Strings(changes.Modified)
+	sort.Strings(changes.Deleted)
+	sort.Slice(changes.Renamed, func(i, j int) bool { return changes.Renamed[i].To < changes.Renamed[j].To })
+
+	stats, err := r.numStat(rangeSpec)
+	if err != nil {
+		return nil, err
+	}
+	changes.Stats = stats
+
+	return changes, nil
+}
+
+// numStat returns per-file line statistics for a commit range, largest changes first
+func (r *Repo) numStat(rangeSpec string) ([]FileStat, error) {
+	out, err := r.diff("--numstat", "-z", rangeSpec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file statistics: %w", err)
+	}
+
+	var stats []FileStat
+	fields := splitNUL(out)
+	for i := 0; i < len(fields); i++ {
+		counts := strings.SplitN(fields[i], "\t", 3)
+		if len(counts) < 3 {
+			continue
+		}
+
+		// A rename leaves the path empty and is followed by its old and new paths
+		path := counts[2]
+		if path == "" && i+2 < len(fields) {
+			path = fields[i+2]
+			i += 2
+		}
+
+		// Binary files report "-" for both counts
+		stat := FileStat{Path: path}
+		if counts[0] == "-" && counts[1] == "-" {
+			stat.Binary = true
+		} else {
+			stat.Added, _ = strconv.Atoi(counts[0])
+			stat.Deleted, _ = strconv.Atoi(counts[1])
+		}
+		stats = append(stats, stat)
+	}
+
+	sort.SliceStable(stats, func(i, j int) bool {
+		return stats[i].Added+stats[i].Deleted > stats[j].Added+stats[j].Deleted
+	})
+
+	return stats, nil
+}
+
+// splitNUL splits the NUL-terminated fields of git's -z output
+func splitNUL(out string) []string {
+	out = strings.TrimSuffix(out, "\x00")
+	if out == "" {
+		return nil
+	}
+	return strings.Split(out, "\x00")
+}
+
+// IsEmpty reports whether the change set contains no files
+func (c *ChangeSet) IsEmpty() bool {
+	return len(c.Added) == 0 && len(c.Modified) == 0 && len(c.Deleted) == 0 && len(c.Renamed) == 0
+}
+
+// Markdown renders the change set in the changed-files format used by the review artifacts
+func (c *ChangeSet) Markdown(title string) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("# Changed Files for %s\n\n", title))
+
+	writeSection := func(heading string, lines []string) {
+		sb.WriteString(fmt.Sprintf("## %s\n", heading))
+		for _, line := range lines {
+			sb.WriteString(line + "\n")
+		}
+		sb.WriteString("\n")
+	}
+
+	writeSection("Modified Files", c.Modified)
+	writeSection("Added Files", c.Added)
+	writeSection("Deleted Files", c.Deleted)
+
+	renames := make([]string, 0, len(c.Renamed))
+	for _, rename := range c.Renamed {
+		renames = append(renames, fmt.Sprintf("%s -> %s", rename.From, rename.To))
+	}
+	writeSection("Renamed Files", renames)
+
+	stats := make([]string, 0, len(c.Stats))
+	for _, stat := range c.Stats {
+		if stat.Binary {
+			stats = append(stats, fmt.Sprintf("-\t-\t%s", stat.Path))
+			continue
+		}
+		stats = append(stats, fmt.Sprintf("%d\t%d\t%s", stat.Added, stat.Deleted, stat.Path))
+	}
+	writeSection("File Statistics", stats)
+
+	return sb.String()
+}
@@ -0,0 +1,237 @@
+package gitrepo
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// git runs a git command in dir and fails the test on error
+func git(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@example.com"}, args...)...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %s failed: %v\n%s", strings.Join(args, " "), err, out)
+	}
+}
+
+// writeFile writes content to a file inside dir, creating parent directories
+func writeFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+}
+
+// newTestRepo creates a repository with a main branch and a feature branch that
+// modifies, adds, deletes and renames files
+func newTestRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	dir := t.TempDir()
+	git(t, dir, "init", "--quiet")
+	git(t, dir, "checkout", "--quiet", "-b", "main")
+
+	writeFile(t, dir, "src/keep.go", "package src\n\nfunc Keep() int {\n\treturn 1\n}\n")
+	writeFile(t, dir, "src/remove.go", "package src\n\nfunc Remove() {}\n")
+	writeFile(t, dir, "src/old_name.go", "package src\n\n// A fairly long file so rename detection has something to match\nfunc Moved() string {\n\treturn \"moved\"\n}\n")
+	git(t, dir, "add", ".")
+	git(t, dir, "commit", "--quiet", "-m", "initial")
+
+	git(t, dir, "checkout", "--quiet", "-b", "feature")
+	writeFile(t, dir, "src/keep.go", "package src\n\nfunc Keep() int {\n\treturn 2\n}\n")
+	writeFile(t, dir, "src/new.go", "package src\n\nfunc New() {}\n")
+	git(t, dir, "rm", "--quiet", "src/remove.go")
+	git(t, dir, "mv", "src/old_name.go", "src/new_name.go")
+	git(t, dir, "add", ".")
+	git(t, dir, "commit", "--quiet", "-m", "feature")
+
+	return dir
+}
+
+func TestOpen(t *testing.T) {
+	dir := newTestRepo(t)
+
+	if _, err := Open(dir); err != nil {
+		t.Errorf("Unexpected error opening repository: %v", err)
+	}
+
+	if _, err := Open(t.TempDir()); err == nil {
+		t.Error("Expected error for a directory that is not a git repository")
+	}
+
+	if _, err := Open(filepath.Join(dir, "missing")); err == nil {
+		t.Error("Expected error for a missing directory")
+	}
+}
+
+func TestChangedFiles(t *testing.T) {
+	repo, err := Open(newTestRepo(t))
+	if err != nil {
+		t.Fatalf("Failed to open repository: %v", err)
+	}
+
+	base, err := repo.DefaultBranch()
+	if err != nil {
+		t.Fatalf("Failed to find default branch: %v", err)
+	}
+	if base != "main" {
+		t.Errorf("Expected default branch main, got %s", base)
+	}
+
+	mergeBase, err := repo.MergeBase(base, "feature")
+	if err != nil {
+		t.Fatalf("Failed to find merge-base: %v", err)
+	}
+
+	changes, err := repo.ChangedFiles(mergeBase, "feature")
+	if err != nil {
+		t.Fatalf("Failed to list changed files: %v", err)
+	}
+
+	if len(changes.Modified) != 1 || changes.Modified[0] != "src/keep.go" {
+		t.Errorf("Expected modified [src/keep.go], got %v", changes.Modified)
+	}
+	if len(changes.Added) != 1 || changes.Added[0] != "src/new.go" {
+		t.Errorf("Expected added [src/new.go], got %v", changes.Added)
+	}
+	if len(changes.Deleted) != 1 || changes.Deleted[0] != "src/remove.go" {
+		t.Errorf("Expected deleted [src/remove.go], got %v", changes.Deleted)
+	}
+	if len(changes.Renamed) != 1 || changes.Renamed[0] != (Rename{From: "src/old_name.go", To: "src/new_name.go"}) {
+		t.Errorf("Expected rename src/old_name.go -> src/new_name.go, got %v", changes.Renamed)
+	}
+	if changes.IsEmpty() {
+		t.Error("Expected change set to be non-empty")
+	}
+
+	markdown := changes.Markdown("feature")
+	for _, want := range []string{
+		"# Changed Files for feature\n\n## Modified Files\nsrc/keep.go\n",
+		"## Added Files\nsrc/new.go\n",
+		"## Deleted Files\nsrc/remove.go\n",
+		"## Renamed Files\nsrc/old_name.go -> src/new_name.go\n",
+		"## File Statistics\n",
+	} {
+		if !strings.Contains(markdown, want) {
+			t.Errorf("Markdown does not contain %q:\n%s", want, markdown)
+		}
+	}
+}
+
+func TestDiffAndShow(t *testing.T) {
+	repo, err := Open(newTestRepo(t))
+	if err != nil {
+		t.Fatalf("Failed to open repository: %v", err)
+	}
+
+	mergeBase, err := repo.MergeBase("main", "feature")
+	if err != nil {
+		t.Fatalf("Failed to find merge-base: %v", err)
+	}
+
+	diff, err := repo.Diff(mergeBase, "feature")
+	if err != nil {
+		t.Fatalf("Failed to generate diff: %v", err)
+	}
+	if !strings.Contains(diff, "-\treturn 1") || !strings.Contains(diff, "+\treturn 2") {
+		t.Errorf("Diff does not contain the expected change:\n%s", diff)
+	}
+
+	original, err := repo.Show(mergeBase, "src/remove.go")
+	if err != nil {
+		t.Fatalf("Failed to show original file: %v", err)
+	}
+	if !strings.Contains(original, "func Remove()") {
+		t.Errorf("Unexpected original content: %s", original)
+	}
+
+	if _, err := repo.Show("feature", "src/remove.go"); err == nil {
+		t.Error("Expected error showing a deleted file at the head commit")
+	}
+}
+
+func TestRevParse(t *testing.T) {
+	dir := newTestRepo(t)
+	repo, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Failed to open repository: %v", err)
+	}
+
+	commit, err := repo.RevParse("feature")
+	if err != nil {
+		t.Fatalf("Failed to resolve the branch: %v", err)
+	}
+	head, err := repo.RevParse("HEAD")
+	if err != nil {
+		t.Fatalf("Failed to resolve HEAD: %v", err)
+	}
+	if len(commit) != 40 || commit != head {
+		t.Errorf("Expected feature and HEAD to resolve to the same commit, got %q and %q", commit, head)
+	}
+
+	if _, err := repo.RevParse("missing"); err == nil {
+		t.Error("Expected an error for an unknown ref")
+	}
+}
+
+func TestChangedFilesUnusualPaths(t *testing.T) {
+	dir := newTestRepo(t)
+	writeFile(t, dir, "docs/read me.md", "# Notes\n")
+	writeFile(t, dir, "src/naïve.go", "package src\n")
+	git(t, dir, "add", ".")
+	git(t, dir, "commit", "--quiet", "-m", "paths")
+
+	// Neither color nor an external diff driver may leak into the parsed output
+	git(t, dir, "config", "color.ui", "always")
+	git(t, dir, "config", "diff.external", "false")
+
+	repo, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Failed to open repository: %v", err)
+	}
+	mergeBase, err := repo.MergeBase("main", "feature")
+	if err != nil {
+		t.Fatalf("Failed to find merge-base: %v", err)
+	}
+
+	changes, err := repo.ChangedFiles(mergeBase, "feature")
+	if err != nil {
+		t.Fatalf("Failed to list changed files: %v", err)
+	}
+	want := []string{"docs/read me.md", "src/naïve.go", "src/new.go"}
+	if strings.Join(changes.Added, "|") != strings.Join(want, "|") {
+		t.Errorf("Expected added %q, got %q", want, changes.Added)
+	}
+	if len(changes.Renamed) != 1 || changes.Renamed[0] != (Rename{From: "src/old_name.go", To: "src/new_name.go"}) {
+		t.Errorf("Expected rename src/old_name.go -> src/new_name.go, got %v", changes.Renamed)
+	}
+
+	paths := map[string]bool{}
+	for _, stat := range changes.Stats {
+		paths[stat.Path] = true
+	}
+	for _, path := range append(want, "src/new_name.go", "src/keep.go") {
+		if !paths[path] {
+			t.Errorf("Expected statistics for %q, got %+v", path, changes.Stats)
+		}
+	}
+
+	diff, err := repo.Diff(mergeBase, "feature")
+	if err != nil {
+		t.Fatalf("Failed to generate diff: %v", err)
+	}
+	if strings.Contains(diff, "\x1b[") || !strings.Contains(diff, "+\treturn 2") {
+		t.Errorf("Expected a plain unified diff, got:\n%s", diff)
+	}
+}
@@ -2,17 +2,20 @@ module github.com/jeremyhunt/agent-runner
 
 go 1.20
 
-require github.com/joho/godotenv v1.5.1
+require (
+	github.com/andygrunwald/go-jira v1.16.0
+	github.com/joho/godotenv v1.5.1
+	github.com/pkoukk/tiktoken-go v0.1.7
+	github.com/sashabaranov/go-openai v1.40.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
-	github.com/andygrunwald/go-jira v1.16.0 // indirect
 	github.com/dlclark/regexp2 v1.10.0 // indirect
 	github.com/fatih/structs v1.1.0 // indirect
 	github.com/golang-jwt/jwt/v4 v4.4.2 // indirect
 	github.com/google/go-querystring v1.1.0 // indirect
 	github.com/google/uuid v1.3.0 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
-	github.com/pkoukk/tiktoken-go v0.1.7 // indirect
-	github.com/sashabaranov/go-openai v1.40.0 // indirect
 	github.com/trivago/tgo v1.0.7 // indirect
 )
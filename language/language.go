@@ -0,0 +1,374 @@
+// Package language detects the programming language of files and repositories and provides
+// the language-specific wording used in review prompts.
+package language
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Profile describes how prompts should refer to and illustrate a language
+type Profile struct {
+	// Name is the human-readable language name used in prompt intros (e.g. "Go")
+	Name string `json:"name"`
+	// Fence is the markdown code fence language (e.g. "go")
+	Fence string `json:"fence"`
+	// Extensions are the file extensions, including the dot, that identify the language
+	Extensions []string `json:"extensions"`
+	// Filenames are exact file names that identify the language (e.g. "Dockerfile")
+	Filenames []string `json:"filenames,omitempty"`
+	// Interpreters are the shebang interpreters that identify the language (e.g. "python3")
+	Interpreters []string `json:"interpreters,omitempty"`
+	// LineComment is the single-line comment prefix used in example snippets
+	LineComment string `json:"line_comment"`
+	// ExampleFile is a placeholder path used in example ISSUE blocks
+	ExampleFile string `json:"example_file"`
+	// ExampleSignature is a function signature that opens example diffs
+	ExampleSignature string `json:"example_signature"`
+	// ExampleBlockEnd closes the example function, if the language needs it
+	ExampleBlockEnd string `json:"example_block_end,omitempty"`
+	// ExampleOriginal is a line of code shown before a suggested fix
+	ExampleOriginal string `json:"example_original"`
+	// ExampleFixed is the same line after the suggested fix
+	ExampleFixed string `json:"example_fixed"`
+	// Frameworks are common frameworks of the language, given as examples when asking which one is used
+	Frameworks []string `json:"frameworks,omitempty"`
+}
+
+// IsGeneric reports whether the profile is the language-neutral fallback
+func (p *Profile) IsGeneric() bool {
+	return p.Name == ""
+}
+
+// Expertise returns the phrase used to describe the reviewer's expertise
+func (p *Profile) Expertise() string {
+	if p.IsGeneric() {
+		return "general software development"
+	}
+	return p.Name + " and general software development"
+}
+
+// generic is used when no profile matches a file or repository
+var generic = Profile{
+	LineComment:      "//",
+	ExampleFile:      "path/to/file",
+	ExampleSignature: "function processPayment(clientId) {",
+	ExampleBlockEnd:  "}",
+	ExampleOriginal:  "total = order.getTotal()",
+	ExampleFixed:     "total = order != null ? order.getTotal() : 0",
+}
+
+// builtinProfiles are the languages known without any configuration
+var builtinProfiles = []Profile{
+	{
+		Name:             "PHP",
+		Fence:            "php",
+		Extensions:       []string{".php", ".phtml"},
+		Interpreters:     []string{"php"},
+		LineComment:      "//",
+		ExampleFile:      "path/to/file.php",
+		ExampleSignature: "public function processPayment($clientId) {",
+		ExampleBlockEnd:  "}",
+		ExampleOriginal:  "$total = $order->getTotal();",
+		ExampleFixed:     "$total = $order?->getTotal() ?? 0;",
+		Frameworks:       []string{"Laravel", "Symfony", "CodeIgniter"},
+	},
+	{
+		Name:             "Go",
+		Fence:            "go",
+		Extensions:       []string{".go"},
+		LineComment:      "//",
+		ExampleFile:      "path/to/file.go",
+		ExampleSignature: "func (s *Service) ProcessPayment(ctx context.Context, clientID int) error {",
+		ExampleBlockEnd:  "}",
+		ExampleOriginal:  "total := order.Total()",
+		ExampleFixed:     "if order == nil {\n\treturn ErrOrderNotFound\n}\ntotal := order.Total()",
+		Frameworks:       []string{"Gin", "Echo", "Chi"},
+	},
+	{
+		Name:             "TypeScript",
+		Fence:            "typescript",
+		Extensions:       []string{".ts", ".tsx", ".mts", ".cts"},
+		Interpreters:     []string{"ts-node", "deno"},
+		LineComment:      "//",
+		ExampleFile:      "path/to/file.ts",
+		ExampleSignature: "async function processPayment(clientId: number): Promise<PaymentStatus> {",
+		ExampleBlockEnd:  "}",
+		ExampleOriginal:  "const total = order.getTotal();",
+		ExampleFixed:     "const total = order?.getTotal() ?? 0;",
+		Frameworks:       []string{"Angular", "NestJS", "Next.js"},
+	},
+	{
+		Name:             "JavaScript",
+		Fence:            "javascript",
+		Extensions:       []string{".js", ".jsx", ".mjs", ".cjs"},
+		Interpreters:     []string{"node"},
+		LineComment:      "//",
+		ExampleFile:      "path/to/file.js",
+		ExampleSignature: "async function processPayment(clientId) {",
+		ExampleBlockEnd:  "}",
+		ExampleOriginal:  "const total = order.getTotal();",
+		ExampleFixed:     "const total = order?.getTotal() ?? 0;",
+		Frameworks:       []string{"React", "Express", "Vue"},
+	},
+	{
+		Name:             "Python",
+		Fence:            "python",
+		Extensions:       []string{".py", ".pyi"},
+		Interpreters:     []string{"python", "python3"},
+		LineComment:      "#",
+		ExampleFile:      "path/to/file.py",
+		ExampleSignature: "def process_payment(self, client_id: int) -> PaymentStatus:",
+		ExampleOriginal:  "total = order.get_total()",
+		ExampleFixed:     "total = order.get_total() if order is not None else 0",
+		Frameworks:       []string{"Django", "Flask", "FastAPI"},
+	},
+	{
+		Name:             "Java",
+		Fence:            "java",
+		Extensions:       []string{".java"},
+		LineComment:      "//",
+		ExampleFile:      "path/to/File.java",
+		ExampleSignature: "public PaymentStatus processPayment(long clientId) {",
+		ExampleBlockEnd:  "}",
+		ExampleOriginal:  "BigDecimal total = order.getTotal();",
+		ExampleFixed:     "BigDecimal total = order != null ? order.getTotal() : BigDecimal.ZERO;",
+		Frameworks:       []string{"Spring Boot", "Jakarta EE", "Quarkus"},
+	},
+	{
+		Name:             "Kotlin",
+		Fence:            "kotlin",
+		Extensions:       []string{".kt", ".kts"},
+		LineComment:      "//",
+		ExampleFile:      "path/to/File.kt",
+		ExampleSignature: "fun processPayment(clientId: Long): PaymentStatus {",
+		ExampleBlockEnd:  "}",
+		ExampleOriginal:  "val total = order!!.total",
+		ExampleFixed:     "val total = order?.total ?: BigDecimal.ZERO",
+		Frameworks:       []string{"Spring Boot", "Ktor", "Android"},
+	},
+	{
+		Name:             "C#",
+		Fence:            "csharp",
+		Extensions:       []string{".cs"},
+		LineComment:      "//",
+		ExampleFile:      "path/to/File.cs",
+		ExampleSignature: "public PaymentStatus ProcessPayment(int clientId) {",
+		ExampleBlockEnd:  "}",
+		ExampleOriginal:  "var total = order.GetTotal();",
+		ExampleFixed:     "var total = order?.GetTotal() ?? 0m;",
+		Frameworks:       []string{"ASP.NET Core", "Entity Framework", "Blazor"},
+	},
+	{
+		Name:             "Ruby",
+		Fence:            "ruby",
+		Extensions:       []string{".rb", ".rake"},
+		Filenames:        []string{"Gemfile", "Rakefile"},
+		Interpreters:     []string{"ruby"},
+		LineComment:      "#",
+		ExampleFile:      "path/to/file.rb",
+		ExampleSignature: "def process_payment(client_id)",
+		ExampleBlockEnd:  "end",
+		ExampleOriginal:  "total = order.total",
+		ExampleFixed:     "total = order&.total || 0",
+		Frameworks:       []string{"Rails", "Sinatra", "Hanami"},
+	},
+	{
+		Name:             "Rust",
+		Fence:            "rust",
+		Extensions:       []string{".rs"},
+		LineComment:      "//",
+		ExampleFile:      "path/to/file.rs",
+		ExampleSignature: "pub fn process_payment(&self, client_id: u64) -> Result<PaymentStatus, Error> {",
+		ExampleBlockEnd:  "}",
+		ExampleOriginal:  "let total = order.unwrap().total();",
+		ExampleFixed:     "let total = order.ok_or(Error::OrderNotFound)?.total();",
+		Frameworks:       []string{"Actix Web", "Axum", "Rocket"},
+	},
+	{
+		Name:             "Shell",
+		Fence:            "bash",
+		Extensions:       []string{".sh", ".bash"},
+		Interpreters:     []string{"sh", "bash", "zsh"},
+		LineComment:      "#",
+		ExampleFile:      "path/to/script.sh",
+		ExampleSignature: "process_payment() {",
+		ExampleBlockEnd:  "}",
+		ExampleOriginal:  "total=$(get_total $order)",
+		ExampleFixed:     "total=$(get_total \"$order\") || return 1",
+	},
+	{
+		Name:             "SQL",
+		Fence:            "sql",
+		Extensions:       []string{".sql"},
+		LineComment:      "--",
+		ExampleFile:      "path/to/migration.sql",
+		ExampleSignature: "CREATE PROCEDURE process_payment(IN client_id INT)",
+		ExampleOriginal:  "SELECT total FROM orders WHERE client_id = client_id;",
+		ExampleFixed:     "SELECT total FROM orders o WHERE o.client_id = process_payment.client_id;",
+	},
+}
+
+// Registry holds the known language profiles
+type Registry struct {
+	profiles []*Profile
+}
+
+// NewRegistry creates a registry containing the built-in profiles
+func NewRegistry() *Registry {
+	r := &Registry{}
+	for _, profile := range builtinProfiles {
+		r.Register(profile)
+	}
+	return r
+}
+
+// Register adds a profile, replacing any existing profile with the same name
+func (r *Registry) Register(profile Profile) {
+	for i, existing := range r.profiles {
+		if strings.EqualFold(existing.Name, profile.Name) {
+			r.profiles[i] = &profile
+			return
+		}
+	}
+	r.profiles = append(r.profiles, &profile)
+}
+
+// LoadFile reads a JSON array of profiles and registers them, overriding built-ins with the same name
+func (r *Registry) LoadFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading language profiles: %w", err)
+	}
+
+	var profiles []Profile
+	if err := json.Unmarshal(content, &profiles); err != nil {
+		return fmt.Errorf("error parsing language profiles %s: %w", path, err)
+	}
+
+	for _, profile := range profiles {
+		if profile.Name == "" {
+			return fmt.Errorf("language profile in %s is missing a name", path)
+		}
+		r.Register(profile)
+	}
+	return nil
+}
+
+// Generic returns the language-neutral profile
+func (r *Registry) Generic() *Profile {
+	profile := generic
+	return &profile
+}
+
+// Lookup returns the profile with the given name, or nil if there is none
+func (r *Registry) Lookup(name string) *Profile {
+	for _, profile := range r.profiles {
+		if strings.EqualFold(profile.Name, name) {
+			return profile
+		}
+	}
+	return nil
+}
+
+// Detect returns the profile for a file based on its name, extension or shebang line.
+// The generic profile is returned when nothing matches.
+func (r *Registry) Detect(path, content string) *Profile {
+	if profile := r.detectByName(path); profile != nil {
+		return profile
+	}
+
+	if interpreter := shebangInterpreter(content); interpreter != "" {
+		for _, profile := range r.profiles {
+			for _, candidate := range profile.Interpreters {
+				if candidate == interpreter {
+					return profile
+				}
+			}
+		}
+	}
+
+	return r.Generic()
+}
+
+// DetectRepo returns the most common language among the given file paths.
+// Ties are broken by registration order and the generic profile is returned when nothing matches.
+func (r *Registry) DetectRepo(paths []string) *Profile {
+	counts := make(map[*Profile]int)
+	for _, path := range paths {
+		if profile := r.detectByName(path); profile != nil {
+			counts[profile]++
+		}
+	}
+
+	var best *Profile
+	for _, profile := range r.profiles {
+		if counts[profile] > 0 && (best == nil || counts[profile] > counts[best]) {
+			best = profile
+		}
+	}
+
+	if best == nil {
+		return r.Generic()
+	}
+	return best
+}
+
+// detectByName matches a path against the profiles' file names and extensions
+func (r *Registry) detectByName(path string) *Profile {
+	base := filepath.Base(path)
+	ext := strings.ToLower(filepath.Ext(base))
+
+	for _, profile := range r.profiles {
+		for _, name := range profile.Filenames {
+			if name == base {
+				return profile
+			}
+		}
+	}
+
+	if ext == "" {
+		return nil
+	}
+
+	for _, profile := range r.profiles {
+		for _, candidate := range profile.Extensions {
+			if strings.ToLower(candidate) == ext {
+				return profile
+			}
+		}
+	}
+	return nil
+}
+
+// shebangInterpreter returns the interpreter named on a "#!" first line, if any
+func shebangInterpreter(content string) string {
+	if !strings.HasPrefix(content, "#!") {
+		return ""
+	}
+
+	line := content[2:]
+	if idx := strings.IndexByte(line, '\n'); idx != -1 {
+		line = line[:idx]
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return ""
+	}
+
+	// "#!/usr/bin/env python3" names the interpreter as an argument
+	interpreter := filepath.Base(fields[0])
+	if interpreter == "env" {
+		for _, field := range fields[1:] {
+			if !strings.HasPrefix(field, "-") {
+				return field
+			}
+		}
+		return ""
+	}
+	return interpreter
+}
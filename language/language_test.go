@@ -0,0 +1,90 @@
+package language
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	registry := NewRegistry()
+
+	tests := []struct {
+		name     string
+		path     string
+		content  string
+		expected string
+	}{
+		{name: "PHP extension", path: "app/Domain/PayCycleDomain.php", expected: "PHP"},
+		{name: "Go extension", path: "review/review.go", expected: "Go"},
+		{name: "TSX extension", path: "src/components/Button.tsx", expected: "TypeScript"},
+		{name: "Uppercase extension", path: "scripts/Setup.PY", expected: "Python"},
+		{name: "Exact file name", path: "Gemfile", expected: "Ruby"},
+		{name: "Env shebang", path: "bin/migrate", content: "#!/usr/bin/env python3\nimport sys\n", expected: "Python"},
+		{name: "Direct shebang", path: "bin/deploy", content: "#!/bin/bash\nset -e\n", expected: "Shell"},
+		{name: "Unknown file", path: "README", content: "Hello", expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile := registry.Detect(tt.path, tt.content)
+			if profile.Name != tt.expected {
+				t.Errorf("Detect(%q) = %q, expected %q", tt.path, profile.Name, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDetectRepo(t *testing.T) {
+	registry := NewRegistry()
+
+	profile := registry.DetectRepo([]string{"main.go", "handler.go", "web/app.ts", "README.md"})
+	if profile.Name != "Go" {
+		t.Errorf("Expected Go, got %q", profile.Name)
+	}
+
+	profile = registry.DetectRepo([]string{"README.md", "LICENSE"})
+	if !profile.IsGeneric() {
+		t.Errorf("Expected generic profile, got %q", profile.Name)
+	}
+	if profile.Expertise() != "general software development" {
+		t.Errorf("Unexpected generic expertise %q", profile.Expertise())
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	registry := NewRegistry()
+
+	path := filepath.Join(t.TempDir(), "languages.json")
+	content := `[
+		{"name": "Elixir", "fence": "elixir", "extensions": [".ex", ".exs"], "line_comment": "#", "frameworks": ["Phoenix"]},
+		{"name": "go", "fence": "golang", "extensions": [".go"], "line_comment": "//"}
+	]`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write profiles: %v", err)
+	}
+
+	if err := registry.LoadFile(path); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if profile := registry.Detect("lib/app.ex", ""); profile.Name != "Elixir" || len(profile.Frameworks) != 1 {
+		t.Errorf("Expected the added Elixir profile, got %+v", profile)
+	}
+
+	// Profiles with an existing name replace the built-in one
+	if profile := registry.Lookup("Go"); profile == nil || profile.Fence != "golang" {
+		t.Errorf("Expected the Go profile to be overridden, got %+v", profile)
+	}
+
+	if err := registry.LoadFile(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("Expected error for a missing profiles file")
+	}
+
+	if err := os.WriteFile(path, []byte(`[{"fence": "x"}]`), 0644); err != nil {
+		t.Fatalf("Failed to write profiles: %v", err)
+	}
+	if err := registry.LoadFile(path); err == nil {
+		t.Error("Expected error for a profile without a name")
+	}
+}
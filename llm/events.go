@@ -0,0 +1,39 @@
+package llm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ReadEvents reads a server-sent event stream, passing the data of each event to handle until it
+// reports that the stream is done. A stream that ends before then is an error.
+func ReadEvents(body io.Reader, handle func(data string) (done bool, err error)) error {
+	scanner := bufio.NewScanner(body)
+	// Events carry a single delta, but allow for long lines from errors or large deltas
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// Blank lines separate events, lines starting with a colon are comments and
+		// the event type is repeated in the data
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+
+		done, err := handle(strings.TrimSpace(strings.TrimPrefix(line, "data:")))
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading stream: %w", err)
+	}
+	return fmt.Errorf("stream ended before completion")
+}
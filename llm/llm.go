@@ -0,0 +1,109 @@
+// Package llm defines the provider interface the review depends on and the pieces shared by its
+// backends: chat messages, options, usage and an HTTP transport with retries and timeouts.
+package llm
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// Message roles
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// ResponseReserve is the part of a model's context window kept free for the response
+const ResponseReserve = 8000
+
+// ResponseTokens returns the tokens kept free for a response: ResponseReserve, or the model's
+// max output if that is smaller (0 when unknown)
+func ResponseTokens(maxOutputTokens int) int {
+	if maxOutputTokens > 0 && maxOutputTokens < ResponseReserve {
+		return maxOutputTokens
+	}
+	return ResponseReserve
+}
+
+// PromptBudget returns the tokens a prompt may use with a model, leaving room for the response
+func PromptBudget(p Provider) int {
+	return p.ContextWindow() - ResponseTokens(p.MaxOutputTokens())
+}
+
+// Message is one message in a chat conversation
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// ChatOptions are optional sampling settings for a chat request. Zero values use the provider defaults.
+type ChatOptions struct {
+	// Temperature controls randomness, from 0 to 2
+	Temperature *float32
+
+	// MaxTokens limits the length of the response
+	MaxTokens int
+
+	// Seed asks the provider to sample deterministically, as far as it can
+	Seed *int
+
+	// Stop lists sequences where the provider stops generating
+	Stop []string
+
+	// ResponseSchema asks for a JSON response matching a schema. Only set it for providers that
+	// support it.
+	ResponseSchema *ResponseSchema
+
+	// OnDelta streams the response, receiving each piece of content as it arrives
+	OnDelta func(delta string)
+
+	// OnUsage receives the tokens the provider reports for the request once the response is complete
+	OnUsage func(usage Usage)
+}
+
+// ResponseSchema is a JSON Schema the response must match
+type ResponseSchema struct {
+	// Name identifies the schema to the provider
+	Name string
+
+	// Schema is the JSON Schema. Every property must be required and objects must not allow
+	// additional properties, as strict structured outputs require.
+	Schema json.RawMessage
+}
+
+// Usage is the token usage a provider reports for a request
+type Usage struct {
+	// PromptTokens counts every prompt token, including cached ones
+	PromptTokens int
+
+	// CachedTokens are prompt tokens served from the prompt cache, which are billed at a lower rate
+	CachedTokens int
+
+	// CompletionTokens counts the tokens of the response
+	CompletionTokens int
+}
+
+// Provider is a chat model the review sends its prompts to
+type Provider interface {
+	// Chat sends a conversation and returns the assistant's reply
+	Chat(ctx context.Context, messages []Message, opts ChatOptions) (string, error)
+
+	// CountText estimates the number of tokens text uses with the provider's model
+	CountText(text string) (int, error)
+
+	// ContextWindow is the number of tokens the model accepts, prompt and response together
+	ContextWindow() int
+
+	// MaxOutputTokens is the longest response the model can produce, or 0 if it isn't known
+	MaxOutputTokens() int
+
+	// SupportsResponseSchema reports whether the model can be asked for JSON matching a schema
+	SupportsResponseSchema() bool
+
+	// Model returns the model requests are sent to
+	Model() string
+
+	// WithModel returns a copy of the provider that sends requests to a different model
+	WithModel(model string) Provider
+}
@@ -0,0 +1,110 @@
+package llm
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryPolicy controls how requests are retried after transient failures
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first (1 disables retries)
+	MaxAttempts int
+
+	// BaseDelay is the backoff before the first retry; it doubles with each further attempt
+	BaseDelay time.Duration
+
+	// MaxDelay caps the exponential backoff
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy retries transient failures up to three times, backing off from one second
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   time.Second,
+	MaxDelay:    30 * time.Second,
+}
+
+// retryableStatus reports whether a response status indicates a transient failure worth retrying
+func retryableStatus(status int) bool {
+	switch status {
+	case http.StatusRequestTimeout,
+		http.StatusConflict,
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoff returns the jittered exponential delay before the given retry (1 for the first retry)
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < retry && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+
+	// Spread retries from concurrent requests over the second half of the delay
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// serverDelay returns how long the server asked us to wait before retrying, if it said.
+// Retry-After takes precedence; otherwise the later of the x-ratelimit-reset-* headers is used.
+func serverDelay(header http.Header, now time.Time) (time.Duration, bool) {
+	if value := strings.TrimSpace(header.Get("Retry-After")); value != "" {
+		if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds >= 0 {
+			return time.Duration(seconds * float64(time.Second)), true
+		}
+		if at, err := http.ParseTime(value); err == nil {
+			if delay := at.Sub(now); delay > 0 {
+				return delay, true
+			}
+			return 0, true
+		}
+	}
+
+	var delay time.Duration
+	found := false
+	for _, name := range []string{"x-ratelimit-reset-requests", "x-ratelimit-reset-tokens"} {
+		value := strings.TrimSpace(header.Get(name))
+		if value == "" {
+			continue
+		}
+		// Reset times are durations such as "1s", "6m0s" or "120ms"
+		reset, err := time.ParseDuration(value)
+		if err != nil {
+			continue
+		}
+		found = true
+		if reset > delay {
+			delay = reset
+		}
+	}
+	return delay, found
+}
+
+// sleep waits for the delay, returning early with the context's error if it is cancelled
+func sleep(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,51 @@
+package llm
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestServerDelay(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    time.Duration
+		found   bool
+	}{
+		{name: "No headers", found: false},
+		{name: "Retry-After seconds", headers: map[string]string{"Retry-After": "7"}, want: 7 * time.Second, found: true},
+		{name: "Retry-After date", headers: map[string]string{"Retry-After": "Mon, 01 Jan 2024 12:00:30 GMT"}, want: 30 * time.Second, found: true},
+		{name: "Rate limit resets", headers: map[string]string{"x-ratelimit-reset-requests": "120ms", "x-ratelimit-reset-tokens": "6m0s"}, want: 6 * time.Minute, found: true},
+		{name: "Retry-After takes precedence", headers: map[string]string{"Retry-After": "2", "x-ratelimit-reset-tokens": "1m"}, want: 2 * time.Second, found: true},
+		{name: "Unparseable values", headers: map[string]string{"Retry-After": "soon", "x-ratelimit-reset-tokens": "later"}, found: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			for key, value := range tt.headers {
+				header.Set(key, value)
+			}
+			got, found := serverDelay(header, now)
+			if got != tt.want || found != tt.found {
+				t.Errorf("serverDelay() = %v, %v; expected %v, %v", got, found, tt.want, tt.found)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 10, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	for retry, max := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 3: 400 * time.Millisecond, 8: time.Second} {
+		for i := 0; i < 20; i++ {
+			delay := policy.backoff(retry)
+			if delay < max/2 || delay > max {
+				t.Errorf("backoff(%d) = %v, expected between %v and %v", retry, delay, max/2, max)
+			}
+		}
+	}
+}
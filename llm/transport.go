@@ -0,0 +1,198 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/jeremyhunt/agent-runner/logger"
+)
+
+const (
+	// DefaultRequestTimeout bounds a single attempt of a non-streaming request, including reading the response
+	DefaultRequestTimeout = 5 * time.Minute
+
+	// DefaultStreamIdleTimeout bounds how long a streaming request may go without receiving data
+	DefaultStreamIdleTimeout = 90 * time.Second
+)
+
+// HTTPClient is an interface for HTTP clients
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Transport sends JSON requests to an LLM API, retrying transient failures and bounding each attempt
+type Transport struct {
+	HTTPClient  HTTPClient
+	RetryPolicy RetryPolicy
+
+	// RequestTimeout bounds each attempt of a non-streaming request (0 for no limit)
+	RequestTimeout time.Duration
+
+	// StreamIdleTimeout bounds the gap between events of a streaming request (0 for no limit)
+	StreamIdleTimeout time.Duration
+}
+
+// NewTransport creates a transport with the default retry policy and timeouts
+func NewTransport() Transport {
+	return Transport{
+		// Requests are bounded by their own deadlines, so a long stream isn't cut off part way
+		HTTPClient:        &http.Client{},
+		RetryPolicy:       DefaultRetryPolicy,
+		RequestTimeout:    DefaultRequestTimeout,
+		StreamIdleTimeout: DefaultStreamIdleTimeout,
+	}
+}
+
+// Post sends a JSON request, retrying transient failures according to the retry policy. The header
+// carries the provider's authentication. On success the caller must close the response body.
+// A streaming request is only retried until the stream starts, since deltas already delivered
+// can't be taken back.
+func (t Transport) Post(ctx context.Context, url string, header http.Header, body []byte, stream bool) (*http.Response, error) {
+	maxAttempts := t.RetryPolicy.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	// Streams only need to keep making progress; other requests must finish within the request timeout
+	timeout := t.RequestTimeout
+	if stream {
+		timeout = t.StreamIdleTimeout
+	}
+
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := context.WithCancelCause(ctx)
+		var timer *time.Timer
+		if timeout > 0 {
+			timer = time.AfterFunc(timeout, func() { cancel(errTimeout{timeout: timeout, idle: stream}) })
+		}
+		stopTimer := func() {
+			if timer != nil {
+				timer.Stop()
+			}
+		}
+
+		req, err := http.NewRequestWithContext(attemptCtx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			stopTimer()
+			cancel(nil)
+			return nil, fmt.Errorf("error creating request: %w", err)
+		}
+
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if stream {
+			req.Header.Set("Accept", "text/event-stream")
+		}
+
+		resp, err := t.HTTPClient.Do(req)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			stopTimer()
+		}
+
+		var failure error
+		delay := t.RetryPolicy.backoff(attempt)
+		switch {
+		case err != nil:
+			cause := context.Cause(attemptCtx)
+			cancel(nil)
+
+			// A cancelled context isn't a transient failure, but a timed-out attempt is
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("error sending request: %w", ctx.Err())
+			}
+			var timedOut errTimeout
+			if errors.As(cause, &timedOut) {
+				err = timedOut
+			}
+			failure = fmt.Errorf("error sending request: %w", err)
+		case resp.StatusCode == http.StatusOK:
+			body := &timedBody{ReadCloser: resp.Body, ctx: attemptCtx, timer: timer, cancel: cancel}
+			if stream {
+				body.idle = timeout
+			}
+			resp.Body = body
+			return resp, nil
+		default:
+			bodyBytes, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			cancel(nil)
+			failure = fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, string(bodyBytes))
+			if !retryableStatus(resp.StatusCode) {
+				return nil, failure
+			}
+			if wait, ok := serverDelay(resp.Header, time.Now()); ok {
+				delay = wait
+			}
+		}
+
+		if attempt >= maxAttempts {
+			if maxAttempts > 1 {
+				return nil, fmt.Errorf("giving up after %d attempts: %w", attempt, failure)
+			}
+			return nil, failure
+		}
+
+		logger.Verbose("Request to %s failed (%v), retrying in %s (attempt %d of %d)",
+			req.URL.Host, failure, delay.Round(time.Millisecond), attempt+1, maxAttempts)
+		if err := sleep(ctx, delay); err != nil {
+			return nil, fmt.Errorf("error sending request: %w", err)
+		}
+	}
+}
+
+// timedBody is a response body that cancels its request when a deadline passes. With an idle
+// timeout the deadline moves forward whenever data arrives, so a long stream isn't cut off.
+// The timer is nil when the request has no deadline.
+type timedBody struct {
+	io.ReadCloser
+	ctx    context.Context
+	timer  *time.Timer
+	idle   time.Duration
+	cancel context.CancelCauseFunc
+}
+
+// Read implements io.Reader
+func (b *timedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 && b.idle > 0 {
+		b.timer.Reset(b.idle)
+	}
+	if err != nil && err != io.EOF {
+		// Report the deadline rather than a bare "context canceled"
+		var timeout errTimeout
+		if errors.As(context.Cause(b.ctx), &timeout) {
+			err = timeout
+		}
+	}
+	return n, err
+}
+
+// Close implements io.Closer
+func (b *timedBody) Close() error {
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	err := b.ReadCloser.Close()
+	b.cancel(nil)
+	return err
+}
+
+// errTimeout is the cause of a request cancelled by its deadline
+type errTimeout struct {
+	timeout time.Duration
+	idle    bool
+}
+
+func (e errTimeout) Error() string {
+	if e.idle {
+		return fmt.Sprintf("no data received for %s", e.timeout)
+	}
+	return fmt.Sprintf("request timed out after %s", e.timeout)
+}
@@ -155,8 +155,8 @@ func Success(format string, args ...interface{}) {
 	}
 }
 
-// Complete prints a completion message for the entire process
-func Complete() {
+// Complete prints a completion message for the entire process, followed by the usage summary if there is one
+func Complete(usage string) {
 	if verbosity >= VerbosityNormal {
 		elapsed := time.Since(startTime)
 
@@ -165,6 +165,9 @@ func Complete() {
 		fmt.Println(strings.Repeat("-", 50))
 		fmt.Println("REVIEW COMPLETED SUCCESSFULLY")
 		fmt.Printf("Total time: %s\n", formatDuration(elapsed))
+		if usage != "" {
+			fmt.Printf("Usage: %s\n", usage)
+		}
 		fmt.Println()
 	}
 
@@ -184,3 +187,34 @@ func formatDuration(d time.Duration) string {
 	}
 	return fmt.Sprintf("%ds", seconds)
 }
+
+// Progress shows a live count of the tokens received while a response streams in
+type Progress struct {
+	label    string
+	tokens   int
+	lastDraw time.Time
+}
+
+// progressInterval limits how often the live counter is redrawn
+const progressInterval = 100 * time.Millisecond
+
+// StartProgress starts a live token counter for a streaming response
+func StartProgress(label string) *Progress {
+	return &Progress{label: label}
+}
+
+// Add counts a streamed delta. The API sends about one token per delta, so each delta counts as a token.
+func (p *Progress) Add(delta string) {
+	p.tokens++
+	if verbosity >= VerbosityNormal && time.Since(p.lastDraw) >= progressInterval {
+		p.lastDraw = time.Now()
+		fmt.Printf("\r  %s: %d tokens received", p.label, p.tokens)
+	}
+}
+
+// Done replaces the live counter with the final count
+func (p *Progress) Done() {
+	if verbosity >= VerbosityNormal && p.tokens > 0 {
+		fmt.Printf("\r  %s: %d tokens received\n", p.label, p.tokens)
+	}
+}
@@ -0,0 +1,175 @@
+// Package models describes the capabilities of LLM models: how their tokens are counted and how many fit.
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Info describes a model's tokenizer and limits
+type Info struct {
+	// Encoding is the tiktoken encoding of the model. Models without one, such as Claude, are
+	// estimated with cl100k_base.
+	Encoding string `json:"encoding,omitempty"`
+
+	// ContextWindow is the number of tokens the prompt and response share
+	ContextWindow int `json:"context_window,omitempty"`
+
+	// MaxOutputTokens is the longest response the model can produce
+	MaxOutputTokens int `json:"max_output_tokens,omitempty"`
+
+	// TokensPerMessage is the overhead of each chat message (0 uses DefaultTokensPerMessage)
+	TokensPerMessage int `json:"tokens_per_message,omitempty"`
+
+	// TokensPerName is the overhead of a message's name (0 uses DefaultTokensPerName)
+	TokensPerName int `json:"tokens_per_name,omitempty"`
+
+	// StructuredOutputs is true when the model can be asked for JSON matching a schema
+	StructuredOutputs bool `json:"structured_outputs,omitempty"`
+}
+
+// Per-message overheads of the current chat models
+const (
+	DefaultTokensPerMessage = 3
+	DefaultTokensPerName    = 1
+)
+
+// MessageOverhead returns the tokens added to each message and to each message name
+func (i Info) MessageOverhead() (perMessage, perName int) {
+	perMessage, perName = DefaultTokensPerMessage, DefaultTokensPerName
+	if i.TokensPerMessage != 0 {
+		perMessage = i.TokensPerMessage
+	}
+	if i.TokensPerName != 0 {
+		perName = i.TokensPerName
+	}
+	return perMessage, perName
+}
+
+// builtinModels are the published limits and capabilities of the OpenAI and Anthropic models when the
+// registry was last updated
+var builtinModels = map[string]Info{
+	"gpt-5":        {Encoding: "o200k_base", ContextWindow: 400000, MaxOutputTokens: 128000, StructuredOutputs: true},
+	"gpt-5-mini":   {Encoding: "o200k_base", ContextWindow: 400000, MaxOutputTokens: 128000, StructuredOutputs: true},
+	"gpt-5-nano":   {Encoding: "o200k_base", ContextWindow: 400000, MaxOutputTokens: 128000, StructuredOutputs: true},
+	"gpt-4.1":      {Encoding: "o200k_base", ContextWindow: 1047576, MaxOutputTokens: 32768, StructuredOutputs: true},
+	"gpt-4.1-mini": {Encoding: "o200k_base", ContextWindow: 1047576, MaxOutputTokens: 32768, StructuredOutputs: true},
+	"gpt-4.1-nano": {Encoding: "o200k_base", ContextWindow: 1047576, MaxOutputTokens: 32768, StructuredOutputs: true},
+	"gpt-4o":       {Encoding: "o200k_base", ContextWindow: 128000, MaxOutputTokens: 16384, StructuredOutputs: true},
+	"gpt-4o-mini":  {Encoding: "o200k_base", ContextWindow: 128000, MaxOutputTokens: 16384, StructuredOutputs: true},
+	"o1":           {Encoding: "o200k_base", ContextWindow: 200000, MaxOutputTokens: 100000, StructuredOutputs: true},
+	"o1-mini":      {Encoding: "o200k_base", ContextWindow: 128000, MaxOutputTokens: 65536},
+	"o3":           {Encoding: "o200k_base", ContextWindow: 200000, MaxOutputTokens: 100000, StructuredOutputs: true},
+	"o3-mini":      {Encoding: "o200k_base", ContextWindow: 200000, MaxOutputTokens: 100000, StructuredOutputs: true},
+	"o4-mini":      {Encoding: "o200k_base", ContextWindow: 200000, MaxOutputTokens: 100000, StructuredOutputs: true},
+	"gpt-4-turbo":  {Encoding: "cl100k_base", ContextWindow: 128000, MaxOutputTokens: 4096},
+	"gpt-4":        {Encoding: "cl100k_base", ContextWindow: 8192, MaxOutputTokens: 8192},
+	"gpt-4-32k":    {Encoding: "cl100k_base", ContextWindow: 32768, MaxOutputTokens: 8192},
+
+	"gpt-3.5-turbo": {Encoding: "cl100k_base", ContextWindow: 16385, MaxOutputTokens: 4096},
+	// The first gpt-3.5-turbo snapshot framed messages differently and drops the role when there's a name
+	"gpt-3.5-turbo-0301": {Encoding: "cl100k_base", ContextWindow: 4096, MaxOutputTokens: 4096, TokensPerMessage: 4, TokensPerName: -1},
+
+	"claude-opus-4-1":   {ContextWindow: 200000, MaxOutputTokens: 32000},
+	"claude-opus-4":     {ContextWindow: 200000, MaxOutputTokens: 32000},
+	"claude-sonnet-4-5": {ContextWindow: 200000, MaxOutputTokens: 64000},
+	"claude-sonnet-4":   {ContextWindow: 200000, MaxOutputTokens: 64000},
+	"claude-haiku-4-5":  {ContextWindow: 200000, MaxOutputTokens: 64000},
+	"claude-3-7-sonnet": {ContextWindow: 200000, MaxOutputTokens: 64000},
+	"claude-3-5-haiku":  {ContextWindow: 200000, MaxOutputTokens: 8192},
+}
+
+// Registry holds the capabilities of the known models
+type Registry struct {
+	models map[string]Info
+}
+
+// NewRegistry creates a registry containing the built-in models
+func NewRegistry() *Registry {
+	r := &Registry{models: make(map[string]Info)}
+	for model, info := range builtinModels {
+		r.Set(model, info)
+	}
+	return r
+}
+
+// Set sets the capabilities of a model, replacing any existing entry
+func (r *Registry) Set(model string, info Info) {
+	r.models[strings.ToLower(model)] = info
+}
+
+// LoadFile reads a JSON object of model capabilities keyed by model and adds them. Fields set for a
+// model the registry already knows override its built-in values; the others are kept.
+func (r *Registry) LoadFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading models: %w", err)
+	}
+
+	var models map[string]Info
+	if err := json.Unmarshal(content, &models); err != nil {
+		return fmt.Errorf("error parsing models %s: %w", path, err)
+	}
+	// Flags can be turned off, so whether they are set is read separately
+	var fields map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(content, &fields); err != nil {
+		return fmt.Errorf("error parsing models %s: %w", path, err)
+	}
+
+	for model, override := range models {
+		if override.ContextWindow < 0 || override.MaxOutputTokens < 0 {
+			return fmt.Errorf("model %s in %s has a negative limit", model, path)
+		}
+		if override.MaxOutputTokens > 0 && override.ContextWindow > 0 && override.MaxOutputTokens > override.ContextWindow {
+			return fmt.Errorf("model %s in %s has a max output larger than its context window", model, path)
+		}
+
+		info, _ := r.Lookup(model)
+		if override.Encoding != "" {
+			info.Encoding = override.Encoding
+		}
+		if override.ContextWindow != 0 {
+			info.ContextWindow = override.ContextWindow
+		}
+		if override.MaxOutputTokens != 0 {
+			info.MaxOutputTokens = override.MaxOutputTokens
+		}
+		if override.TokensPerMessage != 0 {
+			info.TokensPerMessage = override.TokensPerMessage
+		}
+		if override.TokensPerName != 0 {
+			info.TokensPerName = override.TokensPerName
+		}
+		if _, ok := fields[model]["structured_outputs"]; ok {
+			info.StructuredOutputs = override.StructuredOutputs
+		}
+		r.Set(model, info)
+	}
+	return nil
+}
+
+// Lookup returns the capabilities of a model. Dated snapshots such as gpt-4o-2024-08-06 use the entry
+// of the longest model name they start with. ok is false when the model is unknown or the registry is nil.
+func (r *Registry) Lookup(model string) (info Info, ok bool) {
+	if r == nil {
+		return Info{}, false
+	}
+
+	model = strings.ToLower(model)
+	if info, ok := r.models[model]; ok {
+		return info, true
+	}
+
+	best := ""
+	for name := range r.models {
+		if strings.HasPrefix(model, name+"-") && len(name) > len(best) {
+			best = name
+		}
+	}
+	if best == "" {
+		return Info{}, false
+	}
+	return r.models[best], true
+}
@@ -0,0 +1,94 @@
+package models
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	registry := NewRegistry()
+
+	tests := []struct {
+		name          string
+		model         string
+		contextWindow int
+		encoding      string
+		wantOK        bool
+	}{
+		{name: "Exact model", model: "gpt-4o", contextWindow: 128000, encoding: "o200k_base", wantOK: true},
+		{name: "Dated snapshot", model: "gpt-4.1-2025-04-14", contextWindow: 1047576, encoding: "o200k_base", wantOK: true},
+		{name: "Longest prefix wins", model: "o3-mini-2025-01-31", contextWindow: 200000, encoding: "o200k_base", wantOK: true},
+		{name: "Claude", model: "claude-sonnet-4-5-20250929", contextWindow: 200000, wantOK: true},
+		{name: "Unknown model", model: "llama3.1:8b", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, ok := registry.Lookup(tt.model)
+			if ok != tt.wantOK || info.ContextWindow != tt.contextWindow || info.Encoding != tt.encoding {
+				t.Errorf("Lookup(%q) = %+v, %v; expected %d tokens with %q, %v", tt.model, info, ok, tt.contextWindow, tt.encoding, tt.wantOK)
+			}
+		})
+	}
+
+	var nilRegistry *Registry
+	if _, ok := nilRegistry.Lookup("gpt-4o"); ok {
+		t.Error("Expected a nil registry to know no models")
+	}
+}
+
+func TestMessageOverhead(t *testing.T) {
+	registry := NewRegistry()
+
+	info, _ := registry.Lookup("gpt-4o")
+	if perMessage, perName := info.MessageOverhead(); perMessage != 3 || perName != 1 {
+		t.Errorf("Expected the default overhead, got %d and %d", perMessage, perName)
+	}
+	info, _ = registry.Lookup("gpt-3.5-turbo-0301")
+	if perMessage, perName := info.MessageOverhead(); perMessage != 4 || perName != -1 {
+		t.Errorf("Expected the gpt-3.5-turbo-0301 overhead, got %d and %d", perMessage, perName)
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	registry := NewRegistry()
+
+	path := filepath.Join(t.TempDir(), "models.json")
+	content := `{
+		"gpt-4o": {"context_window": 64000},
+		"gpt-4o-mini": {"structured_outputs": false},
+		"llama3.1:8b": {"encoding": "cl100k_base", "context_window": 131072, "max_output_tokens": 4096, "structured_outputs": true}
+	}`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write models: %v", err)
+	}
+	if err := registry.LoadFile(path); err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+
+	// Overrides keep the built-in values they don't set
+	if info, _ := registry.Lookup("gpt-4o"); info.ContextWindow != 64000 || info.MaxOutputTokens != 16384 || info.Encoding != "o200k_base" ||
+		!info.StructuredOutputs {
+		t.Errorf("Expected the file to override the gpt-4o context window only, got %+v", info)
+	}
+	if info, ok := registry.Lookup("llama3.1:8b"); !ok || info.ContextWindow != 131072 || !info.StructuredOutputs {
+		t.Errorf("Expected llama3.1:8b from the file, got %+v", info)
+	}
+	if info, ok := registry.Lookup("gpt-4o-mini"); !ok || info.ContextWindow != 128000 || info.StructuredOutputs {
+		t.Errorf("Expected structured outputs to be turned off for gpt-4o-mini only, got %+v", info)
+	}
+
+	for _, invalid := range []string{
+		`{"gpt-4o": {"context_window": -1}}`,
+		`{"tiny": {"context_window": 4096, "max_output_tokens": 8192}}`,
+		`not json`,
+	} {
+		if err := os.WriteFile(path, []byte(invalid), 0644); err != nil {
+			t.Fatalf("Failed to write models: %v", err)
+		}
+		if err := registry.LoadFile(path); err == nil {
+			t.Errorf("Expected an error loading %s", invalid)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jeremyhunt/agent-runner/llm"
+	"github.com/jeremyhunt/agent-runner/models"
+)
+
+func TestChat(t *testing.T) {
+	var received ChatCompletionRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = ChatCompletionRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		fmt.Fprint(w, completionBody)
+	}))
+	defer server.Close()
+	client := newTestClient(server, DefaultRetryPolicy)
+
+	temperature := float32(0.2)
+	seed := 42
+	messages := []Message{
+		{Role: RoleSystem, Content: "You are a reviewer."},
+		{Role: RoleUser, Content: "Review this."},
+		{Role: RoleAssistant, Content: "Looks fine."},
+		{Role: RoleUser, Content: "Look again."},
+	}
+	response, err := client.Chat(context.Background(), messages, ChatOptions{
+		Temperature: &temperature,
+		MaxTokens:   500,
+		Seed:        &seed,
+		Stop:        []string{"</ISSUE>"},
+	})
+	if err != nil || response != "Hello" {
+		t.Fatalf("Expected Hello, got %q (error: %v)", response, err)
+	}
+
+	if !reflect.DeepEqual(received.Messages, messages) {
+		t.Errorf("Expected messages %v, got %v", messages, received.Messages)
+	}
+	if received.Temperature == nil || *received.Temperature != temperature || received.MaxTokens != 500 ||
+		received.Seed == nil || *received.Seed != seed || !reflect.DeepEqual(received.Stop, []string{"</ISSUE>"}) {
+		t.Errorf("Unexpected options in request: %+v", received)
+	}
+
+	// Complete sends a single user message without options
+	if _, err := client.Complete(context.Background(), "Hi"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(received.Messages, []Message{{Role: RoleUser, Content: "Hi"}}) {
+		t.Errorf("Unexpected Complete messages %v", received.Messages)
+	}
+	if received.Temperature != nil || received.MaxTokens != 0 || received.Seed != nil || received.Stop != nil ||
+		received.ResponseFormat != nil {
+		t.Errorf("Expected no options in a Complete request, got %+v", received)
+	}
+
+	// A response schema is sent as a strict JSON schema response format
+	schema := json.RawMessage(`{"type":"object","properties":{},"required":[],"additionalProperties":false}`)
+	if _, err := client.Chat(context.Background(), messages, ChatOptions{
+		ResponseSchema: &llm.ResponseSchema{Name: "review", Schema: schema},
+	}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	format := received.ResponseFormat
+	if format == nil || format.Type != "json_schema" || format.JSONSchema == nil || format.JSONSchema.Name != "review" ||
+		!format.JSONSchema.Strict || string(format.JSONSchema.Schema) != string(schema) {
+		t.Errorf("Unexpected response format %+v", format)
+	}
+
+	if _, err := client.Chat(context.Background(), nil, ChatOptions{}); err == nil {
+		t.Error("Expected an error for an empty conversation")
+	}
+}
+
+func TestChatStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request ChatCompletionRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || !request.Stream ||
+			request.StreamOptions == nil || !request.StreamOptions.IncludeUsage {
+			t.Errorf("Expected a streaming request that includes usage, got %+v (error: %v)", request, err)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range []string{
+			`: keep-alive`,
+			`data: {"choices": [{"delta": {"role": "assistant"}}]}`,
+			`data: {"choices": [{"delta": {"content": "Hel"}}]}`,
+			`data: {"choices": [{"delta": {"content": "lo"}}]}`,
+			`data: {"choices": [], "usage": {"prompt_tokens": 12, "completion_tokens": 2, "total_tokens": 14}}`,
+			`data: [DONE]`,
+		} {
+			fmt.Fprintf(w, "%s\n\n", event)
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer server.Close()
+
+	var deltas []string
+	var usage Usage
+	response, err := newTestClient(server, DefaultRetryPolicy).Chat(context.Background(),
+		[]Message{{Role: RoleUser, Content: "Hi"}},
+		ChatOptions{
+			OnDelta: func(delta string) { deltas = append(deltas, delta) },
+			OnUsage: func(u Usage) { usage = u },
+		})
+	if err != nil || response != "Hello" {
+		t.Fatalf("Expected Hello, got %q (error: %v)", response, err)
+	}
+	if !reflect.DeepEqual(deltas, []string{"Hel", "lo"}) {
+		t.Errorf("Unexpected deltas %v", deltas)
+	}
+	if usage.PromptTokens != 12 || usage.CompletionTokens != 2 {
+		t.Errorf("Unexpected usage %+v", usage)
+	}
+}
+
+func TestChatUsage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"choices": [{"index": 0, "message": {"role": "assistant", "content": "Hello"}}],
+			"usage": {"prompt_tokens": 1200, "completion_tokens": 80, "total_tokens": 1280,
+				"prompt_tokens_details": {"cached_tokens": 1024}}}`)
+	}))
+	defer server.Close()
+
+	var usage Usage
+	reported := 0
+	_, err := newTestClient(server, DefaultRetryPolicy).Chat(context.Background(),
+		[]Message{{Role: RoleUser, Content: "Hi"}},
+		ChatOptions{OnUsage: func(u Usage) { usage = u; reported++ }})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if reported != 1 || usage.PromptTokens != 1200 || usage.CompletionTokens != 80 || usage.CachedTokens != 1024 {
+		t.Errorf("Unexpected usage %+v (reported %d times)", usage, reported)
+	}
+}
+
+func TestReadStreamErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		stream  string
+		wantErr string
+	}{
+		{name: "Error event", stream: "data: {\"error\": {\"message\": \"overloaded\"}}\n\n", wantErr: "overloaded"},
+		{name: "Truncated stream", stream: "data: {\"choices\": [{\"delta\": {\"content\": \"Hel\"}}]}\n\n", wantErr: "stream ended before completion"},
+		{name: "Malformed event", stream: "data: {\n\n", wantErr: "error decoding stream event"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := readStream(strings.NewReader(tt.stream), func(string) {})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestStreamIdleTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Keep sending within the idle timeout for longer than the timeout, then stall
+		for i := 0; i < 4; i++ {
+			fmt.Fprintf(w, "data: {\"choices\": [{\"delta\": {\"content\": \"%d\"}}]}\n\n", i)
+			w.(http.Flusher).Flush()
+			time.Sleep(30 * time.Millisecond)
+		}
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client := newTestClient(server, DefaultRetryPolicy)
+	client.transport.StreamIdleTimeout = 80 * time.Millisecond
+
+	var received strings.Builder
+	_, err := client.Chat(context.Background(), []Message{{Role: RoleUser, Content: "Hi"}},
+		ChatOptions{OnDelta: func(delta string) { received.WriteString(delta) }})
+	if err == nil || !strings.Contains(err.Error(), "no data received for 80ms") {
+		t.Errorf("Expected an idle timeout, got %v", err)
+	}
+
+	// The stream wasn't cut off while data kept arriving
+	if received.String() != "0123" {
+		t.Errorf("Expected every delta before the stall, got %q", received.String())
+	}
+}
+
+func TestRequestTimeoutIsRetried(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			time.Sleep(200 * time.Millisecond)
+		}
+		fmt.Fprint(w, completionBody)
+	}))
+	defer server.Close()
+
+	client := newTestClient(server, RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+	client.transport.RequestTimeout = 50 * time.Millisecond
+	if response, err := client.Complete(context.Background(), "Hi"); err != nil || response != "Hello" {
+		t.Errorf("Expected the timed-out attempt to be retried, got %q (error: %v)", response, err)
+	}
+	if attempts != 2 {
+		t.Errorf("Expected 2 attempts, got %d", attempts)
+	}
+}
+
+func TestProviderEndpoints(t *testing.T) {
+	var path, query string
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, query, header = r.URL.Path, r.URL.RawQuery, r.Header
+		fmt.Fprint(w, completionBody)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		client     *Client
+		wantPath   string
+		wantQuery  string
+		wantHeader string
+		wantValue  string
+	}{
+		{name: "Azure deployment", client: NewAzureClient(server.URL+"/", "azure-key", "review-gpt4o", "2024-10-21"),
+			wantPath: "/openai/deployments/review-gpt4o/chat/completions", wantQuery: "api-version=2024-10-21",
+			wantHeader: "Api-Key", wantValue: "azure-key"},
+		{name: "Compatible server", client: NewCompatibleClient(server.URL+"/v1", "", "llama3.1"),
+			wantPath: "/v1/chat/completions", wantHeader: "Authorization", wantValue: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := tt.client.WithRetryPolicy(DefaultRetryPolicy)
+			client.transport.HTTPClient = server.Client()
+			client.tokenCounter = fixedCounter{}
+			if _, err := client.Complete(context.Background(), "Hi"); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if path != tt.wantPath || query != tt.wantQuery {
+				t.Errorf("Expected %s?%s, got %s?%s", tt.wantPath, tt.wantQuery, path, query)
+			}
+			if header.Get(tt.wantHeader) != tt.wantValue {
+				t.Errorf("Expected %s header %q, got %q", tt.wantHeader, tt.wantValue, header.Get(tt.wantHeader))
+			}
+		})
+	}
+}
+
+func TestContextWindow(t *testing.T) {
+	registry := models.NewRegistry()
+	registry.Set("llama3.1", models.Info{ContextWindow: 32768, MaxOutputTokens: 2048})
+
+	tests := []struct {
+		name       string
+		client     *Client
+		wantWindow int
+		wantBudget int
+		wantSchema bool
+	}{
+		{name: "Registry model", client: NewClient("key", "gpt-4.1-2025-04-14"), wantWindow: 1047576, wantBudget: 1047576 - llm.ResponseReserve,
+			wantSchema: true},
+		{name: "Unknown model", client: NewCompatibleClient("http://localhost:11434/v1", "", "mistral"), wantWindow: DefaultContextWindow,
+			wantBudget: DefaultContextWindow - llm.ResponseReserve},
+		{name: "Small max output", client: NewCompatibleClient("http://localhost:11434/v1", "", "llama3.1").WithModels(registry),
+			wantWindow: 32768, wantBudget: 32768 - 2048},
+		{name: "Configured window", client: NewClient("key", "gpt-4o").WithContextWindow(64000), wantWindow: 64000, wantBudget: 64000 - llm.ResponseReserve,
+			wantSchema: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if window := tt.client.ContextWindow(); window != tt.wantWindow {
+				t.Errorf("Expected a context window of %d, got %d", tt.wantWindow, window)
+			}
+			if budget := llm.PromptBudget(tt.client); budget != tt.wantBudget {
+				t.Errorf("Expected a prompt budget of %d, got %d", tt.wantBudget, budget)
+			}
+			if schema := tt.client.SupportsResponseSchema(); schema != tt.wantSchema {
+				t.Errorf("Expected structured output support to be %v", tt.wantSchema)
+			}
+		})
+	}
+}
@@ -1,23 +1,22 @@
 package openai
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
-	"time"
+	"net/url"
+	"strings"
 
+	"github.com/jeremyhunt/agent-runner/llm"
 	"github.com/jeremyhunt/agent-runner/logger"
+	"github.com/jeremyhunt/agent-runner/models"
 	"github.com/jeremyhunt/agent-runner/tokens"
 	"github.com/sashabaranov/go-openai"
 )
 
 // HTTPClient is an interface for HTTP clients
-type HTTPClient interface {
-	Do(req *http.Request) (*http.Response, error)
-}
+type HTTPClient = llm.HTTPClient
 
 // TokenCounter is an interface for token counting
 type TokenCounter interface {
@@ -25,32 +24,205 @@ type TokenCounter interface {
 	CountMessages(messages []openai.ChatCompletionMessage, model string) (int, error)
 }
 
-// Client represents an OpenAI API client
+// RetryPolicy controls how requests are retried after transient failures
+type RetryPolicy = llm.RetryPolicy
+
+// DefaultRetryPolicy retries transient failures up to three times, backing off from one second
+var DefaultRetryPolicy = llm.DefaultRetryPolicy
+
+// DefaultContextWindow is the context window assumed for models missing from the model registry, which is that of GPT-4o
+const DefaultContextWindow = 128000
+
+// Client is a client for the OpenAI chat completion API. It also serves Azure OpenAI deployments
+// and OpenAI-compatible servers such as Ollama or vLLM.
 type Client struct {
 	apiKey       string
-	httpClient   HTTPClient
+	transport    llm.Transport
 	baseURL      string
 	model        string
 	tokenCounter TokenCounter
+
+	// contextWindow overrides the model's context window (0 uses the registry)
+	contextWindow int
+
+	// models gives the context window and max output of the known models
+	models *models.Registry
+
+	// apiVersion is set for Azure OpenAI, where the model is the name of a deployment
+	apiVersion string
 }
 
 // NewClient creates a new OpenAI client
 func NewClient(apiKey, model string) *Client {
 	return &Client{
-		apiKey: apiKey,
-		httpClient: &http.Client{
-			Timeout: 90 * time.Second,
-		},
+		apiKey:       apiKey,
+		transport:    llm.NewTransport(),
 		baseURL:      "https://api.openai.com/v1",
 		model:        model,
 		tokenCounter: tokens.NewCounter(),
+		models:       models.NewRegistry(),
+	}
+}
+
+// NewAzureClient creates a client for an Azure OpenAI resource, such as https://NAME.openai.azure.com.
+// Requests go to the given deployment using the api-version query parameter.
+func NewAzureClient(endpoint, apiKey, deployment, apiVersion string) *Client {
+	client := NewClient(apiKey, deployment)
+	client.baseURL = strings.TrimSuffix(endpoint, "/")
+	client.apiVersion = apiVersion
+	return client
+}
+
+// NewCompatibleClient creates a client for a server implementing the OpenAI chat completion API,
+// such as Ollama (http://localhost:11434/v1) or vLLM. The API key may be empty.
+func NewCompatibleClient(baseURL, apiKey, model string) *Client {
+	client := NewClient(apiKey, model)
+	client.baseURL = strings.TrimSuffix(baseURL, "/")
+	return client
+}
+
+// WithRetryPolicy returns a copy of the client that retries transient failures according to the policy
+func (c *Client) WithRetryPolicy(policy RetryPolicy) *Client {
+	clone := *c
+	clone.transport.RetryPolicy = policy
+	return &clone
+}
+
+// WithHTTPClient returns a copy of the client that sends requests with another HTTP client, such as a
+// cassette recorder or player
+func (c *Client) WithHTTPClient(client HTTPClient) *Client {
+	clone := *c
+	clone.transport.HTTPClient = client
+	return &clone
+}
+
+// WithModels returns a copy of the client that looks up model limits and tokenizers in a registry
+func (c *Client) WithModels(registry *models.Registry) *Client {
+	clone := *c
+	clone.models = registry
+	clone.tokenCounter = tokens.NewCounterWithRegistry(registry)
+	return &clone
+}
+
+// WithContextWindow returns a copy of the client that assumes the model has a different context window
+func (c *Client) WithContextWindow(tokens int) *Client {
+	clone := *c
+	clone.contextWindow = tokens
+	return &clone
+}
+
+// WithModel implements llm.Provider. For Azure OpenAI the model is a deployment name.
+func (c *Client) WithModel(model string) llm.Provider {
+	clone := *c
+	clone.model = model
+	return &clone
+}
+
+// Model implements llm.Provider
+func (c *Client) Model() string {
+	return c.model
+}
+
+// Endpoint returns the base URL requests are sent to, with the API version for Azure OpenAI
+func (c *Client) Endpoint() string {
+	if c.apiVersion != "" {
+		return c.baseURL + "?api-version=" + c.apiVersion
+	}
+	return c.baseURL
+}
+
+// ContextWindow implements llm.Provider
+func (c *Client) ContextWindow() int {
+	if c.contextWindow > 0 {
+		return c.contextWindow
+	}
+	if info, ok := c.models.Lookup(c.model); ok && info.ContextWindow > 0 {
+		return info.ContextWindow
+	}
+	return DefaultContextWindow
+}
+
+// MaxOutputTokens implements llm.Provider
+func (c *Client) MaxOutputTokens() int {
+	info, _ := c.models.Lookup(c.model)
+	return info.MaxOutputTokens
+}
+
+// SupportsResponseSchema implements llm.Provider. The registry records which models support structured
+// outputs; an Azure deployment or OpenAI-compatible model is only known if it is named after one.
+func (c *Client) SupportsResponseSchema() bool {
+	info, _ := c.models.Lookup(c.model)
+	return info.StructuredOutputs
+}
+
+// Message roles
+const (
+	RoleSystem    = llm.RoleSystem
+	RoleUser      = llm.RoleUser
+	RoleAssistant = llm.RoleAssistant
+)
+
+// Message is one message in a chat conversation
+type Message = llm.Message
+
+// ChatOptions are optional sampling settings for a chat request
+type ChatOptions = llm.ChatOptions
+
+// Usage is the token usage the API reports for a request
+type Usage = llm.Usage
+
+// usage is the token usage as the API reports it
+type usage struct {
+	PromptTokens        int `json:"prompt_tokens"`
+	CompletionTokens    int `json:"completion_tokens"`
+	PromptTokensDetails struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"prompt_tokens_details"`
+}
+
+// toUsage converts the reported usage
+func (u usage) toUsage() Usage {
+	return Usage{
+		PromptTokens:     u.PromptTokens,
+		CachedTokens:     u.PromptTokensDetails.CachedTokens,
+		CompletionTokens: u.CompletionTokens,
 	}
 }
 
+// StreamOptions configures a streaming request
+type StreamOptions struct {
+	// IncludeUsage asks for a final event carrying the request's token usage
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// ResponseFormat asks for a JSON response matching a schema
+type ResponseFormat struct {
+	Type       string      `json:"type"`
+	JSONSchema *JSONSchema `json:"json_schema,omitempty"`
+}
+
+// JSONSchema is the schema of a structured output
+type JSONSchema struct {
+	Name   string          `json:"name"`
+	Schema json.RawMessage `json:"schema"`
+	Strict bool            `json:"strict"`
+}
+
 // ChatCompletionRequest represents a request to the chat completion API
 type ChatCompletionRequest struct {
-	Model    string                         `json:"model"`
-	Messages []openai.ChatCompletionMessage `json:"messages"`
+	Model       string    `json:"model"`
+	Messages    []Message `json:"messages"`
+	Temperature *float32  `json:"temperature,omitempty"`
+	MaxTokens   int       `json:"max_tokens,omitempty"`
+	Seed        *int      `json:"seed,omitempty"`
+	Stop        []string  `json:"stop,omitempty"`
+	Stream      bool      `json:"stream,omitempty"`
+
+	// ResponseFormat is only sent when a response schema is requested
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+
+	// StreamOptions is only sent with streaming requests
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"`
 }
 
 // ChatCompletionResponse represents a response from the chat completion API
@@ -65,27 +237,28 @@ type ChatCompletionResponse struct {
 			Content string `json:"content"`
 		} `json:"message"`
 	} `json:"choices"`
+	Usage *usage `json:"usage"`
 }
 
-// Complete sends a prompt to the OpenAI API and returns the response
+// Complete sends a prompt to the OpenAI API as a single user message and returns the response
 func (c *Client) Complete(ctx context.Context, prompt string) (string, error) {
-	// Create the message
-	messages := []openai.ChatCompletionMessage{
-		{
-			Role:    "user",
-			Content: prompt,
-		},
+	return c.Chat(ctx, []Message{{Role: RoleUser, Content: prompt}}, ChatOptions{})
+}
+
+// Chat implements llm.Provider, sending a conversation to the chat completion API
+func (c *Client) Chat(ctx context.Context, messages []Message, opts ChatOptions) (string, error) {
+	if len(messages) == 0 {
+		return "", fmt.Errorf("no messages to send")
 	}
 
-	// Count tokens in the prompt
-	tokenCount, err := c.CountTokens(messages)
+	// Count tokens in the conversation
+	tokenCount, err := c.CountTokens(chatCompletionMessages(messages))
 	if err != nil {
 		return "", fmt.Errorf("error counting tokens: %w", err)
 	}
 
-	// Get the maximum token limit for the model
-	// GPT-4o has a 128K token limit, but we'll be conservative
-	maxTokens := 120000
+	// Leave room in the context window for the response
+	maxTokens := llm.PromptBudget(c)
 
 	// Check if the token count exceeds the maximum limit
 	if tokenCount > maxTokens {
@@ -93,12 +266,26 @@ func (c *Client) Complete(ctx context.Context, prompt string) (string, error) {
 	}
 
 	// Log the token count
-	logger.Verbose("Sending prompt to %s (token count: %d)", c.model, tokenCount)
+	logger.Verbose("Sending %d messages to %s (token count: %d)", len(messages), c.model, tokenCount)
 
 	// Create the request body
 	reqBody := ChatCompletionRequest{
-		Model:    c.model,
-		Messages: messages,
+		Model:       c.model,
+		Messages:    messages,
+		Temperature: opts.Temperature,
+		MaxTokens:   opts.MaxTokens,
+		Seed:        opts.Seed,
+		Stop:        opts.Stop,
+		Stream:      opts.OnDelta != nil,
+	}
+	if reqBody.Stream {
+		reqBody.StreamOptions = &StreamOptions{IncludeUsage: true}
+	}
+	if opts.ResponseSchema != nil {
+		reqBody.ResponseFormat = &ResponseFormat{
+			Type:       "json_schema",
+			JSONSchema: &JSONSchema{Name: opts.ResponseSchema.Name, Schema: opts.ResponseSchema.Schema, Strict: true},
+		}
 	}
 
 	reqBytes, err := json.Marshal(reqBody)
@@ -106,28 +293,21 @@ func (c *Client) Complete(ctx context.Context, prompt string) (string, error) {
 		return "", fmt.Errorf("error marshaling request: %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(
-		ctx,
-		http.MethodPost,
-		fmt.Sprintf("%s/chat/completions", c.baseURL),
-		bytes.NewReader(reqBytes),
-	)
+	resp, err := c.transport.Post(ctx, c.endpoint("/chat/completions"), c.header(), reqBytes, reqBody.Stream)
 	if err != nil {
-		return "", fmt.Errorf("error creating request: %w", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.apiKey))
-
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("error sending request: %w", err)
+		return "", err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, string(bodyBytes))
+	if reqBody.Stream {
+		content, usage, err := readStream(resp.Body, opts.OnDelta)
+		if err != nil {
+			return "", err
+		}
+		if usage != nil && opts.OnUsage != nil {
+			opts.OnUsage(usage.toUsage())
+		}
+		return content, nil
 	}
 
 	var result ChatCompletionResponse
@@ -138,16 +318,49 @@ func (c *Client) Complete(ctx context.Context, prompt string) (string, error) {
 	if len(result.Choices) == 0 {
 		return "", fmt.Errorf("no choices in response")
 	}
+	if result.Usage != nil && opts.OnUsage != nil {
+		opts.OnUsage(result.Usage.toUsage())
+	}
 
 	return result.Choices[0].Message.Content, nil
 }
 
+// chatCompletionMessages converts messages to the form the token counter accepts
+func chatCompletionMessages(messages []Message) []openai.ChatCompletionMessage {
+	converted := make([]openai.ChatCompletionMessage, len(messages))
+	for i, message := range messages {
+		converted[i] = openai.ChatCompletionMessage{Role: message.Role, Content: message.Content}
+	}
+	return converted
+}
+
 // CountTokens counts the number of tokens in a slice of chat messages
 func (c *Client) CountTokens(messages []openai.ChatCompletionMessage) (int, error) {
 	return c.tokenCounter.CountMessages(messages, c.model)
 }
 
-// CountText counts the number of tokens in a text string
+// CountText implements llm.Provider, counting the tokens in a text string
 func (c *Client) CountText(text string) (int, error) {
 	return c.tokenCounter.CountText(text, c.model)
 }
+
+// endpoint returns the URL of an API path
+func (c *Client) endpoint(path string) string {
+	if c.apiVersion != "" {
+		return fmt.Sprintf("%s/openai/deployments/%s%s?api-version=%s",
+			c.baseURL, url.PathEscape(c.model), path, url.QueryEscape(c.apiVersion))
+	}
+	return c.baseURL + path
+}
+
+// header returns the authentication headers of a request
+func (c *Client) header() http.Header {
+	header := http.Header{}
+	switch {
+	case c.apiVersion != "":
+		header.Set("api-key", c.apiKey)
+	case c.apiKey != "":
+		header.Set("Authorization", fmt.Sprintf("Bearer %s", c.apiKey))
+	}
+	return header
+}
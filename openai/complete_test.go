@@ -9,6 +9,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/jeremyhunt/agent-runner/llm"
 	"github.com/sashabaranov/go-openai"
 )
 
@@ -155,7 +156,7 @@ func TestComplete(t *testing.T) {
 				baseURL:      "https://api.openai.com/v1",
 				apiKey:       "test-key",
 				model:        "gpt-4o",
-				httpClient:   mockHTTPClient,
+				transport:    llm.Transport{HTTPClient: mockHTTPClient},
 				tokenCounter: mockCounter,
 			}
 
@@ -0,0 +1,118 @@
+package openai
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// fixedCounter is a TokenCounter that doesn't need the tokenizer data
+type fixedCounter struct{}
+
+func (fixedCounter) CountText(text, model string) (int, error) { return len(text), nil }
+func (fixedCounter) CountMessages(messages []openai.ChatCompletionMessage, model string) (int, error) {
+	return len(messages), nil
+}
+
+// newTestClient creates a client that sends requests to a test server
+func newTestClient(server *httptest.Server, policy RetryPolicy) *Client {
+	client := NewClient("dummy-api-key", "gpt-4o").WithRetryPolicy(policy)
+	client.transport.HTTPClient = server.Client()
+	client.baseURL = server.URL
+	client.tokenCounter = fixedCounter{}
+	return client
+}
+
+const completionBody = `{"choices": [{"index": 0, "message": {"role": "assistant", "content": "Hello"}}]}`
+
+func TestCompleteRetries(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantErr      string
+		wantAttempts int32
+	}{
+		{name: "Succeeds first time", statuses: []int{200}, wantAttempts: 1},
+		{name: "Retries rate limit", statuses: []int{429, 200}, wantAttempts: 2},
+		{name: "Retries server errors", statuses: []int{503, 502, 200}, wantAttempts: 3},
+		{name: "Gives up after max attempts", statuses: []int{500, 500, 500, 200}, wantErr: "giving up after 3 attempts", wantAttempts: 3},
+		{name: "Doesn't retry client errors", statuses: []int{400, 200}, wantErr: "unexpected status code: 400", wantAttempts: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				status := tt.statuses[atomic.AddInt32(&attempts, 1)-1]
+				w.WriteHeader(status)
+				if status == http.StatusOK {
+					fmt.Fprint(w, completionBody)
+				}
+			}))
+			defer server.Close()
+
+			response, err := newTestClient(server, policy).Complete(context.Background(), "Hi")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+				}
+			} else if err != nil || response != "Hello" {
+				t.Errorf("Expected Hello, got %q (error: %v)", response, err)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("Expected %d attempts, got %d", tt.wantAttempts, attempts)
+			}
+		})
+	}
+}
+
+func TestCompleteHonorsRetryAfter(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.Header().Set("Retry-After", "0.2")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprint(w, completionBody)
+	}))
+	defer server.Close()
+
+	start := time.Now()
+	policy := RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	if _, err := newTestClient(server, policy).Complete(context.Background(), "Hi"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("Expected the retry to wait for Retry-After, waited %v", elapsed)
+	}
+}
+
+func TestCompleteCancelledDuringBackoff(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := newTestClient(server, DefaultRetryPolicy).Complete(ctx, "Hi")
+	if err == nil || !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+		t.Errorf("Expected the context error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected cancellation to stop the backoff, took %v", elapsed)
+	}
+}
@@ -0,0 +1,60 @@
+package openai
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jeremyhunt/agent-runner/llm"
+)
+
+// chatCompletionChunk is one server-sent event of a streaming chat completion
+type chatCompletionChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+	} `json:"choices"`
+	// Usage is only set on the final event, and only when the request asked for it
+	Usage *usage `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// readStream reads a server-sent event stream of chat completion chunks, passing each content delta
+// to onDelta, and returns the full content and the reported usage, if any, once the stream is done
+func readStream(body io.Reader, onDelta func(string)) (string, *usage, error) {
+	var content strings.Builder
+	var reported *usage
+	err := llm.ReadEvents(body, func(data string) (bool, error) {
+		if data == "[DONE]" {
+			return true, nil
+		}
+
+		var chunk chatCompletionChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return false, fmt.Errorf("error decoding stream event: %w", err)
+		}
+		if chunk.Error != nil {
+			return false, fmt.Errorf("error in stream: %s", chunk.Error.Message)
+		}
+		if chunk.Usage != nil {
+			reported = chunk.Usage
+		}
+
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content == "" {
+				continue
+			}
+			content.WriteString(choice.Delta.Content)
+			onDelta(choice.Delta.Content)
+		}
+		return false, nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return content.String(), reported, nil
+}
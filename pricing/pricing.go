@@ -0,0 +1,109 @@
+// Package pricing converts token usage into an estimated cost using per-model rates.
+package pricing
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Rates are the prices of a model in US dollars per million tokens
+type Rates struct {
+	// Input is the price of prompt tokens
+	Input float64 `json:"input"`
+	// CachedInput is the price of prompt tokens served from the prompt cache (defaults to Input)
+	CachedInput float64 `json:"cached_input,omitempty"`
+	// Output is the price of completion tokens
+	Output float64 `json:"output"`
+}
+
+// Cost returns the price of a request. promptTokens includes the cached tokens.
+func (r Rates) Cost(promptTokens, cachedTokens, completionTokens int) float64 {
+	cachedRate := r.CachedInput
+	if cachedRate == 0 {
+		cachedRate = r.Input
+	}
+	cost := float64(promptTokens-cachedTokens)*r.Input +
+		float64(cachedTokens)*cachedRate +
+		float64(completionTokens)*r.Output
+	return cost / 1_000_000
+}
+
+// builtinRates are the published OpenAI and Anthropic list prices when the table was last updated
+var builtinRates = map[string]Rates{
+	"gpt-4o":       {Input: 2.50, CachedInput: 1.25, Output: 10.00},
+	"gpt-4o-mini":  {Input: 0.15, CachedInput: 0.075, Output: 0.60},
+	"gpt-4.1":      {Input: 2.00, CachedInput: 0.50, Output: 8.00},
+	"gpt-4.1-mini": {Input: 0.40, CachedInput: 0.10, Output: 1.60},
+	"gpt-4.1-nano": {Input: 0.10, CachedInput: 0.025, Output: 0.40},
+	"gpt-4-turbo":  {Input: 10.00, Output: 30.00},
+	"o3-mini":      {Input: 1.10, CachedInput: 0.55, Output: 4.40},
+	"o4-mini":      {Input: 1.10, CachedInput: 0.275, Output: 4.40},
+
+	"claude-opus-4-1":   {Input: 15.00, CachedInput: 1.50, Output: 75.00},
+	"claude-sonnet-4-5": {Input: 3.00, CachedInput: 0.30, Output: 15.00},
+	"claude-sonnet-4":   {Input: 3.00, CachedInput: 0.30, Output: 15.00},
+	"claude-haiku-4-5":  {Input: 1.00, CachedInput: 0.10, Output: 5.00},
+	"claude-3-5-haiku":  {Input: 0.80, CachedInput: 0.08, Output: 4.00},
+}
+
+// Table holds the rates of the known models
+type Table struct {
+	rates map[string]Rates
+}
+
+// NewTable creates a table containing the built-in rates
+func NewTable() *Table {
+	t := &Table{rates: make(map[string]Rates)}
+	for model, rates := range builtinRates {
+		t.Set(model, rates)
+	}
+	return t
+}
+
+// Set sets the rates of a model, replacing any existing rates
+func (t *Table) Set(model string, rates Rates) {
+	t.rates[strings.ToLower(model)] = rates
+}
+
+// LoadFile reads a JSON object of rates keyed by model and adds them, overriding built-ins for the same model
+func (t *Table) LoadFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading pricing: %w", err)
+	}
+
+	var rates map[string]Rates
+	if err := json.Unmarshal(content, &rates); err != nil {
+		return fmt.Errorf("error parsing pricing %s: %w", path, err)
+	}
+
+	for model, r := range rates {
+		if r.Input < 0 || r.CachedInput < 0 || r.Output < 0 {
+			return fmt.Errorf("pricing for %s in %s has a negative rate", model, path)
+		}
+		t.Set(model, r)
+	}
+	return nil
+}
+
+// Lookup returns the rates of a model. Dated snapshots such as gpt-4o-2024-08-06 use the rates of the
+// longest model name they start with. ok is false when the model has no rates.
+func (t *Table) Lookup(model string) (rates Rates, ok bool) {
+	model = strings.ToLower(model)
+	if rates, ok := t.rates[model]; ok {
+		return rates, true
+	}
+
+	best := ""
+	for name := range t.rates {
+		if strings.HasPrefix(model, name+"-") && len(name) > len(best) {
+			best = name
+		}
+	}
+	if best == "" {
+		return Rates{}, false
+	}
+	return t.rates[best], true
+}
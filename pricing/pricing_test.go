@@ -0,0 +1,85 @@
+package pricing
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCost(t *testing.T) {
+	rates := Rates{Input: 2.50, CachedInput: 1.25, Output: 10.00}
+
+	// 1M uncached prompt tokens, 1M cached and 100K completion tokens
+	cost := rates.Cost(2_000_000, 1_000_000, 100_000)
+	if math.Abs(cost-4.75) > 1e-9 {
+		t.Errorf("Expected $4.75, got $%f", cost)
+	}
+
+	// Without a cached rate, cached tokens cost the same as other prompt tokens
+	rates = Rates{Input: 10.00, Output: 30.00}
+	cost = rates.Cost(1_000_000, 500_000, 0)
+	if math.Abs(cost-10.00) > 1e-9 {
+		t.Errorf("Expected $10.00, got $%f", cost)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	table := NewTable()
+
+	tests := []struct {
+		name   string
+		model  string
+		input  float64
+		wantOK bool
+	}{
+		{name: "Exact model", model: "gpt-4o", input: 2.50, wantOK: true},
+		{name: "Case insensitive", model: "GPT-4o-mini", input: 0.15, wantOK: true},
+		{name: "Dated snapshot", model: "gpt-4o-2024-08-06", input: 2.50, wantOK: true},
+		{name: "Longest prefix wins", model: "gpt-4o-mini-2024-07-18", input: 0.15, wantOK: true},
+		{name: "Unknown model", model: "llama3", wantOK: false},
+		{name: "Prefix without separator", model: "gpt-4oo", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rates, ok := table.Lookup(tt.model)
+			if ok != tt.wantOK || rates.Input != tt.input {
+				t.Errorf("Lookup(%q) = %+v, %v; expected input %v, %v", tt.model, rates, ok, tt.input, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	table := NewTable()
+
+	path := filepath.Join(t.TempDir(), "pricing.json")
+	content := `{
+		"gpt-4o": {"input": 2.00, "cached_input": 1.00, "output": 8.00},
+		"llama3": {"input": 0, "output": 0}
+	}`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write pricing: %v", err)
+	}
+	if err := table.LoadFile(path); err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+
+	if rates, _ := table.Lookup("gpt-4o"); rates.Input != 2.00 {
+		t.Errorf("Expected the file to override gpt-4o, got %+v", rates)
+	}
+	if _, ok := table.Lookup("llama3"); !ok {
+		t.Error("Expected rates for llama3 from the file")
+	}
+	if _, ok := table.Lookup("gpt-4o-mini"); !ok {
+		t.Error("Expected built-in rates to remain")
+	}
+
+	if err := os.WriteFile(path, []byte(`{"gpt-4o": {"input": -1}}`), 0644); err != nil {
+		t.Fatalf("Failed to write pricing: %v", err)
+	}
+	if err := table.LoadFile(path); err == nil {
+		t.Error("Expected an error for a negative rate")
+	}
+}
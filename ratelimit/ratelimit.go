@@ -0,0 +1,93 @@
+// Package ratelimit paces LLM requests to stay within per-minute request and token budgets.
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Budget limits the requests and tokens sent within a rolling one-minute window.
+// It is safe for concurrent use.
+type Budget struct {
+	requestsPerMinute int
+	tokensPerMinute   int
+
+	// window is the period the budgets apply to
+	window time.Duration
+
+	mu   sync.Mutex
+	sent []usage
+}
+
+// usage is one request admitted by the budget
+type usage struct {
+	at     time.Time
+	tokens int
+}
+
+// NewBudget creates a budget allowing the given requests and tokens per minute.
+// A limit of zero or less is not enforced.
+func NewBudget(requestsPerMinute, tokensPerMinute int) *Budget {
+	return &Budget{
+		requestsPerMinute: requestsPerMinute,
+		tokensPerMinute:   tokensPerMinute,
+		window:            time.Minute,
+	}
+}
+
+// Wait blocks until a request using the given number of tokens fits in the budget, then records it.
+// A request larger than the whole token budget is admitted once the window is empty, so it can't wait forever.
+func (b *Budget) Wait(ctx context.Context, tokens int) error {
+	for {
+		delay := b.reserve(tokens)
+		if delay == 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// reserve records the request if it fits, otherwise returns how long to wait before trying again
+func (b *Budget) reserve(tokens int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+
+	// Forget requests that have left the window
+	expired := 0
+	for expired < len(b.sent) && now.Sub(b.sent[expired].at) >= b.window {
+		expired++
+	}
+	b.sent = b.sent[expired:]
+
+	if b.requestsPerMinute > 0 && len(b.sent) >= b.requestsPerMinute {
+		return b.sent[0].at.Add(b.window).Sub(now)
+	}
+
+	if b.tokensPerMinute > 0 && len(b.sent) > 0 {
+		used := 0
+		for _, u := range b.sent {
+			used += u.tokens
+		}
+
+		// Wait for the oldest requests to leave the window until enough tokens are free
+		for i := 0; used+tokens > b.tokensPerMinute && i < len(b.sent); i++ {
+			used -= b.sent[i].tokens
+			if used+tokens <= b.tokensPerMinute || i == len(b.sent)-1 {
+				return b.sent[i].at.Add(b.window).Sub(now)
+			}
+		}
+	}
+
+	b.sent = append(b.sent, usage{at: now, tokens: tokens})
+	return 0
+}
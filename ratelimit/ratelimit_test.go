@@ -0,0 +1,78 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBudgetRequests(t *testing.T) {
+	budget := NewBudget(2, 0)
+	budget.window = 100 * time.Millisecond
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := budget.Wait(context.Background(), 10); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	// The third request has to wait for the first to leave the window
+	if elapsed := time.Since(start); elapsed < budget.window {
+		t.Errorf("Expected the third request to wait at least %v, waited %v", budget.window, elapsed)
+	}
+}
+
+func TestBudgetTokens(t *testing.T) {
+	budget := NewBudget(0, 100)
+	budget.window = 100 * time.Millisecond
+
+	tests := []struct {
+		name   string
+		tokens int
+		waits  bool
+	}{
+		{name: "Fits in the budget", tokens: 60, waits: false},
+		{name: "Exceeds the remaining budget", tokens: 60, waits: true},
+		{name: "Larger than the whole budget", tokens: 500, waits: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			if err := budget.Wait(context.Background(), tt.tokens); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			waited := time.Since(start) >= budget.window/2
+			if waited != tt.waits {
+				t.Errorf("Expected waiting to be %v, took %v", tt.waits, time.Since(start))
+			}
+		})
+	}
+}
+
+func TestBudgetCancel(t *testing.T) {
+	budget := NewBudget(1, 0)
+	if err := budget.Wait(context.Background(), 0); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := budget.Wait(ctx, 0); err != context.DeadlineExceeded {
+		t.Errorf("Expected the wait to be cancelled, got %v", err)
+	}
+}
+
+func TestBudgetUnlimited(t *testing.T) {
+	budget := NewBudget(0, 0)
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		if err := budget.Wait(context.Background(), 1000000); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("Expected an unlimited budget not to wait, took %v", elapsed)
+	}
+}
@@ -0,0 +1,155 @@
+package review
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jeremyhunt/agent-runner/logger"
+	"gopkg.in/yaml.v3"
+)
+
+// ArchitectureProfile describes a repository's architecture so prompts can refer to its conventions
+type ArchitectureProfile struct {
+	// Description is a short overview of the architecture (e.g. "custom silo/service/domain architecture")
+	Description string `json:"description" yaml:"description"`
+
+	// Layers are the architectural layers, in order from lowest to highest
+	Layers []ArchitectureLayer `json:"layers" yaml:"layers"`
+
+	// NamingConventions are conventions the codebase follows for names and file locations
+	NamingConventions []string `json:"naming_conventions" yaml:"naming_conventions"`
+
+	// FileOrderHints guide the order in which changed files should be analyzed
+	FileOrderHints []string `json:"file_order_hints" yaml:"file_order_hints"`
+}
+
+// ArchitectureLayer describes one layer of the architecture
+type ArchitectureLayer struct {
+	// Name is the layer name (e.g. "Repository")
+	Name string `json:"name" yaml:"name"`
+
+	// Responsibility describes what belongs in the layer
+	Responsibility string `json:"responsibility" yaml:"responsibility"`
+
+	// Paths are example path patterns where the layer's files live
+	Paths []string `json:"paths,omitempty" yaml:"paths,omitempty"`
+}
+
+// architectureProfileExtensions are the file extensions an architecture profile is looked up with, in order
+var architectureProfileExtensions = []string{".yaml", ".yml", ".json"}
+
+// ArchitectureProfilePath returns the location of the architecture profile for a repository
+func ArchitectureProfilePath(repoName string) string {
+	return filepath.Join(".context", "profiles", repoName+architectureProfileExtensions[0])
+}
+
+// LoadArchitectureProfile loads the architecture profile for the repository if it exists, as YAML,
+// or as JSON when the file has a .json extension
+func (w *Workflow) LoadArchitectureProfile() error {
+	if w.Ctx.RepoName == "" {
+		return nil // No repository specified, nothing to do
+	}
+
+	// Repositories without a profile get neutral wording
+	base := strings.TrimSuffix(ArchitectureProfilePath(w.Ctx.RepoName), architectureProfileExtensions[0])
+	var fullPath string
+	var content []byte
+	for _, ext := range architectureProfileExtensions {
+		var err error
+		content, err = os.ReadFile(base + ext)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error reading architecture profile: %w", err)
+		}
+		fullPath = base + ext
+		break
+	}
+	if fullPath == "" {
+		logger.Debug("No architecture profile for %s at %s", w.Ctx.RepoName, ArchitectureProfilePath(w.Ctx.RepoName))
+		return nil
+	}
+
+	var profile ArchitectureProfile
+	unmarshal := yaml.Unmarshal
+	if filepath.Ext(fullPath) == ".json" {
+		unmarshal = json.Unmarshal
+	}
+	if err := unmarshal(content, &profile); err != nil {
+		return fmt.Errorf("error parsing architecture profile %s: %w", fullPath, err)
+	}
+
+	w.Ctx.Architecture = &profile
+	logger.Success("Architecture profile for %s loaded successfully", w.Ctx.RepoName)
+	return nil
+}
+
+// architectureIntro describes the codebase's architecture in one sentence for the prompt intros
+func (w *Workflow) architectureIntro() string {
+	if w.Ctx.Architecture == nil || w.Ctx.Architecture.Description == "" {
+		return ""
+	}
+	return fmt.Sprintf(" that uses a %s", strings.TrimSuffix(w.Ctx.Architecture.Description, "."))
+}
+
+// architectureSection returns the markdown description of the architecture profile, or "" without one
+func (w *Workflow) architectureSection(heading string) string {
+	profile := w.Ctx.Architecture
+	if profile == nil {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteString(heading + " Architecture\n\n")
+	if profile.Description != "" {
+		sb.WriteString(fmt.Sprintf("The codebase uses a %s.\n\n", strings.TrimSuffix(profile.Description, ".")))
+	}
+
+	if len(profile.Layers) > 0 {
+		sb.WriteString("Layers, from lowest to highest:\n")
+		for _, layer := range profile.Layers {
+			sb.WriteString(fmt.Sprintf("- **%s**: %s", layer.Name, layer.Responsibility))
+			if len(layer.Paths) > 0 {
+				sb.WriteString(fmt.Sprintf(" (`%s`)", strings.Join(layer.Paths, "`, `")))
+			}
+			sb.WriteString("\n")
+		}
+		sb.WriteString("\n")
+	}
+
+	if len(profile.NamingConventions) > 0 {
+		sb.WriteString("Naming conventions:\n")
+		for _, convention := range profile.NamingConventions {
+			sb.WriteString(fmt.Sprintf("- %s\n", convention))
+		}
+		sb.WriteString("\n")
+	}
+
+	return sb.String()
+}
+
+// fileOrderGuidance returns the instructions for ordering files in the discovery prompt
+func (w *Workflow) fileOrderGuidance() string {
+	profile := w.Ctx.Architecture
+	if profile == nil || (len(profile.FileOrderHints) == 0 && len(profile.Layers) == 0) {
+		return "Consider the layering of the codebase: start with files that define data structures and data access, then business logic, then the code that orchestrates or exposes it."
+	}
+
+	var lines []string
+	if len(profile.FileOrderHints) > 0 {
+		lines = append(lines, "Follow these ordering hints for this codebase:")
+		for _, hint := range profile.FileOrderHints {
+			lines = append(lines, "- "+hint)
+		}
+	} else {
+		lines = append(lines, "Consider the architecture layers where:")
+		for _, layer := range profile.Layers {
+			lines = append(lines, fmt.Sprintf("- %s: %s", layer.Name, layer.Responsibility))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,146 @@
+package review
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jeremyhunt/agent-runner/diff"
+	"github.com/jeremyhunt/agent-runner/gitrepo"
+	"github.com/jeremyhunt/agent-runner/logger"
+)
+
+// PrepareChanges computes the diff and changed-file list for the PR and stores them in the context.
+// The markdown versions are written to the output directory as artifacts only.
+func (w *Workflow) PrepareChanges() error {
+	if err := w.prepareChanges(); err != nil {
+		return err
+	}
+
+	// Steps read the language concurrently, so it is resolved before any of them runs
+	w.resolveLanguage()
+	return nil
+}
+
+// prepareChanges reads the changes from GitHub, the local clone or the artifacts of an earlier run
+func (w *Workflow) prepareChanges() error {
+	// A pull request on GitHub is read through the API, without a clone
+	if w.Ctx.GitHub != nil {
+		return w.prepareChangesFromGitHub()
+	}
+
+	// Without a repository we fall back to artifacts generated by the Makefile targets
+	if w.Ctx.RepoDir == "" {
+		if err := w.loadChangesFromArtifacts(); err != nil {
+			return err
+		}
+		w.parseDiff()
+		return nil
+	}
+
+	repo, err := gitrepo.Open(w.Ctx.RepoDir)
+	if err != nil {
+		return err
+	}
+
+	// Compare against the configured base, or the default branch
+	base := w.Ctx.BaseRef
+	if base == "" {
+		base, err = repo.DefaultBranch()
+		if err != nil {
+			return err
+		}
+	}
+
+	head := w.Ctx.HeadRef
+	if head == "" {
+		head = w.Ctx.Branch
+	}
+	if head == "" {
+		head = "HEAD"
+	}
+
+	// Resolve the head and merge-base once so later steps don't need to shell out for them, and
+	// the diff stays that of the resolved commit if the branch moves during the review
+	headCommit, err := repo.RevParse(head)
+	if err != nil {
+		return err
+	}
+	mergeBase, err := repo.MergeBase(base, headCommit)
+	if err != nil {
+		return err
+	}
+	logger.Debug("Comparing %s (%s) against merge-base %s (%s)", head, headCommit, mergeBase, base)
+
+	w.Ctx.Repo = repo
+	w.Ctx.BaseRef = base
+	w.Ctx.HeadRef = head
+	w.Ctx.HeadCommit = headCommit
+	w.Ctx.MergeBase = mergeBase
+
+	diffContent, err := repo.Diff(mergeBase, headCommit)
+	if err != nil {
+		return err
+	}
+
+	changes, err := repo.ChangedFiles(mergeBase, headCommit)
+	if err != nil {
+		return err
+	}
+	if changes.IsEmpty() {
+		return fmt.Errorf("no changes found between %s and %s", base, head)
+	}
+
+	w.Ctx.Changes = changes
+	w.Ctx.DiffContent = diffContent
+	w.Ctx.FilesContent = changes.Markdown(head)
+	w.parseDiff()
+	return w.saveChanges()
+}
+
+// saveChanges writes the diff and file list of the prepared changes
+func (w *Workflow) saveChanges() error {
+	changes := w.Ctx.Changes
+
+	// Write the artifacts so the diff and file list can be inspected after the run
+	if err := os.MkdirAll(w.Ctx.OutputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	if err := os.WriteFile(w.Ctx.DiffPath, []byte(w.Ctx.DiffContent), 0644); err != nil {
+		return fmt.Errorf("failed to write diff file: %w", err)
+	}
+	if err := os.WriteFile(w.Ctx.FilesPath, []byte(w.Ctx.FilesContent), 0644); err != nil {
+		return fmt.Errorf("failed to write files list: %w", err)
+	}
+
+	logger.Verbose("Found %d modified, %d added, %d deleted and %d renamed files",
+		len(changes.Modified), len(changes.Added), len(changes.Deleted), len(changes.Renamed))
+	return nil
+}
+
+// loadChangesFromArtifacts reads a previously generated diff and files list from disk
+func (w *Workflow) loadChangesFromArtifacts() error {
+	diffContent, err := os.ReadFile(w.Ctx.DiffPath)
+	if err != nil {
+		return fmt.Errorf("error reading diff file: %w", err)
+	}
+
+	filesContent, err := os.ReadFile(w.Ctx.FilesPath)
+	if err != nil {
+		return fmt.Errorf("error reading files list: %w", err)
+	}
+
+	w.Ctx.DiffContent = string(diffContent)
+	w.Ctx.FilesContent = string(filesContent)
+	return nil
+}
+
+// parseDiff builds the structured diff; prompts fall back to the raw diff if parsing fails
+func (w *Workflow) parseDiff() {
+	parsed, err := diff.Parse(w.Ctx.DiffContent)
+	if err != nil {
+		logger.Debug("Warning: could not parse diff: %v", err)
+		return
+	}
+	w.Ctx.ParsedDiff = parsed
+	logger.Debug("Parsed diff with %d files", len(parsed.Files))
+}
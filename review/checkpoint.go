@@ -0,0 +1,191 @@
+package review
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jeremyhunt/agent-runner/logger"
+)
+
+// Manifest records the inputs and outputs of each completed pipeline step so a review can be resumed
+type Manifest struct {
+	// Ticket is the ticket the review belongs to
+	Ticket string `json:"ticket"`
+
+	// Steps maps step names to their last completed run
+	Steps map[string]*StepRecord `json:"steps"`
+}
+
+// StepRecord describes the last completed run of a step
+type StepRecord struct {
+	// InputHash is the hash of the PR context and input artifacts the step ran with
+	InputHash string `json:"input_hash"`
+
+	// Outputs maps each artifact the step wrote to the hash of its content
+	Outputs map[string]string `json:"outputs"`
+
+	// CompletedAt is when the step finished
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+// ManifestPath returns the location of the run manifest for a ticket
+func ManifestPath(outputDir, ticket string) string {
+	return filepath.Join(outputDir, ticket, "manifest.json")
+}
+
+// LoadManifest reads a run manifest, returning an empty one if it doesn't exist yet
+func LoadManifest(path, ticket string) (*Manifest, error) {
+	manifest := &Manifest{Ticket: ticket, Steps: make(map[string]*StepRecord)}
+
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading run manifest: %w", err)
+	}
+
+	if err := json.Unmarshal(content, manifest); err != nil {
+		return nil, fmt.Errorf("error parsing run manifest %s: %w", path, err)
+	}
+	if manifest.Steps == nil {
+		manifest.Steps = make(map[string]*StepRecord)
+	}
+	return manifest, nil
+}
+
+// Save writes the manifest to disk
+func (m *Manifest) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create manifest directory: %w", err)
+	}
+
+	content, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode run manifest: %w", err)
+	}
+
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		return fmt.Errorf("failed to write run manifest: %w", err)
+	}
+	return nil
+}
+
+// Record stores a completed run of a step. Other steps that write the same artifacts have their
+// output hashes refreshed, so a shared artifact doesn't look modified to the steps that wrote it first.
+func (m *Manifest) Record(name, inputHash string, outputs []string) {
+	hashes := make(map[string]string, len(outputs))
+	for _, output := range outputs {
+		hash, err := hashFile(output)
+		if err != nil {
+			logger.Debug("Warning: could not hash output %s: %v", output, err)
+			continue
+		}
+		hashes[output] = hash
+	}
+
+	for _, record := range m.Steps {
+		for output := range record.Outputs {
+			if hash, ok := hashes[output]; ok {
+				record.Outputs[output] = hash
+			}
+		}
+	}
+
+	m.Steps[name] = &StepRecord{
+		InputHash:   inputHash,
+		Outputs:     hashes,
+		CompletedAt: time.Now(),
+	}
+}
+
+// Unchanged reports whether a step last ran with the given inputs and its outputs are still intact
+func (m *Manifest) Unchanged(name, inputHash string) bool {
+	record, ok := m.Steps[name]
+	if !ok || record.InputHash != inputHash {
+		return false
+	}
+	return record.outputsIntact()
+}
+
+// HasOutputs reports whether a step has completed before and its outputs still exist on disk
+func (m *Manifest) HasOutputs(name string) bool {
+	record, ok := m.Steps[name]
+	if !ok {
+		return false
+	}
+	for output := range record.Outputs {
+		if _, err := os.Stat(output); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// outputsIntact reports whether every recorded output still has the content the step wrote
+func (r *StepRecord) outputsIntact() bool {
+	for output, expected := range r.Outputs {
+		hash, err := hashFile(output)
+		if err != nil || hash != expected {
+			return false
+		}
+	}
+	return true
+}
+
+// contextHash hashes the PR context shared by every step
+func (w *Workflow) contextHash() string {
+	architecture, _ := json.Marshal(w.Ctx.Architecture)
+
+	h := sha256.New()
+	for _, part := range []string{
+		w.Ctx.Model,
+		w.Ctx.BaseRef,
+		w.Ctx.HeadRef,
+		w.Ctx.MergeBase,
+		w.Ctx.DiffContent,
+		w.Ctx.FilesContent,
+		w.Ctx.DesignDocContent,
+		w.Ctx.TicketSource,
+		w.Ctx.PullRequestDetails,
+		string(architecture),
+	} {
+		// Length-prefix each part so adjacent values can't run together
+		fmt.Fprintf(h, "%d:%s", len(part), part)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// inputHash hashes everything a step reads: the shared PR context and the content of its input artifacts
+func (w *Workflow) inputHash(step Step) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n", step.Name(), w.contextHash())
+	if client, ok := w.stepClient(step.Name()); ok {
+		fmt.Fprintf(h, "model:%s\n", client.Model())
+	}
+	for _, input := range step.Inputs() {
+		content, err := os.ReadFile(input)
+		if err != nil {
+			fmt.Fprintf(h, "%s:missing\n", input)
+			continue
+		}
+		fmt.Fprintf(h, "%s:%d:", input, len(content))
+		h.Write(content)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// hashFile returns the hex-encoded SHA-256 of a file's content
+func hashFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
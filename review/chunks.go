@@ -0,0 +1,228 @@
+package review
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jeremyhunt/agent-runner/diff"
+	"github.com/jeremyhunt/agent-runner/logger"
+)
+
+// chunkNoteAllowance is the room left in each chunk for the note saying which part of the diff it is
+const chunkNoteAllowance = 100
+
+// DiffChunk is a part of the diff small enough to fit a prompt on its own
+type DiffChunk struct {
+	// Files are the paths of the files in the chunk
+	Files []string
+
+	// Diff is the chunk's diff text
+	Diff string
+
+	// Tokens is the size of the diff text
+	Tokens int
+}
+
+// promptPart is a prompt covering the whole diff, or one chunk of it
+type promptPart struct {
+	// Files are the paths covered by the part, or nil when it covers the whole diff
+	Files  []string
+	Prompt string
+}
+
+// approxTokens counts text with a step's model, estimating when the tokenizer isn't available
+func (w *Workflow) approxTokens(step, text string) int {
+	if _, ok := w.stepClient(step); !ok && w.Ctx.TokenCounter == nil {
+		return len(text) / 4
+	}
+	count, err := w.countTokens(step, text)
+	if err != nil {
+		// Roughly four characters per token
+		return len(text) / 4
+	}
+	return count
+}
+
+// ChunkDiff splits the diff along file boundaries into chunks of at most budget tokens, counted with
+// the step's model. Files larger than the budget are split between their hunks; a single hunk larger
+// than the budget gets a chunk of its own.
+func (w *Workflow) ChunkDiff(step string, budget int) []DiffChunk {
+	if w.Ctx.ParsedDiff == nil || len(w.Ctx.ParsedDiff.Files) == 0 {
+		return []DiffChunk{{Diff: w.Ctx.DiffContent, Tokens: w.approxTokens(step, w.Ctx.DiffContent)}}
+	}
+
+	// Split the diff into pieces that each fit the budget where possible
+	var pieces []DiffChunk
+	for _, file := range w.Ctx.ParsedDiff.Files {
+		text := file.String()
+		tokens := w.approxTokens(step, text)
+		if tokens <= budget || len(file.Hunks) < 2 {
+			pieces = append(pieces, DiffChunk{Files: []string{file.Path()}, Diff: text, Tokens: tokens})
+			continue
+		}
+		for _, hunks := range splitHunks(text) {
+			pieces = append(pieces, DiffChunk{Files: []string{file.Path()}, Diff: hunks, Tokens: w.approxTokens(step, hunks)})
+		}
+	}
+
+	// Pack the pieces into chunks, keeping them in diff order
+	var chunks []DiffChunk
+	for _, piece := range pieces {
+		if n := len(chunks); n > 0 && chunks[n-1].Tokens+piece.Tokens <= budget {
+			last := &chunks[n-1]
+			if last.Files[len(last.Files)-1] != piece.Files[0] {
+				last.Files = append(last.Files, piece.Files[0])
+			}
+			last.Diff += piece.Diff
+			last.Tokens += piece.Tokens
+			continue
+		}
+		if piece.Tokens > budget {
+			logger.Debug("Warning: %s has a hunk larger than the %d-token chunk budget", piece.Files[0], budget)
+		}
+		chunks = append(chunks, piece)
+	}
+	return chunks
+}
+
+// splitHunks splits the diff of one file into one piece per hunk, each repeating the file header
+func splitHunks(text string) []string {
+	var header, current strings.Builder
+	var hunks []string
+	inHunks := false
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if strings.HasPrefix(line, "@@ ") {
+			if inHunks {
+				hunks = append(hunks, current.String())
+				current.Reset()
+			}
+			inHunks = true
+			current.WriteString(header.String())
+		}
+		if !inHunks {
+			header.WriteString(line)
+			continue
+		}
+		current.WriteString(line)
+	}
+	if inHunks {
+		hunks = append(hunks, current.String())
+	}
+	return hunks
+}
+
+// splitPrompt returns the prompt built around the whole diff if it fits the step's model. Otherwise the
+// diff is chunked so that each prompt fits, and one prompt is returned per chunk.
+func (w *Workflow) splitPrompt(step string, prompt func(diff string) string) ([]promptPart, error) {
+	full := prompt(w.Ctx.DiffContent)
+	limit := w.maxTokens(step)
+	tokens := w.approxTokens(step, full)
+	logger.Verbose("%s prompt contains %d tokens", step, tokens)
+	if tokens <= limit {
+		return []promptPart{{Prompt: full}}, nil
+	}
+
+	budget := limit - w.approxTokens(step, prompt("")) - chunkNoteAllowance
+	if budget <= 0 {
+		return nil, fmt.Errorf("the %s prompt is too large for %d tokens even without the diff", step, limit)
+	}
+
+	chunks := w.ChunkDiff(step, budget)
+	logger.StepDetail("The diff is too large for one %s prompt, reviewing it in %d parts", step, len(chunks))
+	parts := make([]promptPart, len(chunks))
+	for i, chunk := range chunks {
+		note := fmt.Sprintf("This is part %d of %d of the PR diff. The other files are reviewed separately, "+
+			"so only report issues in the files below.\n\n", i+1, len(chunks))
+		parts[i] = promptPart{Files: chunk.Files, Prompt: prompt(note + chunk.Diff)}
+	}
+	return parts, nil
+}
+
+// askInParts sends each part's prompt and merges the responses, heading each with the files it covers
+func askInParts(parts []promptPart, ask func(prompt string) (string, error)) (string, error) {
+	if len(parts) == 1 && parts[0].Files == nil {
+		return ask(parts[0].Prompt)
+	}
+
+	var sb strings.Builder
+	for i, part := range parts {
+		response, err := ask(part.Prompt)
+		if err != nil {
+			return "", fmt.Errorf("part %d of %d: %w", i+1, len(parts), err)
+		}
+		if i > 0 {
+			sb.WriteString("\n\n")
+		}
+		fmt.Fprintf(&sb, "## Part %d of %d\n\nFiles: %s\n\n", i+1, len(parts), strings.Join(part.Files, ", "))
+		sb.WriteString(strings.TrimSpace(response))
+	}
+	return sb.String(), nil
+}
+
+// fitDiff returns the whole diff if the prompt built around it fits the step's model. Otherwise it returns
+// as many whole files as fit, starting with the preferred ones, followed by a list of the files left out.
+func (w *Workflow) fitDiff(step string, prompt func(diff string) string, prefer []string) string {
+	limit := w.maxTokens(step)
+	if w.approxTokens(step, prompt(w.Ctx.DiffContent)) <= limit || w.Ctx.ParsedDiff == nil {
+		return w.Ctx.DiffContent
+	}
+
+	// Preferred files first, then the rest in diff order
+	files := append([]*diff.File(nil), w.Ctx.ParsedDiff.Files...)
+	rank := make(map[string]int, len(prefer))
+	for i, path := range prefer {
+		if _, ok := rank[path]; !ok {
+			rank[path] = i
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		ri, iok := rank[files[i].Path()]
+		rj, jok := rank[files[j].Path()]
+		if iok != jok {
+			return iok
+		}
+		return iok && ri < rj
+	})
+
+	// Leave room for the list of left out files, which at most names them all
+	var paths []string
+	for _, file := range files {
+		paths = append(paths, file.Path())
+	}
+	budget := limit - w.approxTokens(step, prompt("")) - w.approxTokens(step, strings.Join(paths, ", ")) - chunkNoteAllowance
+
+	var included strings.Builder
+	var omitted []string
+	used := 0
+	for _, file := range files {
+		tokens := w.approxTokens(step, file.String())
+		if used+tokens > budget {
+			omitted = append(omitted, file.Path())
+			continue
+		}
+		included.WriteString(file.String())
+		used += tokens
+	}
+
+	logger.Verbose("The diff is too large for the %s prompt; %d files were left out", step, len(omitted))
+	return included.String() + fmt.Sprintf("\n\nThe diff is too large to include in full. These files were left out: %s\n",
+		strings.Join(omitted, ", "))
+}
+
+// referencedFiles returns the files of the diff that review output points at, in order of first mention
+func (w *Workflow) referencedFiles(content string) []string {
+	var files []string
+	seen := make(map[string]bool)
+	for _, ref := range w.CheckLineReferences(content) {
+		if !ref.InDiff {
+			continue
+		}
+		path := w.Ctx.ParsedDiff.File(ref.File).Path()
+		if !seen[path] {
+			seen[path] = true
+			files = append(files, path)
+		}
+	}
+	return files
+}
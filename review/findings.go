@@ -0,0 +1,306 @@
+package review
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jeremyhunt/agent-runner/logger"
+)
+
+// Severities of a finding, as requested in the ISSUE block format
+const (
+	SeverityCritical = "Critical"
+	SeverityMajor    = "Major"
+	SeverityMinor    = "Minor"
+)
+
+// Finding is one issue reported by a review phase in an ISSUE block
+type Finding struct {
+	// Phase is the review phase that reported the issue
+	Phase string `json:"phase"`
+
+	// Category is the tag the ISSUE block was listed under, such as LOGIC_ISSUES
+	Category string `json:"category,omitempty"`
+
+	File string `json:"file,omitempty"`
+
+	// Line is the line the issue is on, and EndLine the last line when a range was given
+	Line    int `json:"line,omitempty"`
+	EndLine int `json:"end_line,omitempty"`
+
+	// Severity is Critical, Major or Minor, or whatever else the model wrote
+	Severity string `json:"severity,omitempty"`
+
+	Problem string `json:"problem,omitempty"`
+
+	// Solution is the SOLUTION_CODE text, including its code fences
+	Solution string `json:"solution,omitempty"`
+}
+
+// Location formats the finding's file and line as path:line, or path:start-end for a range
+func (f Finding) Location() string {
+	switch {
+	case f.Line == 0:
+		return f.File
+	case f.EndLine > f.Line:
+		return fmt.Sprintf("%s:%d-%d", f.File, f.Line, f.EndLine)
+	}
+	return fmt.Sprintf("%s:%d", f.File, f.Line)
+}
+
+// Findings are the issues reported by one review phase
+type Findings struct {
+	Phase string `json:"phase"`
+
+	Issues []Finding `json:"issues"`
+
+	// NoIssues lists the categories marked with <NO_ISSUES_FOUND/>
+	NoIssues []string `json:"no_issues,omitempty"`
+}
+
+// findingTagPattern matches an opening, closing or self-closing tag
+var findingTagPattern = regexp.MustCompile(`(?i)<(/?)([A-Z][A-Z_]*)\s*(/?)>`)
+
+// findingFieldPattern matches a field label at the start of a line, allowing for list markers and
+// markdown emphasis such as "- **FILE:**"
+var findingFieldPattern = regexp.MustCompile(
+	`(?im)^[ \t]*(?:[-*>][ \t]+)?\**(FILE|LINE|SEVERITY|PROBLEM|SOLUTION_CODE|SOLUTION)\**[ \t]*:\**[ \t]*`)
+
+// findingLinePattern matches a line number or range such as "42", "~42", "L42" or "42-45"
+var findingLinePattern = regexp.MustCompile(`(\d+)(?:\s*[-–]\s*L?(\d+))?`)
+
+// isCategoryTag reports whether a tag groups issues, like CRITICAL_ISSUES, or encloses a phase's
+// output, like SYNTAX_REVIEW
+func isCategoryTag(name string) bool {
+	return strings.HasSuffix(name, "_ISSUES") || strings.HasSuffix(name, "_SUGGESTIONS") ||
+		strings.HasSuffix(name, "_REVIEW") || strings.HasPrefix(name, "REVIEW_")
+}
+
+// ParseFindings extracts the ISSUE blocks from a review phase's output. The parser is tolerant of the
+// ways models stray from the format: unclosed ISSUE blocks, lower-case tags, markdown around the field
+// labels and approximate line numbers. Output without any ISSUE tags is split at its FILE labels.
+func ParseFindings(phase, content string) Findings {
+	findings := Findings{Phase: phase, Issues: []Finding{}}
+	var categories []string
+	category := func() string {
+		if len(categories) == 0 {
+			return ""
+		}
+		return categories[len(categories)-1]
+	}
+
+	issueStart := -1
+	flush := func(end int) {
+		if issueStart >= 0 {
+			if finding, ok := parseFinding(content[issueStart:end]); ok {
+				finding.Phase = phase
+				finding.Category = category()
+				findings.Issues = append(findings.Issues, finding)
+			}
+		}
+		issueStart = -1
+	}
+
+	sawIssueTag := false
+	for _, match := range findingTagPattern.FindAllStringSubmatchIndex(content, -1) {
+		start, end := match[0], match[1]
+		closing := match[3] > match[2]
+		name := strings.ToUpper(content[match[4]:match[5]])
+
+		switch {
+		case name == "ISSUE":
+			sawIssueTag = true
+			flush(start)
+			if !closing {
+				issueStart = end
+			}
+		case name == "NO_ISSUES_FOUND":
+			if issueStart < 0 {
+				findings.NoIssues = append(findings.NoIssues, category())
+			}
+		case isCategoryTag(name):
+			// A category ends any ISSUE block left open in it
+			flush(start)
+			if !closing {
+				categories = append(categories, name)
+				continue
+			}
+			for i := len(categories) - 1; i >= 0; i-- {
+				if categories[i] == name {
+					categories = categories[:i]
+					break
+				}
+			}
+		}
+	}
+	flush(len(content))
+
+	if !sawIssueTag {
+		findings.Issues = append(findings.Issues, parseUntaggedFindings(phase, content)...)
+	}
+	return findings
+}
+
+// parseUntaggedFindings splits output without ISSUE tags into blocks starting at each FILE label
+func parseUntaggedFindings(phase, content string) []Finding {
+	var starts []int
+	for _, match := range findingFieldPattern.FindAllStringSubmatchIndex(content, -1) {
+		if strings.EqualFold(content[match[2]:match[3]], "FILE") {
+			starts = append(starts, match[0])
+		}
+	}
+
+	var issues []Finding
+	for i, start := range starts {
+		end := len(content)
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		if finding, ok := parseFinding(content[start:end]); ok {
+			finding.Phase = phase
+			issues = append(issues, finding)
+		}
+	}
+	return issues
+}
+
+// parseFinding reads the fields of one ISSUE block. Each field runs until the next label; everything
+// after SOLUTION_CODE is the solution, so code in it isn't mistaken for labels. A block without a file
+// or a problem isn't a finding.
+func parseFinding(block string) (Finding, bool) {
+	fields := make(map[string]string)
+	var order []string
+	var bounds [][]int
+	for _, match := range findingFieldPattern.FindAllStringSubmatchIndex(block, -1) {
+		name := strings.ToUpper(block[match[2]:match[3]])
+		if name == "SOLUTION" {
+			name = "SOLUTION_CODE"
+		}
+		if _, seen := fields[name]; seen {
+			// A repeated label is part of the previous field's text
+			continue
+		}
+		fields[name] = ""
+		order = append(order, name)
+		bounds = append(bounds, []int{match[0], match[1]})
+		if name == "SOLUTION_CODE" {
+			break
+		}
+	}
+	for i, name := range order {
+		end := len(block)
+		if i+1 < len(bounds) {
+			end = bounds[i+1][0]
+		}
+		fields[name] = strings.TrimSpace(block[bounds[i][1]:end])
+	}
+
+	finding := Finding{
+		File:     cleanFindingFile(firstLine(fields["FILE"])),
+		Severity: normalizeSeverity(firstLine(fields["SEVERITY"])),
+		Problem:  fields["PROBLEM"],
+		Solution: fields["SOLUTION_CODE"],
+	}
+	finding.Line, finding.EndLine = parseFindingLine(firstLine(fields["LINE"]))
+
+	// Some models put the line in the file, as in path/to/file.go:42
+	if finding.Line == 0 {
+		if i := strings.LastIndex(finding.File, ":"); i > 0 {
+			if line, end := parseFindingLine(finding.File[i+1:]); line > 0 {
+				finding.File, finding.Line, finding.EndLine = finding.File[:i], line, end
+			}
+		}
+	}
+
+	return finding, finding.File != "" || finding.Problem != ""
+}
+
+// firstLine returns the first line of a field's text
+func firstLine(text string) string {
+	line, _, _ := strings.Cut(text, "\n")
+	return strings.TrimSpace(line)
+}
+
+// cleanFindingFile strips the quoting models put around paths
+func cleanFindingFile(file string) string {
+	return strings.Trim(file, "`'\"*[] ")
+}
+
+// parseFindingLine reads a line number or range, returning 0 when there is none
+func parseFindingLine(text string) (line, endLine int) {
+	match := findingLinePattern.FindStringSubmatch(text)
+	if match == nil {
+		return 0, 0
+	}
+	line, _ = strconv.Atoi(match[1])
+	if match[2] != "" {
+		endLine, _ = strconv.Atoi(match[2])
+		if endLine <= line {
+			endLine = 0
+		}
+	}
+	return line, endLine
+}
+
+// normalizeSeverity spells the known severities the same way, keeping any other value as written
+func normalizeSeverity(severity string) string {
+	severity = strings.Trim(severity, "[]*` ")
+	for _, known := range []string{SeverityCritical, SeverityMajor, SeverityMinor} {
+		if strings.EqualFold(severity, known) {
+			return known
+		}
+	}
+	return severity
+}
+
+// findingsPath returns the path of the findings saved next to a markdown artifact
+func findingsPath(markdownPath string) string {
+	return strings.TrimSuffix(markdownPath, filepath.Ext(markdownPath)) + ".json"
+}
+
+// saveFindings parses a review phase's output and saves its findings next to the phase's artifact
+func (w *Workflow) saveFindings(phase, outputPath, response string) error {
+	findings := ParseFindings(phase, response)
+	logger.Verbose("%s reported %d issues", phase, len(findings.Issues))
+	return writeFindings(findingsPath(outputPath), findings)
+}
+
+// writeFindings saves findings as JSON
+func writeFindings(path string, findings interface{}) error {
+	content, err := json.MarshalIndent(findings, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode findings: %w", err)
+	}
+	if err := os.WriteFile(path, append(content, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write findings: %w", err)
+	}
+	return nil
+}
+
+// LoadFindings reads the findings saved for a review phase
+func LoadFindings(path string) (Findings, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return Findings{}, fmt.Errorf("failed to read findings: %w", err)
+	}
+	var findings Findings
+	if err := json.Unmarshal(content, &findings); err != nil {
+		return Findings{}, fmt.Errorf("failed to parse findings %s: %w", path, err)
+	}
+	return findings, nil
+}
+
+// phaseFindings returns the findings of a review phase artifact, parsing the artifact if they weren't
+// saved, as for output from before findings were recorded
+func (w *Workflow) phaseFindings(markdownPath string, content string) Findings {
+	if findings, err := LoadFindings(findingsPath(markdownPath)); err == nil {
+		return findings
+	}
+	phase := strings.TrimSuffix(filepath.Base(markdownPath), filepath.Ext(markdownPath))
+	return ParseFindings(strings.TrimPrefix(phase, w.Ctx.Ticket+"-"), content)
+}
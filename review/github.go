@@ -0,0 +1,219 @@
+package review
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/jeremyhunt/agent-runner/github"
+	"github.com/jeremyhunt/agent-runner/gitrepo"
+	"github.com/jeremyhunt/agent-runner/logger"
+)
+
+// prepareChangesFromGitHub reads the pull request's diff, changed files, commits and review comments
+// through the GitHub API. File contents are then read from the API at the merge-base and head commits.
+func (w *Workflow) prepareChangesFromGitHub() error {
+	client, repo := w.Ctx.GitHub, w.Ctx.GitHubRepo
+	ctx := context.Background()
+
+	number := w.Ctx.PullRequestNumber
+	if number == 0 {
+		if w.Ctx.Branch == "" {
+			return fmt.Errorf("a pull request number or branch is needed to review from GitHub")
+		}
+		found, err := client.FindPullRequest(ctx, repo, w.Ctx.Branch)
+		if err != nil {
+			return err
+		}
+		number = found.Number
+	}
+	pr, err := client.PullRequest(ctx, repo, number)
+	if err != nil {
+		return err
+	}
+	w.Ctx.PullRequestNumber = pr.Number
+	logger.Verbose("Reviewing %s#%d: %s", repo, pr.Number, pr.Title)
+
+	// The pull request's diff is taken against the merge-base, which the original contents are read at
+	mergeBase, err := client.MergeBase(ctx, repo, pr.Base.SHA, pr.Head.SHA)
+	if err != nil {
+		return err
+	}
+	diffContent, err := client.PullRequestDiff(ctx, repo, pr.Number)
+	if err != nil {
+		return err
+	}
+	files, err := client.PullRequestFiles(ctx, repo, pr.Number)
+	if err != nil {
+		return err
+	}
+	changes := changeSetFromFiles(files)
+	if changes.IsEmpty() {
+		return fmt.Errorf("no changes found in %s#%d", repo, pr.Number)
+	}
+	comments, err := client.ReviewComments(ctx, repo, pr.Number)
+	if err != nil {
+		return err
+	}
+
+	w.Ctx.Repo = client.Repository(repo)
+	w.Ctx.BaseRef = pr.Base.Ref
+	w.Ctx.HeadRef = pr.Head.SHA
+	w.Ctx.HeadCommit = pr.Head.SHA
+	w.Ctx.MergeBase = mergeBase
+	w.Ctx.Changes = changes
+	w.Ctx.DiffContent = diffContent
+	w.Ctx.FilesContent = changes.Markdown(pr.Head.Ref)
+	w.Ctx.PullRequestDetails = formatPullRequest(pr, comments)
+	w.parseDiff()
+	logger.Debug("Comparing %s against merge-base %s (%s)", pr.Head.SHA, mergeBase, pr.Base.Ref)
+	return w.saveChanges()
+}
+
+// changeSetFromFiles sorts a pull request's changed files by status, in the order git lists them
+func changeSetFromFiles(files []github.File) *gitrepo.ChangeSet {
+	changes := &gitrepo.ChangeSet{}
+	for _, file := range files {
+		switch file.Status {
+		case github.StatusAdded, github.StatusCopied:
+			changes.Added = append(changes.Added, file.Filename)
+		case github.StatusRemoved:
+			changes.Deleted = append(changes.Deleted, file.Filename)
+		case github.StatusRenamed:
+			changes.Renamed = append(changes.Renamed, gitrepo.Rename{From: file.PreviousFilename, To: file.Filename})
+		default:
+			changes.Modified = append(changes.Modified, file.Filename)
+		}
+
+		// Binary files have no patch and no line counts
+		stat := gitrepo.FileStat{Path: file.Filename, Added: file.Additions, Deleted: file.Deletions}
+		stat.Binary = file.Patch == "" && file.Additions == 0 && file.Deletions == 0 && file.Status != github.StatusRenamed
+		changes.Stats = append(changes.Stats, stat)
+	}
+
+	sort.Strings(changes.Added)
+	sort.Strings(changes.Modified)
+	sort.Strings(changes.Deleted)
+	sort.Slice(changes.Renamed, func(i, j int) bool { return changes.Renamed[i].To < changes.Renamed[j].To })
+	sort.SliceStable(changes.Stats, func(i, j int) bool {
+		return changes.Stats[i].Added+changes.Stats[i].Deleted > changes.Stats[j].Added+changes.Stats[j].Deleted
+	})
+	return changes
+}
+
+// formatPullRequest formats a pull request's title, description and existing review comments for the prompts
+func formatPullRequest(pr *github.PullRequest, comments []github.Comment) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("**Title:** %s\n", pr.Title))
+	if pr.User.Login != "" {
+		sb.WriteString(fmt.Sprintf("**Author:** %s\n", pr.User.Login))
+	}
+	sb.WriteString(fmt.Sprintf("**Branch:** %s into %s\n", pr.Head.Ref, pr.Base.Ref))
+
+	description := strings.TrimSpace(pr.Body)
+	if description == "" {
+		description = "_No description provided._"
+	}
+	sb.WriteString(fmt.Sprintf("\n**Description:**\n\n%s\n", description))
+
+	if len(comments) > 0 {
+		sb.WriteString("\n**Existing Review Comments:**\n\n")
+		for _, comment := range comments {
+			location := comment.Path
+			if comment.Line > 0 {
+				location = fmt.Sprintf("%s:%d", comment.Path, comment.Line)
+			}
+			body := strings.ReplaceAll(strings.TrimSpace(comment.Body), "\n", "\n  ")
+			sb.WriteString(fmt.Sprintf("- %s (%s): %s\n", location, comment.User.Login, body))
+		}
+	}
+	return sb.String()
+}
+
+// GitHubReview builds the pull request review of the last run: the final summary as its body, and an
+// inline comment for each finding whose lines are in the diff. It also returns the number of findings
+// left out because their file or line isn't in the diff; they are still covered by the summary.
+func (w *Workflow) GitHubReview() (github.Review, int, error) {
+	summary, err := os.ReadFile(w.artifactPath("final-summary.md"))
+	if err != nil {
+		return github.Review{}, 0, fmt.Errorf("failed to read final summary: %w", err)
+	}
+	// Pin the comments to the commit the diff was computed from, in case the pull request was
+	// pushed to during the review
+	review := github.Review{
+		CommitID: w.Ctx.HeadCommit,
+		Body:     strings.TrimSpace(string(summary)),
+		Event:    github.EventComment,
+	}
+
+	content, err := os.ReadFile(findingsPath(w.artifactPath("review-result.md")))
+	if err != nil {
+		return github.Review{}, 0, fmt.Errorf("failed to read review findings: %w", err)
+	}
+	var phases []Findings
+	if err := json.Unmarshal(content, &phases); err != nil {
+		return github.Review{}, 0, fmt.Errorf("failed to parse review findings: %w", err)
+	}
+
+	skipped := 0
+	for _, phase := range phases {
+		for _, finding := range phase.Issues {
+			comment, ok := w.reviewComment(finding)
+			if !ok {
+				skipped++
+				continue
+			}
+			review.Comments = append(review.Comments, comment)
+		}
+	}
+	return review, skipped, nil
+}
+
+// reviewComment returns the inline comment of a finding. GitHub only accepts comments on lines shown
+// in the diff, so a finding gets one only when its line is in a hunk of its file, and a range only
+// when its first line is in the same hunk as its last.
+func (w *Workflow) reviewComment(f Finding) (github.ReviewComment, bool) {
+	if w.Ctx.ParsedDiff == nil || f.Line == 0 {
+		return github.ReviewComment{}, false
+	}
+	file := w.Ctx.ParsedDiff.File(f.File)
+	if file == nil || file.IsDeleted || !file.InHunk(f.Line) {
+		return github.ReviewComment{}, false
+	}
+
+	comment := github.ReviewComment{Path: file.NewPath, Line: f.Line, Side: github.SideRight, Body: commentBody(f)}
+	if f.EndLine > f.Line {
+		for _, hunk := range file.Hunks {
+			end := hunk.NewStart + hunk.NewLines
+			if f.Line >= hunk.NewStart && f.EndLine < end {
+				comment.StartLine, comment.StartSide, comment.Line = f.Line, github.SideRight, f.EndLine
+				break
+			}
+		}
+	}
+	return comment, true
+}
+
+// commentBody formats a finding as the markdown of an inline comment
+func commentBody(f Finding) string {
+	var sb strings.Builder
+	title := strings.TrimSuffix(f.Phase, "-review") + " review"
+	if f.Category != "" {
+		words := strings.ToLower(strings.ReplaceAll(strings.TrimSuffix(f.Category, "_ISSUES"), "_", " "))
+		title = fmt.Sprintf("%s (%s)", words, title)
+	}
+	if f.Severity != "" {
+		title = fmt.Sprintf("[%s] %s", f.Severity, title)
+	}
+	sb.WriteString(fmt.Sprintf("**%s**\n\n", title))
+	if f.Problem != "" {
+		sb.WriteString(f.Problem + "\n")
+	}
+	if f.Solution != "" {
+		sb.WriteString("\n**Suggested fix:**\n\n" + f.Solution + "\n")
+	}
+	return sb.String()
+}
@@ -0,0 +1,130 @@
+package review
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jeremyhunt/agent-runner/language"
+	"github.com/jeremyhunt/agent-runner/logger"
+)
+
+// builtinLanguages is the registry used when none was configured
+var builtinLanguages = language.NewRegistry()
+
+// languages returns the language registry, using the built-in profiles if none was configured
+func (w *Workflow) languages() *language.Registry {
+	if w.Ctx.Languages == nil {
+		return builtinLanguages
+	}
+	return w.Ctx.Languages
+}
+
+// repoLanguage returns the predominant language of the changed files. It is resolved once by
+// PrepareChanges, before any step reads it concurrently; until then it is detected on each call.
+func (w *Workflow) repoLanguage() *language.Profile {
+	if w.Ctx.Language != nil {
+		return w.Ctx.Language
+	}
+	return w.detectLanguage()
+}
+
+// resolveLanguage detects the predominant language of the changed files and stores it in the context.
+// Nothing is stored without a file list, so a generic profile isn't kept once the files are known.
+func (w *Workflow) resolveLanguage() {
+	if w.Ctx.FilesContent == "" {
+		return
+	}
+	w.Ctx.Language = w.detectLanguage()
+	if !w.Ctx.Language.IsGeneric() {
+		logger.Verbose("Detected %s as the primary language", w.Ctx.Language.Name)
+	}
+}
+
+// detectLanguage detects the predominant language from the paths in the file list
+func (w *Workflow) detectLanguage() *language.Profile {
+	var paths []string
+	if sections, err := parseFileSections(w.Ctx.FilesContent); err == nil {
+		paths = append(paths, sections["Modified"]...)
+		paths = append(paths, sections["Added"]...)
+		paths = append(paths, sections["Deleted"]...)
+		for _, rename := range sections["Renamed"] {
+			_, to, _ := strings.Cut(rename, " -> ")
+			paths = append(paths, to)
+		}
+	}
+	return w.languages().DetectRepo(paths)
+}
+
+// frameworkExamples returns the examples given when the discovery prompt asks which framework is used,
+// taken from the repository's language
+func (w *Workflow) frameworkExamples() string {
+	profile := w.repoLanguage()
+	if len(profile.Frameworks) == 0 {
+		return "(e.g., a web, application or testing framework, or a custom one)"
+	}
+	return fmt.Sprintf("(e.g., %s, custom)", strings.Join(profile.Frameworks, ", "))
+}
+
+// codeBlock wraps file content in a markdown code fence for the file's language
+func (w *Workflow) codeBlock(filename, content string) string {
+	profile := w.languages().Detect(filename, content)
+	return fmt.Sprintf("```%s\n%s\n```", profile.Fence, content)
+}
+
+// issueFormatExample returns the ISSUE block example shown in the review phase prompts
+func (w *Workflow) issueFormatExample() string {
+	profile := w.repoLanguage()
+
+	var sb strings.Builder
+	sb.WriteString("```\n")
+	sb.WriteString("<ISSUE>\n")
+	sb.WriteString(fmt.Sprintf("FILE: %s\n", profile.ExampleFile))
+	sb.WriteString("LINE: 42\n")
+	sb.WriteString("SEVERITY: [Critical|Major|Minor]\n")
+	sb.WriteString("PROBLEM: Brief description\n")
+	sb.WriteString("... several lines of prior context with line numbers...\n")
+	sb.WriteString("SOLUTION_CODE:\n")
+	sb.WriteString(w.solutionCodeExample())
+	sb.WriteString("... more lines of prior context with line numbers if available...\n")
+	sb.WriteString("</ISSUE>\n")
+	sb.WriteString("```\n\n")
+	return sb.String()
+}
+
+// solutionCodeExample returns the original and fixed code example of an issue's solution
+func (w *Workflow) solutionCodeExample() string {
+	profile := w.repoLanguage()
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("```%s\n", profile.Fence))
+	sb.WriteString(fmt.Sprintf("%s Original\n", profile.LineComment))
+	sb.WriteString(profile.ExampleOriginal + "\n\n")
+	sb.WriteString(fmt.Sprintf("%s Fixed\n", profile.LineComment))
+	sb.WriteString(profile.ExampleFixed + "\n")
+	sb.WriteString("```\n")
+	return sb.String()
+}
+
+// exampleDiff returns a suggested-fix diff example in the repository's language, indented for the summary prompt
+func (w *Workflow) exampleDiff(indent string) string {
+	profile := w.repoLanguage()
+
+	var lines []string
+	lines = append(lines, "```diff")
+	lines = append(lines, fmt.Sprintf("%s Show function/class signature and at least 3-5 lines of context before the change", profile.LineComment))
+	lines = append(lines, profile.ExampleSignature)
+	lines = append(lines, fmt.Sprintf("    %s Several lines of context...", profile.LineComment))
+	for _, line := range strings.Split(profile.ExampleOriginal, "\n") {
+		lines = append(lines, "-   "+line)
+	}
+	for _, line := range strings.Split(profile.ExampleFixed, "\n") {
+		lines = append(lines, "+   "+line)
+	}
+	lines = append(lines, fmt.Sprintf("    %s More context lines...", profile.LineComment))
+	if profile.ExampleBlockEnd != "" {
+		lines = append(lines, profile.ExampleBlockEnd)
+	}
+	lines = append(lines, "```")
+
+	return indent + strings.Join(lines, "\n"+indent) + "\n\n"
+}
@@ -0,0 +1,58 @@
+package review
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// issueLocationPattern matches a FILE: line followed by a LINE: line inside an ISSUE block
+var issueLocationPattern = regexp.MustCompile(`(?m)^\s*FILE:\s*(\S+)\s*\n\s*LINE:\s*~?(\d+)`)
+
+// LineReference is a FILE/LINE pair reported by a review phase
+type LineReference struct {
+	File string
+	Line int
+	// InDiff is true when the file is part of the PR diff
+	InDiff bool
+	// Changed is true when the line was added or modified by the PR
+	Changed bool
+}
+
+// String formats the reference as path:line
+func (r LineReference) String() string {
+	return fmt.Sprintf("%s:%d", r.File, r.Line)
+}
+
+// CheckLineReferences extracts the FILE/LINE pairs from review output and checks them against the parsed diff
+func (w *Workflow) CheckLineReferences(content string) []LineReference {
+	var refs []LineReference
+	for _, match := range issueLocationPattern.FindAllStringSubmatch(content, -1) {
+		line, err := strconv.Atoi(match[2])
+		if err != nil {
+			continue
+		}
+
+		ref := LineReference{File: strings.Trim(match[1], "`"), Line: line}
+		if w.Ctx.ParsedDiff != nil {
+			if file := w.Ctx.ParsedDiff.File(ref.File); file != nil {
+				ref.InDiff = true
+				ref.Changed = file.IsChangedLine(line)
+			}
+		}
+		refs = append(refs, ref)
+	}
+	return refs
+}
+
+// unverifiedLineReferences returns the references that do not point at a line changed by the PR
+func (w *Workflow) unverifiedLineReferences(content string) []LineReference {
+	var unverified []LineReference
+	for _, ref := range w.CheckLineReferences(content) {
+		if !ref.Changed {
+			unverified = append(unverified, ref)
+		}
+	}
+	return unverified
+}
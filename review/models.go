@@ -0,0 +1,114 @@
+package review
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jeremyhunt/agent-runner/llm"
+)
+
+// ModelGroups maps each group of steps whose model can be chosen on its own to the built-in steps in it
+var ModelGroups = map[string][]string{
+	"discovery":  {"discovery"},
+	"analysis":   {"original-analysis", "new-components"},
+	"synthesis":  {"synthesis"},
+	"review":     {"syntax-review", "functionality-review", "defensive-review"},
+	"validation": {"validation"},
+	"summary":    {"final-summary"},
+}
+
+// SetGroupClient sends the prompts of a group of steps from ModelGroups to another provider or model
+func (ctx *ReviewContext) SetGroupClient(group string, client llm.Provider) error {
+	steps, ok := ModelGroups[group]
+	if !ok {
+		groups := make([]string, 0, len(ModelGroups))
+		for name := range ModelGroups {
+			groups = append(groups, name)
+		}
+		sort.Strings(groups)
+		return fmt.Errorf("unknown model step %q (expected one of %s)", group, strings.Join(groups, ", "))
+	}
+
+	if ctx.StepClients == nil {
+		ctx.StepClients = make(map[string]llm.Provider)
+	}
+	for _, step := range steps {
+		ctx.StepClients[step] = client
+	}
+	return nil
+}
+
+// client returns the provider a step's prompts are sent to
+func (w *Workflow) client(step string) llm.Provider {
+	if client, ok := w.stepClient(step); ok {
+		return client
+	}
+	return w.Ctx.Client
+}
+
+// stepClient returns the provider of a step that doesn't use the default model: a step of a group
+// set with SetGroupClient, or a configured step with a model override. The override is sent to the
+// step's own provider.
+func (w *Workflow) stepClient(step string) (llm.Provider, bool) {
+	client, ok := w.Ctx.StepClients[step]
+	model := w.stepModel(step)
+	if model == "" {
+		return client, ok
+	}
+	if !ok {
+		client = w.Ctx.Client
+	}
+	if client == nil {
+		return nil, false
+	}
+	if client.Model() != model {
+		client = client.WithModel(model)
+	}
+	return client, true
+}
+
+// stepModel returns the model override of a step in the pipeline config, if any
+func (w *Workflow) stepModel(step string) string {
+	if w.Ctx.Pipeline == nil {
+		return ""
+	}
+	for _, config := range w.Ctx.Pipeline.Steps {
+		if config.Name == step {
+			return config.Model
+		}
+	}
+	return ""
+}
+
+// ownModelSteps returns the steps that don't use the default model
+func (w *Workflow) ownModelSteps() []string {
+	var steps []string
+	for step := range w.Ctx.StepClients {
+		steps = append(steps, step)
+	}
+	if w.Ctx.Pipeline != nil {
+		for _, config := range w.Ctx.Pipeline.Steps {
+			if _, ok := w.Ctx.StepClients[config.Name]; !ok && config.Model != "" {
+				steps = append(steps, config.Name)
+			}
+		}
+	}
+	return steps
+}
+
+// countTokens counts the tokens of a step's prompt with the tokenizer of the step's model
+func (w *Workflow) countTokens(step, text string) (int, error) {
+	if client, ok := w.stepClient(step); ok {
+		return client.CountText(text)
+	}
+	return w.Ctx.TokenCounter.CountText(text, w.Ctx.Model)
+}
+
+// maxTokens returns the prompt budget of a step's model, leaving room for the response
+func (w *Workflow) maxTokens(step string) int {
+	if client, ok := w.stepClient(step); ok {
+		return llm.PromptBudget(client)
+	}
+	return w.Ctx.MaxTokens
+}
@@ -0,0 +1,115 @@
+package review
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jeremyhunt/agent-runner/logger"
+)
+
+// ParseAddedFiles extracts the list of files added by the PR
+func (w *Workflow) ParseAddedFiles() ([]string, error) {
+	if w.Ctx.FilesContent == "" {
+		return nil, fmt.Errorf("files content is empty")
+	}
+
+	sections, err := parseFileSections(w.Ctx.FilesContent)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Debug("Found %d added files in the PR", len(sections["Added"]))
+	return sections["Added"], nil
+}
+
+// GetHeadFileContent retrieves the content of a file as of the head commit under review
+func (w *Workflow) GetHeadFileContent(file string) (string, error) {
+	if w.Ctx.Repo == nil || w.Ctx.HeadRef == "" {
+		return "", fmt.Errorf("head commit has not been resolved")
+	}
+
+	return w.Ctx.Repo.Show(w.Ctx.HeadRef, file)
+}
+
+// NewFileAnalysisPrompt generates a prompt for analyzing a file added by the PR
+func (w *Workflow) NewFileAnalysisPrompt(filename, content string) string {
+	prompt := w.architectureSection("##")
+	prompt += "Your goal is to understand what this NEW file, added by the PR, introduces and how it fits into the existing system.\n\n"
+	prompt += fmt.Sprintf("File: %s\n\n", filename)
+	prompt += "Here's the full content of the new file:\n"
+	prompt += w.codeBlock(filename, content)
+	prompt += "\n\n"
+	prompt += "Focus on:\n"
+	prompt += "1. What responsibility does this new component have, and which feature in the PR does it serve?\n"
+	prompt += "2. What are its public functions/methods, with their inputs, outputs and side effects?\n"
+	prompt += "3. What existing parts of the system does it depend on or get called from?\n"
+	prompt += "4. What business rules, validation and error handling does it implement?\n"
+	prompt += "5. What assumptions does it make that a reviewer should verify (inputs, nullability, ordering, external state)?\n\n"
+	prompt += "IMPORTANT: Your analysis will be used by another LLM as context when reviewing the PR. Be concrete about names and behavior, and don't review the code yet.\n\n"
+	prompt += "Provide a clear, concise analysis of what this new component does and how it interacts with the rest of the system."
+
+	return prompt
+}
+
+// AnalyzeNewFile sends a new file to the LLM for analysis and returns the result
+func (w *Workflow) AnalyzeNewFile(filename, content string) (string, error) {
+	prompt := w.NewFileAnalysisPrompt(filename, content)
+
+	response, err := w.ask("new-components", w.GetCommonPromptIntro("analyzer"), prompt)
+	if err != nil {
+		return "", fmt.Errorf("error analyzing new file %s: %w", filename, err)
+	}
+
+	return response, nil
+}
+
+// AnalyzeNewComponents analyzes each file added by the PR using its content at the head commit
+func (w *Workflow) AnalyzeNewComponents() error {
+	// 1. Get the list of added files
+	addedFiles, err := w.ParseAddedFiles()
+	if err != nil {
+		return fmt.Errorf("error parsing added files: %w", err)
+	}
+
+	outputPath := w.newComponentsPath()
+	if len(addedFiles) == 0 {
+		// Remove stale output from a previous run so the synthesis doesn't pick it up
+		if err := os.Remove(outputPath); err != nil && !os.IsNotExist(err) {
+			logger.Debug("Warning: Could not remove existing new components file: %v", err)
+		}
+		logger.StepDetail("No added files to analyze")
+		return nil
+	}
+
+	// 2. Analyze each file concurrently
+	results := w.analyzeFiles("new-components", addedFiles, w.GetHeadFileContent, w.AnalyzeNewFile)
+
+	// 3. Build the output in the original order
+	var sb strings.Builder
+	sb.WriteString("# New Components\n\n")
+	sb.WriteString("This document provides an analysis of the files added by this PR.\n\n")
+	for _, result := range results {
+		if result.err != nil {
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("## %s\n\n%s\n\n", result.file, result.analysis))
+	}
+	sb.WriteString(unanalyzedFilesSection(results))
+
+	// 4. Write the result to a file
+	err = os.WriteFile(outputPath, []byte(sb.String()), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write new components analysis: %w", err)
+	}
+
+	logger.Debug("New components analysis saved")
+	logger.Debug("Output path: %s", outputPath)
+	return nil
+}
+
+// newComponentsPath returns the path of the new components analysis artifact
+func (w *Workflow) newComponentsPath() string {
+	return filepath.Join(w.Ctx.OutputDir, fmt.Sprintf("%s-new-components.md", w.Ctx.Ticket))
+}
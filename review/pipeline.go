@@ -0,0 +1,417 @@
+package review
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/jeremyhunt/agent-runner/logger"
+)
+
+// artifactPath returns the path of a review artifact for the current ticket
+func (w *Workflow) artifactPath(suffix string) string {
+	return filepath.Join(w.Ctx.OutputDir, fmt.Sprintf("%s-%s", w.Ctx.Ticket, suffix))
+}
+
+// stepNames returns the names of the given steps, in order
+func stepNames(steps []Step) []string {
+	var names []string
+	for _, step := range steps {
+		names = append(names, step.Name())
+	}
+	return names
+}
+
+// builtinSteps returns the default review pipeline, in execution order
+func (w *Workflow) builtinSteps() []Step {
+	discoveryPath := w.artifactPath("initial-discovery.md")
+	originalContentPath := w.artifactPath("original-file-content.md")
+	originalImplementationPath := w.artifactPath("original-implementation.md")
+	newComponentsPath := w.newComponentsPath()
+	synthesisPath := w.artifactPath("original-synthesis.md")
+	reviewPath := w.artifactPath("review-result.md")
+	validationPath := w.artifactPath("validation.md")
+	summaryPath := w.artifactPath("final-summary.md")
+
+	return []Step{
+		&funcStep{
+			name:    "discovery",
+			title:   "Performing initial discovery",
+			outputs: []string{discoveryPath},
+			run: func() error {
+				logger.StepDetail("Sending Initial Discovery prompt to %s", w.client("discovery").Model())
+				return w.RunLLMStep("discovery", "Initial Discovery", "discoverer", w.InitialDiscoveryPrompt, discoveryPath)
+			},
+		},
+		&funcStep{
+			name:    "original-contents",
+			title:   "Collecting original file contents",
+			outputs: []string{originalContentPath},
+			run: func() error {
+				if err := w.CollectOriginalFileContents(); err != nil {
+					return fmt.Errorf("error collecting original file contents: %w", err)
+				}
+				logger.Success("Original file content collection completed")
+				return nil
+			},
+		},
+		&funcStep{
+			name:    "original-analysis",
+			section: "PREVIOUS IMPLEMENTATION ANALYSIS",
+			title:   "Analyzing original implementation",
+			inputs:  []string{discoveryPath, originalContentPath},
+			outputs: []string{originalImplementationPath},
+			run: func() error {
+				// Get the number of files to analyze from the recommended file order
+				orderedFiles, err := w.ParseRecommendedFileOrder()
+				if err != nil {
+					logger.Debug("Could not parse recommended file order: %v", err)
+					logger.StepDetail("Starting file analysis using concurrent workers")
+				} else {
+					logger.StepDetail("Starting analysis of %d files with up to %d workers", len(orderedFiles), w.concurrency())
+				}
+				// Add a blank line after the message
+				fmt.Println()
+
+				if err := w.AnalyzeOriginalImplementation(); err != nil {
+					return fmt.Errorf("error analyzing original implementation: %w", err)
+				}
+				logger.Success("Original implementation analysis completed")
+				return nil
+			},
+		},
+		&funcStep{
+			name:    "new-components",
+			title:   "Analyzing new components",
+			inputs:  []string{w.Ctx.FilesPath, w.Ctx.DiffPath},
+			outputs: []string{newComponentsPath},
+			run: func() error {
+				addedFiles, err := w.ParseAddedFiles()
+				if err != nil {
+					logger.Debug("Could not parse added files: %v", err)
+				} else if len(addedFiles) > 0 {
+					logger.StepDetail("Starting analysis of %d added files with up to %d workers", len(addedFiles), w.concurrency())
+					// Add a blank line after the message
+					fmt.Println()
+				}
+
+				if err := w.AnalyzeNewComponents(); err != nil {
+					return fmt.Errorf("error analyzing new components: %w", err)
+				}
+				logger.Success("New components analysis completed")
+				return nil
+			},
+		},
+		&funcStep{
+			name:    "synthesis",
+			title:   "Synthesizing original implementation",
+			inputs:  []string{originalImplementationPath, newComponentsPath},
+			outputs: []string{synthesisPath},
+			run: func() error {
+				logger.StepDetail("Synthesizing file analyses")
+				if err := w.SynthesizeOriginalImplementation(); err != nil {
+					return fmt.Errorf("error synthesizing original implementation: %w", err)
+				}
+				// Add a blank line before the success message
+				fmt.Println()
+				logger.Success("Original implementation synthesis completed")
+				return nil
+			},
+			restore: func() error {
+				content, err := os.ReadFile(synthesisPath)
+				if err != nil {
+					return fmt.Errorf("failed to read synthesis: %w", err)
+				}
+				w.Ctx.SynthesisContent = string(content)
+				return nil
+			},
+		},
+		&funcStep{
+			name:        "syntax-review",
+			section:     "PR REVIEW GENERATION",
+			title:       "Generating syntax and best practices review",
+			inputs:      []string{synthesisPath},
+			outputs:     []string{w.reviewPhasePath("syntax"), findingsPath(w.reviewPhasePath("syntax"))},
+			reviewPhase: true,
+			run: func() error {
+				if err := w.GenerateSyntaxReview(); err != nil {
+					return fmt.Errorf("error generating syntax review: %w", err)
+				}
+				logger.Success("Syntax review completed")
+				return nil
+			},
+		},
+		&funcStep{
+			name:        "functionality-review",
+			title:       "Generating functionality review",
+			inputs:      []string{synthesisPath},
+			outputs:     []string{w.reviewPhasePath("functionality"), findingsPath(w.reviewPhasePath("functionality"))},
+			reviewPhase: true,
+			run: func() error {
+				if err := w.GenerateFunctionalityReview(); err != nil {
+					return fmt.Errorf("error generating functionality review: %w", err)
+				}
+				logger.Success("Functionality review completed")
+				return nil
+			},
+		},
+		&funcStep{
+			name:        "defensive-review",
+			title:       "Generating defensive programming review",
+			inputs:      []string{synthesisPath},
+			outputs:     []string{w.reviewPhasePath("defensive"), findingsPath(w.reviewPhasePath("defensive"))},
+			reviewPhase: true,
+			run: func() error {
+				if err := w.GenerateDefensiveReview(); err != nil {
+					return fmt.Errorf("error generating defensive programming review: %w", err)
+				}
+				logger.Success("Defensive programming review completed")
+				return nil
+			},
+		},
+		&funcStep{
+			name:    "validation",
+			title:   "Validating review findings",
+			inputs:  []string{reviewPath},
+			outputs: []string{validationPath},
+			run: func() error {
+				logger.StepDetail("Challenging assumptions and validating issues")
+				if err := w.ValidateReviewFindings(); err != nil {
+					return fmt.Errorf("error validating review findings: %w", err)
+				}
+				// Add a blank line before the success message
+				fmt.Println()
+				logger.Success("Review validation completed")
+				return nil
+			},
+		},
+		&funcStep{
+			name:    "final-summary",
+			title:   "Generating final review summary",
+			inputs:  []string{reviewPath, validationPath},
+			outputs: []string{summaryPath},
+			run: func() error {
+				logger.StepDetail("Creating human-friendly review summary")
+				if err := w.GenerateFinalSummary(); err != nil {
+					return fmt.Errorf("error generating final summary: %w", err)
+				}
+				// Add a blank line before the success message
+				fmt.Println()
+				logger.Success("Final review summary saved")
+				return nil
+			},
+		},
+	}
+}
+
+// forcedSteps returns the steps --from-step or --only-step require to run, or nil when neither is set.
+// Steps that write the same artifact as a forced step are forced too, since they build it together.
+func (w *Workflow) forcedSteps(steps []Step) (map[string]bool, error) {
+	target := w.Ctx.FromStep
+	if w.Ctx.OnlyStep != "" {
+		target = w.Ctx.OnlyStep
+	}
+	if target == "" {
+		return nil, nil
+	}
+
+	index := -1
+	for i, step := range steps {
+		if step.Name() == target {
+			index = i
+			break
+		}
+	}
+	if index == -1 {
+		return nil, fmt.Errorf("unknown step %q (available steps: %s)", target, strings.Join(stepNames(steps), ", "))
+	}
+
+	forced := make(map[string]bool)
+	for i, step := range steps {
+		if step.Name() == target || (w.Ctx.OnlyStep == "" && i > index) {
+			forced[step.Name()] = true
+		}
+	}
+
+	for _, step := range steps {
+		if !forced[step.Name()] {
+			continue
+		}
+		for _, other := range steps {
+			if !forced[other.Name()] && sharesOutput(step, other) {
+				logger.Verbose("Also running %s because it writes the same artifact as %s", other.Name(), step.Name())
+				forced[other.Name()] = true
+			}
+		}
+	}
+	return forced, nil
+}
+
+// sharesOutput reports whether two steps write any of the same artifacts
+func sharesOutput(a, b Step) bool {
+	for _, x := range a.Outputs() {
+		for _, y := range b.Outputs() {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// runSteps executes the pipeline, skipping steps the checkpoint options allow to be reused.
+// Consecutive review phases run concurrently and are merged into the review file once they finish.
+func (w *Workflow) runSteps(steps []Step) error {
+	forced, err := w.forcedSteps(steps)
+	if err != nil {
+		return err
+	}
+
+	manifestPath := ManifestPath(w.Ctx.OutputDir, w.Ctx.Ticket)
+	manifest, err := LoadManifest(manifestPath, w.Ctx.Ticket)
+	if err != nil {
+		return err
+	}
+
+	run := &stepRun{forced: forced, manifest: manifest, manifestPath: manifestPath}
+	var phases []string
+	for i := 0; i < len(steps); {
+		// Collect the group of consecutive review phases starting here
+		end := i + 1
+		if steps[i].ReviewPhase() {
+			for end < len(steps) && steps[end].ReviewPhase() {
+				end++
+			}
+		}
+		group := steps[i:end]
+		i = end
+
+		if len(group) == 1 && !group[0].ReviewPhase() {
+			if err := w.runStep(run, group[0]); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := w.runReviewPhases(run, group); err != nil {
+			return err
+		}
+		for _, phase := range group {
+			phases = append(phases, phase.Outputs()[0])
+		}
+		if err := w.MergeReviewPhases(phases); err != nil {
+			return err
+		}
+		logger.Success("Review phases merged")
+	}
+	return nil
+}
+
+// stepRun holds the checkpoint state of a pipeline run
+type stepRun struct {
+	forced       map[string]bool
+	manifest     *Manifest
+	manifestPath string
+	ranForced    bool
+}
+
+// announce prints the step header and decides whether the step can reuse its saved output.
+// Inputs are hashed here, just before the step, so they reflect any regenerated artifacts.
+func (w *Workflow) announce(run *stepRun, step Step) (skip bool, inputHash string, err error) {
+	if step.Section() != "" {
+		logger.Section(step.Section())
+	}
+	logger.Step(step.Title())
+
+	inputHash = w.inputHash(step)
+	switch {
+	case run.forced != nil && !run.forced[step.Name()]:
+		// Steps after the requested ones may be left without output, but earlier ones feed them
+		if !run.ranForced && !run.manifest.HasOutputs(step.Name()) {
+			return false, "", fmt.Errorf("step %s has no saved output to reuse; run it first", step.Name())
+		}
+		skip = true
+	case run.forced == nil && w.Ctx.Resume:
+		skip = run.manifest.Unchanged(step.Name(), inputHash)
+	}
+
+	if skip {
+		if err := step.Restore(); err != nil {
+			return false, "", fmt.Errorf("error restoring %s step: %w", step.Name(), err)
+		}
+		logger.StepDetail("Skipped, reusing saved output")
+	}
+	return skip, inputHash, nil
+}
+
+// complete checkpoints a step that ran, so a failure later on doesn't lose completed work
+func (w *Workflow) complete(run *stepRun, step Step, inputHash string) error {
+	run.ranForced = run.ranForced || run.forced[step.Name()]
+	run.manifest.Record(step.Name(), inputHash, step.Outputs())
+	return run.manifest.Save(run.manifestPath)
+}
+
+// runStep runs a single step unless its saved output can be reused
+func (w *Workflow) runStep(run *stepRun, step Step) error {
+	skip, inputHash, err := w.announce(run, step)
+	if err != nil || skip {
+		return err
+	}
+
+	if err := step.Run(); err != nil {
+		return err
+	}
+	return w.complete(run, step, inputHash)
+}
+
+// runReviewPhases runs a group of review phases concurrently. Every phase is allowed to finish
+// and successful ones are checkpointed before any failure is reported.
+func (w *Workflow) runReviewPhases(run *stepRun, phases []Step) error {
+	var pending []Step
+	hashes := make(map[string]string)
+	for _, phase := range phases {
+		skip, inputHash, err := w.announce(run, phase)
+		if err != nil {
+			return err
+		}
+		if !skip {
+			pending = append(pending, phase)
+			hashes[phase.Name()] = inputHash
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	if len(pending) > 1 {
+		fmt.Println()
+		logger.StepDetail("Running %d review phases concurrently", len(pending))
+	}
+
+	errs := make([]error, len(pending))
+	var wg sync.WaitGroup
+	for i, phase := range pending {
+		wg.Add(1)
+		go func(i int, phase Step) {
+			defer wg.Done()
+			errs[i] = phase.Run()
+		}(i, phase)
+	}
+	wg.Wait()
+
+	// Checkpoint and report in pipeline order so the outcome doesn't depend on timing
+	var failures []error
+	for i, phase := range pending {
+		if errs[i] != nil {
+			failures = append(failures, errs[i])
+			continue
+		}
+		if err := w.complete(run, phase, hashes[phase.Name()]); err != nil {
+			return err
+		}
+	}
+	return errors.Join(failures...)
+}
@@ -0,0 +1,401 @@
+package review
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/jeremyhunt/agent-runner/logger"
+	"gopkg.in/yaml.v3"
+)
+
+// PipelineConfig lists the steps of a review pipeline, in execution order
+type PipelineConfig struct {
+	Steps []StepConfig `json:"steps" yaml:"steps"`
+}
+
+// StepConfig configures one pipeline step. An entry that only names a built-in step runs it
+// unchanged; any other entry is a prompt step that renders a template and saves the response.
+type StepConfig struct {
+	// Name identifies the step (e.g. "synthesis" or "performance-review")
+	Name string `json:"name" yaml:"name"`
+
+	// Title is shown in the step header (defaults to the name)
+	Title string `json:"title,omitempty" yaml:"title,omitempty"`
+
+	// Section starts a new output section before the step
+	Section string `json:"section,omitempty" yaml:"section,omitempty"`
+
+	// Prompt is the path of a text/template prompt file, relative to the pipeline config
+	Prompt string `json:"prompt,omitempty" yaml:"prompt,omitempty"`
+
+	// Model overrides the model used for the step, built-in or configured
+	Model string `json:"model,omitempty" yaml:"model,omitempty"`
+
+	// Inputs are the names of earlier steps whose output the prompt uses
+	Inputs []string `json:"inputs,omitempty" yaml:"inputs,omitempty"`
+
+	// Output is the artifact name, saved as TICKET-<output> (defaults to <name>.md)
+	Output string `json:"output,omitempty" yaml:"output,omitempty"`
+
+	// ReviewPhase merges the response into the combined review so it is validated and summarized
+	ReviewPhase bool `json:"review_phase,omitempty" yaml:"review_phase,omitempty"`
+
+	// promptPath is the resolved location of the prompt file
+	promptPath string
+
+	// promptTemplate is the parsed prompt file
+	promptTemplate *template.Template
+}
+
+// LoadPipelineConfig reads a YAML pipeline config, or a JSON one if the file ends in .json, and parses
+// the prompt templates it refers to
+func LoadPipelineConfig(path string) (*PipelineConfig, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading pipeline config: %w", err)
+	}
+
+	var config PipelineConfig
+	unmarshal := yaml.Unmarshal
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		unmarshal = json.Unmarshal
+	}
+	if err := unmarshal(content, &config); err != nil {
+		return nil, fmt.Errorf("error parsing pipeline config %s: %w", path, err)
+	}
+	if len(config.Steps) == 0 {
+		return nil, fmt.Errorf("pipeline config %s has no steps", path)
+	}
+
+	for i := range config.Steps {
+		step := &config.Steps[i]
+		if step.Name == "" {
+			return nil, fmt.Errorf("step %d in %s is missing a name", i+1, path)
+		}
+		if step.Prompt == "" {
+			continue
+		}
+
+		// Prompt paths are relative to the config file
+		promptPath := step.Prompt
+		if !filepath.IsAbs(promptPath) {
+			promptPath = filepath.Join(filepath.Dir(path), promptPath)
+		}
+		step.promptPath = promptPath
+		promptContent, err := os.ReadFile(promptPath)
+		if err != nil {
+			return nil, fmt.Errorf("error reading prompt for step %s: %w", step.Name, err)
+		}
+
+		step.promptTemplate, err = template.New(step.Name).Funcs(template.FuncMap{
+			// Placeholder so the template parses; the real function is bound when the step runs
+			"input": func(string) (string, error) { return "", nil },
+		}).Parse(string(promptContent))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing prompt for step %s: %w", step.Name, err)
+		}
+	}
+
+	return &config, nil
+}
+
+// pipeline returns the configured steps, or the built-in pipeline if none is configured
+func (w *Workflow) pipeline() ([]Step, error) {
+	builtins := w.builtinSteps()
+	if w.Ctx.Pipeline == nil {
+		return builtins, nil
+	}
+
+	byName := make(map[string]Step, len(builtins))
+	for _, step := range builtins {
+		byName[step.Name()] = step
+	}
+
+	var steps []Step
+	outputs := make(map[string][]string)
+	for _, config := range w.Ctx.Pipeline.Steps {
+		if _, exists := outputs[config.Name]; exists {
+			return nil, fmt.Errorf("pipeline step %s is listed more than once", config.Name)
+		}
+
+		// Inputs must come from earlier steps so they exist when the step runs
+		var inputs []string
+		for _, input := range config.Inputs {
+			paths, ok := outputs[input]
+			if !ok {
+				return nil, fmt.Errorf("pipeline step %s depends on %s, which is not an earlier step", config.Name, input)
+			}
+			if len(paths) > 0 {
+				// A step's first output is its main artifact
+				inputs = append(inputs, paths[0])
+			}
+		}
+
+		var step Step
+		if config.promptTemplate == nil {
+			builtin, ok := byName[config.Name]
+			if !ok {
+				return nil, fmt.Errorf("pipeline step %s is not a built-in step and has no prompt (built-in steps: %s)",
+					config.Name, strings.Join(stepNames(builtins), ", "))
+			}
+			if config.Output != "" || len(config.Inputs) > 0 || config.ReviewPhase {
+				return nil, fmt.Errorf("built-in step %s only supports name, title, section and model", config.Name)
+			}
+			step = builtin
+			if config.Title != "" || config.Section != "" {
+				override := *builtin.(*funcStep)
+				if config.Title != "" {
+					override.title = config.Title
+				}
+				if config.Section != "" {
+					override.section = config.Section
+				}
+				step = &override
+			}
+		} else {
+			if _, ok := byName[config.Name]; ok {
+				return nil, fmt.Errorf("prompt step %s has the same name as a built-in step", config.Name)
+			}
+			step = w.newPromptStep(config, inputs)
+		}
+
+		outputs[config.Name] = step.Outputs()
+		steps = append(steps, step)
+	}
+
+	return steps, nil
+}
+
+// promptStep is a configured step that renders a prompt template and saves the LLM's response
+type promptStep struct {
+	w       *Workflow
+	config  StepConfig
+	inputs  []string
+	output  string
+	outputs []string
+}
+
+// newPromptStep creates a prompt step from its configuration
+func (w *Workflow) newPromptStep(config StepConfig, inputs []string) *promptStep {
+	output := config.Output
+	if output == "" {
+		output = config.Name + ".md"
+	}
+
+	// The prompt file is an input so editing it reruns the step on resume
+	step := &promptStep{
+		w:      w,
+		config: config,
+		inputs: append([]string{config.promptPath}, inputs...),
+		output: w.artifactPath(output),
+	}
+	step.outputs = []string{step.output}
+	if config.ReviewPhase {
+		step.outputs = append(step.outputs, findingsPath(step.output))
+	}
+	return step
+}
+
+// Name implements Step
+func (s *promptStep) Name() string { return s.config.Name }
+
+// Title implements Step
+func (s *promptStep) Title() string {
+	if s.config.Title != "" {
+		return s.config.Title
+	}
+	return s.config.Name
+}
+
+// Section implements Step
+func (s *promptStep) Section() string { return s.config.Section }
+
+// Inputs implements Step
+func (s *promptStep) Inputs() []string { return s.inputs }
+
+// Outputs implements Step
+func (s *promptStep) Outputs() []string { return s.outputs }
+
+// Restore implements Step
+func (s *promptStep) Restore() error { return nil }
+
+// ReviewPhase implements Step
+func (s *promptStep) ReviewPhase() bool { return s.config.ReviewPhase }
+
+// promptData is the data available to prompt templates
+type promptData struct {
+	// IssueFormat is the ISSUE block example used by the review phases
+	IssueFormat string
+	// Ticket is the ticket number
+	Ticket string
+	// TicketDetails is the formatted Jira ticket
+	TicketDetails string
+	// PullRequest is the pull request's description and existing review comments, when read from GitHub
+	PullRequest string
+	// DesignDoc is the design document
+	DesignDoc string
+	// Architecture describes the repository's architecture profile
+	Architecture string
+	// Language is the repository's primary language
+	Language string
+	// Files is the changed-file list
+	Files string
+	// Diff is the PR diff
+	Diff string
+	// Synthesis is the synthesized original implementation
+	Synthesis string
+}
+
+// Render builds the step's prompt around the whole diff
+func (s *promptStep) Render() (string, error) {
+	return s.render(s.w.Ctx.DiffContent)
+}
+
+// render builds the step's prompt around the given diff
+func (s *promptStep) render(diffContent string) (string, error) {
+	w := s.w
+	data := promptData{
+		IssueFormat:   w.issueFormatExample(),
+		Ticket:        w.Ctx.Ticket,
+		TicketDetails: w.Ctx.TicketDetails,
+		PullRequest:   w.Ctx.PullRequestDetails,
+		DesignDoc:     w.Ctx.DesignDocContent,
+		Architecture:  w.architectureSection("###"),
+		Language:      w.repoLanguage().Name,
+		Files:         w.Ctx.FilesContent,
+		Diff:          diffContent,
+		Synthesis:     w.Ctx.SynthesisContent,
+	}
+
+	tmpl, err := s.config.promptTemplate.Clone()
+	if err != nil {
+		return "", err
+	}
+	tmpl.Funcs(template.FuncMap{
+		// input returns the output of an earlier step listed in the step's inputs
+		"input": func(name string) (string, error) {
+			if !contains(s.config.Inputs, name) {
+				return "", fmt.Errorf("step %s is not listed in the inputs of %s", name, s.config.Name)
+			}
+			outputs := w.stepOutputs(name)
+			if len(outputs) == 0 {
+				return "", nil
+			}
+			content, err := os.ReadFile(outputs[0])
+			if os.IsNotExist(err) {
+				// Steps like new-components leave no output when there is nothing to report
+				return "", nil
+			}
+			if err != nil {
+				return "", fmt.Errorf("error reading output of %s: %w", name, err)
+			}
+			return string(content), nil
+		},
+	})
+
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", fmt.Errorf("error rendering prompt for step %s: %w", s.config.Name, err)
+	}
+	return sb.String(), nil
+}
+
+// Run implements Step
+func (s *promptStep) Run() error {
+	w := s.w
+	if _, err := s.Render(); err != nil {
+		return err
+	}
+	// Sizing the diff renders the template several times; the first failure, such as an input that
+	// can't be read any more, stops the step before anything is sent
+	var renderErr error
+	render := func(diffContent string) string {
+		prompt, err := s.render(diffContent)
+		if err != nil && renderErr == nil {
+			renderErr = err
+		}
+		return prompt
+	}
+
+	// The model override, if any, is sent to the step's own provider
+	client := w.client(s.config.Name)
+
+	logger.Debug("Sending %s prompt to %s", s.config.Name, client.Model())
+	// The standard reviewer introduction is the system message; the rendered template is the user message
+	// Review phases run concurrently, so only steps that run on their own show a live token count
+	opts := w.chatOptions(s.config.Name, client)
+	ask := func(prompt string) (string, error) {
+		return client.Chat(context.Background(), chatMessages(w.GetCommonPromptIntro("reviewer"), prompt), opts)
+	}
+
+	// Review phases are run over each part of a diff too large for the model, like the built-in phases.
+	// Other steps get the files that fit.
+	var response string
+	var err error
+	if s.config.ReviewPhase {
+		var parts []promptPart
+		parts, err = w.splitPrompt(s.config.Name, render)
+		if renderErr != nil {
+			return renderErr
+		}
+		if err == nil {
+			response, err = askInParts(parts, ask)
+		}
+	} else {
+		prompt := render(w.fitDiff(s.config.Name, render, nil))
+		if renderErr != nil {
+			return renderErr
+		}
+		progress := logger.StartProgress(s.Title())
+		defer progress.Done()
+		opts.OnDelta = progress.Add
+		response, err = ask(prompt)
+	}
+	if err != nil {
+		return fmt.Errorf("error in %s step: %w", s.config.Name, err)
+	}
+
+	if err := os.WriteFile(s.output, []byte(response), 0644); err != nil {
+		return fmt.Errorf("error saving %s output: %w", s.config.Name, err)
+	}
+
+	if s.config.ReviewPhase {
+		w.logUnverifiedLineReferences(s.Title(), response)
+		if err := w.saveFindings(s.config.Name, s.output, response); err != nil {
+			return err
+		}
+	}
+
+	logger.Success("%s step completed", s.Title())
+	logger.Debug("Output saved to %s", s.output)
+	return nil
+}
+
+// stepOutputs returns the artifacts written by a step in the current pipeline
+func (w *Workflow) stepOutputs(name string) []string {
+	steps, err := w.pipeline()
+	if err != nil {
+		return nil
+	}
+	for _, step := range steps {
+		if step.Name() == name {
+			return step.Outputs()
+		}
+	}
+	return nil
+}
+
+// contains reports whether a slice contains a value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -4,21 +4,34 @@ package review
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strings"
 	"sync"
-	"sync/atomic"
-	"time"
 
+	"github.com/jeremyhunt/agent-runner/diff"
+	"github.com/jeremyhunt/agent-runner/github"
+	"github.com/jeremyhunt/agent-runner/gitrepo"
+	"github.com/jeremyhunt/agent-runner/language"
+	"github.com/jeremyhunt/agent-runner/llm"
 	"github.com/jeremyhunt/agent-runner/logger"
-	"github.com/jeremyhunt/agent-runner/openai"
+	"github.com/jeremyhunt/agent-runner/models"
+	"github.com/jeremyhunt/agent-runner/pricing"
+	"github.com/jeremyhunt/agent-runner/ratelimit"
 	"github.com/jeremyhunt/agent-runner/tokens"
 )
 
+const (
+	// DefaultConcurrency is the number of files analyzed at the same time unless configured otherwise
+	DefaultConcurrency = 4
+
+	// analysisTokenAllowance is added to a file's token count to estimate the prompt instructions and response
+	analysisTokenAllowance = 2000
+)
+
 // ReviewContext holds all the information needed for a PR review
 type ReviewContext struct {
 	// Ticket is the ticket number (e.g., "WIRE-1231")
@@ -33,20 +46,53 @@ type ReviewContext struct {
 	// RepoDir is the path to the cloned repository
 	RepoDir string
 
+	// RepoName is the repository name used to look up its architecture profile (e.g. "payroll-gateway")
+	RepoName string
+
 	// Branch is the PR branch name
 	Branch string
 
+	// BaseRef is the branch or commit the PR is compared against (defaults to main/master)
+	BaseRef string
+
+	// HeadRef is the branch or commit under review (defaults to Branch)
+	HeadRef string
+
+	// HeadCommit is the commit HeadRef resolved to, which the diff was computed from
+	HeadCommit string
+
+	// MergeBase is the resolved common ancestor of BaseRef and HeadRef
+	MergeBase string
+
+	// Repo is the repository the review reads file contents from: the local clone, or the GitHub API
+	Repo FileSource
+
+	// GitHub reads the pull request from the GitHub API instead of a local clone when set
+	GitHub *github.Client
+
+	// GitHubRepo is the owner/name of the repository on GitHub
+	GitHubRepo string
+
+	// PullRequestNumber is the pull request to review from GitHub, or 0 to use the open pull request of Branch
+	PullRequestNumber int
+
+	// PullRequestDetails is the formatted title, description and existing review comments of the pull request
+	PullRequestDetails string
+
 	// OutputDir is the directory where output files are stored
 	OutputDir string
 
 	// MaxTokens is the maximum number of tokens allowed for the LLM context
 	MaxTokens int
 
-	// Model is the OpenAI model to use
+	// Model is the model the review uses, as reported by the provider
 	Model string
 
-	// Client is the OpenAI client
-	Client *openai.Client
+	// Client is the LLM provider the prompts are sent to
+	Client llm.Provider
+
+	// StepClients maps step names to the provider used instead of Client, set with SetGroupClient
+	StepClients map[string]llm.Provider
 
 	// TokenCounter is used to count tokens
 	TokenCounter *tokens.Counter
@@ -60,6 +106,51 @@ type ReviewContext struct {
 	// TicketDetails is the formatted Jira ticket information
 	TicketDetails string
 
+	// TicketSource is the raw Jira ticket the details were formatted from, used for checkpoint hashing
+	TicketSource string
+
+	// Resume skips steps whose inputs are unchanged since their last completed run
+	Resume bool
+
+	// FromStep reruns the named step and every step after it, reusing earlier output
+	FromStep string
+
+	// OnlyStep reruns just the named step, reusing the output of the others
+	OnlyStep string
+
+	// Concurrency is the number of files analyzed at the same time
+	Concurrency int
+
+	// RequestsPerMinute limits the per-file analysis requests sent each minute (0 for no limit)
+	RequestsPerMinute int
+
+	// TokensPerMinute limits the estimated tokens sent for per-file analysis each minute (0 for no limit)
+	TokensPerMinute int
+
+	// Pipeline lists the steps to run; the built-in pipeline is used when it is nil
+	Pipeline *PipelineConfig
+
+	// Changes is the set of files changed by the PR, computed from the repository
+	Changes *gitrepo.ChangeSet
+
+	// ParsedDiff is the structured form of the diff, used for per-file prompts and line checks
+	ParsedDiff *diff.Diff
+
+	// Languages is the registry of language profiles used to word prompts
+	Languages *language.Registry
+
+	// Language is the predominant language of the changed files
+	Language *language.Profile
+
+	// Architecture describes the repository's layers and conventions, if a profile exists
+	Architecture *ArchitectureProfile
+
+	// Usage accumulates the tokens reported for each step's requests
+	Usage *UsageTracker
+
+	// Pricing holds the per-model rates used to estimate the cost of the review
+	Pricing *pricing.Table
+
 	// Results from processing steps
 	DiffContent      string
 	FilesContent     string
@@ -69,26 +160,51 @@ type ReviewContext struct {
 	TotalTokens      int
 }
 
+// FileSource reads the content of a file at a commit
+type FileSource interface {
+	Show(ref, path string) (string, error)
+}
+
+// DefaultModel is the model a review context assumes when it has no provider
+const DefaultModel = "gpt-4o"
+
 // NewReviewContext creates a new ReviewContext with default values
-func NewReviewContext(ticket string, client *openai.Client) *ReviewContext {
+func NewReviewContext(ticket string, client llm.Provider) *ReviewContext {
 	outputDir := filepath.Join(".context", "reviews")
-	return &ReviewContext{
+	ctx := &ReviewContext{
 		Ticket:       ticket,
 		DiffPath:     filepath.Join(outputDir, ticket+"-diff.md"),
 		FilesPath:    filepath.Join(outputDir, ticket+"-files.md"),
 		RepoDir:      "", // Will be set when needed
 		Branch:       "", // Will be set when needed
 		OutputDir:    outputDir,
-		MaxTokens:    120000, // Default for GPT-4o
-		Model:        "gpt-4o",
+		Model:        DefaultModel,
 		Client:       client,
 		TokenCounter: tokens.NewCounter(),
+		Languages:    language.NewRegistry(),
+		Concurrency:  DefaultConcurrency,
+		Usage:        NewUsageTracker(),
+		Pricing:      pricing.NewTable(),
+	}
+
+	// Size the review to the provider's model, leaving room for the response
+	if client != nil {
+		ctx.Model = client.Model()
+		ctx.MaxTokens = llm.PromptBudget(client)
+	} else {
+		info, _ := models.NewRegistry().Lookup(DefaultModel)
+		ctx.MaxTokens = info.ContextWindow - llm.ResponseTokens(info.MaxOutputTokens)
 	}
+	return ctx
 }
 
 // Workflow handles the PR review process
 type Workflow struct {
 	Ctx *ReviewContext
+
+	// budget paces the per-file analysis requests across the whole review
+	budget     *ratelimit.Budget
+	budgetOnce sync.Once
 }
 
 // NewWorkflow creates a new workflow for the given context
@@ -98,27 +214,18 @@ func NewWorkflow(ctx *ReviewContext) *Workflow {
 	}
 }
 
-// CountTokens reads the diff and files list and counts tokens
+// CountTokens counts tokens in the diff and files list and records the counts in the artifacts
 func (w *Workflow) CountTokens() error {
-	// Read the diff file
-	diffContent, err := os.ReadFile(w.Ctx.DiffPath)
-	if err != nil {
-		return fmt.Errorf("error reading diff file: %w", err)
-	}
-
-	// Read the files list
-	filesContent, err := os.ReadFile(w.Ctx.FilesPath)
-	if err != nil {
-		return fmt.Errorf("error reading files list: %w", err)
-	}
+	diffContent := w.Ctx.DiffContent
+	filesContent := w.Ctx.FilesContent
 
 	// Count tokens in both files
-	diffTokens, err := w.Ctx.TokenCounter.CountText(string(diffContent), w.Ctx.Model)
+	diffTokens, err := w.Ctx.TokenCounter.CountText(diffContent, w.Ctx.Model)
 	if err != nil {
 		return fmt.Errorf("error counting diff tokens: %w", err)
 	}
 
-	filesTokens, err := w.Ctx.TokenCounter.CountText(string(filesContent), w.Ctx.Model)
+	filesTokens, err := w.Ctx.TokenCounter.CountText(filesContent, w.Ctx.Model)
 	if err != nil {
 		return fmt.Errorf("error counting files tokens: %w", err)
 	}
@@ -126,15 +233,13 @@ func (w *Workflow) CountTokens() error {
 	totalTokens := diffTokens + filesTokens
 
 	// Store results in context
-	w.Ctx.DiffContent = string(diffContent)
-	w.Ctx.FilesContent = string(filesContent)
 	w.Ctx.DiffTokens = diffTokens
 	w.Ctx.FilesTokens = filesTokens
 	w.Ctx.TotalTokens = totalTokens
 
 	// Add token count to the diff file
 	tokenInfoDiff := fmt.Sprintf("\n\nThis diff contains **%d tokens** when processed by %s.\n\n", diffTokens, w.Ctx.Model)
-	newDiffContent := tokenInfoDiff + string(diffContent)
+	newDiffContent := tokenInfoDiff + diffContent
 	err = os.WriteFile(w.Ctx.DiffPath, []byte(newDiffContent), 0644)
 	if err != nil {
 		return fmt.Errorf("error updating diff file with token count: %w", err)
@@ -142,7 +247,7 @@ func (w *Workflow) CountTokens() error {
 
 	// Add token count to the files list file
 	tokenInfoFiles := fmt.Sprintf("\n\nThis file list contains **%d tokens** when processed by %s.\n\n", filesTokens, w.Ctx.Model)
-	newFilesContent := strings.Replace(string(filesContent), "\n\n## Modified Files", tokenInfoFiles+"## Modified Files", 1)
+	newFilesContent := strings.Replace(filesContent, "\n\n## Modified Files", tokenInfoFiles+"## Modified Files", 1)
 	err = os.WriteFile(w.Ctx.FilesPath, []byte(newFilesContent), 0644)
 	if err != nil {
 		return fmt.Errorf("error updating files list with token count: %w", err)
@@ -157,25 +262,34 @@ func (w *Workflow) CountTokens() error {
 	logger.Verbose("  Diff file:  %d tokens", diffTokens)
 	logger.Verbose("  Files list: %d tokens", filesTokens)
 	logger.Verbose("  Total:      %d tokens", totalTokens)
-	logger.Verbose("  Max tokens: %d", w.Ctx.MaxTokens)
+	// Every step sees the diff, so the smallest model used by any step sets the limit
+	limit := w.Ctx.MaxTokens
+	for _, step := range w.ownModelSteps() {
+		if stepLimit := w.maxTokens(step); stepLimit < limit {
+			limit = stepLimit
+		}
+	}
+	logger.Verbose("  Max tokens: %d", limit)
 
-	if totalTokens > w.Ctx.MaxTokens {
-		logger.Error("Content exceeds token limit by %d tokens", totalTokens-w.Ctx.MaxTokens)
-		return fmt.Errorf("token limit exceeded: %d tokens (limit: %d)", totalTokens, w.Ctx.MaxTokens)
+	// A diff that doesn't fit is reviewed in parts, and cut down to the files that matter elsewhere
+	if totalTokens > limit {
+		logger.Info("The diff and file list (%d tokens) exceed the %d-token limit and will be reviewed in parts", totalTokens, limit)
+		return nil
 	}
 
-	logger.Verbose("Tokens remaining: %d", w.Ctx.MaxTokens-totalTokens)
+	logger.Verbose("Tokens remaining: %d", limit-totalTokens)
 	return nil
 }
 
-// RunLLMStep executes a generic LLM step (prepare prompt, send to LLM, save response)
-func (w *Workflow) RunLLMStep(stepName string, promptFunc func() string, outputPath string) error {
+// RunLLMStep executes a generic LLM step (prepare prompt, send to LLM, save response).
+// The role's introduction is sent as the system message and usage is recorded under step.
+func (w *Workflow) RunLLMStep(step, stepName, role string, promptFunc func() string, outputPath string) error {
 	// 1. Prepare prompt using the provided function
 	prompt := promptFunc()
 
 	// 2. Send to LLM
 	// Note: We don't need to log this here since it's already logged in the Step functions
-	response, err := w.Ctx.Client.Complete(context.Background(), prompt)
+	response, err := w.askStreaming(step, stepName, w.GetCommonPromptIntro(role), prompt)
 	if err != nil {
 		return fmt.Errorf("error in %s step: %w", stepName, err)
 	}
@@ -191,10 +305,35 @@ func (w *Workflow) RunLLMStep(stepName string, promptFunc func() string, outputP
 	return nil
 }
 
-// GetCommonPromptIntro returns a standardized introduction for prompts
+// ask sends a prompt to the LLM with the given instructions as the system message,
+// recording the tokens used under the given step
+func (w *Workflow) ask(step, system, prompt string) (string, error) {
+	client := w.client(step)
+	return client.Chat(context.Background(), chatMessages(system, prompt), w.chatOptions(step, client))
+}
+
+// askStreaming is ask for long steps that run on their own, streaming the response to show a live token count
+func (w *Workflow) askStreaming(step, label, system, prompt string) (string, error) {
+	progress := logger.StartProgress(label)
+	defer progress.Done()
+	client := w.client(step)
+	opts := w.chatOptions(step, client)
+	opts.OnDelta = progress.Add
+	return client.Chat(context.Background(), chatMessages(system, prompt), opts)
+}
+
+// chatMessages builds a conversation of the system instructions followed by the prompt
+func chatMessages(system, prompt string) []llm.Message {
+	return []llm.Message{
+		{Role: llm.RoleSystem, Content: strings.TrimSpace(system)},
+		{Role: llm.RoleUser, Content: prompt},
+	}
+}
+
+// GetCommonPromptIntro returns a standardized introduction for prompts, sent as the system message
 func (w *Workflow) GetCommonPromptIntro(role string) string {
 	// Common beginning for all roles
-	commonIntro := "You are a skeptical and methodical Sr Developer with expertise in PHP and general software development. "
+	commonIntro := fmt.Sprintf("You are a skeptical and methodical Sr Developer with expertise in %s. ", w.repoLanguage().Expertise())
 	commonIntro += "You assume there are issues, misses, and mistakes unless proven otherwise. "
 
 	// Role-specific additions
@@ -202,9 +341,13 @@ func (w *Workflow) GetCommonPromptIntro(role string) string {
 	case "reviewer":
 		return commonIntro + "You're reviewing code for other senior developers who value helpfulness, brevity, and professionalism. "
 	case "analyzer":
-		return commonIntro + "You're analyzing a file from a codebase that uses a custom silo/service/domain/repository/applicationservice architecture.\n\n"
+		return commonIntro + fmt.Sprintf("You're analyzing a file from a codebase%s.\n\n", w.architectureIntro())
 	case "discoverer":
-		return commonIntro + "You're reviewing a pull request in a PHP codebase that uses a custom architecture with silo/service/domain/repository/applicationservice patterns."
+		codebase := "a codebase"
+		if profile := w.repoLanguage(); !profile.IsGeneric() {
+			codebase = fmt.Sprintf("a %s codebase", profile.Name)
+		}
+		return commonIntro + fmt.Sprintf("You're reviewing a pull request in %s%s.", codebase, w.architectureIntro())
 	case "summarizer":
 		return commonIntro + "You're creating a final summary of a PR review for GitHub. "
 	default:
@@ -212,16 +355,20 @@ func (w *Workflow) GetCommonPromptIntro(role string) string {
 	}
 }
 
-// InitialDiscoveryPrompt generates the prompt for the initial discovery step
+// InitialDiscoveryPrompt generates the prompt for the initial discovery step. A diff too large for the
+// model is cut down to the files that fit.
 func (w *Workflow) InitialDiscoveryPrompt() string {
-	// Base prompt template
-	promptTemplate := `%s
+	return w.initialDiscoveryPrompt(w.fitDiff("discovery", w.initialDiscoveryPrompt, nil))
+}
 
-Here is a list of the files that were changed:
+// initialDiscoveryPrompt generates the initial discovery prompt around the given diff
+func (w *Workflow) initialDiscoveryPrompt(diffContent string) string {
+	// Base prompt template
+	promptTemplate := `Here is a list of the files that were changed:
 %s
 
 Here is the full diff of the changes:
-%s%s%s
+%s%s%s%s
 
 Please provide your analysis in the following format with EXACTLY these section headings:
 
@@ -229,7 +376,7 @@ Please provide your analysis in the following format with EXACTLY these section
 [Your one-paragraph summary of the changes here]
 
 ## 2. Framework Detection
-[Identify the framework(s) being used (e.g., Laravel, Symfony, CodeIgniter, custom) and provide specific evidence from the code that supports your identification]
+[Identify the framework(s) being used %s and provide specific evidence from the code that supports your identification]
 
 ## 3. Flow of Logic
 [Your trace of the logic flow through files and functions here]
@@ -243,15 +390,16 @@ For the recommended file order section:
 - Number each file (1, 2, 3, etc.)
 - Include a brief explanation of why you chose this sequence
 
-Consider the architecture patterns where:
-- DataObjects define data structures
-- Repositories handle data access
-- Domains contain business logic
-- Services orchestrate operations
-- ApplicationServices provide functionality across domains
+%s
 
 Format your response in markdown with clear sections and code references.`
 
+	// Add architecture profile section if available
+	architectureSection := ""
+	if section := w.architectureSection("##"); section != "" {
+		architectureSection = "\n\n" + strings.TrimSuffix(section, "\n")
+	}
+
 	// Add design document section if available
 	designDocSection := ""
 	designDocInstruction := ""
@@ -268,60 +416,30 @@ Format your response in markdown with clear sections and code references.`
 		ticketInstruction = "\n\n## 6. Ticket Alignment\n[Your assessment of how well the changes address the requirements in the ticket]"
 	}
 
-	return fmt.Sprintf(promptTemplate, w.GetCommonPromptIntro("discoverer"), w.Ctx.FilesContent, w.Ctx.DiffContent, designDocSection, ticketSection, designDocInstruction, ticketInstruction)
+	// Add the pull request's description and existing review comments when it was read from GitHub
+	if w.Ctx.PullRequestDetails != "" {
+		ticketSection += fmt.Sprintf("\n\n## Pull Request\n\nThe following pull request description and existing review comments provide context for this PR:\n\n%s", w.Ctx.PullRequestDetails)
+	}
+
+	return fmt.Sprintf(promptTemplate, w.Ctx.FilesContent, diffContent, architectureSection, designDocSection, ticketSection, w.frameworkExamples(), designDocInstruction, ticketInstruction, w.fileOrderGuidance())
 }
 
 // CollectOriginalFileContents reads the original content of modified and deleted files
 func (w *Workflow) CollectOriginalFileContents() error {
-	// 1. Read the files.md to get the list of modified and deleted files
-	filesContent, err := os.ReadFile(w.Ctx.FilesPath)
+	// 1. Parse the in-memory files list to get the modified and deleted files
+	sections, err := parseFileSections(w.Ctx.FilesContent)
 	if err != nil {
-		return fmt.Errorf("failed to read files list: %w", err)
-	}
-
-	// 2. Parse the content to extract modified and deleted files
-	modifiedFiles := []string{}
-	deletedFiles := []string{}
-
-	scanner := bufio.NewScanner(strings.NewReader(string(filesContent)))
-	section := ""
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "## ") {
-			section = strings.TrimPrefix(line, "## ")
-			continue
-		}
-
-		// Skip empty lines, headers, and the stats section
-		if line == "" || strings.HasPrefix(line, "#") || section == "Stats" {
-			continue
-		}
-
-		if section == "Modified Files" {
-			modifiedFiles = append(modifiedFiles, line)
-		} else if section == "Deleted Files" {
-			deletedFiles = append(deletedFiles, line)
-		}
+		return fmt.Errorf("failed to parse files list: %w", err)
 	}
 
-	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("error scanning files list: %w", err)
-	}
+	// 2. Renamed files are compared against their original path
+	modifiedFiles := append(sections["Modified"], renamedFrom(sections["Renamed"])...)
+	deletedFiles := sections["Deleted"]
 
-	// 3. Get the merge-base (common ancestor) of main and PR branch
-	cmd := exec.Command("git", "merge-base", "main", w.Ctx.Branch)
-	cmd.Dir = w.Ctx.RepoDir
-	mergeBase, err := cmd.Output()
-	if err != nil {
-		// Try with master if main fails
-		cmd = exec.Command("git", "merge-base", "master", w.Ctx.Branch)
-		cmd.Dir = w.Ctx.RepoDir
-		mergeBase, err = cmd.Output()
-		if err != nil {
-			return fmt.Errorf("failed to find merge-base: %w", err)
-		}
+	// 3. The merge-base is resolved once when the changes are prepared
+	if w.Ctx.Repo == nil || w.Ctx.MergeBase == "" {
+		return fmt.Errorf("merge-base has not been resolved")
 	}
-	baseCommit := strings.TrimSpace(string(mergeBase))
 
 	// 4. Build the markdown content
 	var sb strings.Builder
@@ -331,30 +449,26 @@ func (w *Workflow) CollectOriginalFileContents() error {
 
 	// 5. For each modified file
 	for _, file := range modifiedFiles {
-		// Execute git command to get content from the merge-base
-		cmd := exec.Command("git", "show", fmt.Sprintf("%s:%s", baseCommit, file))
-		cmd.Dir = w.Ctx.RepoDir
-		content, err := cmd.Output()
+		// Get content from the merge-base
+		content, err := w.Ctx.Repo.Show(w.Ctx.MergeBase, file)
 		if err != nil {
 			logger.Debug("Warning: failed to get content for %s: %v", file, err)
 			continue
 		}
 
 		// Add to our markdown
-		sb.WriteString(fmt.Sprintf("## %s\n```php\n%s\n```\n\n", file, content))
+		sb.WriteString(fmt.Sprintf("## %s\n%s\n\n", file, w.codeBlock(file, content)))
 	}
 
 	// 6. Same for deleted files
 	for _, file := range deletedFiles {
-		cmd := exec.Command("git", "show", fmt.Sprintf("%s:%s", baseCommit, file))
-		cmd.Dir = w.Ctx.RepoDir
-		content, err := cmd.Output()
+		content, err := w.Ctx.Repo.Show(w.Ctx.MergeBase, file)
 		if err != nil {
 			logger.Debug("Warning: failed to get content for deleted file %s: %v", file, err)
 			continue
 		}
 
-		sb.WriteString(fmt.Sprintf("## %s (DELETED)\n```php\n%s\n```\n\n", file, content))
+		sb.WriteString(fmt.Sprintf("## %s (DELETED)\n%s\n\n", file, w.codeBlock(file, content)))
 	}
 
 	// Get the content as a string
@@ -422,14 +536,51 @@ func (w *Workflow) ParseChangedFiles() ([]string, error) {
 		return nil, fmt.Errorf("files content is empty")
 	}
 
-	// Split the content into sections
+	// Parse the file content into sections
+	sections, err := parseFileSections(filesContent)
+	if err != nil {
+		return nil, err
+	}
+
+	// For original implementation analysis, we only want modified, renamed and deleted files
+	// since we need to analyze what they were like before the changes
+	files := append(sections["Modified"], renamedFrom(sections["Renamed"])...)
+	files = append(files, sections["Deleted"]...)
+
+	// If we didn't find any files in any section, try the old regex method as a fallback
+	if len(files) == 0 && len(sections["Added"]) == 0 {
+		logger.Debug("No files found in sections, trying regex fallback")
+		filePattern := "(?m)^([a-zA-Z0-9_\\-./]+\\.[a-zA-Z0-9]+)$"
+		fileRegex := regexp.MustCompile(filePattern)
+		matches := fileRegex.FindAllStringSubmatch(filesContent, -1)
+
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("could not find any filenames in files content")
+		}
+
+		// Extract the filenames from the regex matches
+		for _, match := range matches {
+			if len(match) >= 2 {
+				files = append(files, match[1])
+			}
+		}
+	}
+
+	logger.Debug("Found %d files for analysis (modified: %d, renamed: %d, deleted: %d, added files excluded)",
+		len(files), len(sections["Modified"]), len(sections["Renamed"]), len(sections["Deleted"]))
+
+	return files, nil
+}
+
+// parseFileSections splits a changed-files list into its Modified, Added, Deleted and Renamed sections
+func parseFileSections(filesContent string) (map[string][]string, error) {
 	sections := map[string][]string{
 		"Modified": {},
 		"Added":    {},
 		"Deleted":  {},
+		"Renamed":  {},
 	}
 
-	// Parse the file content into sections
 	scanner := bufio.NewScanner(strings.NewReader(filesContent))
 	currentSection := ""
 	for scanner.Scan() {
@@ -437,8 +588,7 @@ func (w *Workflow) ParseChangedFiles() ([]string, error) {
 
 		// Check if this is a section header
 		if strings.HasPrefix(line, "## ") {
-			sectionName := strings.TrimPrefix(line, "## ")
-			currentSection = sectionName
+			currentSection = strings.TrimPrefix(line, "## ")
 			continue
 		}
 
@@ -455,6 +605,8 @@ func (w *Workflow) ParseChangedFiles() ([]string, error) {
 			sections["Added"] = append(sections["Added"], line)
 		case "Deleted Files":
 			sections["Deleted"] = append(sections["Deleted"], line)
+		case "Renamed Files":
+			sections["Renamed"] = append(sections["Renamed"], line)
 		}
 	}
 
@@ -462,73 +614,39 @@ func (w *Workflow) ParseChangedFiles() ([]string, error) {
 		return nil, fmt.Errorf("error scanning files content: %w", err)
 	}
 
-	// For original implementation analysis, we only want modified and deleted files
-	// since we need to analyze what they were like before the changes
-	files := append(sections["Modified"], sections["Deleted"]...)
-
-	// If we didn't find any files, try the old regex method as a fallback
-	if len(files) == 0 {
-		logger.Debug("No files found in sections, trying regex fallback")
-		filePattern := "(?m)^([a-zA-Z0-9_\\-./]+\\.[a-zA-Z0-9]+)$"
-		fileRegex := regexp.MustCompile(filePattern)
-		matches := fileRegex.FindAllStringSubmatch(filesContent, -1)
-
-		if len(matches) == 0 {
-			return nil, fmt.Errorf("could not find any filenames in files content")
-		}
+	return sections, nil
+}
 
-		// Extract the filenames from the regex matches
-		for _, match := range matches {
-			if len(match) >= 2 {
-				files = append(files, match[1])
-			}
-		}
+// renamedFrom returns the original paths of "old -> new" rename entries
+func renamedFrom(renames []string) []string {
+	paths := make([]string, 0, len(renames))
+	for _, rename := range renames {
+		from, _, _ := strings.Cut(rename, " -> ")
+		paths = append(paths, from)
 	}
-
-	logger.Debug("Found %d files for analysis (modified: %d, deleted: %d, added files excluded)",
-		len(files), len(sections["Modified"]), len(sections["Deleted"]))
-
-	return files, nil
+	return paths
 }
 
 // GetOriginalFileContent retrieves the content of a file from before the PR changes
 func (w *Workflow) GetOriginalFileContent(file string) (string, error) {
-	// Get the merge-base (common ancestor) of main and PR branch
-	cmd := exec.Command("git", "merge-base", "main", w.Ctx.Branch)
-	cmd.Dir = w.Ctx.RepoDir
-	mergeBase, err := cmd.Output()
-	if err != nil {
-		// Try with master if main fails
-		cmd = exec.Command("git", "merge-base", "master", w.Ctx.Branch)
-		cmd.Dir = w.Ctx.RepoDir
-		mergeBase, err = cmd.Output()
-		if err != nil {
-			return "", fmt.Errorf("failed to find merge-base: %w", err)
-		}
+	if w.Ctx.Repo == nil || w.Ctx.MergeBase == "" {
+		return "", fmt.Errorf("merge-base has not been resolved")
 	}
-	baseCommit := strings.TrimSpace(string(mergeBase))
 
 	// Get the file content at the merge-base commit
-	cmd = exec.Command("git", "show", fmt.Sprintf("%s:%s", baseCommit, file))
-	cmd.Dir = w.Ctx.RepoDir
-	content, err := cmd.Output()
-	if err != nil {
-		return "", fmt.Errorf("failed to get content for %s: %w", file, err)
-	}
-
-	return string(content), nil
+	return w.Ctx.Repo.Show(w.Ctx.MergeBase, file)
 }
 
 // FileAnalysisPrompt generates a prompt for analyzing a single file
 func (w *Workflow) FileAnalysisPrompt(filename, content string) string {
-	prompt := w.GetCommonPromptIntro("analyzer")
+	prompt := w.architectureSection("##")
 	prompt += "Your goal is to understand how the specific feature being changed in this PR worked BEFORE the changes were applied.\n\n"
 	prompt += fmt.Sprintf("File: %s\n\n", filename)
-	prompt += "Here's the original content of the file before changes:\n```php\n"
-	prompt += content
-	prompt += "\n```\n\n"
+	prompt += "Here's the original content of the file before changes:\n"
+	prompt += w.codeBlock(filename, content)
+	prompt += "\n\n"
 	prompt += "Here's the diff showing what's changing in the PR:\n"
-	prompt += w.Ctx.DiffContent
+	prompt += w.fileDiff(filename)
 	prompt += "\n\nFocus on:\n"
 	prompt += "1. What specific feature or functionality does this file contribute to, based on the PR changes?\n"
 	prompt += "2. How did the key functions/methods work before the changes, especially those affected by the PR?\n"
@@ -541,12 +659,22 @@ func (w *Workflow) FileAnalysisPrompt(filename, content string) string {
 	return prompt
 }
 
+// fileDiff returns the diff for a single file, falling back to the full diff when it can't be isolated
+func (w *Workflow) fileDiff(filename string) string {
+	if w.Ctx.ParsedDiff != nil {
+		if file := w.Ctx.ParsedDiff.File(filename); file != nil {
+			return file.String()
+		}
+	}
+	return w.Ctx.DiffContent
+}
+
 // AnalyzeFile sends a file to the LLM for analysis and returns the result
 func (w *Workflow) AnalyzeFile(filename, content string) (string, error) {
 	prompt := w.FileAnalysisPrompt(filename, content)
 
 	// We don't need to log here since we're already logging in the worker
-	response, err := w.Ctx.Client.Complete(context.Background(), prompt)
+	response, err := w.ask("original-analysis", w.GetCommonPromptIntro("analyzer"), prompt)
 	if err != nil {
 		return "", fmt.Errorf("error analyzing file %s: %w", filename, err)
 	}
@@ -568,123 +696,12 @@ func (w *Workflow) AnalyzeOriginalImplementation() error {
 	sb.WriteString("# Original Implementation Analysis\n\n")
 	sb.WriteString("This document provides an analysis of how the code worked before the changes in this PR.\n\n")
 
-	// 3. Process files individually with goroutines
-	type analysisResult struct {
-		file     string
-		analysis string
-		err      error
-		index    int
-	}
-
-	// Create a slice to store results in the correct order
-	results := make([]analysisResult, len(orderedFiles))
-
-	// Create a mutex to protect shared resources
-	var resultsMutex sync.Mutex
-
-	// Create a WaitGroup to track when all files have been processed
-	var wg sync.WaitGroup
-
-	// Create an atomic counter for active goroutines
-	var activeWorkers int32
-
-	// We're already logging this in the Step function, so we don't need to log it here
-
-	// Launch a goroutine for each file
-	for i, file := range orderedFiles {
-		// Increment the WaitGroup counter
-		wg.Add(1)
-
-		// Create a goroutine for this file
-		go func(index int, filename string) {
-			// Increment active workers counter
-			atomic.AddInt32(&activeWorkers, 1)
-			workerNum := atomic.LoadInt32(&activeWorkers)
-
-			// Recover from any panics in this goroutine
-			defer func() {
-				if r := recover(); r != nil {
-					panicErr := fmt.Sprintf("panic: %v", r)
-					resultsMutex.Lock()
-					logger.Debug("PANIC in goroutine processing %s: %v", filename, r)
-					logger.AnalysisFailure(int(workerNum), filename, panicErr)
-					resultsMutex.Unlock()
-
-					// Store the error result
-					results[index] = analysisResult{file: filename, err: fmt.Errorf(panicErr), index: index}
-				}
-			}()
-
-			// Make sure we mark this file as done when the goroutine exits
-			defer func() {
-				// Only log completion if there was no error (errors are logged separately)
-				if results[index].err == nil {
-					resultsMutex.Lock()
-					logger.AnalysisCompleted(int(workerNum), filename)
-					resultsMutex.Unlock()
-				}
-
-				// Decrement active workers counter
-				atomic.AddInt32(&activeWorkers, -1)
-
-				wg.Done()
-			}()
-
-			// Print progress (protected by mutex to avoid garbled output)
-			resultsMutex.Lock()
-			logger.AnalysisItem(int(workerNum), filename)
-			// Only print debug info in debug mode
-			if logger.IsDebugEnabled() {
-				logger.Debug("[Worker %d] Analyzing file %d/%d: %s (Active workers: %d)",
-					workerNum, index+1, len(orderedFiles), filename, atomic.LoadInt32(&activeWorkers))
-			}
-			resultsMutex.Unlock()
-
-			// Get original file content
-			content, err := w.GetOriginalFileContent(filename)
-			if err != nil {
-				errMsg := fmt.Sprintf("could not get content: %v", err)
-				resultsMutex.Lock()
-				logger.Debug("Warning: could not get content for %s: %v", filename, err)
-				logger.AnalysisFailure(int(workerNum), filename, errMsg)
-				resultsMutex.Unlock()
-
-				// Store the error result
-				results[index] = analysisResult{file: filename, err: err, index: index}
-				return
-			}
-
-			// Analyze with LLM
-			analysis, err := w.AnalyzeFile(filename, content)
-			if err != nil {
-				errMsg := fmt.Sprintf("LLM analysis failed: %v", err)
-				resultsMutex.Lock()
-				logger.Debug("Warning: analysis failed for %s: %v", filename, err)
-				logger.AnalysisFailure(int(workerNum), filename, errMsg)
-				resultsMutex.Unlock()
-
-				// Store the error result
-				results[index] = analysisResult{file: filename, err: err, index: index}
-				return
-			}
-
-			// Store the successful result
-			results[index] = analysisResult{file: filename, analysis: analysis, index: index}
-		}(i, file)
-
-		// Wait a bit between launching goroutines to avoid API overload
-		time.Sleep(250 * time.Millisecond)
-	}
-
-	// Wait for all files to be processed
-	wg.Wait()
-
-	// Add a blank line after all workers have completed
-	fmt.Println()
+	// 3. Analyze the files with the worker pool
+	results := w.analyzeFiles("original-analysis", orderedFiles, w.GetOriginalFileContent, w.AnalyzeFile)
 
 	// Process results in the original order
 	for _, result := range results {
-		// Skip files that had errors
+		// Files that had errors are listed separately
 		if result.err != nil {
 			continue
 		}
@@ -692,6 +709,7 @@ func (w *Workflow) AnalyzeOriginalImplementation() error {
 		// Add to output
 		sb.WriteString(fmt.Sprintf("## %s\n\n%s\n\n", result.file, result.analysis))
 	}
+	sb.WriteString(unanalyzedFilesSection(results))
 
 	// 4. Count tokens in the result
 	outputContent := sb.String()
@@ -712,6 +730,162 @@ func (w *Workflow) AnalyzeOriginalImplementation() error {
 	return nil
 }
 
+// fileAnalysis is the result of analyzing a single file
+type fileAnalysis struct {
+	file     string
+	analysis string
+	err      error
+	index    int
+
+	// skipped is set when the file's content could not be read, so it was never sent for analysis
+	skipped bool
+}
+
+// analyzeFiles fetches and analyzes the files of a step with a bounded pool of workers, returning the results in the
+// original order. Requests are paced to stay within the configured request and token budgets.
+func (w *Workflow) analyzeFiles(step string, files []string, getContent func(string) (string, error), analyze func(string, string) (string, error)) []fileAnalysis {
+	// Create a slice to store results in the correct order
+	results := make([]fileAnalysis, len(files))
+
+	workers := w.concurrency()
+	if workers > len(files) {
+		workers = len(files)
+	}
+	budget := w.rateBudget()
+
+	// Create a mutex to keep the progress output from interleaving
+	var logMutex sync.Mutex
+
+	// Queue every file, then let the workers drain the queue
+	jobs := make(chan int, len(files))
+	for i := range files {
+		jobs <- i
+	}
+	close(jobs)
+
+	var wg sync.WaitGroup
+	for n := 1; n <= workers; n++ {
+		wg.Add(1)
+		go func(workerNum int) {
+			defer wg.Done()
+			for index := range jobs {
+				results[index] = w.analyzeFile(step, workerNum, index, files, budget, &logMutex, getContent, analyze)
+			}
+		}(n)
+	}
+
+	// Wait for all files to be processed
+	wg.Wait()
+
+	// Add a blank line after all workers have completed
+	fmt.Println()
+
+	return results
+}
+
+// concurrency returns the number of workers that analyze files at the same time
+func (w *Workflow) concurrency() int {
+	if w.Ctx.Concurrency <= 0 {
+		return DefaultConcurrency
+	}
+	return w.Ctx.Concurrency
+}
+
+// analyzeFile fetches and analyzes one file on behalf of a worker
+func (w *Workflow) analyzeFile(step string, workerNum, index int, files []string, budget *ratelimit.Budget, logMutex *sync.Mutex,
+	getContent func(string) (string, error), analyze func(string, string) (string, error)) (result fileAnalysis) {
+	filename := files[index]
+
+	// Recover from any panics so one file can't take down the pool
+	defer func() {
+		if r := recover(); r != nil {
+			panicErr := fmt.Sprintf("panic: %v", r)
+			logMutex.Lock()
+			logger.Debug("PANIC in worker processing %s: %v", filename, r)
+			logger.AnalysisFailure(workerNum, filename, panicErr)
+			logMutex.Unlock()
+
+			result = fileAnalysis{file: filename, err: errors.New(panicErr), index: index}
+		}
+	}()
+
+	// Get file content
+	content, err := getContent(filename)
+	if err != nil {
+		logMutex.Lock()
+		logger.Debug("Warning: could not get content for %s: %v", filename, err)
+		logger.AnalysisFailure(workerNum, filename, fmt.Sprintf("could not get content: %v", err))
+		logMutex.Unlock()
+
+		return fileAnalysis{file: filename, err: fmt.Errorf("could not get content: %w", err), index: index, skipped: true}
+	}
+
+	// Wait for room in the rate limits before sending the request
+	if err := budget.Wait(context.Background(), w.estimateTokens(step, content)); err != nil {
+		return fileAnalysis{file: filename, err: err, index: index}
+	}
+
+	// Print progress (protected by mutex to avoid garbled output)
+	logMutex.Lock()
+	logger.AnalysisItem(workerNum, filename)
+	logger.Debug("[Worker %d] Analyzing file %d/%d: %s", workerNum, index+1, len(files), filename)
+	logMutex.Unlock()
+
+	// Analyze with LLM
+	analysis, err := analyze(filename, content)
+	if err != nil {
+		logMutex.Lock()
+		logger.Debug("Warning: analysis failed for %s: %v", filename, err)
+		logger.AnalysisFailure(workerNum, filename, fmt.Sprintf("LLM analysis failed: %v", err))
+		logMutex.Unlock()
+
+		return fileAnalysis{file: filename, err: err, index: index}
+	}
+
+	logMutex.Lock()
+	logger.AnalysisCompleted(workerNum, filename)
+	logMutex.Unlock()
+
+	return fileAnalysis{file: filename, analysis: analysis, index: index}
+}
+
+// rateBudget returns the request and token budget shared by every analysis in the review
+func (w *Workflow) rateBudget() *ratelimit.Budget {
+	w.budgetOnce.Do(func() {
+		w.budget = ratelimit.NewBudget(w.Ctx.RequestsPerMinute, w.Ctx.TokensPerMinute)
+	})
+	return w.budget
+}
+
+// estimateTokens estimates the tokens a file analysis request of a step uses, counting the file content
+// with the step's model plus an allowance for the rest of the prompt and the response
+func (w *Workflow) estimateTokens(step, content string) int {
+	return w.approxTokens(step, content) + analysisTokenAllowance
+}
+
+// unanalyzedFilesSection lists the files that were skipped or failed, so they aren't silently missing from an artifact
+func unanalyzedFilesSection(results []fileAnalysis) string {
+	var sb strings.Builder
+	for _, result := range results {
+		if result.err == nil {
+			continue
+		}
+		if sb.Len() == 0 {
+			sb.WriteString("## Files Not Analyzed\n\n")
+			sb.WriteString("The following files could not be analyzed and are not covered above:\n\n")
+		}
+		status := "failed"
+		if result.skipped {
+			status = "skipped"
+		}
+		sb.WriteString(fmt.Sprintf("- `%s`: %s (%v)\n", result.file, status, result.err))
+	}
+	if sb.Len() > 0 {
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
 // SynthesizeOriginalImplementation takes the individual file analyses and creates a synthesized understanding
 func (w *Workflow) SynthesizeOriginalImplementation() error {
 	// 1. Read the original implementation analysis file
@@ -722,8 +896,8 @@ func (w *Workflow) SynthesizeOriginalImplementation() error {
 	}
 
 	// 2. Create the prompt for synthesis
-	prompt := "You are a senior software engineer tasked with synthesizing individual file analyses into a cohesive understanding of a specific feature.\n\n"
-	prompt += "Below are detailed analyses of each file involved in a feature that's being changed in a PR.\n\n"
+	system := "You are a senior software engineer tasked with synthesizing individual file analyses into a cohesive understanding of a specific feature."
+	prompt := "Below are detailed analyses of each file involved in a feature that's being changed in a PR.\n\n"
 	prompt += "Your task is to synthesize these individual analyses into a comprehensive understanding of how the specific feature worked as a system BEFORE the changes.\n\n"
 	prompt += "Focus on:\n"
 	prompt += "1. Identify the specific feature being modified based on the file analyses and PR changes\n"
@@ -742,7 +916,7 @@ func (w *Workflow) SynthesizeOriginalImplementation() error {
 
 	// 3. Send to LLM for synthesis
 	logger.Debug("Synthesizing file analyses...")
-	response, err := w.Ctx.Client.Complete(context.Background(), prompt)
+	response, err := w.askStreaming("synthesis", "Synthesis", system, prompt)
 	if err != nil {
 		return fmt.Errorf("error synthesizing original implementation: %w", err)
 	}
@@ -754,6 +928,15 @@ func (w *Workflow) SynthesizeOriginalImplementation() error {
 	sb.WriteString("This document provides a synthesized understanding of how the feature worked as a cohesive system before the changes in this PR.\n\n")
 	sb.WriteString(response)
 
+	// Add the analysis of files introduced by the PR alongside the synthesis
+	newComponents, err := os.ReadFile(w.newComponentsPath())
+	if err == nil {
+		sb.WriteString("\n\n")
+		sb.Write(newComponents)
+	} else if !os.IsNotExist(err) {
+		logger.Debug("Warning: Could not read new components analysis: %v", err)
+	}
+
 	// 5. Count tokens in the result
 	outputContent := sb.String()
 	tokenCount, err := w.Ctx.TokenCounter.CountText(outputContent, w.Ctx.Model)
@@ -778,6 +961,11 @@ func (w *Workflow) SynthesizeOriginalImplementation() error {
 
 // GenerateSyntaxReviewPrompt creates a prompt for the syntax and best practices review step
 func (w *Workflow) GenerateSyntaxReviewPrompt() string {
+	return w.syntaxReviewPrompt(w.Ctx.DiffContent)
+}
+
+// syntaxReviewPrompt creates the syntax review prompt around the given diff
+func (w *Workflow) syntaxReviewPrompt(diffContent string) string {
 	// Use the synthesis content stored in the context
 	synthesisContent := "No synthesis available."
 	if w.Ctx.SynthesisContent != "" {
@@ -789,7 +977,6 @@ func (w *Workflow) GenerateSyntaxReviewPrompt() string {
 
 	// Overview section - common across all review types
 	sb.WriteString("# Code Review: Syntax and Best Practices\n\n")
-	sb.WriteString(w.GetCommonPromptIntro("reviewer"))
 	sb.WriteString("Your goal is to identify syntax issues and best practice violations that could cause the team problems. ")
 	sb.WriteString("Focus on substance over form, and avoid stating things that would be obvious to experienced developers.\n\n")
 
@@ -803,66 +990,20 @@ func (w *Workflow) GenerateSyntaxReviewPrompt() string {
 	sb.WriteString("5. **Review Limitations**: Explicitly state if you have sufficient context and what additional information would improve the review.\n\n")
 
 	// Machine consumption format section
-	sb.WriteString("## Machine Consumption Format\n\n")
-	sb.WriteString("IMPORTANT: Your output will be processed by another LLM to create a consolidated review, not read directly by humans.\n\n")
-	sb.WriteString("Use these consistent tags and format:\n\n")
-	sb.WriteString("```\n")
-	sb.WriteString("<SYNTAX_REVIEW>\n")
-	sb.WriteString("  <REVIEW_SUMMARY>\n")
-	sb.WriteString("  Brief assessment of findings and limitations\n")
-	sb.WriteString("  </REVIEW_SUMMARY>\n\n")
-
-	sb.WriteString("  <CRITICAL_ISSUES>\n")
-	sb.WriteString("  [List syntax errors that would cause runtime failures]\n")
-	sb.WriteString("  </CRITICAL_ISSUES>\n\n")
-
-	sb.WriteString("  <LOGIC_ISSUES>\n")
-	sb.WriteString("  [List problems with variable/parameter usage and logic]\n")
-	sb.WriteString("  </LOGIC_ISSUES>\n\n")
-
-	sb.WriteString("  <IMPROVEMENT_SUGGESTIONS>\n")
-	sb.WriteString("  [List best practice violations]\n")
-	sb.WriteString("  </IMPROVEMENT_SUGGESTIONS>\n\n")
-
-	sb.WriteString("  <REVIEW_LIMITATIONS>\n")
-	sb.WriteString("  [State what additional context would help]\n")
-	sb.WriteString("  </REVIEW_LIMITATIONS>\n")
-	sb.WriteString("</SYNTAX_REVIEW>\n")
-	sb.WriteString("```\n\n")
-
-	sb.WriteString("For each issue, use this format:\n\n")
-	sb.WriteString("```\n")
-	sb.WriteString("<ISSUE>\n")
-	sb.WriteString("FILE: path/to/file.php\n")
-	sb.WriteString("LINE: 42\n")
-	sb.WriteString("SEVERITY: [Critical|Major|Minor]\n")
-	sb.WriteString("PROBLEM: Brief description\n")
-	sb.WriteString("... several lines of prior context with line numbers...\n")
-	sb.WriteString("SOLUTION_CODE:\n")
-	sb.WriteString("```php\n")
-	sb.WriteString("// Original\n")
-	sb.WriteString("$original = $code->here();\n\n")
-	sb.WriteString("// Fixed\n")
-	sb.WriteString("$fixed = $code->here();\n")
-	sb.WriteString("```\n")
-	sb.WriteString("... more lines of prior context with line numbers if available...\n")
-	sb.WriteString("</ISSUE>\n")
-	sb.WriteString("```\n\n")
-
-	sb.WriteString("If no issues found in a category: `<NO_ISSUES_FOUND/>`\n\n")
-	sb.WriteString("Focus exclusively on syntax and logic - ignore broader functionality concerns.\n\n")
+	sb.WriteString(w.reviewFormatSection(syntaxReviewFormat))
 
 	// Context section
 	sb.WriteString("## Context\n\n")
 	sb.WriteString("The following context is provided for your review:\n\n")
 
 	// Original implementation
+	sb.WriteString(w.architectureSection("###"))
 	sb.WriteString("### Original Implementation\n\n")
 	sb.WriteString(synthesisContent)
 
 	// PR changes
 	sb.WriteString("\n\n### Changes in this PR\n\n")
-	sb.WriteString(w.Ctx.DiffContent)
+	sb.WriteString(diffContent)
 
 	// Add design document if available
 	if w.Ctx.DesignDocContent != "" {
@@ -881,6 +1022,11 @@ func (w *Workflow) GenerateSyntaxReviewPrompt() string {
 
 // GenerateFunctionalityReviewPrompt creates a prompt for the functionality review step
 func (w *Workflow) GenerateFunctionalityReviewPrompt() string {
+	return w.functionalityReviewPrompt(w.Ctx.DiffContent)
+}
+
+// functionalityReviewPrompt creates the functionality review prompt around the given diff
+func (w *Workflow) functionalityReviewPrompt(diffContent string) string {
 	// Use the synthesis content stored in the context
 	synthesisContent := "No synthesis available."
 	if w.Ctx.SynthesisContent != "" {
@@ -892,7 +1038,6 @@ func (w *Workflow) GenerateFunctionalityReviewPrompt() string {
 
 	// Overview section - common across all review types
 	sb.WriteString("# Code Review: Implementation vs Requirements\n\n")
-	sb.WriteString(w.GetCommonPromptIntro("reviewer"))
 	sb.WriteString("Your goal is to identify missing or incorrect functionality that could cause the team problems. ")
 	sb.WriteString("Focus on substance over form, and avoid stating things that would be obvious to experienced developers.\n\n")
 
@@ -906,59 +1051,20 @@ func (w *Workflow) GenerateFunctionalityReviewPrompt() string {
 	sb.WriteString("Again, NO above, explain exactly HOW you would use the context you suggest is missing, if you had it.")
 
 	// Machine consumption format section
-	sb.WriteString("## Machine Consumption Format\n\n")
-	sb.WriteString("IMPORTANT: Your output will be processed by another LLM to create a consolidated review, not read directly by humans.\n\n")
-	sb.WriteString("Use these consistent tags and format:\n\n")
-	sb.WriteString("```\n")
-	sb.WriteString("<FUNCTIONALITY_REVIEW>\n")
-	sb.WriteString("  <REVIEW_SUMMARY>\n")
-	sb.WriteString("  Brief assessment of findings and limitations\n")
-	sb.WriteString("  </REVIEW_SUMMARY>\n\n")
-
-	sb.WriteString("  <FUNCTIONALITY_ISSUES>\n")
-	sb.WriteString("  [List misses or errors in implemented functionality, as compared to ticket/design doc reqs]\n")
-	sb.WriteString("  </FUNCTIONALITY_ISSUES>\n\n")
-
-	sb.WriteString("  <REVIEW_LIMITATIONS>\n")
-	sb.WriteString("  [State if able to do a thorough review]\n")
-	sb.WriteString("  [State what additional context would help]\n")
-	sb.WriteString("  </REVIEW_LIMITATIONS>\n")
-	sb.WriteString("</FUNCTIONALITY_REVIEW>\n")
-	sb.WriteString("```\n\n")
-
-	sb.WriteString("For each issue, use this format:\n\n")
-	sb.WriteString("```\n")
-	sb.WriteString("<ISSUE>\n")
-	sb.WriteString("FILE: path/to/file.php\n")
-	sb.WriteString("LINE: 42\n")
-	sb.WriteString("SEVERITY: [Critical|Major|Minor]\n")
-	sb.WriteString("PROBLEM: Brief description\n")
-	sb.WriteString("... several lines of prior context with line numbers...\n")
-	sb.WriteString("SOLUTION_CODE:\n")
-	sb.WriteString("```php\n")
-	sb.WriteString("// Original\n")
-	sb.WriteString("$original = $code->here();\n\n")
-	sb.WriteString("// Fixed\n")
-	sb.WriteString("$fixed = $code->here();\n")
-	sb.WriteString("```\n")
-	sb.WriteString("... more lines of prior context with line numbers if available...\n")
-	sb.WriteString("</ISSUE>\n")
-	sb.WriteString("```\n\n")
-
-	sb.WriteString("If no issues found in a category: `<NO_ISSUES_FOUND/>`\n\n")
-	sb.WriteString("Focus exclusively on functionality implementation per ticket/design doc - ignore broader syntax and logic concerns.\n\n")
+	sb.WriteString(w.reviewFormatSection(functionalityReviewFormat))
 
 	// Context section
 	sb.WriteString("## Context\n\n")
 	sb.WriteString("The following context is provided for your review:\n\n")
 
 	// Original implementation
+	sb.WriteString(w.architectureSection("###"))
 	sb.WriteString("### Original Implementation\n\n")
 	sb.WriteString(synthesisContent)
 
 	// PR changes
 	sb.WriteString("\n\n### Changes in this PR\n\n")
-	sb.WriteString(w.Ctx.DiffContent)
+	sb.WriteString(diffContent)
 
 	// Add design document if available
 	if w.Ctx.DesignDocContent != "" {
@@ -972,11 +1078,22 @@ func (w *Workflow) GenerateFunctionalityReviewPrompt() string {
 		sb.WriteString(w.Ctx.TicketDetails)
 	}
 
+	// Add the pull request's description and existing review comments if available
+	if w.Ctx.PullRequestDetails != "" {
+		sb.WriteString("\n\n### Pull Request\n\n")
+		sb.WriteString(w.Ctx.PullRequestDetails)
+	}
+
 	return sb.String()
 }
 
 // GenerateDefensiveReviewPrompt creates a prompt for the defensive programming review step
 func (w *Workflow) GenerateDefensiveReviewPrompt() string {
+	return w.defensiveReviewPrompt(w.Ctx.DiffContent)
+}
+
+// defensiveReviewPrompt creates the defensive review prompt around the given diff
+func (w *Workflow) defensiveReviewPrompt(diffContent string) string {
 	// Use the synthesis content stored in the context
 	synthesisContent := "No synthesis available."
 	if w.Ctx.SynthesisContent != "" {
@@ -988,7 +1105,6 @@ func (w *Workflow) GenerateDefensiveReviewPrompt() string {
 
 	// Overview section - common across all review types
 	sb.WriteString("# Code Review: Defensive Programming\n\n")
-	sb.WriteString(w.GetCommonPromptIntro("reviewer"))
 	sb.WriteString("Your goal is to identify security issues, error handling gaps, and edge cases that could cause production problems. ")
 	sb.WriteString("Focus on substance over form, and avoid stating things that would be obvious to experienced developers.\n\n")
 
@@ -1007,70 +1123,20 @@ func (w *Workflow) GenerateDefensiveReviewPrompt() string {
 	sb.WriteString("9. **Review Limitations**: Explicitly state if you have sufficient context and what additional information would improve the review.\n\n")
 
 	// Machine consumption format section
-	sb.WriteString("## Machine Consumption Format\n\n")
-	sb.WriteString("IMPORTANT: Your output will be processed by another LLM to create a consolidated review, not read directly by humans.\n\n")
-	sb.WriteString("Use these consistent tags and format:\n\n")
-	sb.WriteString("```\n")
-	sb.WriteString("<DEFENSIVE_REVIEW>\n")
-	sb.WriteString("  <REVIEW_SUMMARY>\n")
-	sb.WriteString("  Brief assessment of findings and limitations\n")
-	sb.WriteString("  </REVIEW_SUMMARY>\n\n")
-
-	sb.WriteString("  <ERROR_HANDLING_ISSUES>\n")
-	sb.WriteString("  [List missing or inadequate error handling]\n")
-	sb.WriteString("  </ERROR_HANDLING_ISSUES>\n\n")
-
-	sb.WriteString("  <EDGE_CASE_ISSUES>\n")
-	sb.WriteString("  [List unhandled edge cases]\n")
-	sb.WriteString("  </EDGE_CASE_ISSUES>\n\n")
-
-	sb.WriteString("  <SECURITY_ISSUES>\n")
-	sb.WriteString("  [List potential security concerns]\n")
-	sb.WriteString("  </SECURITY_ISSUES>\n\n")
-
-	sb.WriteString("  <RESOURCE_ISSUES>\n")
-	sb.WriteString("  [List resource management issues]\n")
-	sb.WriteString("  </RESOURCE_ISSUES>\n\n")
-
-	sb.WriteString("  <REVIEW_LIMITATIONS>\n")
-	sb.WriteString("  [State what additional context would help]\n")
-	sb.WriteString("  </REVIEW_LIMITATIONS>\n")
-	sb.WriteString("</DEFENSIVE_REVIEW>\n")
-	sb.WriteString("```\n\n")
-
-	sb.WriteString("For each issue, use this format:\n\n")
-	sb.WriteString("```\n")
-	sb.WriteString("<ISSUE>\n")
-	sb.WriteString("FILE: path/to/file.php\n")
-	sb.WriteString("LINE: 42\n")
-	sb.WriteString("SEVERITY: [Critical|Major|Minor]\n")
-	sb.WriteString("PROBLEM: Brief description\n")
-	sb.WriteString("... several lines of prior context with line numbers...\n")
-	sb.WriteString("SOLUTION_CODE:\n")
-	sb.WriteString("```php\n")
-	sb.WriteString("// Original\n")
-	sb.WriteString("$original = $code->here();\n\n")
-	sb.WriteString("// Fixed\n")
-	sb.WriteString("$fixed = $code->here();\n")
-	sb.WriteString("```\n")
-	sb.WriteString("... more lines of prior context with line numbers if available...\n")
-	sb.WriteString("</ISSUE>\n")
-	sb.WriteString("```\n\n")
-
-	sb.WriteString("If no issues found in a category: `<NO_ISSUES_FOUND/>`\n\n")
-	sb.WriteString("Focus exclusively on defensive programming concerns - ignore syntax and functionality issues already covered in other reviews.\n\n")
+	sb.WriteString(w.reviewFormatSection(defensiveReviewFormat))
 
 	// Context section
 	sb.WriteString("## Context\n\n")
 	sb.WriteString("The following context is provided for your review:\n\n")
 
 	// Original implementation
+	sb.WriteString(w.architectureSection("###"))
 	sb.WriteString("### Original Implementation\n\n")
 	sb.WriteString(synthesisContent)
 
 	// PR changes
 	sb.WriteString("\n\n### Changes in this PR\n\n")
-	sb.WriteString(w.Ctx.DiffContent)
+	sb.WriteString(diffContent)
 
 	// Add design document if available
 	if w.Ctx.DesignDocContent != "" {
@@ -1110,7 +1176,6 @@ func (w *Workflow) GenerateFinalSummaryPrompt() string {
 
 	// Overview section
 	sb.WriteString("# PR Review Summary Generation\n\n")
-	sb.WriteString(w.GetCommonPromptIntro("summarizer"))
 	sb.WriteString("Your task is to synthesize the machine-generated review phases AND their validation into a concise, actionable, and professional summary. ")
 	sb.WriteString("The team values clear communication, actionable feedback, and a focus on what matters most.\n\n")
 	sb.WriteString("IMPORTANT: The validation results should take precedence over the original review when there are conflicts. ")
@@ -1144,31 +1209,15 @@ func (w *Workflow) GenerateFinalSummaryPrompt() string {
 	sb.WriteString("   **Issue**: [Clear description of the problem]\n\n")
 	sb.WriteString("   **WHY**: [Explanation of why this is important]\n\n")
 	sb.WriteString("   **Suggested Fix**:\n")
-	sb.WriteString("   ```diff\n")
-	sb.WriteString("   // Show function/class signature and at least 3-5 lines of context before the change\n")
-	sb.WriteString("   public function processPayment($clientId) {\n")
-	sb.WriteString("       // Several lines of context...\n")
-	sb.WriteString("   -   if ($condition) {\n")
-	sb.WriteString("   +   if ($condition && $additionalCheck) {\n")
-	sb.WriteString("       // More context lines...\n")
-	sb.WriteString("   }\n")
-	sb.WriteString("   ```\n\n")
+	sb.WriteString(w.exampleDiff("   "))
 	sb.WriteString("4. **Non-Blocker Issues**: List the non-blocker issues that are still important to address. Format as follows:\n\n")
 	sb.WriteString("   ### 1. [Suggestion Title]\n")
 	sb.WriteString("   **Suggestion**: [Description of the suggestion]\n\n")
 	sb.WriteString("   **Benefit**: [Explanation of the benefit]\n\n")
-	sb.WriteString("   **File**: /full/path/to/file.php\n")
+	sb.WriteString(fmt.Sprintf("   **File**: %s\n", w.repoLanguage().ExampleFile))
 	sb.WriteString("   **Line**: ~142 (approximate line number)\n\n")
 	sb.WriteString("   **Example**:\n")
-	sb.WriteString("   ```diff\n")
-	sb.WriteString("   // Show function/class signature and at least 3-5 lines of context before the change\n")
-	sb.WriteString("   public function processPayment($clientId) {\n")
-	sb.WriteString("       // Several lines of context...\n")
-	sb.WriteString("   -   if ($condition) {\n")
-	sb.WriteString("   +   if ($condition && $additionalCheck) {\n")
-	sb.WriteString("       // More context lines...\n")
-	sb.WriteString("   }\n")
-	sb.WriteString("   ```\n\n")
+	sb.WriteString(w.exampleDiff("   "))
 	sb.WriteString("5. For each issue, also include these fields in your internal analysis (but they don't need to appear in the final output):\n\n")
 	sb.WriteString("   - Source: Which review phase identified it (Syntax, Functionality, or Defensive)\n")
 	sb.WriteString("   - Confidence: High/Medium/Low based on how clearly it was identified\n")
@@ -1181,32 +1230,10 @@ func (w *Workflow) GenerateFinalSummaryPrompt() string {
 	sb.WriteString("   - **Description**: [Detailed explanation of why this is a problem and what edge cases it addresses]\n")
 	sb.WriteString("   - **Current Logic**: [Explain what the current code is trying to accomplish]\n")
 	sb.WriteString("   - **Functionality Check**: [Confirm that the suggested change preserves all existing functionality]\n")
-	sb.WriteString("   - **File**: app/PayrollServices/Silo/Client/Domain/PayCycleDomain.php\n")
+	sb.WriteString(fmt.Sprintf("   - **File**: %s\n", w.repoLanguage().ExampleFile))
 	sb.WriteString("   - **Line**: ~142 (approximate line number)\n")
 	sb.WriteString("   - **Code**:\n")
-	sb.WriteString("   ```diff\n")
-	sb.WriteString("   /**\n")
-	sb.WriteString("    * Process a payment for the given client ID\n")
-	sb.WriteString("    * @param int $clientId The client ID\n")
-	sb.WriteString("    * @return PaymentStatus\n")
-	sb.WriteString("    */\n")
-	sb.WriteString("   public function processPayment($clientId) {\n")
-	sb.WriteString("       $client = $this->getClient($clientId);\n")
-	sb.WriteString("       $account = $client->getAccount();\n")
-	sb.WriteString("       \n")
-	sb.WriteString("       // Check if client has sufficient balance\n")
-	sb.WriteString("   -   $balance = $this->getBalance($client);\n")
-	sb.WriteString("   -   if ($balance > 0) {\n")
-	sb.WriteString("   +   $balance = $this->getBalanceWithRetry($client);\n")
-	sb.WriteString("   +   if ($balance !== null && $balance > 0) {\n")
-	sb.WriteString("           $this->processPaymentWithBalance($client, $balance);\n")
-	sb.WriteString("       } else {\n")
-	sb.WriteString("           $this->logInsufficientFunds($client);\n")
-	sb.WriteString("       }\n")
-	sb.WriteString("       \n")
-	sb.WriteString("       return $this->getPaymentStatus($client);\n")
-	sb.WriteString("   }\n")
-	sb.WriteString("   ```\n\n")
+	sb.WriteString(w.exampleDiff("   "))
 	sb.WriteString("   IMPORTANT: Use pure diff format. Do NOT include comments like \"// Original\" or \"// Fixed\". Just show the actual code changes with - and + prefixes. Always include enough surrounding code to help developers locate the right spot.\n")
 
 	sb.WriteString("## IMPORTANT FORMATTING RULES\n\n")
@@ -1253,230 +1280,45 @@ func (w *Workflow) GenerateFinalSummaryPrompt() string {
 	return sb.String()
 }
 
-// GenerateSyntaxReview generates a review focusing on PHP syntax and best practices
+// GenerateSyntaxReview generates a review focusing on language syntax and best practices
 func (w *Workflow) GenerateSyntaxReview() error {
-	// 1. Generate the prompt
-	prompt := w.GenerateSyntaxReviewPrompt()
-
-	// 2. Count tokens in the prompt
-	tokenCount, err := w.Ctx.TokenCounter.CountText(prompt, w.Ctx.Model)
-	if err != nil {
-		logger.Debug("Warning: Could not count tokens in syntax review prompt: %v", err)
-	} else {
-		logger.Verbose("Syntax review prompt contains %d tokens", tokenCount)
-		if tokenCount > w.Ctx.MaxTokens/2 {
-			logger.Debug("Warning: Syntax review prompt is very large (%d tokens)", tokenCount)
-		}
-	}
-
-	// 3. Send to LLM for review
-	logger.Debug("Generating syntax review...")
-	response, err := w.Ctx.Client.Complete(context.Background(), prompt)
-	if err != nil {
-		return fmt.Errorf("error generating syntax review: %w", err)
-	}
-
-	// 4. Create or append to the output file
-	outputPath := filepath.Join(w.Ctx.OutputDir, fmt.Sprintf("%s-review-result.md", w.Ctx.Ticket))
-
-	// Check if file exists
-	var content []byte
-	fileExists := false
-	if _, err := os.Stat(outputPath); err == nil {
-		// File exists, read it
-		content, err = os.ReadFile(outputPath)
-		if err != nil {
-			return fmt.Errorf("error reading existing review file: %w", err)
-		}
-		fileExists = true
-	}
-
-	// Prepare content to write
-	var sb strings.Builder
-	if !fileExists {
-		// Create new file with header
-		sb.WriteString("# PR Review Results\n\n")
-		sb.WriteString("This document contains a thorough review of the PR changes from multiple perspectives.\n\n")
-	}
-
-	// Append existing content if any
-	if fileExists {
-		sb.Write(content)
-		// Add a separator
-		sb.WriteString("\n\n---\n\n")
-	}
-
-	// Add the syntax review section
-	sb.WriteString(response)
-
-	// 5. Count tokens in the result
-	outputContent := sb.String()
-	tokenCount, err = w.Ctx.TokenCounter.CountText(outputContent, w.Ctx.Model)
-	if err == nil && !fileExists {
-		// Only add token count info if this is a new file
-		sb.WriteString(fmt.Sprintf("\n\n---\n\nThis review contains **%d tokens** when processed by %s.\n", tokenCount, w.Ctx.Model))
-		outputContent = sb.String()
-	}
-
-	// 6. Write the result to a file
-	err = os.WriteFile(outputPath, []byte(outputContent), 0644)
-	if err != nil {
-		return fmt.Errorf("failed to write syntax review: %w", err)
-	}
-
-	logger.Debug("Syntax review saved")
-	logger.Debug("Output path: %s", outputPath)
-	return nil
+	return w.generateReviewPhase(syntaxReviewFormat, "syntax review", w.syntaxReviewPrompt)
 }
 
 // GenerateFunctionalityReview generates a review focusing on functionality against requirements
 func (w *Workflow) GenerateFunctionalityReview() error {
-	// 1. Generate the prompt
-	prompt := w.GenerateFunctionalityReviewPrompt()
-
-	// 2. Count tokens in the prompt
-	tokenCount, err := w.Ctx.TokenCounter.CountText(prompt, w.Ctx.Model)
-	if err != nil {
-		logger.Debug("Warning: Could not count tokens in functionality review prompt: %v", err)
-	} else {
-		logger.Verbose("Functionality review prompt contains %d tokens", tokenCount)
-		if tokenCount > w.Ctx.MaxTokens/2 {
-			logger.Debug("Warning: Functionality review prompt is very large (%d tokens)", tokenCount)
-		}
-	}
-
-	// 3. Send to LLM for review
-	logger.Debug("Generating functionality review...")
-	response, err := w.Ctx.Client.Complete(context.Background(), prompt)
-	if err != nil {
-		return fmt.Errorf("error generating functionality review: %w", err)
-	}
-
-	// 4. Create or append to the output file
-	outputPath := filepath.Join(w.Ctx.OutputDir, fmt.Sprintf("%s-review-result.md", w.Ctx.Ticket))
-
-	// Check if file exists
-	var content []byte
-	fileExists := false
-	if _, err := os.Stat(outputPath); err == nil {
-		// File exists, read it
-		content, err = os.ReadFile(outputPath)
-		if err != nil {
-			return fmt.Errorf("error reading existing review file: %w", err)
-		}
-		fileExists = true
-	}
-
-	// Prepare content to write
-	var sb strings.Builder
-	if !fileExists {
-		// Create new file with header
-		sb.WriteString("# PR Review Results\n\n")
-		sb.WriteString("This document contains a thorough review of the PR changes from multiple perspectives.\n\n")
-	}
-
-	// Append existing content if any
-	if fileExists {
-		sb.Write(content)
-		// Add a separator
-		sb.WriteString("\n\n---\n\n")
-	}
-
-	// Add the functionality review section
-	sb.WriteString(response)
-
-	// 5. Count tokens in the result
-	outputContent := sb.String()
-	tokenCount, err = w.Ctx.TokenCounter.CountText(outputContent, w.Ctx.Model)
-	if err == nil && !fileExists {
-		// Only add token count info if this is a new file
-		sb.WriteString(fmt.Sprintf("\n\n---\n\nThis review contains **%d tokens** when processed by %s.\n", tokenCount, w.Ctx.Model))
-		outputContent = sb.String()
-	}
-
-	// 6. Write the result to a file
-	err = os.WriteFile(outputPath, []byte(outputContent), 0644)
-	if err != nil {
-		return fmt.Errorf("failed to write functionality review: %w", err)
-	}
-
-	logger.Debug("Functionality review saved")
-	logger.Debug("Output path: %s", outputPath)
-	return nil
+	return w.generateReviewPhase(functionalityReviewFormat, "functionality review", w.functionalityReviewPrompt)
 }
 
 // GenerateDefensiveReview generates a review focusing on defensive programming
 func (w *Workflow) GenerateDefensiveReview() error {
-	// 1. Generate the prompt
-	prompt := w.GenerateDefensiveReviewPrompt()
+	return w.generateReviewPhase(defensiveReviewFormat, "defensive programming review", w.defensiveReviewPrompt)
+}
 
-	// 2. Count tokens in the prompt
-	tokenCount, err := w.Ctx.TokenCounter.CountText(prompt, w.Ctx.Model)
-	if err != nil {
-		logger.Debug("Warning: Could not count tokens in defensive review prompt: %v", err)
-	} else {
-		logger.Verbose("Defensive review prompt contains %d tokens", tokenCount)
-		if tokenCount > w.Ctx.MaxTokens/2 {
-			logger.Debug("Warning: Defensive review prompt is very large (%d tokens)", tokenCount)
-		}
-	}
+// generateReviewPhase runs one review phase and writes its artifact and findings. The phases are
+// merged into the review file afterwards.
+func (w *Workflow) generateReviewPhase(format reviewFormat, name string, prompt func(diff string) string) error {
+	title := strings.ToUpper(name[:1]) + name[1:]
 
-	// 3. Send to LLM for review
-	logger.Debug("Generating defensive programming review...")
-	response, err := w.Ctx.Client.Complete(context.Background(), prompt)
+	// 1. Send the prompt to LLM for review, split into parts if the diff doesn't fit the model
+	logger.Debug("Generating %s...", name)
+	response, findings, err := w.askReviewPhase(format, prompt)
 	if err != nil {
-		return fmt.Errorf("error generating defensive programming review: %w", err)
-	}
-
-	// 4. Create or append to the output file
-	outputPath := filepath.Join(w.Ctx.OutputDir, fmt.Sprintf("%s-review-result.md", w.Ctx.Ticket))
-
-	// Check if file exists
-	var content []byte
-	fileExists := false
-	if _, err := os.Stat(outputPath); err == nil {
-		// File exists, read it
-		content, err = os.ReadFile(outputPath)
-		if err != nil {
-			return fmt.Errorf("error reading existing review file: %w", err)
-		}
-		fileExists = true
-	}
-
-	// Prepare content to write
-	var sb strings.Builder
-	if !fileExists {
-		// Create new file with header
-		sb.WriteString("# PR Review Results\n\n")
-		sb.WriteString("This document contains a thorough review of the PR changes from multiple perspectives.\n\n")
-	}
-
-	// Append existing content if any
-	if fileExists {
-		sb.Write(content)
-		// Add a separator
-		sb.WriteString("\n\n---\n\n")
+		return fmt.Errorf("error generating %s: %w", name, err)
 	}
+	w.logUnverifiedLineReferences(title, response)
 
-	// Add the defensive review section
-	sb.WriteString(response)
-
-	// 5. Count tokens in the result
-	outputContent := sb.String()
-	tokenCount, err = w.Ctx.TokenCounter.CountText(outputContent, w.Ctx.Model)
-	if err == nil && !fileExists {
-		// Only add token count info if this is a new file
-		sb.WriteString(fmt.Sprintf("\n\n---\n\nThis review contains **%d tokens** when processed by %s.\n", tokenCount, w.Ctx.Model))
-		outputContent = sb.String()
+	// 2. Write the phase's own artifact and its findings
+	outputPath := w.reviewPhasePath(strings.TrimSuffix(format.step, "-review"))
+	if err := os.WriteFile(outputPath, []byte(response), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
 	}
-
-	// 6. Write the result to a file
-	err = os.WriteFile(outputPath, []byte(outputContent), 0644)
-	if err != nil {
-		return fmt.Errorf("failed to write defensive programming review: %w", err)
+	logger.Verbose("%s reported %d issues", findings.Phase, len(findings.Issues))
+	if err := writeFindings(findingsPath(outputPath), findings); err != nil {
+		return err
 	}
 
-	logger.Debug("Defensive programming review saved")
+	logger.Debug("%s saved", title)
 	logger.Debug("Output path: %s", outputPath)
 	return nil
 }
@@ -1490,31 +1332,34 @@ func (w *Workflow) ValidateReviewFindings() error {
 		return fmt.Errorf("error reading review file for validation: %w", err)
 	}
 
-	// 2. Read the diff file
-	diffPath := filepath.Join(w.Ctx.OutputDir, fmt.Sprintf("%s-diff.md", w.Ctx.Ticket))
-	diffContent, err := os.ReadFile(diffPath)
-	if err != nil {
-		logger.Debug("Warning: Could not read diff file for validation: %v", err)
-		diffContent = []byte("No diff content available.")
+	// 2. Use the diff computed at the start of the run. If it is too large for the model, keep the
+	// files the findings point at.
+	validationPrompt := func(diffContent string) string {
+		return w.GenerateValidationPrompt(string(reviewContent), diffContent)
+	}
+	diffContent := w.fitDiff("validation", validationPrompt, w.referencedFiles(string(reviewContent)))
+	if diffContent == "" {
+		logger.Debug("Warning: No diff content available for validation")
+		diffContent = "No diff content available."
 	}
 
 	// 3. Generate the validation prompt
-	prompt := w.GenerateValidationPrompt(string(reviewContent), string(diffContent))
+	prompt := validationPrompt(diffContent)
 
 	// 4. Count tokens in the prompt
-	tokenCount, err := w.Ctx.TokenCounter.CountText(prompt, w.Ctx.Model)
+	tokenCount, err := w.countTokens("validation", prompt)
 	if err != nil {
 		logger.Debug("Warning: Could not count tokens in validation prompt: %v", err)
 	} else {
 		logger.Verbose("Validation prompt contains %d tokens", tokenCount)
-		if tokenCount > w.Ctx.MaxTokens/2 {
+		if tokenCount > w.maxTokens("validation")/2 {
 			logger.Debug("Warning: Validation prompt is very large (%d tokens)", tokenCount)
 		}
 	}
 
 	// 5. Send to LLM for validation
 	logger.Debug("Generating review validation...")
-	response, err := w.Ctx.Client.Complete(context.Background(), prompt)
+	response, err := w.askStreaming("validation", "Validation", w.GetCommonPromptIntro(""), prompt)
 	if err != nil {
 		return fmt.Errorf("error generating review validation: %w", err)
 	}
@@ -1538,8 +1383,6 @@ func (w *Workflow) GenerateValidationPrompt(reviewContent, diffContent string) s
 
 	// Overview section
 	sb.WriteString("# PR Review Validation\n\n")
-	sb.WriteString("You are a skeptical and methodical Sr Developer with expertise in PHP and general software development. ")
-	sb.WriteString("You assume there are issues, misses, and mistakes unless proven otherwise. ")
 	sb.WriteString("Your task is to critically evaluate the machine-generated review of a PR and validate or challenge its findings.\n\n")
 
 	// Ticket details if available - show this first for proper context
@@ -1567,6 +1410,8 @@ func (w *Workflow) GenerateValidationPrompt(reviewContent, diffContent string) s
 	sb.WriteString("   - **Adjust**: The issue is real but needs adjustment (e.g., severity, description)\n")
 	sb.WriteString("   - **Reject**: The issue is not valid or is a false positive\n\n")
 
+	exampleFile := w.repoLanguage().ExampleFile
+
 	sb.WriteString("## OUTPUT FORMAT\n\n")
 	sb.WriteString("Structure your validation as follows:\n\n")
 	sb.WriteString("```xml\n")
@@ -1576,7 +1421,7 @@ func (w *Workflow) GenerateValidationPrompt(reviewContent, diffContent string) s
 
 	sb.WriteString("<CONFIRMED_ISSUES>\n")
 	sb.WriteString("<ISSUE>\n")
-	sb.WriteString("FILE: path/to/file.php\n")
+	sb.WriteString(fmt.Sprintf("FILE: %s\n", exampleFile))
 	sb.WriteString("ORIGINAL_SEVERITY: Critical/Major/Minor\n")
 	sb.WriteString("CONFIRMED_SEVERITY: Critical/Major/Minor\n")
 	sb.WriteString("PROBLEM: Brief description of the issue\n")
@@ -1587,7 +1432,7 @@ func (w *Workflow) GenerateValidationPrompt(reviewContent, diffContent string) s
 
 	sb.WriteString("<ADJUSTED_ISSUES>\n")
 	sb.WriteString("<ISSUE>\n")
-	sb.WriteString("FILE: path/to/file.php\n")
+	sb.WriteString(fmt.Sprintf("FILE: %s\n", exampleFile))
 	sb.WriteString("ORIGINAL_SEVERITY: Critical/Major/Minor\n")
 	sb.WriteString("ADJUSTED_SEVERITY: Critical/Major/Minor\n")
 	sb.WriteString("ORIGINAL_PROBLEM: Brief description of the original issue\n")
@@ -1600,7 +1445,7 @@ func (w *Workflow) GenerateValidationPrompt(reviewContent, diffContent string) s
 
 	sb.WriteString("<REJECTED_ISSUES>\n")
 	sb.WriteString("<ISSUE>\n")
-	sb.WriteString("FILE: path/to/file.php\n")
+	sb.WriteString(fmt.Sprintf("FILE: %s\n", exampleFile))
 	sb.WriteString("ORIGINAL_SEVERITY: Critical/Major/Minor\n")
 	sb.WriteString("ORIGINAL_PROBLEM: Brief description of the original issue\n")
 	sb.WriteString("REJECTION_REASON: Why is this not a valid issue?\n")
@@ -1610,7 +1455,7 @@ func (w *Workflow) GenerateValidationPrompt(reviewContent, diffContent string) s
 
 	sb.WriteString("<MISSED_ISSUES>\n")
 	sb.WriteString("<ISSUE>\n")
-	sb.WriteString("FILE: path/to/file.php\n")
+	sb.WriteString(fmt.Sprintf("FILE: %s\n", exampleFile))
 	sb.WriteString("SEVERITY: Critical/Major/Minor\n")
 	sb.WriteString("PROBLEM: Description of an issue that was missed in the original review\n")
 	sb.WriteString("EVIDENCE: Specific evidence from the diff\n")
@@ -1632,28 +1477,55 @@ func (w *Workflow) GenerateValidationPrompt(reviewContent, diffContent string) s
 	sb.WriteString("### Review Content to Validate\n\n")
 	sb.WriteString(reviewContent)
 
+	// Line references that don't point at a changed line are likely to be inaccurate
+	if unverified := w.unverifiedLineReferences(reviewContent); len(unverified) > 0 {
+		sb.WriteString("\n\n### Line Reference Check\n\n")
+		sb.WriteString("The following FILE/LINE references in the review do not point at a line added or modified by this PR. ")
+		sb.WriteString("Treat the associated issues with extra skepticism and correct the location where you can:\n\n")
+		for _, ref := range unverified {
+			reason := "line not changed in this PR"
+			if !ref.InDiff {
+				reason = "file not part of this PR"
+			}
+			sb.WriteString(fmt.Sprintf("- `%s` (%s)\n", ref.String(), reason))
+		}
+	}
+
 	return sb.String()
 }
 
+// logUnverifiedLineReferences reports issue locations in a review phase that the diff doesn't support
+func (w *Workflow) logUnverifiedLineReferences(phase string, response string) {
+	unverified := w.unverifiedLineReferences(response)
+	if len(unverified) == 0 {
+		return
+	}
+
+	logger.Verbose("%s reported %d line references outside the changed lines", phase, len(unverified))
+	for _, ref := range unverified {
+		logger.Debug("Unverified line reference: %s", ref.String())
+	}
+}
+
 // GenerateFinalSummary generates the final PR review summary
 func (w *Workflow) GenerateFinalSummary() error {
 	// 1. Generate the prompt
 	prompt := w.GenerateFinalSummaryPrompt()
 
 	// 2. Count tokens in the prompt
-	tokenCount, err := w.Ctx.TokenCounter.CountText(prompt, w.Ctx.Model)
+	tokenCount, err := w.countTokens("final-summary", prompt)
 	if err != nil {
 		logger.Debug("Warning: Could not count tokens in final summary prompt: %v", err)
 	} else {
 		logger.Verbose("Final summary prompt contains %d tokens", tokenCount)
-		if tokenCount > w.Ctx.MaxTokens/2 {
+		if tokenCount > w.maxTokens("final-summary")/2 {
 			logger.Debug("Warning: Final summary prompt is very large (%d tokens)", tokenCount)
 		}
 	}
 
 	// 3. Send to LLM for summary generation
 	logger.Debug("Generating final summary...")
-	response, err := w.Ctx.Client.Complete(context.Background(), prompt)
+	response, err := w.askStreaming("final-summary", "Final summary", w.GetCommonPromptIntro("summarizer"), prompt)
 	if err != nil {
 		return fmt.Errorf("error generating final summary: %w", err)
 	}
@@ -1672,10 +1544,75 @@ func (w *Workflow) GenerateFinalSummary() error {
 	return nil
 }
 
+// reviewPhasePath returns the path of the artifact written by a single review phase
+func (w *Workflow) reviewPhasePath(phase string) string {
+	return filepath.Join(w.Ctx.OutputDir, fmt.Sprintf("%s-review-%s.md", w.Ctx.Ticket, phase))
+}
+
+// MergeReviewPhases combines the review phase artifacts, in the given order, into the review file
+func (w *Workflow) MergeReviewPhases(phasePaths []string) error {
+	var sb strings.Builder
+	sb.WriteString("# PR Review Results\n\n")
+	sb.WriteString("This document contains a thorough review of the PR changes from multiple perspectives.\n\n")
+
+	merged := 0
+	findings := []Findings{}
+	for _, path := range phasePaths {
+		content, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			logger.Debug("Review phase %s has no output to merge", path)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error reading review phase: %w", err)
+		}
+
+		// Separate the phases the same way regardless of which ran in this process
+		if merged > 0 {
+			sb.WriteString("\n\n---\n\n")
+		}
+		sb.Write(content)
+		merged++
+		findings = append(findings, w.phaseFindings(path, string(content)))
+	}
+
+	// Count tokens in the result
+	outputContent := sb.String()
+	tokenCount, err := w.Ctx.TokenCounter.CountText(outputContent, w.Ctx.Model)
+	if err == nil {
+		sb.WriteString(fmt.Sprintf("\n\n---\n\nThis review contains **%d tokens** when processed by %s.\n", tokenCount, w.Ctx.Model))
+		outputContent = sb.String()
+	}
+
+	outputPath := filepath.Join(w.Ctx.OutputDir, fmt.Sprintf("%s-review-result.md", w.Ctx.Ticket))
+	err = os.WriteFile(outputPath, []byte(outputContent), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write review file: %w", err)
+	}
+	if err := writeFindings(findingsPath(outputPath), findings); err != nil {
+		return err
+	}
+	sarifPath := w.artifactPath("review.sarif")
+	if err := FindingsSARIF(findings).Save(sarifPath); err != nil {
+		return err
+	}
+
+	logger.Debug("Merged %d review phases", merged)
+	logger.Debug("Output path: %s", outputPath)
+	logger.Debug("SARIF path: %s", sarifPath)
+	return nil
+}
+
 // Run executes the PR review workflow
 func (w *Workflow) Run() error {
+	// Build the pipeline first so configuration errors surface before any work is done
+	steps, err := w.pipeline()
+	if err != nil {
+		return fmt.Errorf("error building review pipeline: %w", err)
+	}
+
 	// Set the total number of steps (we're skipping the token counting step)
-	logger.SetTotalSteps(9)
+	logger.SetTotalSteps(len(steps))
 
 	// Assemble PR context section
 	// Add an extra blank line before the first section
@@ -1692,6 +1629,15 @@ func (w *Workflow) Run() error {
 		// Success message is printed in LoadDesignDocument, so we don't need to print it here
 	}
 
+	// Load the repository's architecture profile if one exists
+	if w.Ctx.RepoName != "" {
+		logger.Info("%s Loading architecture profile", logger.Arrow())
+		err := w.LoadArchitectureProfile()
+		if err != nil {
+			return fmt.Errorf("error loading architecture profile: %w", err)
+		}
+	}
+
 	// Fetch and format Jira ticket information if a ticket is specified
 	if w.Ctx.Ticket != "" {
 		logger.Info("%s Fetching Jira ticket", logger.Arrow())
@@ -1705,136 +1651,35 @@ func (w *Workflow) Run() error {
 		logger.Success("Jira ticket %s loaded successfully", w.Ctx.Ticket)
 	}
 
-	// We'll still count tokens internally, but not show it as a numbered step
-	err := w.CountTokens()
-	if err != nil {
-		return fmt.Errorf("error counting tokens: %w", err)
-	}
-
-	// Step 1: Initial discovery
-	logger.Step("Performing initial discovery")
-	logger.StepDetail("Sending Initial Discovery prompt to OpenAI")
-	err = w.RunLLMStep(
-		"Initial Discovery",
-		w.InitialDiscoveryPrompt,
-		filepath.Join(w.Ctx.OutputDir, w.Ctx.Ticket+"-initial-discovery.md"),
-	)
-	if err != nil {
-		return err
-	}
-
-	// Step 2: Collect original file contents
-	logger.Step("Collecting original file contents")
-	err = w.CollectOriginalFileContents()
-	if err != nil {
-		return fmt.Errorf("error collecting original file contents: %w", err)
-	}
-	logger.Success("Original file content collection completed")
-
-	// Step 3: Analyze original implementation
-	logger.Section("PREVIOUS IMPLEMENTATION ANALYSIS")
-	logger.Step("Analyzing original implementation")
-	// Get the number of files to analyze from the recommended file order
-	orderedFiles, err := w.ParseRecommendedFileOrder()
-	if err != nil {
-		logger.Debug("Could not parse recommended file order: %v", err)
-		logger.StepDetail("Starting file analysis using concurrent workers")
-		// Add a blank line after the message
-		fmt.Println()
-	} else {
-		logger.StepDetail("Starting analysis of %d files using individual goroutines", len(orderedFiles))
-		// Add a blank line after the message
-		fmt.Println()
-	}
-	err = w.AnalyzeOriginalImplementation()
-	if err != nil {
-		return fmt.Errorf("error analyzing original implementation: %w", err)
-	}
-	// Add a blank line before the success message
-	logger.Success("Original implementation analysis completed")
-
-	// Step 4: Synthesize original implementation
-	logger.Step("Synthesizing original implementation")
-	logger.StepDetail("Synthesizing file analyses")
-	err = w.SynthesizeOriginalImplementation()
-	if err != nil {
-		return fmt.Errorf("error synthesizing original implementation: %w", err)
-	}
-	// Add a blank line before the success message
-	fmt.Println()
-	logger.Success("Original implementation synthesis completed")
-
-	// Begin the PR review section
-	logger.Section("PR REVIEW GENERATION")
-
-	// Delete any existing review file to start fresh
-	reviewFilePath := filepath.Join(w.Ctx.OutputDir, fmt.Sprintf("%s-review-result.md", w.Ctx.Ticket))
-	if _, err := os.Stat(reviewFilePath); err == nil {
-		logger.Debug("Removing existing review file: %s", reviewFilePath)
-		err = os.Remove(reviewFilePath)
-		if err != nil {
-			logger.Debug("Warning: Could not remove existing review file: %v", err)
-		}
-	}
-
-	// Step 5: Generate Syntax Review
-	logger.Step("Generating syntax and best practices review")
-	logger.StepDetail("Analyzing PHP syntax and best practices")
-	err = w.GenerateSyntaxReview()
+	// Compute the diff and changed files from the repository
+	logger.Info("%s Computing PR changes", logger.Arrow())
+	err = w.PrepareChanges()
 	if err != nil {
-		return fmt.Errorf("error generating syntax review: %w", err)
+		return fmt.Errorf("error preparing PR changes: %w", err)
 	}
-	// Add a blank line before the success message
-	fmt.Println()
-	logger.Success("Syntax review completed")
+	logger.Success("PR changes computed successfully")
 
-	// Step 6: Generate Functionality Review
-	logger.Step("Generating functionality review")
-	logger.StepDetail("Analyzing functionality against requirements")
-	err = w.GenerateFunctionalityReview()
-	if err != nil {
-		return fmt.Errorf("error generating functionality review: %w", err)
-	}
-	// Add a blank line before the success message
-	fmt.Println()
-	logger.Success("Functionality review completed")
-
-	// Step 7: Generate Defensive Programming Review
-	logger.Step("Generating defensive programming review")
-	logger.StepDetail("Analyzing defensive programming aspects")
-	err = w.GenerateDefensiveReview()
+	// We'll still count tokens internally, but not show it as a numbered step
+	err = w.CountTokens()
 	if err != nil {
-		return fmt.Errorf("error generating defensive programming review: %w", err)
+		return fmt.Errorf("error counting tokens: %w", err)
 	}
-	// Add a blank line before the success message
-	fmt.Println()
-	logger.Success("Defensive programming review completed")
 
-	// Step 8: Validate Review Findings
-	logger.Step("Validating review findings")
-	logger.StepDetail("Challenging assumptions and validating issues")
-	err = w.ValidateReviewFindings()
+	// Run the review steps, reusing checkpointed output where allowed. Usage is reported even if a
+	// step fails, since the requests that were sent are still billed.
+	err = w.runSteps(steps)
+	usage, usageErr := w.WriteUsageReport()
 	if err != nil {
-		return fmt.Errorf("error validating review findings: %w", err)
+		return err
 	}
-	// Add a blank line before the success message
-	fmt.Println()
-	logger.Success("Review validation completed")
-
-	// Step 9: Generate Final Summary
-	logger.Step("Generating final review summary")
-	logger.StepDetail("Creating human-friendly review summary")
-	err = w.GenerateFinalSummary()
-	if err != nil {
-		return fmt.Errorf("error generating final summary: %w", err)
+	if usageErr != nil {
+		return usageErr
 	}
-	// Add a blank line before the success message
-	fmt.Println()
-	logger.Success("Final review summary saved")
 	logger.Success("PR review generation completed")
+	logger.Debug("Usage saved to %s", w.usagePath())
 
-	// Complete the process with timing information
-	logger.Complete()
+	// Complete the process with timing and usage information
+	logger.Complete(usage.Summary())
 
 	return nil
 }
@@ -1,10 +1,26 @@
 package review
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/jeremyhunt/agent-runner/diff"
+	"github.com/jeremyhunt/agent-runner/github"
+	"github.com/jeremyhunt/agent-runner/llm"
+	"github.com/jeremyhunt/agent-runner/pricing"
+	"github.com/jeremyhunt/agent-runner/sarif"
+	"github.com/jeremyhunt/agent-runner/tokens"
 )
 
 func TestNewReviewContext(t *testing.T) {
@@ -314,3 +330,1506 @@ func TestInitialDiscoveryPrompt(t *testing.T) {
 		})
 	}
 }
+
+func TestParseChangedFiles(t *testing.T) {
+	ctx := &ReviewContext{
+		FilesContent: "# Changed Files for feature\n\n" +
+			"## Modified Files\nsrc/keep.go\n\n" +
+			"## Added Files\nsrc/new.go\n\n" +
+			"## Deleted Files\nsrc/remove.go\n\n" +
+			"## Renamed Files\nsrc/old_name.go -> src/new_name.go\n\n" +
+			"## File Statistics\n3\t1\tsrc/keep.go\n",
+	}
+	workflow := NewWorkflow(ctx)
+
+	files, err := workflow.ParseChangedFiles()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Added files are excluded and renamed files use their original path
+	expected := []string{"src/keep.go", "src/old_name.go", "src/remove.go"}
+	if strings.Join(files, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected files %v, got %v", expected, files)
+	}
+}
+
+func TestCheckLineReferences(t *testing.T) {
+	parsed, err := diff.Parse("diff --git a/src/keep.go b/src/keep.go\n--- a/src/keep.go\n+++ b/src/keep.go\n@@ -1,3 +1,3 @@\n func Keep() int {\n-\treturn 1\n+\treturn 2\n }\n")
+	if err != nil {
+		t.Fatalf("Failed to parse diff: %v", err)
+	}
+
+	workflow := NewWorkflow(&ReviewContext{ParsedDiff: parsed})
+	content := "<ISSUE>\nFILE: src/keep.go\nLINE: 2\nSEVERITY: Minor\n</ISSUE>\n" +
+		"<ISSUE>\nFILE: src/keep.go\nLINE: ~3\nSEVERITY: Minor\n</ISSUE>\n" +
+		"<ISSUE>\nFILE: src/other.go\nLINE: 10\nSEVERITY: Major\n</ISSUE>\n"
+
+	refs := workflow.CheckLineReferences(content)
+	if len(refs) != 3 {
+		t.Fatalf("Expected 3 references, got %d", len(refs))
+	}
+
+	expected := []LineReference{
+		{File: "src/keep.go", Line: 2, InDiff: true, Changed: true},
+		{File: "src/keep.go", Line: 3, InDiff: true, Changed: false},
+		{File: "src/other.go", Line: 10, InDiff: false, Changed: false},
+	}
+	for i, ref := range refs {
+		if ref != expected[i] {
+			t.Errorf("Reference %d: expected %+v, got %+v", i, expected[i], ref)
+		}
+	}
+
+	// The validation prompt should flag the references that aren't on changed lines
+	prompt := workflow.GenerateValidationPrompt(content, "diff")
+	if !strings.Contains(prompt, "`src/keep.go:3` (line not changed in this PR)") {
+		t.Error("Validation prompt does not flag the unchanged line reference")
+	}
+	if !strings.Contains(prompt, "`src/other.go:10` (file not part of this PR)") {
+		t.Error("Validation prompt does not flag the reference outside the PR")
+	}
+}
+
+func TestPrepareChangesWithBaseAndHead(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	// Build a repository where the PR targets develop rather than main
+	repoDir := t.TempDir()
+	git := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = repoDir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s failed: %v\n%s", strings.Join(args, " "), err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(repoDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	git("init", "--quiet")
+	git("checkout", "--quiet", "-b", "main")
+	write("app.go", "package app\n")
+	git("add", ".")
+	git("commit", "--quiet", "-m", "initial")
+
+	git("checkout", "--quiet", "-b", "develop")
+	write("develop.go", "package app\n\nvar Develop = true\n")
+	git("add", ".")
+	git("commit", "--quiet", "-m", "develop")
+	developCommit := git("rev-parse", "HEAD")
+
+	git("checkout", "--quiet", "-b", "feature")
+	write("app.go", "package app\n\nvar Feature = true\n")
+	git("add", ".")
+	git("commit", "--quiet", "-m", "feature")
+	featureCommit := git("rev-parse", "HEAD")
+
+	outputDir := t.TempDir()
+	ctx := &ReviewContext{
+		Ticket:    "TEST-123",
+		DiffPath:  filepath.Join(outputDir, "TEST-123-diff.md"),
+		FilesPath: filepath.Join(outputDir, "TEST-123-files.md"),
+		OutputDir: outputDir,
+		RepoDir:   repoDir,
+		Branch:    "feature",
+		BaseRef:   "develop",
+		HeadRef:   featureCommit,
+	}
+	workflow := NewWorkflow(ctx)
+
+	if err := workflow.PrepareChanges(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if ctx.MergeBase != developCommit {
+		t.Errorf("Expected merge-base %s, got %s", developCommit, ctx.MergeBase)
+	}
+
+	// Only the feature change is part of the PR, not the develop-only file
+	if len(ctx.Changes.Modified) != 1 || ctx.Changes.Modified[0] != "app.go" || len(ctx.Changes.Added) != 0 {
+		t.Errorf("Unexpected changes: %+v", ctx.Changes)
+	}
+
+	original, err := workflow.GetOriginalFileContent("app.go")
+	if err != nil {
+		t.Fatalf("Unexpected error getting original content: %v", err)
+	}
+	if original != "package app\n" {
+		t.Errorf("Unexpected original content %q", original)
+	}
+
+	if _, err := os.Stat(ctx.DiffPath); err != nil {
+		t.Errorf("Expected diff artifact to be written: %v", err)
+	}
+
+	// A branch head is resolved to the commit the diff is computed from
+	ctx.HeadRef = ""
+	if err := workflow.PrepareChanges(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ctx.HeadRef != "feature" || ctx.HeadCommit != featureCommit {
+		t.Errorf("Expected head feature at %s, got %s at %s", featureCommit, ctx.HeadRef, ctx.HeadCommit)
+	}
+}
+
+func TestPrepareChangesFromGitHub(t *testing.T) {
+	contents := map[string]string{
+		"base1:app.go":     "package app\n",
+		"head1:app.go":     "package app\n\nvar Feature = true\n",
+		"head1:new/api.go": "package api\n",
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/v3/repos/acme/shop/pulls" && r.URL.Query().Get("head") == "acme:feature":
+			fmt.Fprint(w, `[{"number":7}]`)
+		case r.URL.Path == "/api/v3/repos/acme/shop/pulls/7" && r.Header.Get("Accept") == "application/vnd.github.diff":
+			fmt.Fprint(w, testFileDiff("app.go", "var Feature = true"))
+		case r.URL.Path == "/api/v3/repos/acme/shop/pulls/7":
+			fmt.Fprint(w, `{"number":7,"title":"Add feature","body":"Turns the feature on.","user":{"login":"sam"},
+				"head":{"ref":"feature","sha":"head1"},"base":{"ref":"develop","sha":"tip1"}}`)
+		case r.URL.Path == "/api/v3/repos/acme/shop/compare/tip1...head1":
+			fmt.Fprint(w, `{"merge_base_commit":{"sha":"base1"}}`)
+		case r.URL.Path == "/api/v3/repos/acme/shop/pulls/7/files":
+			fmt.Fprint(w, `[{"filename":"app.go","status":"modified","additions":2,"deletions":0,"patch":"@@"},
+				{"filename":"new/api.go","status":"added","additions":1,"patch":"@@"},
+				{"filename":"logo.png","status":"added"}]`)
+		case r.URL.Path == "/api/v3/repos/acme/shop/pulls/7/comments":
+			fmt.Fprint(w, `[{"path":"app.go","line":3,"body":"Should this be behind a flag?","user":{"login":"kim"}}]`)
+		case strings.HasPrefix(r.URL.Path, "/api/v3/repos/acme/shop/contents/"):
+			content, ok := contents[r.URL.Query().Get("ref")+":"+strings.TrimPrefix(r.URL.Path, "/api/v3/repos/acme/shop/contents/")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+			}
+			fmt.Fprint(w, content)
+		default:
+			t.Errorf("Unexpected request %s", r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	outputDir := t.TempDir()
+	ctx := &ReviewContext{
+		Ticket:     "TEST-123",
+		DiffPath:   filepath.Join(outputDir, "TEST-123-diff.md"),
+		FilesPath:  filepath.Join(outputDir, "TEST-123-files.md"),
+		OutputDir:  outputDir,
+		Branch:     "feature",
+		GitHub:     github.NewClient(server.URL+"/api/v3", "token"),
+		GitHubRepo: "acme/shop",
+	}
+	workflow := NewWorkflow(ctx)
+
+	if err := workflow.PrepareChanges(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ctx.PullRequestNumber != 7 || ctx.MergeBase != "base1" || ctx.HeadRef != "head1" || ctx.HeadCommit != "head1" || ctx.BaseRef != "develop" {
+		t.Errorf("Unexpected refs: #%d %s..%s (%s)", ctx.PullRequestNumber, ctx.MergeBase, ctx.HeadRef, ctx.BaseRef)
+	}
+	if ctx.ParsedDiff == nil || ctx.ParsedDiff.File("app.go") == nil {
+		t.Errorf("Expected the pull request's diff to be parsed")
+	}
+	if !reflect.DeepEqual(ctx.Changes.Modified, []string{"app.go"}) || !reflect.DeepEqual(ctx.Changes.Added, []string{"logo.png", "new/api.go"}) {
+		t.Errorf("Unexpected changes: %+v", ctx.Changes)
+	}
+	if !strings.Contains(ctx.FilesContent, "-\t-\tlogo.png") || !strings.Contains(ctx.FilesContent, "2\t0\tapp.go") {
+		t.Errorf("Unexpected files list:\n%s", ctx.FilesContent)
+	}
+	for _, want := range []string{"**Title:** Add feature", "Turns the feature on.", "- app.go:3 (kim): Should this be behind a flag?"} {
+		if !strings.Contains(ctx.PullRequestDetails, want) {
+			t.Errorf("Expected the pull request details to contain %q, got:\n%s", want, ctx.PullRequestDetails)
+		}
+	}
+	if !strings.Contains(workflow.InitialDiscoveryPrompt(), "## Pull Request") {
+		t.Errorf("Expected the discovery prompt to include the pull request")
+	}
+
+	// File contents come from the API at the merge-base and head commits
+	if original, err := workflow.GetOriginalFileContent("app.go"); err != nil || original != "package app\n" {
+		t.Errorf("Unexpected original content %q (%v)", original, err)
+	}
+	if added, err := workflow.GetHeadFileContent("new/api.go"); err != nil || added != "package api\n" {
+		t.Errorf("Unexpected head content %q (%v)", added, err)
+	}
+	if _, err := os.Stat(ctx.DiffPath); err != nil {
+		t.Errorf("Expected diff artifact to be written: %v", err)
+	}
+}
+
+func TestNewComponentsAnalysisInputs(t *testing.T) {
+	ctx := &ReviewContext{
+		FilesContent: "# Changed Files for feature\n\n" +
+			"## Modified Files\nsrc/keep.go\n\n" +
+			"## Added Files\nsrc/new.go\nsrc/other_new.go\n\n" +
+			"## Deleted Files\n\n",
+	}
+	workflow := NewWorkflow(ctx)
+
+	added, err := workflow.ParseAddedFiles()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if strings.Join(added, ",") != "src/new.go,src/other_new.go" {
+		t.Errorf("Unexpected added files: %v", added)
+	}
+
+	prompt := workflow.NewFileAnalysisPrompt("src/new.go", "func New() {}")
+	if !strings.Contains(prompt, "File: src/new.go") || !strings.Contains(prompt, "func New() {}") {
+		t.Error("New file prompt does not contain the file name and content")
+	}
+	if !strings.Contains(prompt, "NEW file") {
+		t.Error("New file prompt does not describe the file as new")
+	}
+
+	// Without a resolved repository the head content can't be read
+	if _, err := workflow.GetHeadFileContent("src/new.go"); err == nil {
+		t.Error("Expected error reading head content without a repository")
+	}
+}
+
+func TestLanguageAwarePrompts(t *testing.T) {
+	ctx := &ReviewContext{
+		Ticket:      "TEST-123",
+		OutputDir:   t.TempDir(),
+		DiffContent: "Test diff content",
+		FilesContent: "# Changed Files for feature\n\n" +
+			"## Modified Files\nreview/review.go\ncmd/agent/main.go\n\n" +
+			"## Added Files\nweb/app.ts\n\n" +
+			"## Deleted Files\n\n",
+	}
+	workflow := NewWorkflow(ctx)
+
+	intro := workflow.GetCommonPromptIntro("discoverer")
+	if !strings.Contains(intro, "expertise in Go and general software development") {
+		t.Errorf("Intro does not mention Go expertise: %s", intro)
+	}
+	if !strings.Contains(intro, "a Go codebase") || strings.Contains(intro, "PHP") {
+		t.Errorf("Discoverer intro does not describe a Go codebase: %s", intro)
+	}
+
+	prompt := workflow.GenerateSyntaxReviewPrompt()
+	if !strings.Contains(prompt, "FILE: path/to/file.go") || !strings.Contains(prompt, "```go\n") {
+		t.Error("Syntax review example is not written in Go")
+	}
+	if strings.Contains(prompt, "```php") {
+		t.Error("Syntax review prompt still contains a PHP example")
+	}
+
+	// Discovery asks about the language's own frameworks, not PHP ones
+	prompt = workflow.InitialDiscoveryPrompt()
+	if !strings.Contains(prompt, "(e.g., Gin, Echo, Chi, custom)") {
+		t.Error("Discovery prompt does not give Go framework examples")
+	}
+	for _, framework := range []string{"Laravel", "Symfony", "CodeIgniter"} {
+		if strings.Contains(prompt, framework) {
+			t.Errorf("Discovery prompt for a Go repository mentions %s", framework)
+		}
+	}
+
+	// Code fences follow each file's own language
+	if prompt := workflow.NewFileAnalysisPrompt("web/app.ts", "export {}"); !strings.Contains(prompt, "```typescript\nexport {}\n```") {
+		t.Error("New file prompt does not use a TypeScript fence")
+	}
+
+	// Unknown languages get neutral wording
+	ctx.FilesContent = "# Changed Files for feature\n\n## Modified Files\nREADME\n\n"
+	ctx.Language = nil
+	if intro := workflow.GetCommonPromptIntro("discoverer"); !strings.Contains(intro, "in a codebase") || !strings.Contains(intro, "expertise in general software development") {
+		t.Errorf("Expected neutral intro, got: %s", intro)
+	}
+	if prompt := workflow.InitialDiscoveryPrompt(); !strings.Contains(prompt, "(e.g., a web, application or testing framework, or a custom one)") {
+		t.Error("Expected neutral framework examples for an unknown language")
+	}
+}
+
+func TestPrepareChangesResolvesLanguage(t *testing.T) {
+	outputDir := t.TempDir()
+	ctx := &ReviewContext{
+		Ticket:    "TEST-123",
+		DiffPath:  filepath.Join(outputDir, "TEST-123-diff.md"),
+		FilesPath: filepath.Join(outputDir, "TEST-123-files.md"),
+		OutputDir: outputDir,
+	}
+	workflow := NewWorkflow(ctx)
+
+	// Without a file list nothing is known yet, so no generic profile is kept
+	if err := os.WriteFile(ctx.DiffPath, nil, 0644); err != nil {
+		t.Fatalf("Failed to write diff: %v", err)
+	}
+	if err := os.WriteFile(ctx.FilesPath, nil, 0644); err != nil {
+		t.Fatalf("Failed to write files list: %v", err)
+	}
+	if err := workflow.PrepareChanges(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ctx.Language != nil {
+		t.Errorf("Expected no language without a file list, got %s", ctx.Language.Name)
+	}
+
+	// The artifact fallback resolves the language before any step runs
+	files := "# Changed Files for feature\n\n## Modified Files\napp/main.go\n\n"
+	if err := os.WriteFile(ctx.FilesPath, []byte(files), 0644); err != nil {
+		t.Fatalf("Failed to write files list: %v", err)
+	}
+	if err := workflow.PrepareChanges(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ctx.Language == nil || ctx.Language.Name != "Go" {
+		t.Errorf("Expected Go to be resolved, got %+v", ctx.Language)
+	}
+}
+
+func TestPromptsUseSystemMessage(t *testing.T) {
+	ctx := &ReviewContext{Ticket: "TEST-123", OutputDir: t.TempDir(), DiffContent: "Test diff content"}
+	workflow := NewWorkflow(ctx)
+
+	// The role introduction goes in the system message rather than the prompt
+	intro := workflow.GetCommonPromptIntro("reviewer")
+	prompt := workflow.GenerateSyntaxReviewPrompt()
+	if strings.Contains(prompt, "skeptical and methodical") {
+		t.Error("Syntax review prompt still contains the role introduction")
+	}
+
+	messages := chatMessages(intro, prompt)
+	if len(messages) != 2 || messages[0].Role != llm.RoleSystem || messages[1].Role != llm.RoleUser {
+		t.Fatalf("Expected a system and a user message, got %+v", messages)
+	}
+	if messages[0].Content != strings.TrimSpace(intro) || messages[1].Content != prompt {
+		t.Errorf("Unexpected message content: %+v", messages)
+	}
+}
+
+func TestArchitectureProfile(t *testing.T) {
+	tempDir := t.TempDir()
+	profilesDir := filepath.Join(tempDir, ".context", "profiles")
+	if err := os.MkdirAll(profilesDir, 0755); err != nil {
+		t.Fatalf("Failed to create test directory: %v", err)
+	}
+
+	profile := `description: custom silo/service/domain/repository/applicationservice architecture
+layers:
+  - name: Repository
+    responsibility: Handles data access
+    paths: ["app/*/Repository/"]
+  - name: Domain
+    responsibility: Contains business logic
+naming_conventions:
+  - Domain classes end in Domain
+file_order_hints:
+  - Analyze DataObjects before Repositories
+`
+	if err := os.WriteFile(filepath.Join(profilesDir, "payroll-gateway.yaml"), []byte(profile), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(profilesDir, "json-repo.json"), []byte(`{"description": "layered architecture", "layers": [{"name": "Model", "responsibility": "Holds data"}]}`), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(profilesDir, "broken.json"), []byte("{"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// Save the original working directory and change to the temporary directory
+	originalWd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get current working directory: %v", err)
+	}
+	if err := os.Chdir(tempDir); err != nil {
+		t.Fatalf("Failed to change working directory: %v", err)
+	}
+	defer func() {
+		if err := os.Chdir(originalWd); err != nil {
+			t.Fatalf("Failed to restore working directory: %v", err)
+		}
+	}()
+
+	// Repositories without a profile get neutral wording
+	ctx := &ReviewContext{RepoName: "other-repo", DiffContent: "Test diff content", FilesContent: "Test files content"}
+	workflow := NewWorkflow(ctx)
+	if err := workflow.LoadArchitectureProfile(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ctx.Architecture != nil {
+		t.Error("Expected no architecture profile")
+	}
+	prompt := workflow.InitialDiscoveryPrompt()
+	if strings.Contains(prompt, "silo") || strings.Contains(prompt, "## Architecture") {
+		t.Error("Discovery prompt mentions an architecture without a profile")
+	}
+	if !strings.Contains(prompt, "Consider the layering of the codebase") {
+		t.Error("Discovery prompt is missing the neutral file order guidance")
+	}
+
+	// A malformed profile is an error
+	ctx.RepoName = "broken"
+	if err := workflow.LoadArchitectureProfile(); err == nil {
+		t.Error("Expected error for a malformed profile")
+	}
+
+	// A JSON profile is read when there is no YAML one
+	ctx.RepoName = "json-repo"
+	if err := workflow.LoadArchitectureProfile(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ctx.Architecture == nil || ctx.Architecture.Description != "layered architecture" || len(ctx.Architecture.Layers) != 1 {
+		t.Errorf("Expected the JSON profile to be loaded, got %+v", ctx.Architecture)
+	}
+
+	// The profile is injected into discovery, analysis and review prompts
+	ctx.RepoName = "payroll-gateway"
+	if err := workflow.LoadArchitectureProfile(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ctx.Architecture == nil || len(ctx.Architecture.Layers) != 2 {
+		t.Fatalf("Expected the profile to be loaded, got %+v", ctx.Architecture)
+	}
+
+	// The architecture is described in the system message and detailed in the prompt
+	if intro := workflow.GetCommonPromptIntro("discoverer"); !strings.Contains(intro, "that uses a custom silo/service/domain/repository/applicationservice architecture.") {
+		t.Errorf("Discovery intro does not describe the architecture: %s", intro)
+	}
+	prompt = workflow.InitialDiscoveryPrompt()
+	for _, expected := range []string{
+		"## Architecture",
+		"- **Repository**: Handles data access (`app/*/Repository/`)",
+		"- Domain classes end in Domain",
+		"- Analyze DataObjects before Repositories",
+	} {
+		if !strings.Contains(prompt, expected) {
+			t.Errorf("Discovery prompt does not contain %q", expected)
+		}
+	}
+
+	if prompt := workflow.FileAnalysisPrompt("app/Domain/PayCycleDomain.php", "<?php"); !strings.Contains(prompt, "## Architecture") {
+		t.Error("Analysis prompt does not contain the architecture profile")
+	}
+	if prompt := workflow.GenerateDefensiveReviewPrompt(); !strings.Contains(prompt, "### Architecture") {
+		t.Error("Review prompt does not contain the architecture profile")
+	}
+}
+
+func TestAnalysisStepsTrackTheirInputs(t *testing.T) {
+	outputDir := t.TempDir()
+	ctx := &ReviewContext{
+		Ticket:    "TEST-123",
+		OutputDir: outputDir,
+		DiffPath:  filepath.Join(outputDir, "TEST-123-diff.md"),
+		FilesPath: filepath.Join(outputDir, "TEST-123-files.md"),
+	}
+	workflow := NewWorkflow(ctx)
+
+	steps := make(map[string]Step)
+	for _, step := range workflow.builtinSteps() {
+		steps[step.Name()] = step
+	}
+
+	// Re-running an upstream step must invalidate the analyses built on its output
+	for name, input := range map[string]string{
+		"original-analysis": filepath.Join(outputDir, "TEST-123-initial-discovery.md"),
+		"new-components":    ctx.FilesPath,
+	} {
+		before := workflow.inputHash(steps[name])
+		if err := os.WriteFile(input, []byte("regenerated"), 0644); err != nil {
+			t.Fatalf("Failed to write artifact: %v", err)
+		}
+		if workflow.inputHash(steps[name]) == before {
+			t.Errorf("Expected %s to depend on %s", name, filepath.Base(input))
+		}
+	}
+	if !contains(steps["original-analysis"].Inputs(), filepath.Join(outputDir, "TEST-123-original-file-content.md")) {
+		t.Errorf("Expected the original contents as an input, got %v", steps["original-analysis"].Inputs())
+	}
+}
+
+func TestRunStepsCheckpoints(t *testing.T) {
+	outputDir := t.TempDir()
+	ctx := &ReviewContext{Ticket: "TEST-123", OutputDir: outputDir, DiffContent: "diff"}
+	workflow := NewWorkflow(ctx)
+
+	runs := make(map[string]int)
+	contents := map[string]string{"first": "one", "second": "two", "third": "three"}
+	writeStep := func(name string, inputs []string, output string) Step {
+		return &funcStep{
+			name:    name,
+			title:   name,
+			inputs:  inputs,
+			outputs: []string{output},
+			run: func() error {
+				runs[name]++
+				return os.WriteFile(output, []byte(contents[name]), 0644)
+			},
+		}
+	}
+
+	first := filepath.Join(outputDir, "first.md")
+	second := filepath.Join(outputDir, "second.md")
+	third := filepath.Join(outputDir, "third.md")
+	steps := []Step{
+		writeStep("first", nil, first),
+		writeStep("second", []string{first}, second),
+		writeStep("third", []string{second}, third),
+	}
+
+	run := func(t *testing.T, expected map[string]int) {
+		t.Helper()
+		for name := range runs {
+			delete(runs, name)
+		}
+		if err := workflow.runSteps(steps); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		for _, name := range []string{"first", "second", "third"} {
+			if runs[name] != expected[name] {
+				t.Errorf("Expected %s to run %d times, ran %d", name, expected[name], runs[name])
+			}
+		}
+	}
+
+	// A normal run executes every step and writes the manifest
+	run(t, map[string]int{"first": 1, "second": 1, "third": 1})
+	if _, err := os.Stat(ManifestPath(outputDir, "TEST-123")); err != nil {
+		t.Fatalf("Expected manifest to be written: %v", err)
+	}
+
+	// Resuming with unchanged inputs skips everything
+	ctx.Resume = true
+	run(t, map[string]int{})
+
+	// A modified output reruns its step; the regenerated content matches, so later steps are still reused
+	if err := os.WriteFile(second, []byte("edited"), 0644); err != nil {
+		t.Fatalf("Failed to modify artifact: %v", err)
+	}
+	run(t, map[string]int{"second": 1})
+
+	// --only-step reruns just the one step
+	ctx.Resume = false
+	ctx.OnlyStep = "second"
+	contents["second"] = "two, revised"
+	run(t, map[string]int{"second": 1})
+
+	// Resuming afterwards reruns the steps whose inputs changed
+	ctx.OnlyStep = ""
+	ctx.Resume = true
+	run(t, map[string]int{"third": 1})
+
+	// A change to the shared PR context reruns everything
+	ctx.DiffContent = "new diff"
+	run(t, map[string]int{"first": 1, "second": 1, "third": 1})
+
+	// --from-step reruns the step and the ones after it
+	ctx.Resume = false
+	ctx.FromStep = "second"
+	run(t, map[string]int{"second": 1, "third": 1})
+
+	// Unknown steps are rejected
+	ctx.FromStep = ""
+	ctx.OnlyStep = "missing"
+	if err := workflow.runSteps(steps); err == nil || !strings.Contains(err.Error(), "unknown step") {
+		t.Errorf("Expected unknown step error, got %v", err)
+	}
+
+	// Steps without saved output can't be reused
+	if err := os.Remove(first); err != nil {
+		t.Fatalf("Failed to remove artifact: %v", err)
+	}
+	ctx.OnlyStep = "second"
+	if err := workflow.runSteps(steps); err == nil || !strings.Contains(err.Error(), "no saved output") {
+		t.Errorf("Expected missing output error, got %v", err)
+	}
+}
+
+func TestReviewPhasesRunConcurrently(t *testing.T) {
+	outputDir := t.TempDir()
+	ctx := &ReviewContext{Ticket: "TEST-123", OutputDir: outputDir, TokenCounter: tokens.NewCounter()}
+	workflow := NewWorkflow(ctx)
+
+	// Each phase waits for the others to start, so the run only finishes if they run concurrently
+	var started sync.WaitGroup
+	started.Add(3)
+	phase := func(name string, delay time.Duration) Step {
+		path := filepath.Join(outputDir, name+".md")
+		return &funcStep{
+			name:        name,
+			title:       name,
+			outputs:     []string{path},
+			reviewPhase: true,
+			run: func() error {
+				started.Done()
+				started.Wait()
+				time.Sleep(delay)
+				return os.WriteFile(path, []byte("## "+name), 0644)
+			},
+		}
+	}
+	steps := []Step{
+		phase("syntax-review", 30*time.Millisecond),
+		phase("functionality-review", 20*time.Millisecond),
+		phase("defensive-review", 0),
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- workflow.runSteps(steps) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Review phases did not run concurrently")
+	}
+
+	// The merge follows pipeline order, not completion order
+	content, err := os.ReadFile(filepath.Join(outputDir, "TEST-123-review-result.md"))
+	if err != nil {
+		t.Fatalf("Expected the review file to be written: %v", err)
+	}
+	syntax := strings.Index(string(content), "## syntax-review")
+	functionality := strings.Index(string(content), "## functionality-review")
+	defensive := strings.Index(string(content), "## defensive-review")
+	if syntax < 0 || syntax > functionality || functionality > defensive {
+		t.Errorf("Expected the phases merged in pipeline order, got:\n%s", content)
+	}
+
+	// The findings of the phases are merged next to the review file
+	var findings []Findings
+	content, err = os.ReadFile(filepath.Join(outputDir, "TEST-123-review-result.json"))
+	if err != nil || json.Unmarshal(content, &findings) != nil {
+		t.Fatalf("Expected the merged findings to be written: %v", err)
+	}
+	if len(findings) != 3 || findings[0].Phase != "syntax-review" {
+		t.Errorf("Expected the findings of 3 phases, got %+v", findings)
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "TEST-123-review.sarif")); err != nil {
+		t.Errorf("Expected the SARIF log to be written: %v", err)
+	}
+
+	// Each phase has its own artifact, so --only-step reruns just that phase
+	ctx.OnlyStep = "functionality-review"
+	forced, err := workflow.forcedSteps(workflow.builtinSteps())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(forced) != 1 || !forced["functionality-review"] {
+		t.Errorf("Expected only functionality-review to be forced, got %v", forced)
+	}
+}
+
+func TestReviewPhaseFailures(t *testing.T) {
+	outputDir := t.TempDir()
+	ctx := &ReviewContext{Ticket: "TEST-123", OutputDir: outputDir, TokenCounter: tokens.NewCounter()}
+	workflow := NewWorkflow(ctx)
+
+	okPath := filepath.Join(outputDir, "ok.md")
+	steps := []Step{
+		&funcStep{name: "ok", title: "ok", outputs: []string{okPath}, reviewPhase: true, run: func() error {
+			return os.WriteFile(okPath, []byte("## ok"), 0644)
+		}},
+		&funcStep{name: "broken", title: "broken", outputs: []string{filepath.Join(outputDir, "broken.md")}, reviewPhase: true,
+			run: func() error { return fmt.Errorf("phase failed") }},
+	}
+
+	err := workflow.runSteps(steps)
+	if err == nil || !strings.Contains(err.Error(), "phase failed") {
+		t.Fatalf("Expected the phase error, got %v", err)
+	}
+
+	// The phase that succeeded is checkpointed so a resumed run can reuse it
+	manifest, err := LoadManifest(ManifestPath(outputDir, "TEST-123"), "TEST-123")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !manifest.HasOutputs("ok") || manifest.Steps["broken"] != nil {
+		t.Errorf("Expected only the successful phase to be recorded, got %v", manifest.Steps)
+	}
+}
+
+func TestAnalyzeFilesWorkerPool(t *testing.T) {
+	ctx := &ReviewContext{Ticket: "TEST-123", Concurrency: 2, TokenCounter: tokens.NewCounter()}
+	workflow := NewWorkflow(ctx)
+
+	files := []string{"a.go", "missing.go", "b.go", "broken.go", "c.go"}
+	getContent := func(file string) (string, error) {
+		if file == "missing.go" {
+			return "", fmt.Errorf("not found")
+		}
+		return "package " + file, nil
+	}
+
+	// Track how many analyses run at once
+	var mu sync.Mutex
+	active, peak := 0, 0
+	analyze := func(file, content string) (string, error) {
+		mu.Lock()
+		active++
+		if active > peak {
+			peak = active
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		active--
+		mu.Unlock()
+		if file == "broken.go" {
+			return "", fmt.Errorf("rate limited")
+		}
+		return "analysis of " + file, nil
+	}
+
+	results := workflow.analyzeFiles("original-analysis", files, getContent, analyze)
+	if peak > 2 {
+		t.Errorf("Expected at most 2 concurrent analyses, got %d", peak)
+	}
+
+	// Results keep the original order
+	for i, result := range results {
+		if result.file != files[i] {
+			t.Errorf("Expected result %d to be %s, got %s", i, files[i], result.file)
+		}
+	}
+	if results[2].analysis != "analysis of b.go" {
+		t.Errorf("Unexpected analysis %q", results[2].analysis)
+	}
+	if !results[1].skipped || results[3].skipped || results[3].err == nil {
+		t.Errorf("Expected missing.go skipped and broken.go failed, got %+v", results)
+	}
+
+	// Files that weren't analyzed are listed instead of dropped
+	section := unanalyzedFilesSection(results)
+	for _, part := range []string{"## Files Not Analyzed", "`missing.go`: skipped", "`broken.go`: failed (rate limited)"} {
+		if !strings.Contains(section, part) {
+			t.Errorf("Expected section to contain %q, got:\n%s", part, section)
+		}
+	}
+	if strings.Contains(section, "a.go") {
+		t.Errorf("Expected analyzed files to be left out of the section")
+	}
+	if unanalyzedFilesSection(results[:1]) != "" {
+		t.Errorf("Expected no section when every file was analyzed")
+	}
+}
+
+func TestPipelineConfig(t *testing.T) {
+	dir := t.TempDir()
+	prompt := "Review {{.Ticket}} for performance.\n\n{{input \"synthesis\"}}\n\n{{.Diff}}"
+	if err := os.MkdirAll(filepath.Join(dir, "prompts"), 0755); err != nil {
+		t.Fatalf("Failed to create test directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "prompts", "performance.tmpl"), []byte(prompt), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	configPath := filepath.Join(dir, "pipeline.json")
+	writeConfig := func(content string) {
+		if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+
+	// Drop the defensive phase and add a performance phase
+	writeConfig(`{"steps": [
+		{"name": "discovery"},
+		{"name": "original-contents"},
+		{"name": "original-analysis"},
+		{"name": "new-components"},
+		{"name": "synthesis", "title": "Summarizing the original code"},
+		{"name": "syntax-review"},
+		{"name": "functionality-review"},
+		{"name": "performance-review", "title": "Generating performance review", "prompt": "prompts/performance.tmpl",
+		 "model": "gpt-4o-mini", "inputs": ["synthesis"], "review_phase": true},
+		{"name": "validation"},
+		{"name": "final-summary"}
+	]}`)
+
+	pipeline, err := LoadPipelineConfig(configPath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	outputDir := t.TempDir()
+	ctx := &ReviewContext{Ticket: "TEST-123", OutputDir: outputDir, DiffContent: "Test diff content", Pipeline: pipeline}
+	workflow := NewWorkflow(ctx)
+
+	steps, err := workflow.pipeline()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	names := strings.Join(stepNames(steps), ",")
+	expected := "discovery,original-contents,original-analysis,new-components,synthesis,syntax-review,functionality-review,performance-review,validation,final-summary"
+	if names != expected {
+		t.Errorf("Unexpected steps %s", names)
+	}
+	if steps[4].Title() != "Summarizing the original code" {
+		t.Errorf("Expected title override, got %q", steps[4].Title())
+	}
+
+	performance := steps[7].(*promptStep)
+	synthesisPath := filepath.Join(outputDir, "TEST-123-original-synthesis.md")
+	if !contains(performance.Inputs(), synthesisPath) {
+		t.Errorf("Expected the synthesis artifact as an input, got %v", performance.Inputs())
+	}
+	if performance.Outputs()[0] != filepath.Join(outputDir, "TEST-123-performance-review.md") {
+		t.Errorf("Unexpected output %v", performance.Outputs())
+	}
+
+	// The review phase runs alongside the built-in phases and is merged with them
+	if !performance.ReviewPhase() || !steps[6].ReviewPhase() {
+		t.Errorf("Expected performance-review to join the review phases")
+	}
+
+	if err := os.WriteFile(synthesisPath, []byte("Synthesis content"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	rendered, err := performance.Render()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, part := range []string{"Review TEST-123 for performance.", "Synthesis content", "Test diff content"} {
+		if !strings.Contains(rendered, part) {
+			t.Errorf("Rendered prompt does not contain %q", part)
+		}
+	}
+
+	// Invalid pipelines are rejected
+	invalid := []struct {
+		name    string
+		config  string
+		message string
+	}{
+		{"Unknown step without prompt", `{"steps": [{"name": "mystery"}]}`, "not a built-in step"},
+		{"Input from a later step", `{"steps": [{"name": "perf", "prompt": "prompts/performance.tmpl", "inputs": ["synthesis"]}, {"name": "synthesis"}]}`, "not an earlier step"},
+		{"Duplicate step", `{"steps": [{"name": "discovery"}, {"name": "discovery"}]}`, "more than once"},
+		{"Output on a built-in step", `{"steps": [{"name": "discovery", "output": "other.md"}]}`, "only supports"},
+	}
+	for _, tc := range invalid {
+		t.Run(tc.name, func(t *testing.T) {
+			writeConfig(tc.config)
+			pipeline, err := LoadPipelineConfig(configPath)
+			if err != nil {
+				t.Fatalf("Unexpected load error: %v", err)
+			}
+			ctx.Pipeline = pipeline
+			if _, err := workflow.pipeline(); err == nil || !strings.Contains(err.Error(), tc.message) {
+				t.Errorf("Expected error containing %q, got %v", tc.message, err)
+			}
+		})
+	}
+
+	writeConfig(`{"steps": [{"name": "perf", "prompt": "prompts/missing.tmpl"}]}`)
+	if _, err := LoadPipelineConfig(configPath); err == nil {
+		t.Error("Expected error for a missing prompt file")
+	}
+
+	// Files not ending in .json are read as YAML
+	yamlPath := filepath.Join(dir, "pipeline.yaml")
+	yamlConfig := "steps:\n" +
+		"  - name: discovery\n" +
+		"  - name: performance-review\n" +
+		"    prompt: prompts/performance.tmpl\n" +
+		"    model: gpt-4o-mini\n" +
+		"    inputs: [discovery]\n" +
+		"    review_phase: true\n"
+	if err := os.WriteFile(yamlPath, []byte(yamlConfig), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	pipeline, err = LoadPipelineConfig(yamlPath)
+	if err != nil {
+		t.Fatalf("Unexpected error loading YAML: %v", err)
+	}
+	if len(pipeline.Steps) != 2 {
+		t.Fatalf("Expected 2 steps, got %+v", pipeline.Steps)
+	}
+	step := pipeline.Steps[1]
+	if step.Name != "performance-review" || step.Model != "gpt-4o-mini" || !step.ReviewPhase ||
+		!reflect.DeepEqual(step.Inputs, []string{"discovery"}) || step.promptTemplate == nil {
+		t.Errorf("Unexpected YAML step %+v", step)
+	}
+}
+
+func TestPromptStepRenderErrors(t *testing.T) {
+	dir := t.TempDir()
+	// The template only fails without a diff, as when the prompt is sized for a part of a large diff
+	prompt := `{{if .Diff}}Review {{.Diff}}{{else}}{{input "missing"}}{{end}}`
+	if err := os.WriteFile(filepath.Join(dir, "check.tmpl"), []byte(prompt), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	configPath := filepath.Join(dir, "pipeline.yaml")
+	config := "steps:\n  - name: check\n    prompt: check.tmpl\n  - name: check-phase\n    prompt: check.tmpl\n    review_phase: true\n"
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	pipeline, err := LoadPipelineConfig(configPath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	diffContent := testFileDiff("a.go", strings.Repeat("x", 200)) + testFileDiff("b.go", strings.Repeat("y", 200))
+	parsed, err := diff.Parse(diffContent)
+	if err != nil {
+		t.Fatalf("Failed to parse diff: %v", err)
+	}
+	provider := &stubProvider{model: "gpt-4o", contextWindow: 128000, reply: "reply"}
+	ctx := &ReviewContext{
+		Ticket:      "TEST-123",
+		OutputDir:   t.TempDir(),
+		Client:      provider,
+		MaxTokens:   100,
+		DiffContent: diffContent,
+		ParsedDiff:  parsed,
+		Pipeline:    pipeline,
+	}
+	workflow := NewWorkflow(ctx)
+	steps, err := workflow.pipeline()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for _, step := range steps {
+		if err := step.Run(); err == nil || !strings.Contains(err.Error(), "missing") {
+			t.Errorf("Expected %s to fail on the template error, got %v", step.Name(), err)
+		}
+	}
+	if provider.prompts != 0 {
+		t.Errorf("Expected no prompt to be sent, got %d", provider.prompts)
+	}
+}
+
+func TestUsageReport(t *testing.T) {
+	outputDir := t.TempDir()
+	ctx := &ReviewContext{Ticket: "TEST-123", OutputDir: outputDir, Usage: NewUsageTracker(), Pricing: pricing.NewTable()}
+	workflow := NewWorkflow(ctx)
+
+	usage := func(prompt, cached, completion int) llm.Usage {
+		return llm.Usage{PromptTokens: prompt, CachedTokens: cached, CompletionTokens: completion}
+	}
+
+	// Concurrent requests from one step accumulate into a single entry
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx.Usage.Record("original-analysis", "gpt-4o", usage(100_000, 0, 10_000))
+		}()
+	}
+	wg.Wait()
+	ctx.Usage.Record("final-summary", "gpt-4o-2024-08-06", usage(200_000, 100_000, 0))
+	ctx.Usage.Record("performance-review", "local-model", usage(1_000, 0, 100))
+
+	report, err := workflow.WriteUsageReport()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(report.Steps) != 3 || report.Steps[0].Step != "original-analysis" || report.Steps[0].Requests != 10 {
+		t.Fatalf("Unexpected steps %+v", report.Steps)
+	}
+	// 1M prompt tokens at $2.50 and 100K completion tokens at $10
+	if cost := report.Steps[0].Cost; cost == nil || fmt.Sprintf("%.4f", *cost) != "3.5000" {
+		t.Errorf("Unexpected analysis cost %v", cost)
+	}
+	// The dated snapshot uses the gpt-4o rates, with half the prompt cached
+	if cost := report.Steps[1].Cost; cost == nil || fmt.Sprintf("%.4f", *cost) != "0.3750" {
+		t.Errorf("Unexpected summary cost %v", cost)
+	}
+	if report.Steps[2].Cost != nil {
+		t.Errorf("Expected no cost for a model without rates, got %v", *report.Steps[2].Cost)
+	}
+
+	if report.Total.PromptTokens != 1_201_000 || report.Total.CachedTokens != 100_000 || report.Total.Requests != 12 {
+		t.Errorf("Unexpected total %+v", report.Total)
+	}
+	expected := "12 requests, 1201000 prompt tokens (100000 cached), 100100 completion tokens, estimated cost $3.8750 (no rates for local-model)"
+	if report.Summary() != expected {
+		t.Errorf("Unexpected summary %q", report.Summary())
+	}
+
+	content, err := os.ReadFile(filepath.Join(outputDir, "TEST-123-usage.json"))
+	if err != nil {
+		t.Fatalf("Expected usage artifact: %v", err)
+	}
+	if !strings.Contains(string(content), `"step": "final-summary"`) || !strings.Contains(string(content), `"unpriced_models"`) {
+		t.Errorf("Unexpected usage artifact %s", content)
+	}
+}
+
+// stubProvider is an llm.Provider that answers every prompt with a fixed reply
+type stubProvider struct {
+	model         string
+	contextWindow int
+	reply         string
+	prompts       int
+}
+
+func (p *stubProvider) Chat(ctx context.Context, messages []llm.Message, opts llm.ChatOptions) (string, error) {
+	p.prompts++
+	if opts.OnUsage != nil {
+		opts.OnUsage(llm.Usage{PromptTokens: 10, CompletionTokens: 1})
+	}
+	return p.reply, nil
+}
+
+func (p *stubProvider) CountText(text string) (int, error) { return len(text), nil }
+
+func (p *stubProvider) ContextWindow() int { return p.contextWindow }
+
+func (p *stubProvider) MaxOutputTokens() int { return 0 }
+
+func (p *stubProvider) SupportsResponseSchema() bool { return false }
+
+func (p *stubProvider) Model() string { return p.model }
+
+func (p *stubProvider) WithModel(model string) llm.Provider {
+	clone := *p
+	clone.model = model
+	return &clone
+}
+
+func TestStepModels(t *testing.T) {
+	main := &stubProvider{model: "gpt-4o", contextWindow: 128000, reply: "main"}
+	validator := &stubProvider{model: "claude-opus-4-1", contextWindow: 20000, reply: "validator"}
+	ctx := NewReviewContext("TEST-123", main)
+	ctx.OutputDir = t.TempDir()
+	workflow := NewWorkflow(ctx)
+
+	hashBefore := workflow.inputHash(&funcStep{name: "validation"})
+	if err := ctx.SetGroupClient("validation", validator); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := ctx.SetGroupClient("drafting", validator); err == nil {
+		t.Error("Expected an error for an unknown group")
+	}
+
+	// Only the validation step uses the other model, for its prompts and its limits
+	if response, _ := workflow.ask("validation", "system", "prompt"); response != "validator" {
+		t.Errorf("Expected validation to use its own model, got %q", response)
+	}
+	if response, _ := workflow.ask("syntax-review", "system", "prompt"); response != "main" {
+		t.Errorf("Expected syntax-review to use the default model, got %q", response)
+	}
+	if count, _ := workflow.countTokens("validation", "12345"); count != 5 {
+		t.Errorf("Expected the validation model's token counter, got %d", count)
+	}
+	if limit := workflow.maxTokens("validation"); limit != 20000-llm.ResponseReserve {
+		t.Errorf("Expected the validation model's limit, got %d", limit)
+	}
+	if limit := workflow.maxTokens("final-summary"); limit != 128000-llm.ResponseReserve {
+		t.Errorf("Expected the default limit, got %d", limit)
+	}
+
+	// Usage is recorded under each step's own model
+	for _, entry := range ctx.Usage.Report(ctx.Ticket, ctx.Pricing).Steps {
+		if expected := map[string]string{"validation": "claude-opus-4-1", "syntax-review": "gpt-4o"}[entry.Step]; entry.Model != expected {
+			t.Errorf("Expected %s usage under %s, got %s", entry.Step, expected, entry.Model)
+		}
+	}
+
+	// Changing a step's model reruns it on resume
+	if workflow.inputHash(&funcStep{name: "validation"}) == hashBefore {
+		t.Error("Expected the validation input hash to change with its model")
+	}
+
+	// A configured step's model override goes to the step's own provider and sizes its prompts
+	ctx.Pipeline = &PipelineConfig{Steps: []StepConfig{{Name: "performance-review", Model: "gpt-4o-mini"}}}
+	if client := workflow.client("performance-review"); client.Model() != "gpt-4o-mini" || client.(*stubProvider).reply != "main" {
+		t.Errorf("Expected the override on the default provider, got %s", client.Model())
+	}
+	ctx.StepClients["performance-review"] = validator
+	if client := workflow.client("performance-review"); client.Model() != "gpt-4o-mini" || client.(*stubProvider).reply != "validator" {
+		t.Errorf("Expected the override on the step's provider, got %s", client.Model())
+	}
+	if limit := workflow.maxTokens("performance-review"); limit != 20000-llm.ResponseReserve {
+		t.Errorf("Expected the step provider's limit, got %d", limit)
+	}
+
+	// A built-in step can be given its own model in the pipeline, which reruns it on resume
+	ctx.Pipeline = &PipelineConfig{Steps: []StepConfig{{Name: "defensive-review"}}}
+	hashBefore = workflow.inputHash(&funcStep{name: "defensive-review"})
+	ctx.Pipeline.Steps[0].Model = "gpt-4o-mini"
+	steps, err := workflow.pipeline()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(steps) != 1 || steps[0].Name() != "defensive-review" {
+		t.Fatalf("Expected the built-in defensive review, got %v", stepNames(steps))
+	}
+	if client := workflow.client("defensive-review"); client.Model() != "gpt-4o-mini" || client.(*stubProvider).reply != "main" {
+		t.Errorf("Expected the built-in step's override on the default provider, got %s", client.Model())
+	}
+	if workflow.inputHash(steps[0]) == hashBefore {
+		t.Error("Expected the defensive-review input hash to change with its model")
+	}
+
+	// File analyses are paced with the tokenizer of their own step
+	ctx.StepClients["new-components"] = validator
+	if tokens := workflow.estimateTokens("new-components", "12345"); tokens != 5+analysisTokenAllowance {
+		t.Errorf("Expected the new-components model's token count, got %d", tokens)
+	}
+}
+
+// testFileDiff builds the diff of a file with one hunk per body, each changing a line
+func testFileDiff(path string, bodies ...string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "diff --git a/%s b/%s\n--- a/%s\n+++ b/%s\n", path, path, path, path)
+	for i, body := range bodies {
+		fmt.Fprintf(&sb, "@@ -%d,1 +%d,1 @@\n-old\n+%s\n", i*10+1, i*10+1, body)
+	}
+	return sb.String()
+}
+
+func TestChunkDiff(t *testing.T) {
+	long := strings.Repeat("x", 400)
+	diffContent := testFileDiff("a.go", "small") + testFileDiff("b.go", "small") + testFileDiff("big.go", long, long, long)
+	parsed, err := diff.Parse(diffContent)
+	if err != nil {
+		t.Fatalf("Failed to parse diff: %v", err)
+	}
+
+	// Without a token counter, tokens are estimated at four characters each
+	ctx := &ReviewContext{Ticket: "TEST-123", OutputDir: t.TempDir(), DiffContent: diffContent, ParsedDiff: parsed}
+	workflow := NewWorkflow(ctx)
+
+	chunks := workflow.ChunkDiff("syntax-review", 200)
+	var files [][]string
+	for _, chunk := range chunks {
+		files = append(files, chunk.Files)
+		if chunk.Tokens > 200 {
+			t.Errorf("Chunk %v has %d tokens, over the budget", chunk.Files, chunk.Tokens)
+		}
+		if !strings.HasPrefix(chunk.Diff, "diff --git") {
+			t.Errorf("Expected chunk %v to start with a file header, got %q", chunk.Files, chunk.Diff[:20])
+		}
+	}
+
+	// The big file is split between its hunks, each keeping its header, and packed after the small files
+	expected := [][]string{{"a.go", "b.go", "big.go"}, {"big.go"}, {"big.go"}}
+	if !reflect.DeepEqual(files, expected) {
+		t.Fatalf("Expected chunks %v, got %v", expected, files)
+	}
+	if !strings.HasPrefix(chunks[1].Diff, "diff --git a/big.go b/big.go\n--- a/big.go\n+++ b/big.go\n@@ -11,1 +11,1 @@") {
+		t.Errorf("Expected the second hunk under the file header, got %q", chunks[1].Diff)
+	}
+}
+
+func TestReviewPhaseInParts(t *testing.T) {
+	long := strings.Repeat("x", 2000)
+	diffContent := testFileDiff("a.go", long) + testFileDiff("b.go", long)
+	parsed, err := diff.Parse(diffContent)
+	if err != nil {
+		t.Fatalf("Failed to parse diff: %v", err)
+	}
+
+	client := &stubProvider{model: "gpt-4o", contextWindow: 128000, reply: "<SYNTAX_REVIEW/>"}
+	ctx := NewReviewContext("TEST-123", client)
+	ctx.OutputDir = t.TempDir()
+	ctx.TokenCounter = nil
+	ctx.DiffContent = diffContent
+	ctx.ParsedDiff = parsed
+	workflow := NewWorkflow(ctx)
+
+	// A diff that fits is reviewed in one request
+	if err := workflow.GenerateSyntaxReview(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if client.prompts != 1 {
+		t.Errorf("Expected one request, got %d", client.prompts)
+	}
+
+	// Room for the prompt and one file of the diff splits the review in two and merges the findings
+	ctx.MaxTokens = workflow.approxTokens("syntax-review", workflow.syntaxReviewPrompt("")) + 700
+	client.prompts = 0
+	if err := workflow.GenerateSyntaxReview(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if client.prompts != 2 {
+		t.Errorf("Expected a request per part, got %d", client.prompts)
+	}
+	content, err := os.ReadFile(workflow.reviewPhasePath("syntax"))
+	if err != nil {
+		t.Fatalf("Expected the syntax review: %v", err)
+	}
+	if !strings.Contains(string(content), "## Part 1 of 2\n\nFiles: a.go") || !strings.Contains(string(content), "## Part 2 of 2\n\nFiles: b.go") {
+		t.Errorf("Unexpected merged review %q", content)
+	}
+
+	// Validation keeps the files the findings point at
+	prompt := func(diffContent string) string { return "Validate:\n" + diffContent }
+	ctx.MaxTokens = workflow.approxTokens("validation", prompt("")) + 700
+	fitted := workflow.fitDiff("validation", prompt, []string{"b.go"})
+	if !strings.Contains(fitted, "+++ b/b.go") || strings.Contains(fitted, "+++ b/a.go") || !strings.Contains(fitted, "left out: a.go") {
+		t.Errorf("Expected the diff of b.go only, got %q", fitted)
+	}
+}
+
+func TestParseFindings(t *testing.T) {
+	content := `<SYNTAX_REVIEW>
+  <REVIEW_SUMMARY>
+  Two problems found.
+  </REVIEW_SUMMARY>
+
+  <CRITICAL_ISSUES>
+  <ISSUE>
+  FILE: ` + "`app/payments.go`" + `
+  LINE: ~42
+  SEVERITY: [Critical]
+  PROBLEM: The order can be nil.
+  It is dereferenced without a check.
+  SOLUTION_CODE:
+  ` + "```go" + `
+  // Fixed
+  line: 1
+  ` + "```" + `
+  </ISSUE>
+  </CRITICAL_ISSUES>
+
+  <LOGIC_ISSUES>
+  <issue>
+  - **FILE:** app/totals.go:10-12
+  - **SEVERITY:** minor
+  - **PROBLEM:** The total is rounded twice.
+  </LOGIC_ISSUES>
+
+  <IMPROVEMENT_SUGGESTIONS>
+  <NO_ISSUES_FOUND/>
+  </IMPROVEMENT_SUGGESTIONS>
+</SYNTAX_REVIEW>`
+
+	findings := ParseFindings("syntax-review", content)
+	expected := []Finding{
+		{
+			Phase: "syntax-review", Category: "CRITICAL_ISSUES", File: "app/payments.go", Line: 42,
+			Severity: SeverityCritical, Problem: "The order can be nil.\n  It is dereferenced without a check.",
+			Solution: "```go\n  // Fixed\n  line: 1\n  ```",
+		},
+		{
+			Phase: "syntax-review", Category: "LOGIC_ISSUES", File: "app/totals.go", Line: 10, EndLine: 12,
+			Severity: SeverityMinor, Problem: "The total is rounded twice.",
+		},
+	}
+	if !reflect.DeepEqual(findings.Issues, expected) {
+		t.Errorf("Unexpected findings:\n%+v\nexpected:\n%+v", findings.Issues, expected)
+	}
+	if !reflect.DeepEqual(findings.NoIssues, []string{"IMPROVEMENT_SUGGESTIONS"}) {
+		t.Errorf("Expected IMPROVEMENT_SUGGESTIONS to have no issues, got %v", findings.NoIssues)
+	}
+	if location := findings.Issues[1].Location(); location != "app/totals.go:10-12" {
+		t.Errorf("Unexpected location %q", location)
+	}
+
+	// Output that drops the ISSUE tags is split at its FILE labels
+	untagged := ParseFindings("defensive-review", "FILE: a.go\nLINE: 3\nPROBLEM: One\n\nFILE: b.go\nLINE: 7\nPROBLEM: Two\n")
+	if len(untagged.Issues) != 2 || untagged.Issues[1].File != "b.go" || untagged.Issues[1].Line != 7 {
+		t.Errorf("Unexpected untagged findings: %+v", untagged.Issues)
+	}
+
+	// Output with nothing to report has no issues
+	clean := ParseFindings("functionality-review", "<FUNCTIONALITY_REVIEW>\n<NO_ISSUES_FOUND/>\n</FUNCTIONALITY_REVIEW>")
+	if len(clean.Issues) != 0 || !reflect.DeepEqual(clean.NoIssues, []string{"FUNCTIONALITY_REVIEW"}) {
+		t.Errorf("Unexpected findings for a clean review: %+v", clean)
+	}
+}
+
+// schemaProvider is a stubProvider with structured outputs that answers with each reply in turn
+type schemaProvider struct {
+	stubProvider
+	replies  []string
+	requests [][]llm.Message
+	schemas  []*llm.ResponseSchema
+}
+
+func (p *schemaProvider) Chat(ctx context.Context, messages []llm.Message, opts llm.ChatOptions) (string, error) {
+	p.requests = append(p.requests, messages)
+	p.schemas = append(p.schemas, opts.ResponseSchema)
+	reply := p.replies[0]
+	if len(p.replies) > 1 {
+		p.replies = p.replies[1:]
+	}
+	return reply, nil
+}
+
+func (p *schemaProvider) SupportsResponseSchema() bool { return true }
+
+func TestStructuredReviewPhase(t *testing.T) {
+	valid := `{
+		"summary": "One problem found.",
+		"categories": [
+			{"name": "LOGIC_ISSUES", "issues": [{"file": "app/totals.go", "line": 10, "end_line": 12,
+				"severity": "Major", "problem": "The total is rounded twice.", "solution_code": "total = round(sum)"}]},
+			{"name": "CRITICAL_ISSUES", "issues": []}
+		],
+		"limitations": "None."
+	}`
+	client := &schemaProvider{
+		stubProvider: stubProvider{model: "gpt-4o", contextWindow: 128000},
+		replies:      []string{`{"summary": "cut off`, `{"summary": "", "categories": [{"name": "STYLE_ISSUES", "issues": []}], "limitations": ""}`, valid},
+	}
+	ctx := NewReviewContext("TEST-123", client)
+	ctx.OutputDir = t.TempDir()
+	ctx.TokenCounter = nil
+	ctx.DiffContent = testFileDiff("app/totals.go", "total := round(round(sum))")
+	workflow := NewWorkflow(ctx)
+
+	prompt := workflow.syntaxReviewPrompt(ctx.DiffContent)
+	if !strings.Contains(prompt, "matching the response schema") || strings.Contains(prompt, "<NO_ISSUES_FOUND/>") {
+		t.Errorf("Expected the prompt to ask for JSON instead of tags")
+	}
+
+	if err := workflow.GenerateSyntaxReview(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Responses that don't match the schema are asked for again with the problem
+	if len(client.requests) != 3 {
+		t.Fatalf("Expected two re-asks, got %d requests", len(client.requests))
+	}
+	retry := client.requests[2]
+	if len(retry) != 6 || retry[4].Role != llm.RoleAssistant || !strings.Contains(retry[4].Content, "STYLE_ISSUES") ||
+		!strings.Contains(retry[5].Content, `unknown category "STYLE_ISSUES"`) {
+		t.Errorf("Expected the re-ask to include the previous reply and its problem, got %+v", retry[3:])
+	}
+	if client.schemas[0] == nil || client.schemas[0].Name != "syntax_review" ||
+		!strings.Contains(string(client.schemas[0].Schema), `"IMPROVEMENT_SUGGESTIONS"`) {
+		t.Errorf("Unexpected schema %+v", client.schemas[0])
+	}
+
+	// The review is saved in the tag format, and the findings come from the JSON
+	content, err := os.ReadFile(workflow.reviewPhasePath("syntax"))
+	if err != nil {
+		t.Fatalf("Expected the syntax review: %v", err)
+	}
+	if !strings.Contains(string(content), "<LOGIC_ISSUES>\n<ISSUE>\nFILE: app/totals.go\nLINE: 10-12\nSEVERITY: Major\n") ||
+		!strings.Contains(string(content), "<CRITICAL_ISSUES>\n<NO_ISSUES_FOUND/>\n</CRITICAL_ISSUES>") {
+		t.Errorf("Unexpected rendered review:\n%s", content)
+	}
+	findings, err := LoadFindings(findingsPath(workflow.reviewPhasePath("syntax")))
+	if err != nil {
+		t.Fatalf("Expected the findings: %v", err)
+	}
+	expected := []Finding{{
+		Phase: "syntax-review", Category: "LOGIC_ISSUES", File: "app/totals.go", Line: 10, EndLine: 12,
+		Severity: SeverityMajor, Problem: "The total is rounded twice.", Solution: "total = round(sum)",
+	}}
+	if !reflect.DeepEqual(findings.Issues, expected) ||
+		!reflect.DeepEqual(findings.NoIssues, []string{"CRITICAL_ISSUES", "IMPROVEMENT_SUGGESTIONS"}) {
+		t.Errorf("Unexpected findings %+v", findings)
+	}
+	if parsed := ParseFindings("syntax-review", string(content)); !reflect.DeepEqual(parsed.Issues, expected) {
+		t.Errorf("Expected the rendered review to parse to the same findings, got %+v", parsed.Issues)
+	}
+
+	// A model that keeps failing the schema fails the phase
+	client.requests, client.replies = nil, []string{"not JSON"}
+	if err := workflow.GenerateSyntaxReview(); err == nil || !strings.Contains(err.Error(), "after 3 attempts") {
+		t.Errorf("Expected a schema error, got %v", err)
+	}
+
+	// Providers without structured outputs are asked for the tag format
+	tags := NewWorkflow(NewReviewContext("TEST-123", &stubProvider{model: "claude-opus-4-1", contextWindow: 200000}))
+	if prompt := tags.syntaxReviewPrompt(""); !strings.Contains(prompt, "<NO_ISSUES_FOUND/>") || strings.Contains(prompt, "response schema") {
+		t.Errorf("Expected the tag format for a provider without structured outputs")
+	}
+}
+
+func TestFindingsSARIF(t *testing.T) {
+	phases := []Findings{
+		{Phase: "defensive-review", Issues: []Finding{
+			{
+				Phase: "defensive-review", Category: "ERROR_HANDLING_ISSUES", File: "app/store.go", Line: 12, EndLine: 14,
+				Severity: SeverityCritical, Problem: "The error is ignored.",
+				Solution: "```go\n// Original\nsave()\n\n// Fixed\nif err := save(); err != nil {\n\treturn err\n}\n```",
+			},
+			{Phase: "defensive-review", Category: "ERROR_HANDLING_ISSUES", File: "app/load.go", Severity: "Unclear", Problem: "Unchecked read."},
+		}},
+		{Phase: "syntax-review", Issues: []Finding{
+			{Phase: "syntax-review", Category: "IMPROVEMENT_SUGGESTIONS", File: "app/util.go", Line: 3, Severity: SeverityMinor, Problem: "Unused import."},
+		}},
+	}
+
+	log := FindingsSARIF(phases)
+	run := log.Runs[0]
+	var rules []string
+	for _, rule := range run.Tool.Driver.Rules {
+		rules = append(rules, rule.ID)
+	}
+	if !reflect.DeepEqual(rules, []string{"defensive/error-handling", "syntax/improvement-suggestions"}) {
+		t.Errorf("Unexpected rules %v", rules)
+	}
+	if len(run.Results) != 3 {
+		t.Fatalf("Expected a result per finding, got %d", len(run.Results))
+	}
+
+	first := run.Results[0]
+	region := first.Locations[0].PhysicalLocation.Region
+	if first.Level != sarif.LevelError || first.Message.Text != "The error is ignored." || region.StartLine != 12 || region.EndLine != 14 {
+		t.Errorf("Unexpected result %+v", first)
+	}
+	if len(first.Fixes) != 1 ||
+		first.Fixes[0].ArtifactChanges[0].Replacements[0].InsertedContent.Text != "if err := save(); err != nil {\n\treturn err\n}\n" {
+		t.Errorf("Expected the fixed code as the fix, got %+v", first.Fixes)
+	} else if fix := first.Fixes[0]; fix.Description == nil || !strings.Contains(fix.Description.Text, "// Original\nsave()") ||
+		fix.ArtifactChanges[0].ArtifactLocation.URI != "app/store.go" ||
+		fix.ArtifactChanges[0].Replacements[0].DeletedRegion != (sarif.Region{StartLine: 12, EndLine: 14}) {
+		t.Errorf("Expected the fix to replace the finding's lines and describe the whole solution, got %+v", fix)
+	}
+
+	// Findings without a line have no region or fix, and unknown severities are warnings
+	second := run.Results[1]
+	if second.Level != sarif.LevelWarning || second.RuleIndex != 0 || second.Locations[0].PhysicalLocation.Region != nil || second.Fixes != nil {
+		t.Errorf("Unexpected result %+v", second)
+	}
+	if third := run.Results[2]; third.Level != sarif.LevelNote || third.RuleIndex != 1 {
+		t.Errorf("Unexpected result %+v", third)
+	}
+}
+
+func TestGitHubReview(t *testing.T) {
+	parsed, err := diff.Parse(testFileDiff("app/cart.go", "total := sum"))
+	if err != nil {
+		t.Fatalf("Failed to parse diff: %v", err)
+	}
+
+	ctx := NewReviewContext("TEST-123", &stubProvider{model: "gpt-4o", contextWindow: 128000})
+	ctx.OutputDir = t.TempDir()
+	ctx.ParsedDiff = parsed
+	ctx.HeadCommit = "head1"
+	workflow := NewWorkflow(ctx)
+
+	if _, _, err := workflow.GitHubReview(); err == nil {
+		t.Errorf("Expected an error without a final summary")
+	}
+	if err := os.WriteFile(workflow.artifactPath("final-summary.md"), []byte("## Summary\n\nLooks good.\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	phases := []Findings{{Phase: "syntax-review", Issues: []Finding{
+		{Phase: "syntax-review", Category: "LOGIC_ISSUES", File: "app/cart.go", Line: 1, Severity: SeverityMajor,
+			Problem: "The total skips discounts.", Solution: "```go\ntotal := sum - discount\n```"},
+		{Phase: "syntax-review", Category: "LOGIC_ISSUES", File: "app/cart.go", Line: 40, Problem: "Outside the diff."},
+		{Phase: "syntax-review", File: "app/other.go", Line: 1, Problem: "Not in the PR."},
+	}}}
+	if err := writeFindings(findingsPath(workflow.artifactPath("review-result.md")), phases); err != nil {
+		t.Fatal(err)
+	}
+
+	review, skipped, err := workflow.GitHubReview()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if review.Body != "## Summary\n\nLooks good." || review.Event != github.EventComment || review.CommitID != "head1" {
+		t.Errorf("Expected the final summary as the body, got %+v", review)
+	}
+	if skipped != 2 || len(review.Comments) != 1 {
+		t.Fatalf("Expected one inline comment and two findings left out, got %d and %d", len(review.Comments), skipped)
+	}
+	comment := review.Comments[0]
+	if comment.Path != "app/cart.go" || comment.Line != 1 || comment.Side != github.SideRight ||
+		!strings.Contains(comment.Body, "**[Major] logic (syntax review)**") || !strings.Contains(comment.Body, "total := sum - discount") {
+		t.Errorf("Unexpected comment %+v", comment)
+	}
+}
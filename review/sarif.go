@@ -0,0 +1,118 @@
+package review
+
+import (
+	"strings"
+
+	"github.com/jeremyhunt/agent-runner/sarif"
+)
+
+// sarifToolURI is the tool information link shown by SARIF viewers
+const sarifToolURI = "https://github.com/jeremyhunt/agent-runner"
+
+// findingLevels maps the severities of findings to SARIF levels; other severities are warnings
+var findingLevels = map[string]string{
+	SeverityCritical: sarif.LevelError,
+	SeverityMajor:    sarif.LevelWarning,
+	SeverityMinor:    sarif.LevelNote,
+}
+
+// FindingsSARIF converts the findings of the review phases to a SARIF log with a result per finding
+func FindingsSARIF(phases []Findings) *sarif.Log {
+	log := sarif.New("agent-runner", sarifToolURI)
+	for _, phase := range phases {
+		for _, finding := range phase.Issues {
+			log.AddResult(findingRule(finding), findingResult(finding))
+		}
+	}
+	return log
+}
+
+// findingRule returns the rule of a finding, named after its phase and category as in defensive/error-handling
+func findingRule(f Finding) sarif.Rule {
+	phase := strings.TrimSuffix(f.Phase, "-review")
+	category := f.Category
+	for _, suffix := range []string{"_ISSUES", "_REVIEW"} {
+		category = strings.TrimSuffix(category, suffix)
+	}
+	category = strings.ToLower(category)
+	if category == "" {
+		category = "general"
+	}
+
+	description := "Issues found by the " + phase + " review"
+	if f.Category != "" {
+		words := strings.ToLower(strings.ReplaceAll(f.Category, "_", " "))
+		description = strings.ToUpper(words[:1]) + words[1:] + " found by the " + phase + " review"
+	}
+	return sarif.Rule{
+		ID:               phase + "/" + strings.ReplaceAll(category, "_", "-"),
+		ShortDescription: &sarif.Message{Text: description},
+	}
+}
+
+// findingResult returns the SARIF result of a finding, with its solution as a fix when it has a line
+func findingResult(f Finding) sarif.Result {
+	level, ok := findingLevels[f.Severity]
+	if !ok {
+		level = sarif.LevelWarning
+	}
+	message := f.Problem
+	if message == "" {
+		message = "Issue found by the " + strings.TrimSuffix(f.Phase, "-review") + " review"
+	}
+	result := sarif.Result{Level: level, Message: sarif.Message{Text: message}}
+	if f.File == "" {
+		return result
+	}
+
+	artifact := sarif.ArtifactLocation{URI: f.File, URIBaseID: sarif.SourceRoot}
+	location := sarif.PhysicalLocation{ArtifactLocation: artifact}
+	if f.Line > 0 {
+		location.Region = &sarif.Region{StartLine: f.Line, EndLine: f.EndLine}
+	}
+	result.Locations = []sarif.Location{{PhysicalLocation: location}}
+
+	solution := unfence(f.Solution)
+	if f.Line == 0 || solution == "" {
+		return result
+	}
+	endLine := f.EndLine
+	if endLine == 0 {
+		endLine = f.Line
+	}
+	result.Fixes = []sarif.Fix{{
+		Description: &sarif.Message{Text: solution},
+		ArtifactChanges: []sarif.ArtifactChange{{
+			ArtifactLocation: artifact,
+			Replacements: []sarif.Replacement{{
+				DeletedRegion:   sarif.Region{StartLine: f.Line, EndLine: endLine},
+				InsertedContent: &sarif.Content{Text: fixedCode(solution) + "\n"},
+			}},
+		}},
+	}}
+	return result
+}
+
+// unfence returns the code of a SOLUTION_CODE block without its code fences
+func unfence(solution string) string {
+	lines := strings.Split(strings.TrimSpace(solution), "\n")
+	if len(lines) > 0 && strings.HasPrefix(strings.TrimSpace(lines[0]), "```") {
+		lines = lines[1:]
+	}
+	if len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "```" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// fixedCode returns the code after a solution's "Fixed" comment, as in the issue format example, or
+// the whole solution when it has none
+func fixedCode(solution string) string {
+	lines := strings.Split(solution, "\n")
+	for i, line := range lines {
+		if strings.EqualFold(strings.Trim(line, "/#-*;%'!<>: \t"), "Fixed") {
+			return strings.TrimSpace(strings.Join(lines[i+1:], "\n"))
+		}
+	}
+	return solution
+}
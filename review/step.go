@@ -0,0 +1,72 @@
+package review
+
+// Step is a stage of the review pipeline
+type Step interface {
+	// Name identifies the step in the pipeline config, the run manifest and --from-step/--only-step
+	Name() string
+
+	// Title is shown in the step header
+	Title() string
+
+	// Section starts a new output section before the step, or is empty to stay in the current one
+	Section() string
+
+	// Inputs are the artifacts the step reads in addition to the shared PR context
+	Inputs() []string
+
+	// Outputs are the artifacts the step writes
+	Outputs() []string
+
+	// Run performs the step
+	Run() error
+
+	// Restore reloads any in-memory state later steps need when the step is skipped
+	Restore() error
+
+	// ReviewPhase reports whether the step's output is one phase of the combined review.
+	// Consecutive review phases run concurrently and are then merged into the review file.
+	ReviewPhase() bool
+}
+
+// funcStep is a Step implemented by closures, used for the built-in steps
+type funcStep struct {
+	name    string
+	section string
+	title   string
+	inputs  []string
+	outputs []string
+	run     func() error
+	restore func() error
+
+	// reviewPhase marks the step as a phase of the combined review
+	reviewPhase bool
+}
+
+// Name implements Step
+func (s *funcStep) Name() string { return s.name }
+
+// Title implements Step
+func (s *funcStep) Title() string { return s.title }
+
+// Section implements Step
+func (s *funcStep) Section() string { return s.section }
+
+// Inputs implements Step
+func (s *funcStep) Inputs() []string { return s.inputs }
+
+// Outputs implements Step
+func (s *funcStep) Outputs() []string { return s.outputs }
+
+// Run implements Step
+func (s *funcStep) Run() error { return s.run() }
+
+// Restore implements Step
+func (s *funcStep) Restore() error {
+	if s.restore == nil {
+		return nil
+	}
+	return s.restore()
+}
+
+// ReviewPhase implements Step
+func (s *funcStep) ReviewPhase() bool { return s.reviewPhase }
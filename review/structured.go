@@ -0,0 +1,375 @@
+package review
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jeremyhunt/agent-runner/llm"
+	"github.com/jeremyhunt/agent-runner/logger"
+)
+
+// maxSchemaAttempts is how many times a review phase is asked for a response matching its schema
+const maxSchemaAttempts = 3
+
+// reviewCategory is a group of issues reported by a review phase
+type reviewCategory struct {
+	// Name is the category's tag, such as LOGIC_ISSUES
+	Name string
+
+	// Description says which issues the category lists
+	Description string
+}
+
+// reviewFormat describes the output of a built-in review phase. Models that support structured outputs
+// are asked for JSON matching the format's schema; the others are asked for its tag format.
+type reviewFormat struct {
+	// step is the pipeline step of the phase
+	step string
+
+	// tag encloses the phase's output in the tag format, such as SYNTAX_REVIEW
+	tag string
+
+	categories []reviewCategory
+
+	// limitations say what the review limitations should state
+	limitations []string
+
+	// focus is the closing instruction that keeps the phase to its own concerns
+	focus string
+}
+
+var syntaxReviewFormat = reviewFormat{
+	step: "syntax-review",
+	tag:  "SYNTAX_REVIEW",
+	categories: []reviewCategory{
+		{Name: "CRITICAL_ISSUES", Description: "List syntax errors that would cause runtime failures"},
+		{Name: "LOGIC_ISSUES", Description: "List problems with variable/parameter usage and logic"},
+		{Name: "IMPROVEMENT_SUGGESTIONS", Description: "List best practice violations"},
+	},
+	limitations: []string{"State what additional context would help"},
+	focus:       "Focus exclusively on syntax and logic - ignore broader functionality concerns.",
+}
+
+var functionalityReviewFormat = reviewFormat{
+	step: "functionality-review",
+	tag:  "FUNCTIONALITY_REVIEW",
+	categories: []reviewCategory{
+		{Name: "FUNCTIONALITY_ISSUES", Description: "List misses or errors in implemented functionality, as compared to ticket/design doc reqs"},
+	},
+	limitations: []string{"State if able to do a thorough review", "State what additional context would help"},
+	focus:       "Focus exclusively on functionality implementation per ticket/design doc - ignore broader syntax and logic concerns.",
+}
+
+var defensiveReviewFormat = reviewFormat{
+	step: "defensive-review",
+	tag:  "DEFENSIVE_REVIEW",
+	categories: []reviewCategory{
+		{Name: "ERROR_HANDLING_ISSUES", Description: "List missing or inadequate error handling"},
+		{Name: "EDGE_CASE_ISSUES", Description: "List unhandled edge cases"},
+		{Name: "SECURITY_ISSUES", Description: "List potential security concerns"},
+		{Name: "RESOURCE_ISSUES", Description: "List resource management issues"},
+	},
+	limitations: []string{"State what additional context would help"},
+	focus:       "Focus exclusively on defensive programming concerns - ignore syntax and functionality issues already covered in other reviews.",
+}
+
+// structuredOutputs reports whether a step's model is asked for JSON matching a schema
+func (w *Workflow) structuredOutputs(step string) bool {
+	client := w.client(step)
+	return client != nil && client.SupportsResponseSchema()
+}
+
+// reviewFormatSection returns the prompt section describing the output a review phase should produce
+func (w *Workflow) reviewFormatSection(format reviewFormat) string {
+	var sb strings.Builder
+	sb.WriteString("## Machine Consumption Format\n\n")
+	sb.WriteString("IMPORTANT: Your output will be processed by another LLM to create a consolidated review, not read directly by humans.\n\n")
+
+	if w.structuredOutputs(format.step) {
+		sb.WriteString("Respond with a JSON object matching the response schema:\n\n")
+		sb.WriteString("- `summary`: Brief assessment of findings and limitations\n")
+		sb.WriteString("- `categories`: One entry for each of these categories, with an empty `issues` list if no issues are found:\n")
+		for _, category := range format.categories {
+			sb.WriteString(fmt.Sprintf("  - `%s`: %s\n", category.Name, category.Description))
+		}
+		sb.WriteString(fmt.Sprintf("- `limitations`: %s\n\n", strings.Join(format.limitations, ". ")))
+
+		sb.WriteString("For each issue, give the `file` path, the `line` (and the `end_line` of a range, otherwise 0), ")
+		sb.WriteString("the `severity` (Critical, Major or Minor), the `problem` (a brief description followed by several lines of prior context with line numbers) ")
+		sb.WriteString("and the `solution_code`, a code block of the original and fixed code:\n\n")
+		sb.WriteString(w.solutionCodeExample())
+		sb.WriteString("\n")
+		sb.WriteString(format.focus + "\n\n")
+		return sb.String()
+	}
+
+	sb.WriteString("Use these consistent tags and format:\n\n")
+	sb.WriteString("```\n")
+	sb.WriteString(fmt.Sprintf("<%s>\n", format.tag))
+	sb.WriteString("  <REVIEW_SUMMARY>\n")
+	sb.WriteString("  Brief assessment of findings and limitations\n")
+	sb.WriteString("  </REVIEW_SUMMARY>\n\n")
+	for _, category := range format.categories {
+		sb.WriteString(fmt.Sprintf("  <%s>\n", category.Name))
+		sb.WriteString(fmt.Sprintf("  [%s]\n", category.Description))
+		sb.WriteString(fmt.Sprintf("  </%s>\n\n", category.Name))
+	}
+	sb.WriteString("  <REVIEW_LIMITATIONS>\n")
+	for _, limitation := range format.limitations {
+		sb.WriteString(fmt.Sprintf("  [%s]\n", limitation))
+	}
+	sb.WriteString("  </REVIEW_LIMITATIONS>\n")
+	sb.WriteString(fmt.Sprintf("</%s>\n", format.tag))
+	sb.WriteString("```\n\n")
+
+	sb.WriteString("For each issue, use this format:\n\n")
+	sb.WriteString(w.issueFormatExample())
+
+	sb.WriteString("If no issues found in a category: `<NO_ISSUES_FOUND/>`\n\n")
+	sb.WriteString(format.focus + "\n\n")
+	return sb.String()
+}
+
+// schema returns the JSON Schema of a review phase's structured output
+func (f reviewFormat) schema() *llm.ResponseSchema {
+	// Strict schemas require every property
+	object := func(properties map[string]interface{}, required ...string) map[string]interface{} {
+		return map[string]interface{}{
+			"type":                 "object",
+			"properties":           properties,
+			"required":             required,
+			"additionalProperties": false,
+		}
+	}
+	str := map[string]interface{}{"type": "string"}
+	integer := map[string]interface{}{"type": "integer"}
+
+	var names []string
+	for _, category := range f.categories {
+		names = append(names, category.Name)
+	}
+	issue := object(map[string]interface{}{
+		"file":          str,
+		"line":          integer,
+		"end_line":      integer,
+		"severity":      map[string]interface{}{"type": "string", "enum": []string{SeverityCritical, SeverityMajor, SeverityMinor}},
+		"problem":       str,
+		"solution_code": str,
+	}, "file", "line", "end_line", "severity", "problem", "solution_code")
+	category := object(map[string]interface{}{
+		"name":   map[string]interface{}{"type": "string", "enum": names},
+		"issues": map[string]interface{}{"type": "array", "items": issue},
+	}, "name", "issues")
+	review := object(map[string]interface{}{
+		"summary":     str,
+		"categories":  map[string]interface{}{"type": "array", "items": category},
+		"limitations": str,
+	}, "summary", "categories", "limitations")
+
+	// Encoding these maps can't fail
+	schema, _ := json.Marshal(review)
+	return &llm.ResponseSchema{Name: strings.ReplaceAll(f.step, "-", "_"), Schema: schema}
+}
+
+// structuredReview is a review phase's structured output
+type structuredReview struct {
+	Summary    string `json:"summary"`
+	Categories []struct {
+		Name   string            `json:"name"`
+		Issues []structuredIssue `json:"issues"`
+	} `json:"categories"`
+	Limitations string `json:"limitations"`
+}
+
+// structuredIssue is one issue of a review phase's structured output
+type structuredIssue struct {
+	File         string `json:"file"`
+	Line         int    `json:"line"`
+	EndLine      int    `json:"end_line"`
+	Severity     string `json:"severity"`
+	Problem      string `json:"problem"`
+	SolutionCode string `json:"solution_code"`
+}
+
+// parse reads and checks a structured response. Besides the schema, the categories must be the phase's
+// own and each issue needs a file and a problem.
+func (f reviewFormat) parse(response string) (structuredReview, error) {
+	// Some models wrap the JSON in a code fence even when asked for JSON
+	response = strings.TrimSpace(response)
+	if strings.HasPrefix(response, "```") {
+		response = strings.TrimPrefix(strings.TrimPrefix(response, "```json"), "```")
+		response = strings.TrimSuffix(strings.TrimSpace(response), "```")
+	}
+
+	var review structuredReview
+	decoder := json.NewDecoder(bytes.NewReader([]byte(response)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&review); err != nil {
+		return review, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	known := make(map[string]bool, len(f.categories))
+	for _, category := range f.categories {
+		known[category.Name] = true
+	}
+	var problems []string
+	for _, category := range review.Categories {
+		if !known[category.Name] {
+			problems = append(problems, fmt.Sprintf("unknown category %q", category.Name))
+		}
+		for i, issue := range category.Issues {
+			if strings.TrimSpace(issue.File) == "" || strings.TrimSpace(issue.Problem) == "" {
+				problems = append(problems, fmt.Sprintf("issue %d of %s has no file or problem", i+1, category.Name))
+			}
+			switch normalizeSeverity(issue.Severity) {
+			case SeverityCritical, SeverityMajor, SeverityMinor:
+			default:
+				problems = append(problems, fmt.Sprintf("issue %d of %s has severity %q", i+1, category.Name, issue.Severity))
+			}
+			if issue.Line < 0 || issue.EndLine < 0 {
+				problems = append(problems, fmt.Sprintf("issue %d of %s has a negative line", i+1, category.Name))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return review, errors.New(strings.Join(problems, "; "))
+	}
+	return review, nil
+}
+
+// findings returns the issues of a structured review
+func (f reviewFormat) findings(review structuredReview) []Finding {
+	var issues []Finding
+	for _, category := range review.Categories {
+		for _, issue := range category.Issues {
+			finding := Finding{
+				Phase:    f.step,
+				Category: category.Name,
+				File:     cleanFindingFile(issue.File),
+				Line:     issue.Line,
+				Severity: normalizeSeverity(issue.Severity),
+				Problem:  strings.TrimSpace(issue.Problem),
+				Solution: strings.TrimSpace(issue.SolutionCode),
+			}
+			if issue.EndLine > issue.Line {
+				finding.EndLine = issue.EndLine
+			}
+			issues = append(issues, finding)
+		}
+	}
+	return issues
+}
+
+// render writes a structured review in the tag format, so the later steps read the same review whichever
+// format the model was asked for
+func (f reviewFormat) render(review structuredReview) string {
+	byCategory := make(map[string][]Finding)
+	for _, finding := range f.findings(review) {
+		byCategory[finding.Category] = append(byCategory[finding.Category], finding)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("<%s>\n", f.tag))
+	sb.WriteString(fmt.Sprintf("<REVIEW_SUMMARY>\n%s\n</REVIEW_SUMMARY>\n\n", strings.TrimSpace(review.Summary)))
+	for _, category := range f.categories {
+		sb.WriteString(fmt.Sprintf("<%s>\n", category.Name))
+		if len(byCategory[category.Name]) == 0 {
+			sb.WriteString("<NO_ISSUES_FOUND/>\n")
+		}
+		for _, finding := range byCategory[category.Name] {
+			sb.WriteString("<ISSUE>\n")
+			sb.WriteString(fmt.Sprintf("FILE: %s\n", finding.File))
+			if finding.EndLine > 0 {
+				sb.WriteString(fmt.Sprintf("LINE: %d-%d\n", finding.Line, finding.EndLine))
+			} else {
+				sb.WriteString(fmt.Sprintf("LINE: %d\n", finding.Line))
+			}
+			sb.WriteString(fmt.Sprintf("SEVERITY: %s\n", finding.Severity))
+			sb.WriteString(fmt.Sprintf("PROBLEM: %s\n", finding.Problem))
+			sb.WriteString(fmt.Sprintf("SOLUTION_CODE:\n%s\n", finding.Solution))
+			sb.WriteString("</ISSUE>\n")
+		}
+		sb.WriteString(fmt.Sprintf("</%s>\n\n", category.Name))
+	}
+	sb.WriteString(fmt.Sprintf("<REVIEW_LIMITATIONS>\n%s\n</REVIEW_LIMITATIONS>\n", strings.TrimSpace(review.Limitations)))
+	sb.WriteString(fmt.Sprintf("</%s>\n", f.tag))
+	return sb.String()
+}
+
+// askStructured asks for a review phase's structured output, asking again with the problem when the
+// response doesn't match the schema
+func (w *Workflow) askStructured(format reviewFormat, prompt string) (structuredReview, error) {
+	client := w.client(format.step)
+	messages := chatMessages(w.GetCommonPromptIntro("reviewer"), prompt)
+	for attempt := 1; ; attempt++ {
+		opts := w.chatOptions(format.step, client)
+		opts.ResponseSchema = format.schema()
+		response, err := client.Chat(context.Background(), messages, opts)
+		if err != nil {
+			return structuredReview{}, err
+		}
+
+		review, err := format.parse(response)
+		if err == nil {
+			return review, nil
+		}
+		if attempt >= maxSchemaAttempts {
+			return structuredReview{}, fmt.Errorf("the response did not match the schema after %d attempts: %w", attempt, err)
+		}
+
+		logger.Verbose("The %s response did not match the schema (%v), asking again", format.step, err)
+		messages = append(messages,
+			llm.Message{Role: llm.RoleAssistant, Content: response},
+			llm.Message{Role: llm.RoleUser, Content: fmt.Sprintf(
+				"Your response did not match the schema: %v. Reply again with only the JSON object, following the schema exactly.", err)},
+		)
+	}
+}
+
+// askReviewPhase sends a review phase's prompt, in parts if the diff doesn't fit the model, and returns
+// the review in the tag format with its findings
+func (w *Workflow) askReviewPhase(format reviewFormat, prompt func(diff string) string) (string, Findings, error) {
+	parts, err := w.splitPrompt(format.step, prompt)
+	if err != nil {
+		return "", Findings{}, err
+	}
+
+	if !w.structuredOutputs(format.step) {
+		response, err := askInParts(parts, func(prompt string) (string, error) {
+			return w.ask(format.step, w.GetCommonPromptIntro("reviewer"), prompt)
+		})
+		if err != nil {
+			return "", Findings{}, err
+		}
+		return response, ParseFindings(format.step, response), nil
+	}
+
+	findings := Findings{Phase: format.step, Issues: []Finding{}}
+	response, err := askInParts(parts, func(prompt string) (string, error) {
+		review, err := w.askStructured(format, prompt)
+		if err != nil {
+			return "", err
+		}
+		findings.Issues = append(findings.Issues, format.findings(review)...)
+		return format.render(review), nil
+	})
+	if err != nil {
+		return "", Findings{}, err
+	}
+
+	// A category is clean when no part reported an issue in it
+	reported := make(map[string]bool)
+	for _, finding := range findings.Issues {
+		reported[finding.Category] = true
+	}
+	for _, category := range format.categories {
+		if !reported[category.Name] {
+			findings.NoIssues = append(findings.NoIssues, category.Name)
+		}
+	}
+	return response, findings, nil
+}
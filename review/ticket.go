@@ -1,7 +1,6 @@
 package review
 
 import (
-	"context"
 	"fmt"
 	"os"
 
@@ -38,13 +37,16 @@ func (w *Workflow) LoadTicketDetails() error {
 		return fmt.Errorf("failed to get ticket %s: %w", w.Ctx.Ticket, err)
 	}
 
+	// Keep the raw ticket so checkpoints don't depend on the LLM's formatting
+	w.Ctx.TicketSource = fmt.Sprintf("%s\n%s\n%s\n%s",
+		ticket.Key, ticket.Fields.Summary, ticket.Fields.Status.Name, ticket.Fields.Description)
+
 	// Format the ticket as markdown using our existing LLM client
 	logger.Verbose("Formatting ticket as markdown...")
 
 	// Create a prompt for the LLM to format the ticket
-	prompt := fmt.Sprintf(`You are a technical documentation expert tasked with formatting a Jira ticket for use in a code review context.
-
-The ticket information will be used to perform an in-depth code review of a pull request.
+	system := "You are a technical documentation expert tasked with formatting a Jira ticket for use in a code review context."
+	prompt := fmt.Sprintf(`The ticket information will be used to perform an in-depth code review of a pull request.
 
 Format the ticket information as clean, well-structured markdown that highlights the most important aspects relevant for code review.
 
@@ -77,7 +79,7 @@ Format this as markdown, with appropriate sections and highlighting of key infor
 	}
 
 	// Send to LLM
-	formattedTicket, err := w.Ctx.Client.Complete(context.Background(), prompt)
+	formattedTicket, err := w.ask("ticket", system, prompt)
 	if err != nil {
 		return fmt.Errorf("failed to format ticket: %w", err)
 	}
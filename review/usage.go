@@ -0,0 +1,158 @@
+package review
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jeremyhunt/agent-runner/llm"
+	"github.com/jeremyhunt/agent-runner/pricing"
+)
+
+// UsageEntry is the token usage of one step with one model
+type UsageEntry struct {
+	// Step is the pipeline step, or "ticket" for formatting the Jira ticket
+	Step string `json:"step"`
+
+	// Model is the model the requests were sent to
+	Model string `json:"model"`
+
+	// Requests is the number of requests that reported usage
+	Requests int `json:"requests"`
+
+	PromptTokens     int `json:"prompt_tokens"`
+	CachedTokens     int `json:"cached_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+
+	// Cost is the estimated cost in US dollars, or nil when the model has no rates
+	Cost *float64 `json:"cost_usd,omitempty"`
+}
+
+// UsageReport is the token usage and estimated cost of a review run
+type UsageReport struct {
+	// Ticket is the ticket the review belongs to
+	Ticket string `json:"ticket"`
+
+	// Steps lists the usage of each step in the order the steps first sent a request
+	Steps []UsageEntry `json:"steps"`
+
+	// Total sums every step. Its cost only covers models with rates.
+	Total UsageEntry `json:"total"`
+
+	// UnpricedModels lists the models without rates, whose cost is left out of the total
+	UnpricedModels []string `json:"unpriced_models,omitempty"`
+}
+
+// UsageTracker accumulates the token usage of a review per step. It is safe for concurrent use.
+type UsageTracker struct {
+	mu      sync.Mutex
+	entries []*UsageEntry
+}
+
+// NewUsageTracker creates an empty usage tracker
+func NewUsageTracker() *UsageTracker {
+	return &UsageTracker{}
+}
+
+// Record adds the usage of a request sent by a step
+func (t *UsageTracker) Record(step, model string, usage llm.Usage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var entry *UsageEntry
+	for _, existing := range t.entries {
+		if existing.Step == step && existing.Model == model {
+			entry = existing
+			break
+		}
+	}
+	if entry == nil {
+		entry = &UsageEntry{Step: step, Model: model}
+		t.entries = append(t.entries, entry)
+	}
+
+	entry.Requests++
+	entry.PromptTokens += usage.PromptTokens
+	entry.CachedTokens += usage.CachedTokens
+	entry.CompletionTokens += usage.CompletionTokens
+}
+
+// Report prices the recorded usage with the given rates
+func (t *UsageTracker) Report(ticket string, rates *pricing.Table) *UsageReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	report := &UsageReport{Ticket: ticket, Steps: []UsageEntry{}}
+	var totalCost float64
+	unpriced := make(map[string]bool)
+	for _, recorded := range t.entries {
+		entry := *recorded
+		if modelRates, ok := rates.Lookup(entry.Model); ok {
+			cost := modelRates.Cost(entry.PromptTokens, entry.CachedTokens, entry.CompletionTokens)
+			entry.Cost = &cost
+			totalCost += cost
+		} else {
+			unpriced[entry.Model] = true
+		}
+		report.Steps = append(report.Steps, entry)
+
+		report.Total.Requests += entry.Requests
+		report.Total.PromptTokens += entry.PromptTokens
+		report.Total.CachedTokens += entry.CachedTokens
+		report.Total.CompletionTokens += entry.CompletionTokens
+	}
+	report.Total.Cost = &totalCost
+
+	for model := range unpriced {
+		report.UnpricedModels = append(report.UnpricedModels, model)
+	}
+	sort.Strings(report.UnpricedModels)
+	return report
+}
+
+// Summary returns a one-line description of the total usage and cost
+func (r *UsageReport) Summary() string {
+	summary := fmt.Sprintf("%d requests, %d prompt tokens (%d cached), %d completion tokens",
+		r.Total.Requests, r.Total.PromptTokens, r.Total.CachedTokens, r.Total.CompletionTokens)
+	if r.Total.Cost != nil {
+		summary += fmt.Sprintf(", estimated cost $%.4f", *r.Total.Cost)
+	}
+	if len(r.UnpricedModels) > 0 {
+		summary += fmt.Sprintf(" (no rates for %s)", strings.Join(r.UnpricedModels, ", "))
+	}
+	return summary
+}
+
+// usagePath returns the location of the usage report for the current ticket
+func (w *Workflow) usagePath() string {
+	return filepath.Join(w.Ctx.OutputDir, fmt.Sprintf("%s-usage.json", w.Ctx.Ticket))
+}
+
+// WriteUsageReport prices the usage recorded so far and writes it to the usage artifact
+func (w *Workflow) WriteUsageReport() (*UsageReport, error) {
+	report := w.Ctx.Usage.Report(w.Ctx.Ticket, w.Ctx.Pricing)
+
+	content, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode usage report: %w", err)
+	}
+	if err := os.WriteFile(w.usagePath(), content, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write usage report: %w", err)
+	}
+	return report, nil
+}
+
+// chatOptions returns chat options that record the usage of a request under the given step
+func (w *Workflow) chatOptions(step string, client llm.Provider) llm.ChatOptions {
+	if w.Ctx.Usage == nil {
+		return llm.ChatOptions{}
+	}
+	model := client.Model()
+	return llm.ChatOptions{
+		OnUsage: func(usage llm.Usage) { w.Ctx.Usage.Record(step, model, usage) },
+	}
+}
@@ -0,0 +1,155 @@
+// Package sarif writes static analysis results in the SARIF 2.1.0 format read by CI dashboards and IDEs.
+package sarif
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Version and Schema identify the SARIF format of a log
+const (
+	Version = "2.1.0"
+	Schema  = "https://json.schemastore.org/sarif-2.1.0.json"
+)
+
+// Result levels
+const (
+	LevelError   = "error"
+	LevelWarning = "warning"
+	LevelNote    = "note"
+)
+
+// SourceRoot is the base of the artifact paths, resolved by the consumer to the repository checkout
+const SourceRoot = "%SRCROOT%"
+
+// Log is a SARIF file
+type Log struct {
+	Version string `json:"version"`
+	Schema  string `json:"$schema"`
+	Runs    []Run  `json:"runs"`
+}
+
+// Run is the output of one analysis tool
+type Run struct {
+	Tool    Tool     `json:"tool"`
+	Results []Result `json:"results"`
+}
+
+// Tool describes the analysis tool and the rules its results refer to
+type Tool struct {
+	Driver Driver `json:"driver"`
+}
+
+// Driver is the tool's main component
+type Driver struct {
+	Name           string `json:"name"`
+	InformationURI string `json:"informationUri,omitempty"`
+	Rules          []Rule `json:"rules,omitempty"`
+}
+
+// Rule is a kind of result, such as a category of review issue
+type Rule struct {
+	ID               string   `json:"id"`
+	ShortDescription *Message `json:"shortDescription,omitempty"`
+}
+
+// Result is one issue the tool found
+type Result struct {
+	RuleID    string     `json:"ruleId"`
+	RuleIndex int        `json:"ruleIndex"`
+	Level     string     `json:"level"`
+	Message   Message    `json:"message"`
+	Locations []Location `json:"locations,omitempty"`
+	Fixes     []Fix      `json:"fixes,omitempty"`
+}
+
+// Message is the text of a result or description
+type Message struct {
+	Text string `json:"text"`
+}
+
+// Location is where a result was found
+type Location struct {
+	PhysicalLocation PhysicalLocation `json:"physicalLocation"`
+}
+
+// PhysicalLocation is a region of a file
+type PhysicalLocation struct {
+	ArtifactLocation ArtifactLocation `json:"artifactLocation"`
+	Region           *Region          `json:"region,omitempty"`
+}
+
+// ArtifactLocation is a file, relative to a base such as SourceRoot
+type ArtifactLocation struct {
+	URI       string `json:"uri"`
+	URIBaseID string `json:"uriBaseId,omitempty"`
+}
+
+// Region is a range of lines, numbered from 1
+type Region struct {
+	StartLine int `json:"startLine"`
+	EndLine   int `json:"endLine,omitempty"`
+}
+
+// Fix is a proposed change that resolves a result
+type Fix struct {
+	Description     *Message         `json:"description,omitempty"`
+	ArtifactChanges []ArtifactChange `json:"artifactChanges"`
+}
+
+// ArtifactChange is a fix's changes to one file
+type ArtifactChange struct {
+	ArtifactLocation ArtifactLocation `json:"artifactLocation"`
+	Replacements     []Replacement    `json:"replacements"`
+}
+
+// Replacement replaces a region of a file with new content
+type Replacement struct {
+	DeletedRegion   Region   `json:"deletedRegion"`
+	InsertedContent *Content `json:"insertedContent,omitempty"`
+}
+
+// Content is the text inserted by a replacement
+type Content struct {
+	Text string `json:"text"`
+}
+
+// New creates a log with a single run of the named tool
+func New(tool, informationURI string) *Log {
+	return &Log{
+		Version: Version,
+		Schema:  Schema,
+		Runs:    []Run{{Tool: Tool{Driver: Driver{Name: tool, InformationURI: informationURI}}, Results: []Result{}}},
+	}
+}
+
+// AddResult adds a result to the log's run, adding its rule the first time it's used
+func (l *Log) AddResult(rule Rule, result Result) {
+	run := &l.Runs[0]
+	result.RuleID = rule.ID
+	result.RuleIndex = -1
+	for i, existing := range run.Tool.Driver.Rules {
+		if existing.ID == rule.ID {
+			result.RuleIndex = i
+			break
+		}
+	}
+	if result.RuleIndex < 0 {
+		run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, rule)
+		result.RuleIndex = len(run.Tool.Driver.Rules) - 1
+	}
+	run.Results = append(run.Results, result)
+}
+
+// Save writes the log as JSON
+func (l *Log) Save(path string) error {
+	content, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode SARIF: %w", err)
+	}
+	if err := os.WriteFile(path, append(content, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write SARIF: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package sarif
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLog(t *testing.T) {
+	log := New("agent-runner", "")
+	log.AddResult(Rule{ID: "syntax/logic"}, Result{Level: LevelError, Message: Message{Text: "one"}})
+	log.AddResult(Rule{ID: "defensive/security"}, Result{Level: LevelNote, Message: Message{Text: "two"}})
+	log.AddResult(Rule{ID: "syntax/logic"}, Result{Level: LevelWarning, Message: Message{Text: "three"}})
+
+	path := filepath.Join(t.TempDir(), "review.sarif")
+	if err := log.Save(path); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var saved Log
+	if err := json.Unmarshal(content, &saved); err != nil {
+		t.Fatalf("Failed to parse the log: %v", err)
+	}
+	if saved.Version != Version || saved.Schema != Schema || len(saved.Runs) != 1 {
+		t.Fatalf("Unexpected log %s", content)
+	}
+	run := saved.Runs[0]
+	if len(run.Tool.Driver.Rules) != 2 || len(run.Results) != 3 {
+		t.Fatalf("Expected each rule once and every result, got %s", content)
+	}
+	for i, want := range []int{0, 1, 0} {
+		if got := run.Results[i]; got.RuleIndex != want || got.RuleID != run.Tool.Driver.Rules[want].ID {
+			t.Errorf("Result %d refers to rule %d (%s), expected %d", i, got.RuleIndex, got.RuleID, want)
+		}
+	}
+}
@@ -2,10 +2,10 @@ package tokens
 
 import (
 	"fmt"
-	"log"
 	"strings"
 	"sync"
 
+	"github.com/jeremyhunt/agent-runner/models"
 	"github.com/pkoukk/tiktoken-go"
 	"github.com/sashabaranov/go-openai"
 )
@@ -16,12 +16,20 @@ type Counter struct {
 	encoders map[string]*tiktoken.Tiktoken
 	// Mutex to protect concurrent access to the encoders map
 	mutex sync.RWMutex
+	// models gives each model's encoding and message overhead
+	models *models.Registry
 }
 
-// NewCounter creates a new token counter
+// NewCounter creates a new token counter for the built-in models
 func NewCounter() *Counter {
+	return NewCounterWithRegistry(models.NewRegistry())
+}
+
+// NewCounterWithRegistry creates a token counter that looks up encodings and message overheads in a registry
+func NewCounterWithRegistry(registry *models.Registry) *Counter {
 	return &Counter{
 		encoders: make(map[string]*tiktoken.Tiktoken),
+		models:   registry,
 	}
 }
 
@@ -44,32 +52,9 @@ func (c *Counter) CountMessages(messages []openai.ChatCompletionMessage, model s
 		return 0, err
 	}
 
-	var tokensPerMessage, tokensPerName int
-	switch model {
-	case "gpt-3.5-turbo-0613",
-		"gpt-3.5-turbo-16k-0613",
-		"gpt-4-0314",
-		"gpt-4-32k-0314",
-		"gpt-4-0613",
-		"gpt-4-32k-0613",
-		"gpt-4o-2024-05-13",
-		"gpt-4o":
-		tokensPerMessage = 3
-		tokensPerName = 1
-	case "gpt-3.5-turbo-0301":
-		tokensPerMessage = 4 // every message follows <|start|>{role/name}\n{content}<|end|>\n
-		tokensPerName = -1   // if there's a name, the role is omitted
-	default:
-		if strings.Contains(model, "gpt-3.5-turbo") {
-			log.Println("warning: gpt-3.5-turbo may update over time. Returning num tokens assuming gpt-3.5-turbo-0613.")
-			return c.CountMessages(messages, "gpt-3.5-turbo-0613")
-		} else if strings.Contains(model, "gpt-4") {
-			log.Println("warning: gpt-4 may update over time. Returning num tokens assuming gpt-4-0613.")
-			return c.CountMessages(messages, "gpt-4-0613")
-		} else {
-			return 0, fmt.Errorf("token counting not implemented for model %s", model)
-		}
-	}
+	// Unknown models are estimated with the usual overhead of the current chat models
+	info, _ := c.models.Lookup(model)
+	tokensPerMessage, tokensPerName := info.MessageOverhead()
 
 	numTokens := 0
 	for _, message := range messages {
@@ -108,8 +93,17 @@ func (c *Counter) getEncoderForModel(model string) (*tiktoken.Tiktoken, error) {
 		return encoder, nil
 	}
 
-	// Get a new encoder for this model
-	encoder, err := tiktoken.EncodingForModel(model)
+	// Get a new encoder for this model, preferring the registry's encoding. Models neither knows,
+	// such as Claude or local models, are estimated with the cl100k_base encoding.
+	var err error
+	if info, ok := c.models.Lookup(model); ok && info.Encoding != "" {
+		encoder, err = tiktoken.GetEncoding(info.Encoding)
+	} else {
+		encoder, err = tiktoken.EncodingForModel(model)
+	}
+	if err != nil && !c.isKnownModel(model) {
+		encoder, err = tiktoken.GetEncoding(tiktoken.MODEL_CL100K_BASE)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get encoding for model %s: %w", model, err)
 	}
@@ -118,3 +112,20 @@ func (c *Counter) getEncoderForModel(model string) (*tiktoken.Tiktoken, error) {
 	c.encoders[model] = encoder
 	return encoder, nil
 }
+
+// isKnownModel reports whether the registry or tiktoken has an encoding for the model, so a failure to load it
+// isn't hidden by the fallback encoding
+func (c *Counter) isKnownModel(model string) bool {
+	if info, ok := c.models.Lookup(model); ok && info.Encoding != "" {
+		return true
+	}
+	if _, ok := tiktoken.MODEL_TO_ENCODING[model]; ok {
+		return true
+	}
+	for prefix := range tiktoken.MODEL_PREFIX_TO_ENCODING {
+		if strings.HasPrefix(model, prefix) {
+			return true
+		}
+	}
+	return false
+}